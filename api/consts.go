@@ -1,8 +1,10 @@
 package api
 
 const LUA_MINSTACK = 20
+const LUA_MULTRET = -1
 const LUAI_MAXSTACK = 1000000
 const LUA_REGISTRYINDEX = -LUAI_MAXSTACK - 1000
+const LUA_RIDX_MAINTHREAD int64 = 1
 const LUA_RIDX_GLOBALS int64 = 2
 
 /* basic types */
@@ -55,4 +57,35 @@ const (
 	LUA_ERRGCMM
 	LUA_ERRERR
 	LUA_ERRFILE
+	LUA_ERRABORT // context被取消或者超过了指令预算，脚本被中止
+)
+
+/* garbage-collection options */
+const (
+	LUA_GCSTOP = iota
+	LUA_GCRESTART
+	LUA_GCCOLLECT
+	LUA_GCCOUNT
+	LUA_GCCOUNTB
+	LUA_GCSTEP
+	LUA_GCSETPAUSE
+	LUA_GCSETSTEPMUL
+	LUA_GCISRUNNING = 9
+)
+
+/* event codes */
+const (
+	LUA_HOOKCALL = iota
+	LUA_HOOKRET
+	LUA_HOOKLINE
+	LUA_HOOKCOUNT
+	LUA_HOOKTAILCALL
+)
+
+/* event masks */
+const (
+	LUA_MASKCALL  = 1 << LUA_HOOKCALL
+	LUA_MASKRET   = 1 << LUA_HOOKRET
+	LUA_MASKLINE  = 1 << LUA_HOOKLINE
+	LUA_MASKCOUNT = 1 << LUA_HOOKCOUNT
 )
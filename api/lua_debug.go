@@ -0,0 +1,23 @@
+package api
+
+// 钩子函数，ar.Event是触发钩子的事件（LUA_HOOKCALL等），
+// 行事件的ar.CurrentLine是新的行号。要获取其他信息需要调用GetInfo
+type LuaHook func(L LuaState, ar *LuaDebug)
+
+// 活动函数的调试信息，参见lua_Debug
+type LuaDebug struct {
+	Event           int
+	Name            string      // (n) 函数名，找不到合理的名字时为空串
+	NameWhat        string      // (n) "global", "local", "method", "field", "upvalue"或""
+	What            string      // (S) "Lua", "Go", "main"
+	Source          string      // (S) 创建函数的代码块名
+	CurrentLine     int         // (l) 当前正在执行的行号，没有行号信息时为-1
+	LineDefined     int         // (S) 函数定义开始处的行号
+	LastLineDefined int         // (S) 函数定义结束处的行号
+	NUps            int         // (u) 上值的个数
+	NParams         int         // (u) 固定参数的个数（Go函数总是0）
+	IsVararg        bool        // (u) 是否是变参函数（Go函数总是true）
+	IsTailCall      bool        // (t) 是否由尾调用调起
+	ShortSrc        string      // (S) 可打印版本的Source，用于出错信息
+	CallInfo        interface{} // GetStack找到的活动函数，仅供LuaState内部使用
+}
@@ -1,5 +1,7 @@
 package api
 
+import "context"
+
 type LuaType = int
 type ArithOp = int
 type CompareOp = int
@@ -11,6 +13,8 @@ func LuaUpvalueIndex(i int) int {
 }
 
 type LuaState interface {
+	/* state manipulation */
+	Close()
 	/* basic stack manipulation */
 	GetTop() int
 	AbsIndex(idx int) int
@@ -37,6 +41,8 @@ type LuaState interface {
 	IsThread(idx int) bool
 	IsFunction(idx int) bool
 	IsGoFunction(idx int) bool
+	IsUserdata(idx int) bool
+	IsLightUserdata(idx int) bool
 	ToBoolean(idx int) bool
 	ToInteger(idx int) int64
 	ToIntegerX(idx int) (int64, bool)
@@ -45,6 +51,9 @@ type LuaState interface {
 	ToString(idx int) string
 	ToStringX(idx int) (string, bool)
 	ToGoFunction(idx int) GoFunction
+	ToThread(idx int) LuaState
+	ToPointer(idx int) interface{}
+	ToUserdata(idx int) interface{}
 	RawLen(idx int) uint
 	/* push functions (Go -> stack) */
 	PushNil()
@@ -55,6 +64,8 @@ type LuaState interface {
 	PushGoFunction(f GoFunction)
 	PushGoClosure(f GoFunction, n int)
 	PushGlobalTable()
+	PushThread() bool
+	PushLightUserdata(p interface{})
 	/* Comparison and arithmetic functions */
 	Arith(op ArithOp)
 	Compare(idx1, idx2 int, op CompareOp) bool
@@ -69,6 +80,8 @@ type LuaState interface {
 	RawGetI(idx int, i int64) LuaType
 	GetMetatable(idx int) bool
 	GetGlobal(name string) LuaType
+	NewUserdata(data interface{})
+	GetUserValue(idx int) LuaType
 	/* set functions (stack -> Lua) */
 	SetTable(idx int)
 	SetField(idx int, k string)
@@ -77,14 +90,44 @@ type LuaState interface {
 	RawSetI(idx int, i int64)
 	SetMetatable(idx int)
 	SetGlobal(name string)
+	SetUserValue(idx int)
 	Register(name string, f GoFunction)
 	/* 'load' and 'call' functions (load and run Lua code) */
 	Load(chunk []byte, chunkName, mode string) int
+	Dump(strip bool) []byte
 	Call(nArgs, nResults int)
 	PCall(nArgs, nResults, msgh int) int
+	/* garbage-collection function */
+	GC(what, data int) int
+	SetMemoryLimit(n int64)
 	/* miscellaneous functions */
 	Len(idx int)
 	Concat(n int)
 	Next(idx int) bool
 	Error() int
+	StringToNumber(s string) bool
+	/* coroutine functions */
+	NewThread() LuaState
+	Resume(from LuaState, nArgs int) int
+	Yield(nResults int) int
+	Status() int
+	IsYieldable() bool
+	XMove(to LuaState, n int)
+	/* debug API */
+	GetStack(level int, ar *LuaDebug) bool
+	GetInfo(what string, ar *LuaDebug) bool
+	GetLocal(ar *LuaDebug, n int) (string, bool)
+	SetLocal(ar *LuaDebug, n int) (string, bool)
+	GetUpvalue(funcIdx, n int) (string, bool)
+	SetUpvalue(funcIdx, n int) (string, bool)
+	UpvalueID(funcIdx, n int) interface{}
+	UpvalueJoin(funcIdx1, n1, funcIdx2, n2 int)
+	SetHook(f LuaHook, mask, count int)
+	GetHook() LuaHook
+	GetHookMask() int
+	GetHookCount() int
+	/* execution budget */
+	SetContext(ctx context.Context)
+	SetInstructionLimit(n int64)
+	ConsumeBudget(n int64)
 }
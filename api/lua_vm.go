@@ -12,4 +12,5 @@ type LuaVM interface {
 	LoadVararg(n int)
 	LoadProto(idx int)
 	CloseUpvalues(a int)
+	TailCall(nArgs int) // 尾调用，被调函数复用当前调用帧
 }
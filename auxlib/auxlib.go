@@ -0,0 +1,265 @@
+package auxlib
+
+import (
+	"fmt"
+	. "luago/api"
+)
+
+/*
+ * 辅助库，对应lauxlib。它完全建立在LuaState接口之上，
+ * 提供参数检查、出错信息、元表和加载代码块等常用功能，方便编写Go函数
+ */
+
+/* key, in the registry, for table of loaded modules */
+const LUA_LOADED_TABLE = "_LOADED"
+
+/* key, in the registry, for table of preloaded loaders */
+const LUA_PRELOAD_TABLE = "_PRELOAD"
+
+/* predefined references */
+const (
+	LUA_NOREF  = -2
+	LUA_REFNIL = -1
+)
+
+type FuncReg map[string]GoFunction
+
+/* 出错信息 */
+
+// 抛出错误，出错信息前面加上当前位置（参见Where）
+// lua-5.3.4/src/lauxlib.c#luaL_error()
+func Error(L LuaState, format string, a ...interface{}) int {
+	Where(L, 1)
+	L.PushString(fmt.Sprintf(format, a...))
+	L.Concat(2)
+	return L.Error()
+}
+
+// 抛出"bad argument #arg to 'funcname' (extraMsg)"形式的错误，函数名由调试信息推断
+// lua-5.3.4/src/lauxlib.c#luaL_argerror()
+func ArgError(L LuaState, arg int, extraMsg string) int {
+	var ar LuaDebug
+	if !L.GetStack(0, &ar) { /* no stack frame? */
+		return Error(L, "bad argument #%d (%s)", arg, extraMsg)
+	}
+	L.GetInfo("n", &ar)
+	if ar.NameWhat == "method" {
+		arg--         /* do not count 'self' */
+		if arg == 0 { /* error is in the self argument itself? */
+			return Error(L, "calling '%s' on bad self (%s)", ar.Name, extraMsg)
+		}
+	}
+	if ar.Name == "" {
+		if pushGlobalFuncName(L, &ar) {
+			ar.Name = L.ToString(-1)
+		} else {
+			ar.Name = "?"
+		}
+	}
+	return Error(L, "bad argument #%d to '%s' (%s)", arg, ar.Name, extraMsg)
+}
+
+// 抛出"bad argument #arg to 'funcname' (tname expected, got xxx)"形式的错误
+// lua-5.3.4/src/lauxlib.c#luaL_typeerror()
+func TypeError(L LuaState, arg int, tname string) int {
+	var typeArg string /* name for the type of the actual argument */
+	if GetMetafield(L, arg, "__name") == LUA_TSTRING {
+		typeArg = L.ToString(-1) /* use the given type name */
+	} else if L.IsLightUserdata(arg) {
+		typeArg = "light userdata" /* special name for messages */
+	} else {
+		typeArg = L.TypeName(L.Type(arg)) /* standard name */
+	}
+	msg := fmt.Sprintf("%s expected, got %s", tname, typeArg)
+	return ArgError(L, arg, msg)
+}
+
+func tagError(L LuaState, arg int, tag LuaType) {
+	TypeError(L, arg, L.TypeName(tag))
+}
+
+/* 参数检查 */
+
+// cond为假时抛出ArgError
+func ArgCheck(L LuaState, cond bool, arg int, extraMsg string) {
+	if !cond {
+		ArgError(L, arg, extraMsg)
+	}
+}
+
+// 确保栈里还能放下sz个值，msg会出现在出错信息里
+// lua-5.3.4/src/lauxlib.c#luaL_checkstack()
+func CheckStack(L LuaState, sz int, msg string) {
+	if !L.CheckStack(sz) {
+		if msg != "" {
+			Error(L, "stack overflow (%s)", msg)
+		} else {
+			Error(L, "stack overflow")
+		}
+	}
+}
+
+// lua-5.3.4/src/lauxlib.c#luaL_checkany()
+func CheckAny(L LuaState, arg int) {
+	if L.Type(arg) == LUA_TNONE {
+		ArgError(L, arg, "value expected")
+	}
+}
+
+// lua-5.3.4/src/lauxlib.c#luaL_checktype()
+func CheckType(L LuaState, arg int, t LuaType) {
+	if L.Type(arg) != t {
+		tagError(L, arg, t)
+	}
+}
+
+func CheckTable(L LuaState, arg int) {
+	CheckType(L, arg, LUA_TTABLE)
+}
+
+// lua-5.3.4/src/lauxlib.c#luaL_checkinteger()
+func CheckInteger(L LuaState, arg int) int64 {
+	i, ok := L.ToIntegerX(arg)
+	if !ok {
+		if L.IsNumber(arg) {
+			ArgError(L, arg, "number has no integer representation")
+		} else {
+			tagError(L, arg, LUA_TNUMBER)
+		}
+	}
+	return i
+}
+
+// lua-5.3.4/src/lauxlib.c#luaL_checknumber()
+func CheckNumber(L LuaState, arg int) float64 {
+	f, ok := L.ToNumberX(arg)
+	if !ok {
+		tagError(L, arg, LUA_TNUMBER)
+	}
+	return f
+}
+
+// lua-5.3.4/src/lauxlib.c#luaL_checklstring()
+func CheckString(L LuaState, arg int) string {
+	s, ok := L.ToStringX(arg)
+	if !ok {
+		tagError(L, arg, LUA_TSTRING)
+	}
+	return s
+}
+
+// lua-5.3.4/src/lauxlib.c#luaL_optinteger()
+func OptInteger(L LuaState, arg int, def int64) int64 {
+	if L.IsNoneOrNil(arg) {
+		return def
+	}
+	return CheckInteger(L, arg)
+}
+
+// lua-5.3.4/src/lauxlib.c#luaL_optnumber()
+func OptNumber(L LuaState, arg int, def float64) float64 {
+	if L.IsNoneOrNil(arg) {
+		return def
+	}
+	return CheckNumber(L, arg)
+}
+
+// lua-5.3.4/src/lauxlib.c#luaL_optlstring()
+func OptString(L LuaState, arg int, def string) string {
+	if L.IsNoneOrNil(arg) {
+		return def
+	}
+	return CheckString(L, arg)
+}
+
+// 检查arg处的字符串是不是lst里的选项之一，返回它在lst里的索引
+// lua-5.3.4/src/lauxlib.c#luaL_checkoption()
+func CheckOption(L LuaState, arg int, def string, lst []string) int {
+	var name string
+	if def != "" {
+		name = OptString(L, arg, def)
+	} else {
+		name = CheckString(L, arg)
+	}
+	for i, opt := range lst {
+		if opt == name {
+			return i
+		}
+	}
+	return ArgError(L, arg, fmt.Sprintf("invalid option '%s'", name))
+}
+
+/* 库 */
+
+// 创建一个新表，把funcs里的函数注册进去
+// lua-5.3.4/src/lauxlib.h#luaL_newlib()
+func NewLib(L LuaState, funcs FuncReg) {
+	L.CreateTable(0, len(funcs))
+	SetFuncs(L, funcs, 0)
+}
+
+// 把funcs里的函数注册到栈顶下面的表里，栈顶的nup个值是所有函数共享的上值，
+// 注册完毕后会被弹出。值为nil的函数是占位符，字段会被设置为false
+// lua-5.3.4/src/lauxlib.c#luaL_setfuncs()
+func SetFuncs(L LuaState, funcs FuncReg, nup int) {
+	CheckStack(L, nup, "too many upvalues")
+	for name, f := range funcs { /* fill the table with given functions */
+		if f == nil {
+			L.PushBoolean(false) /* placeholder */
+		} else {
+			for i := 0; i < nup; i++ { /* copy upvalues to the top */
+				L.PushValue(-nup)
+			}
+			L.PushGoClosure(f, nup) /* closure with those upvalues */
+		}
+		L.SetField(-(nup + 2), name)
+	}
+	L.Pop(nup) /* remove upvalues */
+}
+
+// 如果t[fname]是表，把它压入栈顶，否则创建一个新表放进t[fname]并压入栈顶
+// lua-5.3.4/src/lauxlib.c#luaL_getsubtable()
+func GetSubTable(L LuaState, idx int, fname string) bool {
+	if L.GetField(idx, fname) == LUA_TTABLE {
+		return true /* table already there */
+	}
+	L.Pop(1) /* remove previous result */
+	idx = L.AbsIndex(idx)
+	L.NewTable()
+	L.PushValue(-1)        /* copy to be left at top */
+	L.SetField(idx, fname) /* assign new table to field */
+	return false           /* false, because did not find table there */
+}
+
+// 如果package.loaded[modname]为假，以modname为参数调用openf，把结果放进package.loaded[modname]，
+// glb为true时还会把结果赋给同名的全局变量。模块的一份拷贝会留在栈顶
+// lua-5.3.4/src/lauxlib.c#luaL_requiref()
+func RequireF(L LuaState, modname string, openf GoFunction, glb bool) {
+	GetSubTable(L, LUA_REGISTRYINDEX, LUA_LOADED_TABLE)
+	L.GetField(-1, modname) /* LOADED[modname] */
+	if !L.ToBoolean(-1) {   /* package not already loaded? */
+		L.Pop(1) /* remove field */
+		L.PushGoFunction(openf)
+		L.PushString(modname)   /* argument to open function */
+		L.Call(1, 1)            /* call 'openf' to open module */
+		L.PushValue(-1)         /* make copy of module (call result) */
+		L.SetField(-3, modname) /* LOADED[modname] = module */
+	}
+	L.Remove(-2) /* remove LOADED table */
+	if glb {
+		L.PushValue(-1)      /* copy of module */
+		L.SetGlobal(modname) /* _G[modname] = module */
+	}
+}
+
+// 返回idx处的值的长度（会调用__len元方法），长度必须是整数
+// lua-5.3.4/src/lauxlib.c#luaL_len()
+func Len(L LuaState, idx int) int64 {
+	L.Len(idx)
+	l, isNum := L.ToIntegerX(-1)
+	if !isNum {
+		Error(L, "object length is not an integer")
+	}
+	L.Pop(1) /* remove object */
+	return l
+}
@@ -0,0 +1,157 @@
+package auxlib
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	. "luago/api"
+	"os"
+	"syscall"
+)
+
+/*
+ * io库、os库和LoadFile的所有文件访问都经过FileSystem接口，
+ * OpenLibs()默认设置操作系统的文件系统，可以用SetFileSystem()换成别的实现（比如内存文件系统），
+ * 这样就可以让不受信任的脚本在虚拟的文件系统上运行。
+ * 文件系统保存在注册表里，脚本可以通过debug.getregistry()删掉它，
+ * 所以找不到文件系统时所有的文件访问都会失败，而不是退回到操作系统的文件系统
+ */
+
+// 打开的文件，只读文件的Write和不支持随机访问的文件的Seek返回错误即可
+type File interface {
+	io.Reader
+	io.Writer
+	io.Seeker
+	io.Closer
+}
+
+type FileSystem interface {
+	// 和os.OpenFile()一样，flag是os.O_RDONLY、os.O_CREATE等标志的组合
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Remove(name string) error
+	Rename(oldpath, newpath string) error
+	// 创建一个新的空的临时文件，返回它的名字（os.tmpname）
+	TempName() (string, error)
+}
+
+// 默认的文件系统，直接调用os包
+type OSFileSystem struct{}
+
+func (OSFileSystem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err // 避免返回值为nil的*os.File
+	}
+	return f, nil
+}
+
+func (OSFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (OSFileSystem) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (OSFileSystem) TempName() (string, error) {
+	f, err := ioutil.TempFile("", "lua_")
+	if err != nil {
+		return "", err
+	}
+	name := f.Name()
+	return name, f.Close()
+}
+
+const _FS_KEY = "_FS" /* registry key for the file system */
+
+// 设置L使用的文件系统，和L共享注册表的所有线程都会使用它
+func SetFileSystem(L LuaState, fs FileSystem) {
+	L.PushLightUserdata(fs)
+	L.SetField(LUA_REGISTRYINDEX, _FS_KEY)
+}
+
+// 返回L使用的文件系统，没有设置过（或者被删掉了）时返回的文件系统拒绝所有的访问
+func GetFileSystem(L LuaState) FileSystem {
+	L.GetField(LUA_REGISTRYINDEX, _FS_KEY)
+	fs, ok := L.ToUserdata(-1).(FileSystem)
+	L.Pop(1)
+	if !ok {
+		return noFileSystem{}
+	}
+	return fs
+}
+
+// L是否已经设置了文件系统
+func HasFileSystem(L LuaState) bool {
+	L.GetField(LUA_REGISTRYINDEX, _FS_KEY)
+	_, ok := L.ToUserdata(-1).(FileSystem)
+	L.Pop(1)
+	return ok
+}
+
+// 没有文件系统可用
+type noFileSystem struct{}
+
+func (noFileSystem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EACCES}
+}
+
+func (noFileSystem) Remove(name string) error {
+	return &os.PathError{Op: "remove", Path: name, Err: syscall.EACCES}
+}
+
+func (noFileSystem) Rename(oldpath, newpath string) error {
+	return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EACCES}
+}
+
+func (noFileSystem) TempName() (string, error) {
+	return "", syscall.EACCES
+}
+
+// 通过文件系统读取整个文件
+func ReadFile(L LuaState, name string) ([]byte, error) {
+	f, err := GetFileSystem(L).OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// 成功时压入true，失败时压入nil、错误消息和错误码
+// lua-5.3.4/src/lauxlib.c#luaL_fileresult()
+func FileResult(L LuaState, err error, fname string) int {
+	if err == nil {
+		L.PushBoolean(true) /* file handle already on stack top */
+		return 1
+	}
+	msg, errno := StrError(err)
+	L.PushNil()
+	if fname != "" {
+		L.PushString(fmt.Sprintf("%s: %s", fname, msg))
+	} else {
+		L.PushString(msg)
+	}
+	L.PushInteger(int64(errno))
+	return 3
+}
+
+// 模仿C的strerror()，*os.PathError等错误里已经包含了文件名，去掉它
+func StrError(err error) (string, int) {
+	switch x := err.(type) {
+	case *os.PathError:
+		err = x.Err
+	case *os.LinkError:
+		err = x.Err
+	case *os.SyscallError:
+		err = x.Err
+	}
+	if errno, ok := err.(syscall.Errno); ok {
+		msg := errno.Error()
+		if len(msg) > 0 && msg[0] >= 'a' && msg[0] <= 'z' {
+			msg = string(msg[0]-('a'-'A')) + msg[1:]
+		}
+		return msg, int(errno)
+	}
+	return err.Error(), 0
+}
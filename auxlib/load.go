@@ -0,0 +1,85 @@
+package auxlib
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	. "luago/api"
+	"os"
+)
+
+/* 加载和运行代码块 */
+
+// 加载内存中的代码块，chunkName和mode的含义和LuaState.Load()一样
+// lua-5.3.4/src/lauxlib.c#luaL_loadbufferx()
+func LoadBufferX(L LuaState, buff []byte, chunkName, mode string) int {
+	return L.Load(buff, chunkName, mode)
+}
+
+// lua-5.3.4/src/lauxlib.h#luaL_loadbuffer()
+func LoadBuffer(L LuaState, buff []byte, chunkName string) int {
+	return LoadBufferX(L, buff, chunkName, "")
+}
+
+// 加载字符串里的代码块，字符串本身就是代码块的名字
+// lua-5.3.4/src/lauxlib.c#luaL_loadstring()
+func LoadString(L LuaState, s string) int {
+	return LoadBuffer(L, []byte(s), s)
+}
+
+// 加载文件（filename为空时读取标准输入）里的代码块，
+// 跳过开头的UTF-8 BOM和以'#'开头的第一行（保留换行符以免行号错位）。
+// 文件通过L的FileSystem读取，打不开时返回LUA_ERRFILE
+// lua-5.3.4/src/lauxlib.c#luaL_loadfilex()
+func LoadFileX(L LuaState, filename, mode string) int {
+	var data []byte
+	var err error
+	var chunkname string
+	if filename == "" {
+		data, err = ioutil.ReadAll(os.Stdin)
+		chunkname = "=stdin"
+		filename = "stdin"
+	} else {
+		data, err = ReadFile(L, filename)
+		chunkname = "@" + filename
+	}
+	if err != nil {
+		msg, _ := StrError(err)
+		L.PushString(fmt.Sprintf("cannot open %s: %s", filename, msg))
+		return LUA_ERRFILE
+	}
+
+	data = bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF")) /* skip BOM */
+	/* first line is a comment (Unix exec. file)? */
+	if len(data) > 0 && data[0] == '#' {
+		if idx := bytes.IndexByte(data, '\n'); idx >= 0 {
+			data = data[idx:] /* keep the newline to correct line numbers */
+		} else {
+			data = nil
+		}
+	}
+	return L.Load(data, chunkname, mode)
+}
+
+// lua-5.3.4/src/lauxlib.h#luaL_loadfile()
+func LoadFile(L LuaState, filename string) int {
+	return LoadFileX(L, filename, "")
+}
+
+// 加载并运行字符串里的代码块，返回LUA_OK或者错误码（错误消息留在栈顶）
+// lua-5.3.4/src/lauxlib.h#luaL_dostring()
+func DoString(L LuaState, str string) int {
+	if status := LoadString(L, str); status != LUA_OK {
+		return status
+	}
+	return L.PCall(0, LUA_MULTRET, 0)
+}
+
+// 加载并运行文件里的代码块，返回LUA_OK或者错误码（错误消息留在栈顶）
+// lua-5.3.4/src/lauxlib.h#luaL_dofile()
+func DoFile(L LuaState, filename string) int {
+	if status := LoadFile(L, filename); status != LUA_OK {
+		return status
+	}
+	return L.PCall(0, LUA_MULTRET, 0)
+}
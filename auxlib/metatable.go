@@ -0,0 +1,129 @@
+package auxlib
+
+import (
+	"fmt"
+	. "luago/api"
+)
+
+/* 元表 */
+
+// 如果obj的元表里有event字段，把它压入栈顶并返回它的类型，
+// 否则什么也不压入，返回LUA_TNIL
+// lua-5.3.4/src/lauxlib.c#luaL_getmetafield()
+func GetMetafield(L LuaState, obj int, event string) LuaType {
+	if !L.GetMetatable(obj) { /* no metatable? */
+		return LUA_TNIL
+	}
+	L.PushString(event)
+	tt := L.RawGet(-2)
+	if tt == LUA_TNIL { /* is metafield nil? */
+		L.Pop(2) /* remove metatable and metafield */
+	} else {
+		L.Remove(-2) /* remove only metatable */
+	}
+	return tt /* return metafield type */
+}
+
+// 如果obj有元方法event，以obj为参数调用它，把结果压入栈顶并返回true
+// lua-5.3.4/src/lauxlib.c#luaL_callmeta()
+func CallMeta(L LuaState, obj int, event string) bool {
+	obj = L.AbsIndex(obj)
+	if GetMetafield(L, obj, event) == LUA_TNIL { /* no metafield? */
+		return false
+	}
+	L.PushValue(obj)
+	L.Call(1, 1)
+	return true
+}
+
+// 在注册表里创建名为tname的元表（已经存在则返回false），并把它压入栈顶
+// lua-5.3.4/src/lauxlib.c#luaL_newmetatable()
+func NewMetatable(L LuaState, tname string) bool {
+	if GetMetatableByName(L, tname) != LUA_TNIL { /* name already in use? */
+		return false /* leave previous value on top, but return false */
+	}
+	L.Pop(1)
+	L.CreateTable(0, 2) /* create metatable */
+	L.PushString(tname)
+	L.SetField(-2, "__name") /* metatable.__name = tname */
+	L.PushValue(-1)
+	L.SetField(LUA_REGISTRYINDEX, tname) /* registry.name = metatable */
+	return true
+}
+
+// 把注册表里名为tname的元表压入栈顶
+// lua-5.3.4/src/lauxlib.h#luaL_getmetatable()
+func GetMetatableByName(L LuaState, tname string) LuaType {
+	return L.GetField(LUA_REGISTRYINDEX, tname)
+}
+
+// 把注册表里名为tname的元表设置为栈顶对象的元表
+// lua-5.3.4/src/lauxlib.c#luaL_setmetatable()
+func SetMetatableByName(L LuaState, tname string) {
+	GetMetatableByName(L, tname)
+	L.SetMetatable(-2)
+}
+
+// 如果arg处是元表为tname的完全用户数据，返回它包装的Go值，否则返回nil
+// lua-5.3.4/src/lauxlib.c#luaL_testudata()
+func TestUdata(L LuaState, arg int, tname string) interface{} {
+	if !L.IsUserdata(arg) || L.IsLightUserdata(arg) {
+		return nil /* value is not a userdata */
+	}
+	if !L.GetMetatable(arg) { /* does it have a metatable? */
+		return nil
+	}
+	GetMetatableByName(L, tname) /* get correct metatable */
+	ok := L.RawEqual(-1, -2)     /* the same? */
+	L.Pop(2)                     /* remove both metatables */
+	if !ok {
+		return nil
+	}
+	return L.ToUserdata(arg)
+}
+
+// 和TestUdata一样，但是不满足条件时抛出错误
+// lua-5.3.4/src/lauxlib.c#luaL_checkudata()
+func CheckUdata(L LuaState, arg int, tname string) interface{} {
+	p := TestUdata(L, arg, tname)
+	if p == nil {
+		TypeError(L, arg, tname)
+	}
+	return p
+}
+
+// 把任意Lua值按照tostring()的规则转换成字符串，结果同时压入栈顶
+// lua-5.3.4/src/lauxlib.c#luaL_tolstring()
+func ToStringMeta(L LuaState, idx int) string {
+	if CallMeta(L, idx, "__tostring") { /* metafield? */
+		if !L.IsString(-1) {
+			Error(L, "'__tostring' must return a string")
+		}
+	} else {
+		switch L.Type(idx) {
+		case LUA_TNUMBER, LUA_TSTRING:
+			L.PushValue(idx)
+		case LUA_TBOOLEAN:
+			if L.ToBoolean(idx) {
+				L.PushString("true")
+			} else {
+				L.PushString("false")
+			}
+		case LUA_TNIL:
+			L.PushString("nil")
+		default:
+			tt := GetMetafield(L, idx, "__name") /* try name */
+			var kind string
+			if tt == LUA_TSTRING {
+				kind = L.ToString(-1)
+			} else {
+				kind = L.TypeName(L.Type(idx))
+			}
+			if tt != LUA_TNIL {
+				L.Pop(1) /* remove '__name' */
+			}
+			L.PushString(fmt.Sprintf("%s: %p", kind, L.ToPointer(idx)))
+		}
+	}
+	return L.ToString(-1)
+}
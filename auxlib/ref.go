@@ -0,0 +1,42 @@
+package auxlib
+
+import . "luago/api"
+
+/* 引用 */
+
+/* index of free-list header */
+const freelist = 0
+
+// 把栈顶的值弹出，放进表t里，返回一个唯一的整数键（引用）。
+// 栈顶是nil时返回LUA_REFNIL。释放了的引用会被重用
+// lua-5.3.4/src/lauxlib.c#luaL_ref()
+func Ref(L LuaState, t int) int {
+	if L.IsNil(-1) {
+		L.Pop(1)          /* remove it from stack */
+		return LUA_REFNIL /* 'nil' has a unique fixed reference */
+	}
+	t = L.AbsIndex(t)
+	L.RawGetI(t, freelist)      /* get first free element */
+	ref := int(L.ToInteger(-1)) /* ref = t[freelist] */
+	L.Pop(1)                    /* remove it from stack */
+	if ref != 0 {               /* any free element? */
+		L.RawGetI(t, int64(ref)) /* remove it from list */
+		L.RawSetI(t, freelist)   /* (t[freelist] = t[ref]) */
+	} else { /* no free elements */
+		ref = int(L.RawLen(t)) + 1 /* get a new reference */
+	}
+	L.RawSetI(t, int64(ref))
+	return ref
+}
+
+// 释放引用ref，对应的值可以被回收，ref可以被再次使用
+// lua-5.3.4/src/lauxlib.c#luaL_unref()
+func Unref(L LuaState, t, ref int) {
+	if ref >= 0 {
+		t = L.AbsIndex(t)
+		L.RawGetI(t, freelist)
+		L.RawSetI(t, int64(ref)) /* t[ref] = t[freelist] */
+		L.PushInteger(int64(ref))
+		L.RawSetI(t, freelist) /* t[freelist] = ref */
+	}
+}
@@ -0,0 +1,155 @@
+package auxlib
+
+import (
+	"fmt"
+	. "luago/api"
+	"strings"
+)
+
+/* 调用栈信息 */
+
+const (
+	_LEVELS1 = 10 /* size of the first part of the stack */
+	_LEVELS2 = 11 /* size of the second part of the stack */
+)
+
+// 把"chunkname:currentline: "形式的当前位置压入栈顶并返回，
+// level为0表示正在运行的函数，1表示调用它的函数，依此类推。
+// 拿不到位置信息（比如level处是Go函数）时压入空串
+// lua-5.3.4/src/lauxlib.c#luaL_where()
+func Where(L LuaState, level int) string {
+	var ar LuaDebug
+	if L.GetStack(level, &ar) { /* check function at level */
+		L.GetInfo("Sl", &ar)    /* get info about it */
+		if ar.CurrentLine > 0 { /* is there info? */
+			where := fmt.Sprintf("%s:%d: ", ar.ShortSrc, ar.CurrentLine)
+			L.PushString(where)
+			return where
+		}
+	}
+	L.PushString("") /* else, no information available... */
+	return ""
+}
+
+// 在package.loaded里（最多两层）查找栈顶的值，找到时把它的名字（比如"string.format"）压入栈顶
+// lua-5.3.4/src/lauxlib.c#findfield()
+func findField(L LuaState, objidx, level int) bool {
+	if level == 0 || !L.IsTable(-1) {
+		return false /* not found */
+	}
+	L.PushNil()      /* start 'next' loop */
+	for L.Next(-2) { /* for each pair in table */
+		if L.Type(-2) == LUA_TSTRING { /* ignore non-string keys */
+			if L.RawEqual(objidx, -1) { /* found object? */
+				L.Pop(1) /* remove value (but keep name) */
+				return true
+			} else if findField(L, objidx, level-1) { /* try recursively */
+				L.Remove(-2) /* remove table (but keep name) */
+				L.PushString(".")
+				L.Insert(-2) /* place '.' between the two names */
+				L.Concat(3)
+				return true
+			}
+		}
+		L.Pop(1) /* remove value */
+	}
+	return false /* not found */
+}
+
+// 在已经加载的模块里查找ar对应的函数，找到时把它的全名压入栈顶并返回true
+// lua-5.3.4/src/lauxlib.c#pushglobalfuncname()
+func pushGlobalFuncName(L LuaState, ar *LuaDebug) bool {
+	top := L.GetTop()
+	L.GetInfo("f", ar) /* push function */
+	L.GetField(LUA_REGISTRYINDEX, LUA_LOADED_TABLE)
+	if findField(L, top+1, 2) {
+		name := L.ToString(-1)
+		if strings.HasPrefix(name, "_G.") { /* name start with '_G.'? */
+			L.PushString(name[3:]) /* push name without prefix */
+			L.Remove(-2)           /* remove original name */
+		}
+		L.Copy(-1, top+1) /* move name to proper place */
+		L.Pop(2)          /* remove pushed values */
+		return true
+	}
+	L.SetTop(top) /* remove function and global table */
+	return false
+}
+
+// lua-5.3.4/src/lauxlib.c#pushfuncname()
+func pushFuncName(L LuaState, ar *LuaDebug) {
+	if pushGlobalFuncName(L, ar) { /* try first a global name */
+		L.PushString(fmt.Sprintf("function '%s'", L.ToString(-1)))
+		L.Remove(-2) /* remove name */
+	} else if ar.NameWhat != "" { /* is there a name from code? */
+		L.PushString(fmt.Sprintf("%s '%s'", ar.NameWhat, ar.Name)) /* use it */
+	} else if ar.What == "main" { /* main? */
+		L.PushString("main chunk")
+	} else if ar.What != "Go" { /* for Lua functions, use <file:line> */
+		L.PushString(fmt.Sprintf("function <%s:%d>", ar.ShortSrc, ar.LineDefined))
+	} else { /* nothing left... */
+		L.PushString("?")
+	}
+}
+
+// 调用栈的层数
+// lua-5.3.4/src/lauxlib.c#lastlevel()
+func lastLevel(L LuaState) int {
+	var ar LuaDebug
+	li, le := 1, 1
+	/* find an upper bound */
+	for L.GetStack(le, &ar) {
+		li = le
+		le *= 2
+	}
+	/* do a binary search */
+	for li < le {
+		m := (li + le) / 2
+		if L.GetStack(m, &ar) {
+			li = m + 1
+		} else {
+			le = m
+		}
+	}
+	return le - 1
+}
+
+// 把线程L1从level层开始的调用栈回溯信息压入L的栈顶并返回，msg不为空时放在回溯信息前面。
+// 调用栈太深时只显示开头和结尾的若干层
+// lua-5.3.4/src/lauxlib.c#luaL_traceback()
+func Traceback(L, L1 LuaState, msg string, level int) string {
+	var ar LuaDebug
+	top := L.GetTop()
+	last := lastLevel(L1)
+	n1 := -1
+	if last-level > _LEVELS1+_LEVELS2 {
+		n1 = _LEVELS1
+	}
+	if msg != "" {
+		L.PushString(msg + "\n")
+	}
+	CheckStack(L, 10, "")
+	L.PushString("stack traceback:")
+	for L1.GetStack(level, &ar) {
+		level++
+		if n1 == 0 { /* too many levels? */
+			L.PushString("\n\t...")     /* add a '...' */
+			level = last - _LEVELS2 + 1 /* and skip to last ones */
+		} else {
+			L1.GetInfo("Slnt", &ar)
+			L.PushString(fmt.Sprintf("\n\t%s:", ar.ShortSrc))
+			if ar.CurrentLine > 0 {
+				L.PushString(fmt.Sprintf("%d:", ar.CurrentLine))
+			}
+			L.PushString(" in ")
+			pushFuncName(L, &ar)
+			if ar.IsTailCall {
+				L.PushString("\n\t(...tail calls...)")
+			}
+			L.Concat(L.GetTop() - top)
+		}
+		n1--
+	}
+	L.Concat(L.GetTop() - top)
+	return L.ToString(-1)
+}
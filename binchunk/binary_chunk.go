@@ -71,9 +71,37 @@ type LocVar struct {
 	EndPC   uint32
 }
 
-func Undump(data []byte) *Prototype {
+// 二进制chunk以LUA_SIGNATURE开头，据此和Lua源代码区分开
+func IsBinaryChunk(data []byte) bool {
+	return len(data) >= len(LUA_SIGNATURE) &&
+		string(data[:len(LUA_SIGNATURE)]) == LUA_SIGNATURE
+}
+
+// 解析二进制chunk，数据不合法时返回错误而不是panic：
+// 头部不匹配时返回ErrSignature、ErrVersion等错误或者*SizeMismatchError，
+// 数据不完整时返回ErrTruncated，遇到未知的常量类型时返回*ConstantTagError
+func Undump(data []byte) (proto *Prototype, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(undumpError); ok {
+				proto, err = nil, e.err
+			} else {
+				panic(r)
+			}
+		}
+	}()
+
 	reader := &reader{data}
 	reader.checkHeader()
 	reader.readByte() // size_upvalues
-	return reader.readProto("")
+	return reader.readProto(""), nil
+}
+
+// 把函数原型序列化成和luac 5.3兼容的二进制chunk，strip为true时去掉调试信息
+func Dump(proto *Prototype, strip bool) []byte {
+	writer := &writer{strip: strip}
+	writer.writeHeader()
+	writer.writeByte(byte(len(proto.Upvalues))) // size_upvalues
+	writer.writeProto(proto, "")
+	return writer.data
 }
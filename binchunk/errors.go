@@ -0,0 +1,41 @@
+package binchunk
+
+import "errors"
+import "fmt"
+
+// Undump返回的错误，消息和Lua官方实现（lundump.c）保持一致
+var (
+	ErrSignature   = errors.New("not a precompiled chunk")
+	ErrVersion     = errors.New("version mismatch in precompiled chunk")
+	ErrFormat      = errors.New("format mismatch in precompiled chunk")
+	ErrCorrupted   = errors.New("corrupted precompiled chunk")
+	ErrTruncated   = errors.New("truncated precompiled chunk")
+	ErrEndianness  = errors.New("endianness mismatch in precompiled chunk")
+	ErrFloatFormat = errors.New("float format mismatch in precompiled chunk")
+)
+
+// 头部记录的某种数据类型的大小和本实现不一致
+type SizeMismatchError struct {
+	Type     string // int、size_t、Instruction、lua_Integer或lua_Number
+	Size     byte
+	Expected byte
+}
+
+func (e *SizeMismatchError) Error() string {
+	return fmt.Sprintf("%s size mismatch in precompiled chunk (%d, expected %d)",
+		e.Type, e.Size, e.Expected)
+}
+
+// 常量表里出现了未知的类型标签
+type ConstantTagError struct {
+	Tag byte
+}
+
+func (e *ConstantTagError) Error() string {
+	return fmt.Sprintf("bad constant tag 0x%02x in precompiled chunk", e.Tag)
+}
+
+// reader内部用panic传递错误，由Undump统一恢复，这样可以和Go运行时错误区分开
+type undumpError struct {
+	err error
+}
@@ -7,28 +7,40 @@ type reader struct {
 	data []byte
 }
 
+func (R *reader) error(err error) {
+	panic(undumpError{err})
+}
+
 func (R *reader) readByte() byte {
-	b := R.data[0]
-	R.data = R.data[1:]
-	return b
+	return R.readBytes(1)[0]
 }
 
+// 所有的读取操作最终都经过这里做边界检查
 func (R *reader) readBytes(n uint) []byte {
+	if n > uint(len(R.data)) {
+		R.error(ErrTruncated)
+	}
 	bytes := R.data[:n]
 	R.data = R.data[n:]
 	return bytes
 }
 
 func (R *reader) readUint32() uint32 {
-	i := binary.LittleEndian.Uint32(R.data)
-	R.data = R.data[4:]
-	return i
+	return binary.LittleEndian.Uint32(R.readBytes(4))
 }
 
 func (R *reader) readUint64() uint64 {
-	i := binary.LittleEndian.Uint64(R.data)
-	R.data = R.data[8:]
-	return i
+	return binary.LittleEndian.Uint64(R.readBytes(8))
+}
+
+// 读取数组长度，每个元素至少占elemSize个字节，
+// 长度超过剩余数据能容纳的元素个数说明数据被截断了，避免按错误的长度分配内存
+func (R *reader) readCount(elemSize uint) int {
+	n := uint(R.readUint32())
+	if n > uint(len(R.data))/elemSize {
+		R.error(ErrTruncated)
+	}
+	return int(n)
 }
 
 func (R *reader) readLuaInteger() int64 {
@@ -45,45 +57,46 @@ func (R *reader) readString() string {
 		return ""
 	}
 	if size == 0xFF {
-		size = uint(R.readUint64()) // size_t
+		size64 := R.readUint64() // size_t
+		if size64 == 0 || size64-1 > uint64(len(R.data)) {
+			R.error(ErrTruncated)
+		}
+		size = uint(size64)
 	}
 	bytes := R.readBytes(size - 1)
-	return string(bytes) // todo
+	return string(bytes)
 }
 
 func (R *reader) checkHeader() {
-	if string(R.readBytes(4)) != LUA_SIGNATURE {
-		panic("not a precompiled chunk!")
+	if len(R.data) < len(LUA_SIGNATURE) ||
+		string(R.readBytes(4)) != LUA_SIGNATURE {
+		R.error(ErrSignature)
 	}
 	if R.readByte() != LUAC_VERSION {
-		panic("version mismatch!")
+		R.error(ErrVersion)
 	}
 	if R.readByte() != LUAC_FORMAT {
-		panic("format mismatch!")
+		R.error(ErrFormat)
 	}
 	if string(R.readBytes(6)) != LUAC_DATA {
-		panic("corrupted!")
-	}
-	if R.readByte() != CINT_SIZE {
-		panic("int size mismatch!")
-	}
-	if R.readByte() != CSIZET_SIZE {
-		panic("size_t size mismatch!")
-	}
-	if R.readByte() != INSTRUCTION_SIZE {
-		panic("instruction size mismatch!")
-	}
-	if R.readByte() != LUA_INTEGER_SIZE {
-		panic("lua_Integer size mismatch!")
-	}
-	if R.readByte() != LUA_NUMBER_SIZE {
-		panic("lua_Number size mismatch!")
+		R.error(ErrCorrupted)
 	}
+	R.checkSize("int", CINT_SIZE)
+	R.checkSize("size_t", CSIZET_SIZE)
+	R.checkSize("Instruction", INSTRUCTION_SIZE)
+	R.checkSize("lua_Integer", LUA_INTEGER_SIZE)
+	R.checkSize("lua_Number", LUA_NUMBER_SIZE)
 	if R.readLuaInteger() != LUAC_INT {
-		panic("endianness mismatch!")
+		R.error(ErrEndianness)
 	}
 	if R.readLuaNumber() != LUAC_NUM {
-		panic("float format mismatch!")
+		R.error(ErrFloatFormat)
+	}
+}
+
+func (R *reader) checkSize(typ string, expected byte) {
+	if size := R.readByte(); size != expected {
+		R.error(&SizeMismatchError{Type: typ, Size: size, Expected: expected})
 	}
 }
 
@@ -110,7 +123,7 @@ func (R *reader) readProto(parentSource string) *Prototype {
 }
 
 func (R *reader) readCode() []uint32 {
-	code := make([]uint32, R.readUint32())
+	code := make([]uint32, R.readCount(4))
 	for i := range code {
 		code[i] = R.readUint32()
 	}
@@ -118,7 +131,7 @@ func (R *reader) readCode() []uint32 {
 }
 
 func (R *reader) readConstants() []interface{} {
-	constants := make([]interface{}, R.readUint32())
+	constants := make([]interface{}, R.readCount(1))
 	for i := range constants {
 		constants[i] = R.readConstant()
 	}
@@ -126,7 +139,7 @@ func (R *reader) readConstants() []interface{} {
 }
 
 func (R *reader) readConstant() interface{} {
-	switch R.readByte() {
+	switch tag := R.readByte(); tag {
 	case TAG_NIL:
 		return nil
 	case TAG_BOOLEAN:
@@ -138,12 +151,13 @@ func (R *reader) readConstant() interface{} {
 	case TAG_SHORT_STR, TAG_LONG_STR:
 		return R.readString()
 	default:
-		panic("corrupted!") // todo
+		R.error(&ConstantTagError{tag})
+		return nil
 	}
 }
 
 func (R *reader) readUpvalues() []Upvalue {
-	upvalues := make([]Upvalue, R.readUint32())
+	upvalues := make([]Upvalue, R.readCount(2))
 	for i := range upvalues {
 		upvalues[i] = Upvalue{
 			Instack: R.readByte(),
@@ -154,7 +168,7 @@ func (R *reader) readUpvalues() []Upvalue {
 }
 
 func (R *reader) readProtos(parentSource string) []*Prototype {
-	protos := make([]*Prototype, R.readUint32())
+	protos := make([]*Prototype, R.readCount(1))
 	for i := range protos {
 		protos[i] = R.readProto(parentSource)
 	}
@@ -162,7 +176,7 @@ func (R *reader) readProtos(parentSource string) []*Prototype {
 }
 
 func (R *reader) readLineInfo() []uint32 {
-	lineInfo := make([]uint32, R.readUint32())
+	lineInfo := make([]uint32, R.readCount(4))
 	for i := range lineInfo {
 		lineInfo[i] = R.readUint32()
 	}
@@ -170,7 +184,7 @@ func (R *reader) readLineInfo() []uint32 {
 }
 
 func (R *reader) readLocVars() []LocVar {
-	locVars := make([]LocVar, R.readUint32())
+	locVars := make([]LocVar, R.readCount(9))
 	for i := range locVars {
 		locVars[i] = LocVar{
 			VarName: R.readString(),
@@ -182,7 +196,7 @@ func (R *reader) readLocVars() []LocVar {
 }
 
 func (R *reader) readUpvalueNames() []string {
-	names := make([]string, R.readUint32())
+	names := make([]string, R.readCount(1))
 	for i := range names {
 		names[i] = R.readString()
 	}
@@ -0,0 +1,154 @@
+package binchunk
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// 手工构造的函数原型，覆盖所有的常量类型、子函数和调试信息。
+// Undump把空数组读成长度为0的切片，这里也用空切片，方便直接比较
+func testProto() *Prototype {
+	return &Prototype{
+		Source:          "@test.lua",
+		LastLineDefined: 0,
+		IsVararg:        1,
+		MaxStackSize:    2,
+		Code:            []uint32{0x00000001, 0x00000041, 0x00800026},
+		Constants: []interface{}{
+			nil, true, int64(42), 3.5, "abc",
+			string(bytes.Repeat([]byte{'x'}, 300)), // 长字符串
+		},
+		Upvalues: []Upvalue{{Instack: 1, Idx: 0}},
+		Protos: []*Prototype{{
+			Source:          "@test.lua",
+			LineDefined:     1,
+			LastLineDefined: 2,
+			NumParams:       1,
+			MaxStackSize:    2,
+			Code:            []uint32{0x00800026},
+			Constants:       []interface{}{},
+			Upvalues:        []Upvalue{},
+			Protos:          []*Prototype{},
+			LineInfo:        []uint32{2},
+			LocVars:         []LocVar{{VarName: "x", StartPC: 0, EndPC: 1}},
+			UpvalueNames:    []string{},
+		}},
+		LineInfo:     []uint32{1, 1, 2},
+		LocVars:      []LocVar{{VarName: "t", StartPC: 1, EndPC: 3}},
+		UpvalueNames: []string{"_ENV"},
+	}
+}
+
+func TestDumpUndump(t *testing.T) {
+	data := Dump(testProto(), false)
+	if !IsBinaryChunk(data) {
+		t.Fatalf("IsBinaryChunk(Dump()) = false")
+	}
+	proto, err := Undump(data)
+	if err != nil {
+		t.Fatalf("Undump: %v", err)
+	}
+	// 主函数的Source写到chunk里，子函数和父函数相同的Source省略，读取时再补上
+	if !reflect.DeepEqual(proto, testProto()) {
+		t.Errorf("Undump(Dump(p)) = %+v, want %+v", proto, testProto())
+	}
+	if again := Dump(proto, false); !bytes.Equal(again, data) {
+		t.Errorf("Dump(Undump(data)) differs from data")
+	}
+
+	proto, err = Undump(Dump(testProto(), true))
+	if err != nil {
+		t.Fatalf("Undump stripped: %v", err)
+	}
+	if proto.Source != "" || len(proto.LineInfo) != 0 || len(proto.LocVars) != 0 ||
+		len(proto.UpvalueNames) != 0 || len(proto.Protos[0].LineInfo) != 0 {
+		t.Errorf("stripped chunk keeps debug info: %+v", proto)
+	}
+}
+
+func TestUndumpHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		off  int  // 要修改的字节
+		b    byte // 新的值
+		err  error
+	}{
+		{"signature", 1, 'l', ErrSignature},
+		{"version", 4, 0x52, ErrVersion},
+		{"format", 5, 1, ErrFormat},
+		{"LUAC_DATA", 8, '\n', ErrCorrupted},
+		{"LUAC_INT", 17, 0x79, ErrEndianness},
+		{"LUAC_NUM", 32, 0x41, ErrFloatFormat},
+	}
+	for _, tt := range tests {
+		data := Dump(testProto(), false)
+		data[tt.off] = tt.b
+		if _, err := Undump(data); err != tt.err {
+			t.Errorf("%s: Undump() error = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+
+	sizes := []struct {
+		typ      string
+		expected byte
+	}{
+		{"int", CINT_SIZE},
+		{"size_t", CSIZET_SIZE},
+		{"Instruction", INSTRUCTION_SIZE},
+		{"lua_Integer", LUA_INTEGER_SIZE},
+		{"lua_Number", LUA_NUMBER_SIZE},
+	}
+	for i, tt := range sizes {
+		data := Dump(testProto(), false)
+		data[12+i] = 16
+		_, err := Undump(data)
+		want := &SizeMismatchError{Type: tt.typ, Size: 16, Expected: tt.expected}
+		if e, ok := err.(*SizeMismatchError); !ok || *e != *want {
+			t.Errorf("%s: Undump() error = %v, want %v", tt.typ, err, want)
+		}
+	}
+}
+
+// 任何长度的截断都应该返回错误，而不是越界或者按错误的长度分配内存
+func TestUndumpTruncated(t *testing.T) {
+	data := Dump(testProto(), false)
+	for n := 0; n < len(data); n++ {
+		want := ErrTruncated
+		if n < len(LUA_SIGNATURE) {
+			want = ErrSignature
+		}
+		if _, err := Undump(data[:n]); err != want {
+			t.Errorf("Undump(data[:%d]) error = %v, want %v", n, err, want)
+		}
+	}
+}
+
+func TestUndumpBadCount(t *testing.T) {
+	tests := []struct {
+		name string
+		mark []byte // 要修改的数据的位置
+		off  int    // 相对mark的偏移
+		b    []byte
+		err  error
+	}{
+		// 常量"abc"的类型标签
+		{"constant tag", []byte("\x04\x04abc"), 0, []byte{0x09}, &ConstantTagError{0x09}},
+		// 长字符串的长度超过剩余的数据
+		{"long string", []byte("\x14\xff"), 2, []byte{0, 0, 0, 0, 0, 0, 0, 1}, ErrTruncated},
+		// 子函数的个数（紧跟在upvalue数组后面）
+		{"protos count", []byte{1, 0, 0, 0, 1, 0, 1, 0, 0, 0}, 6, []byte{0xff, 0xff, 0xff, 0x7f}, ErrTruncated},
+	}
+	for _, tt := range tests {
+		data := Dump(testProto(), false)
+		i := bytes.Index(data, tt.mark)
+		if i < 0 {
+			t.Fatalf("%s: mark not found", tt.name)
+		}
+		copy(data[i+tt.off:], tt.b)
+		_, err := Undump(data)
+		if !reflect.DeepEqual(err, tt.err) {
+			t.Errorf("%s: Undump() error = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,395 @@
+package binchunk
+
+import (
+	"fmt"
+	. "luago/vm"
+	"strings"
+)
+
+// 校验失败的原因以及出错的位置
+type VerifyError struct {
+	Source      string
+	LineDefined uint32
+	PC          int // 出错指令的索引，-1表示错误和具体的指令无关
+	Msg         string
+}
+
+func (e *VerifyError) Error() string {
+	if e.PC < 0 {
+		return fmt.Sprintf("bad function <%s:%d>: %s",
+			e.Source, e.LineDefined, e.Msg)
+	}
+	return fmt.Sprintf("bad instruction %d in function <%s:%d>: %s",
+		e.PC+1, e.Source, e.LineDefined, e.Msg)
+}
+
+// 静态检查函数原型（包括所有子函数），保证虚拟机执行时不会访问
+// 超出MaxStackSize的寄存器、不存在的常量、Upvalue和子函数，也不会跳转到代码之外。
+// Undump不校验输入，加载来源不可信的二进制chunk之前应该先调用这个函数
+func Verify(proto *Prototype) error {
+	return verifyProto(proto, nil)
+}
+
+type verifier struct {
+	proto   *Prototype
+	pc      int
+	targets map[int]bool // 跳转指令的目标
+}
+
+func (V *verifier) error(f string, a ...interface{}) error {
+	return &VerifyError{
+		Source:      V.proto.Source,
+		LineDefined: V.proto.LineDefined,
+		PC:          V.pc,
+		Msg:         fmt.Sprintf(f, a...),
+	}
+}
+
+func verifyProto(proto, parent *Prototype) error {
+	V := &verifier{proto: proto, pc: -1}
+	if err := V.checkHeader(parent); err != nil {
+		return err
+	}
+	V.collectTargets()
+	for V.pc = 0; V.pc < len(proto.Code); V.pc++ {
+		if err := V.checkInstruction(); err != nil {
+			return err
+		}
+	}
+	for _, p := range proto.Protos {
+		if err := verifyProto(p, proto); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (V *verifier) checkHeader(parent *Prototype) error {
+	p := V.proto
+	if p.IsVararg > 1 {
+		return V.error("bad vararg flag %d", p.IsVararg)
+	}
+	if p.NumParams > p.MaxStackSize {
+		return V.error("%d params but only %d registers", p.NumParams, p.MaxStackSize)
+	}
+	if n := len(p.Code); n == 0 || Instruction(p.Code[n-1]).Opcode() != OP_RETURN {
+		return V.error("missing final RETURN")
+	}
+	if len(p.LineInfo) != 0 && len(p.LineInfo) != len(p.Code) {
+		return V.error("line info size mismatch")
+	}
+	if len(p.UpvalueNames) != 0 && len(p.UpvalueNames) != len(p.Upvalues) {
+		return V.error("upvalue names size mismatch")
+	}
+	for _, locVar := range p.LocVars {
+		if locVar.StartPC > locVar.EndPC || int(locVar.EndPC) > len(p.Code) {
+			return V.error("bad pc range of local '%s'", locVar.VarName)
+		}
+	}
+	for i, k := range p.Constants {
+		switch k.(type) {
+		case nil, bool, int64, float64, string:
+		default:
+			return V.error("bad constant %d", i)
+		}
+	}
+
+	// 主函数的Upvalue由Load初始化，子函数的Upvalue要么捕获父函数的寄存器，
+	// 要么引用父函数的Upvalue
+	for i, uv := range p.Upvalues {
+		if uv.Instack > 1 {
+			return V.error("bad instack flag of upvalue %d", i)
+		}
+		if parent == nil {
+			continue
+		}
+		if uv.Instack == 1 && uv.Idx >= parent.MaxStackSize {
+			return V.error("upvalue %d captures register %d out of range", i, uv.Idx)
+		}
+		if uv.Instack == 0 && int(uv.Idx) >= len(parent.Upvalues) {
+			return V.error("upvalue %d refers to enclosing upvalue %d out of range", i, uv.Idx)
+		}
+	}
+	return nil
+}
+
+// 记录所有可能跳转到的指令，跳出范围的目标由checkInstruction报告
+func (V *verifier) collectTargets() {
+	V.targets = map[int]bool{}
+	for pc, code := range V.proto.Code {
+		i := Instruction(code)
+		switch i.Opcode() {
+		case OP_JMP, OP_FORLOOP, OP_FORPREP, OP_TFORLOOP:
+			_, sbx := i.AsBx()
+			V.targets[pc+1+sbx] = true
+		case OP_LOADBOOL:
+			if _, _, c := i.ABC(); c != 0 {
+				V.targets[pc+2] = true
+			}
+		}
+	}
+}
+
+func (V *verifier) checkInstruction() error {
+	i := Instruction(V.proto.Code[V.pc])
+	if !i.IsValid() {
+		return V.error("bad opcode %d", i.Opcode())
+	}
+	if err := V.checkOpenResults(i); err != nil {
+		return err
+	}
+
+	if i.IsTest() {
+		if err := V.checkNextOp(OP_JMP); err != nil {
+			return err
+		}
+	}
+
+	switch op := i.Opcode(); op {
+	case OP_LOADK:
+		a, bx := i.ABx()
+		return V.checkRegConst(a, bx)
+	case OP_LOADKX:
+		a, _ := i.ABx()
+		if err := V.checkNextOp(OP_EXTRAARG); err != nil {
+			return err
+		}
+		return V.checkRegConst(a, Instruction(V.proto.Code[V.pc+1]).Ax())
+	case OP_EXTRAARG:
+		if V.pc == 0 {
+			return V.error("unexpected EXTRAARG")
+		}
+		prev := Instruction(V.proto.Code[V.pc-1])
+		if prev.Opcode() == OP_LOADKX {
+			return nil
+		}
+		if prev.Opcode() == OP_SETLIST {
+			if _, _, c := prev.ABC(); c == 0 {
+				return nil
+			}
+		}
+		return V.error("unexpected EXTRAARG")
+	case OP_CLOSURE:
+		a, bx := i.ABx()
+		if bx >= len(V.proto.Protos) {
+			return V.error("function index %d out of range", bx)
+		}
+		return V.checkReg(a)
+	case OP_JMP, OP_FORLOOP, OP_FORPREP, OP_TFORLOOP:
+		a, sbx := i.AsBx()
+		if dest := V.pc + 1 + sbx; dest < 0 || dest >= len(V.proto.Code) {
+			return V.error("jump to %d out of range", dest+1)
+		}
+		switch op {
+		case OP_JMP:
+			if a > 0 {
+				return V.checkReg(a - 1)
+			}
+			return nil
+		case OP_TFORLOOP:
+			return V.checkRegs(a, 2)
+		default:
+			return V.checkRegs(a, 4)
+		}
+	default:
+		a, b, c := i.ABC()
+		return V.checkABC(i, a, b, c)
+	}
+}
+
+func (V *verifier) checkABC(i Instruction, a, b, c int) error {
+	switch op := i.Opcode(); op {
+	case OP_SETTABUP:
+		if err := V.checkUpval(a); err != nil {
+			return err
+		}
+	case OP_EQ, OP_LT, OP_LE: // A是布尔值
+	case OP_LOADNIL:
+		if err := V.checkRegs(a, b+1); err != nil {
+			return err
+		}
+	case OP_SELF:
+		if err := V.checkRegs(a, 2); err != nil {
+			return err
+		}
+	case OP_CALL, OP_TAILCALL:
+		// B-1个参数，C-1个返回值，B或C为0表示一直到栈顶
+		if err := V.checkRegs(a, b); err != nil {
+			return err
+		}
+		if err := V.checkRegs(a, c-1); err != nil {
+			return err
+		}
+	case OP_RETURN, OP_VARARG:
+		if err := V.checkRegs(a, b-1); err != nil {
+			return err
+		}
+	case OP_TFORCALL:
+		if err := V.checkNextOp(OP_TFORLOOP); err != nil {
+			return err
+		}
+		if err := V.checkRegs(a, 3+c); err != nil {
+			return err
+		}
+	case OP_SETLIST:
+		if err := V.checkRegs(a, b+1); err != nil {
+			return err
+		}
+		if c == 0 {
+			if err := V.checkNextOp(OP_EXTRAARG); err != nil {
+				return err
+			}
+		}
+	case OP_NEWTABLE:
+		// B和C是数组部分和哈希部分大小的“浮点字节”编码（参见Int2fb）。
+		// 每个元素至少需要一条指令来初始化，所以合法的提示不会超过指令的条数，
+		// 否则恶意的chunk可以让虚拟机一下子分配一个巨大的表
+		if maxHint := Int2fb(len(V.proto.Code)); b > maxHint || c > maxHint {
+			return V.error("table size hint too large")
+		}
+		if err := V.checkReg(a); err != nil {
+			return err
+		}
+	case OP_LOADBOOL:
+		if c != 0 && V.pc+2 >= len(V.proto.Code) {
+			return V.error("skip out of range")
+		}
+		if err := V.checkReg(a); err != nil {
+			return err
+		}
+	case OP_CONCAT:
+		if b > c {
+			return V.error("bad register range %d..%d", b, c)
+		}
+		if err := V.checkReg(a); err != nil {
+			return err
+		}
+	default:
+		if err := V.checkReg(a); err != nil {
+			return err
+		}
+	}
+
+	if err := V.checkArg(i, i.BMode(), b); err != nil {
+		return err
+	}
+	return V.checkArg(i, i.CMode(), c)
+}
+
+// 根据操作数的类型（OpArgR、OpArgK、OpArgU）检查操作数，
+// OpArgU类型的操作数含义因指令而异
+func (V *verifier) checkArg(i Instruction, mode byte, arg int) error {
+	switch mode {
+	case OpArgR:
+		return V.checkReg(arg)
+	case OpArgK:
+		if arg > 0xFF {
+			return V.checkConst(arg & 0xFF)
+		}
+		return V.checkReg(arg)
+	case OpArgU:
+		switch i.Opcode() {
+		case OP_GETUPVAL, OP_SETUPVAL:
+			return V.checkUpval(arg)
+		case OP_GETTABUP:
+			if mode == i.BMode() {
+				return V.checkUpval(arg)
+			}
+		}
+	}
+	return nil
+}
+
+// 产生不定数量结果的指令（C为0的CALL、B为0的VARARG，以及TAILCALL）把结果留在
+// 栈顶，再压入第一个结果的寄存器索引；B为0的CALL、TAILCALL、RETURN和SETLIST
+// 弹出这个索引，使用栈顶的结果。所以这两种指令必须紧挨着成对出现，而且中间
+// 不能有跳转进来，否则后者会把任意的寄存器值当成索引
+// lua-5.1.5/src/ldebug.c#checkopenop()
+func (V *verifier) checkOpenResults(i Instruction) error {
+	name := strings.TrimSpace(i.OpName())
+	if usesOpenResults(i) && (V.pc == 0 || V.targets[V.pc] ||
+		!hasOpenResults(Instruction(V.proto.Code[V.pc-1]))) {
+		return V.error("%s without open results", name)
+	}
+	if !hasOpenResults(i) {
+		return nil
+	}
+	if V.pc+1 >= len(V.proto.Code) ||
+		!usesOpenResults(Instruction(V.proto.Code[V.pc+1])) {
+		return V.error("open results of %s not used", name)
+	}
+	// 后者从自己的A开始收集结果，A不能超过前者的A
+	a, _, _ := i.ABC()
+	if next, _, _ := Instruction(V.proto.Code[V.pc+1]).ABC(); next > a {
+		return V.error("open results of %s used from register %d", name, next)
+	}
+	return nil
+}
+
+func hasOpenResults(i Instruction) bool {
+	_, b, c := i.ABC()
+	switch i.Opcode() {
+	case OP_CALL:
+		return c == 0
+	case OP_VARARG:
+		return b == 0
+	case OP_TAILCALL:
+		return true
+	}
+	return false
+}
+
+func usesOpenResults(i Instruction) bool {
+	switch i.Opcode() {
+	case OP_CALL, OP_TAILCALL, OP_RETURN, OP_SETLIST:
+		_, b, _ := i.ABC()
+		return b == 0
+	}
+	return false
+}
+
+func (V *verifier) checkReg(r int) error {
+	if r >= int(V.proto.MaxStackSize) {
+		return V.error("register %d out of range", r)
+	}
+	return nil
+}
+
+// 检查从a开始的n个寄存器，n小于等于0时不检查
+func (V *verifier) checkRegs(a, n int) error {
+	if n <= 0 {
+		return V.checkReg(a)
+	}
+	return V.checkReg(a + n - 1)
+}
+
+func (V *verifier) checkConst(idx int) error {
+	if idx >= len(V.proto.Constants) {
+		return V.error("constant %d out of range", idx)
+	}
+	return nil
+}
+
+func (V *verifier) checkRegConst(a, idx int) error {
+	if err := V.checkReg(a); err != nil {
+		return err
+	}
+	return V.checkConst(idx)
+}
+
+func (V *verifier) checkUpval(idx int) error {
+	if idx >= len(V.proto.Upvalues) {
+		return V.error("upvalue %d out of range", idx)
+	}
+	return nil
+}
+
+func (V *verifier) checkNextOp(opcode int) error {
+	if V.pc+1 >= len(V.proto.Code) ||
+		Instruction(V.proto.Code[V.pc+1]).Opcode() != opcode {
+		return V.error("%s must be followed by %s",
+			strings.TrimSpace(Instruction(V.proto.Code[V.pc]).OpName()),
+			strings.TrimSpace(Instruction(opcode).OpName()))
+	}
+	return nil
+}
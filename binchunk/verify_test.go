@@ -0,0 +1,298 @@
+package binchunk_test
+
+import (
+	"io/ioutil"
+	. "luago/api"
+	. "luago/auxlib"
+	. "luago/binchunk"
+	"luago/compiler"
+	"luago/state"
+	"luago/stdlib"
+	. "luago/vm"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func iABC(op, a, b, c int) uint32 {
+	return uint32(b<<23 | c<<14 | a<<6 | op)
+}
+
+func iABx(op, a, bx int) uint32 {
+	return uint32(bx<<14 | a<<6 | op)
+}
+
+func iAsBx(op, a, sbx int) uint32 {
+	return iABx(op, a, sbx+MAXARG_sBx)
+}
+
+// 编译器生成的代码都应该能通过校验
+func TestVerifyCompiled(t *testing.T) {
+	sources := []string{
+		"",
+		"local t = {1, 2, 3, x = 1}; for i, v in ipairs(t) do print(i, v) end",
+		"local a, b = ...; return a and b or a // b",
+		"local function f(...) return select('#', ...), ... end; return f(1, nil, 3)",
+		"for i = 10, 1, -1 do if i % 2 == 0 then goto continue end; print(i) ::continue:: end",
+		"local x = 0; local function inc() x = x + 1; return x end; return inc(), inc()",
+		"local t = {} ; t[1], t.x, t[t] = 1 < 2, 'a' .. 'b' .. 'c', not nil; return #t",
+		"return {" + strings.Repeat("1, ", 100) + "}", // SETLIST的C为0
+		"local s = 'k'; return {" + strings.Repeat("s, ", 300) + "}",
+	}
+	files, _ := filepath.Glob("../lua/*.lua")
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources = append(sources, string(data))
+	}
+
+	for i, src := range sources {
+		proto := compiler.Compile(src, "=test")
+		if err := Verify(proto); err != nil {
+			t.Errorf("source %d: Verify() = %v", i, err)
+		}
+		// 序列化再读回来以后也一样
+		proto, err := Undump(Dump(proto, true))
+		if err != nil {
+			t.Fatalf("source %d: Undump() = %v", i, err)
+		}
+		if err := Verify(proto); err != nil {
+			t.Errorf("source %d: Verify() after Undump = %v", i, err)
+		}
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	ret := iABC(OP_RETURN, 0, 1, 0)
+	tests := []struct {
+		name  string
+		code  []uint32
+		proto func(p *Prototype) // 修改函数原型的其他部分
+		pc    int
+		msg   string
+	}{
+		{"register", []uint32{iABC(OP_MOVE, 2, 0, 0), ret}, nil,
+			0, "register 2 out of range"},
+		{"RK register", []uint32{iABC(OP_ADD, 0, 1, 5), ret}, nil,
+			0, "register 5 out of range"},
+		{"constant", []uint32{iABx(OP_LOADK, 0, 1), ret}, nil,
+			0, "constant 1 out of range"},
+		{"RK constant", []uint32{iABC(OP_ADD, 0, 0x100|1, 0), ret}, nil,
+			0, "constant 1 out of range"},
+		{"upvalue", []uint32{iABC(OP_GETUPVAL, 0, 1, 0), ret}, nil,
+			0, "upvalue 1 out of range"},
+		{"jump forward", []uint32{iAsBx(OP_JMP, 0, 1), ret}, nil,
+			0, "jump to 3 out of range"},
+		{"jump backward", []uint32{ret, iAsBx(OP_JMP, 0, -3), ret}, nil,
+			1, "jump to 0 out of range"},
+		{"forloop registers", []uint32{iAsBx(OP_FORPREP, 0, 0), ret}, nil,
+			0, "register 3 out of range"},
+		{"call registers", []uint32{iABC(OP_CALL, 1, 3, 1), ret}, nil,
+			0, "register 3 out of range"},
+		{"loadnil range", []uint32{iABC(OP_LOADNIL, 0, 2, 0), ret}, nil,
+			0, "register 2 out of range"},
+		{"concat range", []uint32{iABC(OP_CONCAT, 0, 1, 0), ret}, nil,
+			0, "bad register range 1..0"},
+		{"bad opcode", []uint32{0x3F, ret}, nil,
+			0, "bad opcode 63"},
+		{"test without jump", []uint32{iABC(OP_TEST, 0, 0, 0), ret}, nil,
+			0, "TEST must be followed by JMP"},
+		{"loadkx without extraarg", []uint32{iABx(OP_LOADKX, 0, 0), ret}, nil,
+			0, "LOADKX must be followed by EXTRAARG"},
+		{"stray extraarg", []uint32{iABC(OP_MOVE, 0, 1, 0), iABx(OP_EXTRAARG, 0, 0), ret}, nil,
+			1, "unexpected EXTRAARG"},
+		{"loadbool skip", []uint32{iABC(OP_LOADBOOL, 0, 1, 1), ret}, nil,
+			0, "skip out of range"},
+		{"closure", []uint32{iABx(OP_CLOSURE, 0, 0), ret}, nil,
+			0, "function index 0 out of range"},
+		// 浮点字节0x1ff表示一个巨大的数，合法的代码不可能有这么多元素
+		{"newtable array hint", []uint32{iABC(OP_NEWTABLE, 0, 0x1ff, 0), ret}, nil,
+			0, "table size hint too large"},
+		{"newtable hash hint", []uint32{iABC(OP_NEWTABLE, 0, 0, 0x1ff), ret}, nil,
+			0, "table size hint too large"},
+		// 不定数量的结果必须由紧挨着的前一条指令产生
+		{"open results without producer", []uint32{iABC(OP_CALL, 0, 0, 1), ret}, nil,
+			0, "CALL without open results"},
+		{"open results after jump", []uint32{
+			iAsBx(OP_JMP, 0, 1), iABC(OP_VARARG, 0, 0, 0), iABC(OP_RETURN, 0, 0, 0),
+		}, nil, 2, "RETURN without open results"},
+		{"open results not used", []uint32{iABC(OP_VARARG, 0, 0, 0), ret}, nil,
+			0, "open results of VARARG not used"},
+		{"open results register", []uint32{iABC(OP_VARARG, 0, 0, 0), iABC(OP_RETURN, 1, 0, 0)}, nil,
+			0, "open results of VARARG used from register 1"},
+		{"missing return", []uint32{iABx(OP_LOADK, 0, 0)}, nil,
+			-1, "missing final RETURN"},
+		{"empty code", []uint32{}, nil,
+			-1, "missing final RETURN"},
+		{"vararg flag", []uint32{ret}, func(p *Prototype) { p.IsVararg = 2 },
+			-1, "bad vararg flag 2"},
+		{"params", []uint32{ret}, func(p *Prototype) { p.NumParams = 3 },
+			-1, "3 params but only 2 registers"},
+		{"line info", []uint32{ret}, func(p *Prototype) { p.LineInfo = []uint32{1, 2} },
+			-1, "line info size mismatch"},
+		{"constant type", []uint32{ret}, func(p *Prototype) { p.Constants[0] = []byte{} },
+			-1, "bad constant 0"},
+		{"local pc range", []uint32{ret}, func(p *Prototype) {
+			p.LocVars = []LocVar{{VarName: "x", StartPC: 0, EndPC: 2}}
+		}, -1, "bad pc range of local 'x'"},
+	}
+
+	for _, tt := range tests {
+		proto := &Prototype{
+			Source:       "=test",
+			IsVararg:     1,
+			MaxStackSize: 2,
+			Code:         tt.code,
+			Constants:    []interface{}{int64(1)},
+			Upvalues:     []Upvalue{{Instack: 1, Idx: 0}},
+		}
+		if tt.proto != nil {
+			tt.proto(proto)
+		}
+		err := Verify(proto)
+		e, ok := err.(*VerifyError)
+		if !ok {
+			t.Errorf("%s: Verify() = %v, want *VerifyError", tt.name, err)
+			continue
+		}
+		if e.PC != tt.pc || e.Msg != tt.msg {
+			t.Errorf("%s: Verify() = {PC: %d, Msg: %q}, want {PC: %d, Msg: %q}",
+				tt.name, e.PC, e.Msg, tt.pc, tt.msg)
+		}
+	}
+}
+
+// 子函数的Upvalue只能捕获父函数的寄存器或者引用父函数的Upvalue
+func TestVerifySubUpvalues(t *testing.T) {
+	tests := []struct {
+		uv  Upvalue
+		msg string
+	}{
+		{Upvalue{Instack: 1, Idx: 1}, ""},
+		{Upvalue{Instack: 1, Idx: 2}, "upvalue 0 captures register 2 out of range"},
+		{Upvalue{Instack: 0, Idx: 0}, ""},
+		{Upvalue{Instack: 0, Idx: 1}, "upvalue 0 refers to enclosing upvalue 1 out of range"},
+		{Upvalue{Instack: 2, Idx: 0}, "bad instack flag of upvalue 0"},
+	}
+	for _, tt := range tests {
+		sub := &Prototype{
+			MaxStackSize: 2,
+			Code:         []uint32{iABC(OP_RETURN, 0, 1, 0)},
+			Upvalues:     []Upvalue{tt.uv},
+		}
+		main := &Prototype{
+			IsVararg:     1,
+			MaxStackSize: 2,
+			Code:         []uint32{iABx(OP_CLOSURE, 0, 0), iABC(OP_RETURN, 0, 1, 0)},
+			Upvalues:     []Upvalue{{Instack: 1, Idx: 0}},
+			Protos:       []*Prototype{sub},
+		}
+		msg := ""
+		if err := Verify(main); err != nil {
+			msg = err.(*VerifyError).Msg
+		}
+		if msg != tt.msg {
+			t.Errorf("%+v: Verify() = %q, want %q", tt.uv, msg, tt.msg)
+		}
+	}
+}
+
+// 标准库的load、loadfile和dofile总是校验二进制chunk，脚本没法加载构造出来的坏字节码
+func TestVerifyStdlibLoad(t *testing.T) {
+	proto := compiler.Compile("return 1", "=bad")
+	proto.Code = []uint32{iAsBx(OP_JMP, 0, 100), iABC(OP_RETURN, 0, 1, 0)}
+	bad := string(Dump(proto, true))
+	file, err := ioutil.TempFile("", "bad*.luac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(bad)
+	file.Close()
+
+	const rejected = "bad instruction 1 in function <:0>: jump to 102 out of range"
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"return select(2, load(bad))", rejected},
+		{"return select(2, load(bad, nil, 'b'))", rejected},
+		{"local s = bad return select(2, load(function() local r = s s = nil return r end))", rejected},
+		{"return select(2, load(bad, nil, 't'))", "attempt to load a binary chunk (mode is 't')"},
+		{"return select(2, loadfile(path))", rejected},
+		{"return select(2, pcall(dofile, path))", rejected},
+		// 编译器生成的代码照常可以加载
+		{"return load(string.dump(function(a) return a + 1 end))(41)", "42"},
+	}
+	for _, tt := range tests {
+		L := state.New()
+		stdlib.OpenLibs(L)
+		L.PushString(bad)
+		L.SetGlobal("bad")
+		L.PushString(file.Name())
+		L.SetGlobal("path")
+		if DoString(L, tt.code) != LUA_OK {
+			t.Fatalf("%s: %s", tt.code, L.ToString(-1))
+		}
+		// 错误消息前面是块名
+		if got := L.ToString(-1); !strings.HasSuffix(got, tt.want) {
+			t.Errorf("%s = %q, want suffix %q", tt.code, got, tt.want)
+		}
+	}
+}
+
+// 任意的二进制chunk，只要通过了Undump和Verify，就可以在资源限制下安全地运行：
+// 出错只能是普通的Lua错误，不能是Go运行时错误，也不能卡住或者耗尽内存
+func FuzzVerifyLoadRun(f *testing.F) {
+	seeds := []string{
+		"return 1 + 2",
+		"local t = {} for i = 1, 10 do t[i] = i * i end return #t",
+		"local s = '' for i = 1, 100 do s = s .. i end return s",
+		"local function f(n) if n < 2 then return n end return f(n-1) + f(n-2) end return f(10)",
+		"local t = setmetatable({}, {__index = function(t, k) return k end}) return t.x",
+		"local co = coroutine.wrap(function(a) local b = coroutine.yield(a) return b end) co(1) return co(2)",
+		"return string.rep('x', 10):upper(), table.concat({1, 2, 3}, ','), math.max(1, 2)",
+		"local a, b = pcall(error, {}) return select('#', a, b)",
+	}
+	for _, src := range seeds {
+		proto := compiler.Compile(src, "=seed")
+		f.Add(Dump(proto, false))
+		f.Add(Dump(proto, true))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		proto, err := Undump(data)
+		if err != nil || Verify(proto) != nil {
+			return
+		}
+
+		L := state.New()
+		for _, lib := range []struct {
+			name string
+			open GoFunction
+		}{
+			{"_G", stdlib.OpenBase},
+			{"coroutine", stdlib.OpenCoroutine},
+			{"table", stdlib.OpenTable},
+			{"string", stdlib.OpenString},
+			{"math", stdlib.OpenMath},
+			{"utf8", stdlib.OpenUTF8},
+		} {
+			RequireF(L, lib.name, lib.open, true)
+			L.Pop(1)
+		}
+		if L.Load(data, "=fuzz", "bv") != LUA_OK {
+			t.Fatalf("Load() = %v after Verify passed", L.ToString(-1))
+		}
+		L.SetInstructionLimit(100000)
+		L.SetMemoryLimit(16 << 20)
+		if L.PCall(0, 0, 0) != LUA_OK {
+			if msg, _ := L.ToStringX(-1); strings.HasPrefix(msg, "runtime error") {
+				t.Fatalf("PCall() = %s", msg)
+			}
+		}
+	})
+}
@@ -0,0 +1,181 @@
+package binchunk
+
+import "encoding/binary"
+import "math"
+
+// 短字符串的最大长度，和Lua官方实现里的LUAI_MAXSHORTLEN一致
+const LUAI_MAXSHORTLEN = 40
+
+type writer struct {
+	data  []byte
+	strip bool // 是否去掉调试信息
+}
+
+func (W *writer) writeByte(b byte) {
+	W.data = append(W.data, b)
+}
+
+func (W *writer) writeBytes(bytes []byte) {
+	W.data = append(W.data, bytes...)
+}
+
+func (W *writer) writeUint32(i uint32) {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], i)
+	W.data = append(W.data, buf[:]...)
+}
+
+func (W *writer) writeUint64(i uint64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], i)
+	W.data = append(W.data, buf[:]...)
+}
+
+func (W *writer) writeLuaInteger(i int64) {
+	W.writeUint64(uint64(i))
+}
+
+func (W *writer) writeLuaNumber(f float64) {
+	W.writeUint64(math.Float64bits(f))
+}
+
+// 长度加1后写入：0表示NULL，小于0xFF的长度占一个字节，否则先写0xFF再写size_t
+func (W *writer) writeString(s string) {
+	size := uint64(len(s)) + 1
+	if size < 0xFF {
+		W.writeByte(byte(size))
+	} else {
+		W.writeByte(0xFF)
+		W.writeUint64(size)
+	}
+	W.writeBytes([]byte(s))
+}
+
+func (W *writer) writeNilString() {
+	W.writeByte(0)
+}
+
+func (W *writer) writeHeader() {
+	W.writeBytes([]byte(LUA_SIGNATURE))
+	W.writeByte(LUAC_VERSION)
+	W.writeByte(LUAC_FORMAT)
+	W.writeBytes([]byte(LUAC_DATA))
+	W.writeByte(CINT_SIZE)
+	W.writeByte(CSIZET_SIZE)
+	W.writeByte(INSTRUCTION_SIZE)
+	W.writeByte(LUA_INTEGER_SIZE)
+	W.writeByte(LUA_NUMBER_SIZE)
+	W.writeLuaInteger(LUAC_INT)
+	W.writeLuaNumber(LUAC_NUM)
+}
+
+// 和父函数来源相同的子函数不再重复写入Source
+func (W *writer) writeProto(proto *Prototype, parentSource string) {
+	if W.strip || proto.Source == parentSource {
+		W.writeNilString()
+	} else {
+		W.writeString(proto.Source)
+	}
+	W.writeUint32(proto.LineDefined)
+	W.writeUint32(proto.LastLineDefined)
+	W.writeByte(proto.NumParams)
+	W.writeByte(proto.IsVararg)
+	W.writeByte(proto.MaxStackSize)
+	W.writeCode(proto.Code)
+	W.writeConstants(proto.Constants)
+	W.writeUpvalues(proto.Upvalues)
+	W.writeProtos(proto.Protos, proto.Source)
+	W.writeLineInfo(proto.LineInfo)
+	W.writeLocVars(proto.LocVars)
+	W.writeUpvalueNames(proto.UpvalueNames)
+}
+
+func (W *writer) writeCode(code []uint32) {
+	W.writeUint32(uint32(len(code)))
+	for _, inst := range code {
+		W.writeUint32(inst)
+	}
+}
+
+func (W *writer) writeConstants(constants []interface{}) {
+	W.writeUint32(uint32(len(constants)))
+	for _, k := range constants {
+		W.writeConstant(k)
+	}
+}
+
+func (W *writer) writeConstant(k interface{}) {
+	switch x := k.(type) {
+	case nil:
+		W.writeByte(TAG_NIL)
+	case bool:
+		W.writeByte(TAG_BOOLEAN)
+		if x {
+			W.writeByte(1)
+		} else {
+			W.writeByte(0)
+		}
+	case int64:
+		W.writeByte(TAG_INTEGER)
+		W.writeLuaInteger(x)
+	case float64:
+		W.writeByte(TAG_NUMBER)
+		W.writeLuaNumber(x)
+	case string:
+		if len(x) <= LUAI_MAXSHORTLEN {
+			W.writeByte(TAG_SHORT_STR)
+		} else {
+			W.writeByte(TAG_LONG_STR)
+		}
+		W.writeString(x)
+	default:
+		panic("unknown constant type!")
+	}
+}
+
+func (W *writer) writeUpvalues(upvalues []Upvalue) {
+	W.writeUint32(uint32(len(upvalues)))
+	for _, upval := range upvalues {
+		W.writeByte(upval.Instack)
+		W.writeByte(upval.Idx)
+	}
+}
+
+func (W *writer) writeProtos(protos []*Prototype, parentSource string) {
+	W.writeUint32(uint32(len(protos)))
+	for _, proto := range protos {
+		W.writeProto(proto, parentSource)
+	}
+}
+
+func (W *writer) writeLineInfo(lineInfo []uint32) {
+	if W.strip {
+		lineInfo = nil
+	}
+	W.writeUint32(uint32(len(lineInfo)))
+	for _, line := range lineInfo {
+		W.writeUint32(line)
+	}
+}
+
+func (W *writer) writeLocVars(locVars []LocVar) {
+	if W.strip {
+		locVars = nil
+	}
+	W.writeUint32(uint32(len(locVars)))
+	for _, locVar := range locVars {
+		W.writeString(locVar.VarName)
+		W.writeUint32(locVar.StartPC)
+		W.writeUint32(locVar.EndPC)
+	}
+}
+
+func (W *writer) writeUpvalueNames(names []string) {
+	if W.strip {
+		names = nil
+	}
+	W.writeUint32(uint32(len(names)))
+	for _, name := range names {
+		W.writeString(name)
+	}
+}
@@ -25,12 +25,22 @@ stat ::=  ‘;’ |
 
 type Stat interface{}
 
-type EmptyStat struct{}              // ‘;’ 空语句
-type BreakStat struct{ Line int }    // break
-type LabelStat struct{ Name string } // ‘::’ Name ‘::’ 标签
-type GotoStat struct{ Name string }  // goto Name
-type DoStat struct{ Block *Block }   // do block end
-type FuncCallStat = FuncCallExp      // functioncall
+type EmptyStat struct{}            // ‘;’ 空语句
+type BreakStat struct{ Line int }  // break
+type DoStat struct{ Block *Block } // do block end
+type FuncCallStat = FuncCallExp    // functioncall
+
+// ‘::’ Name ‘::’ 标签
+type LabelStat struct {
+	Line int
+	Name string
+}
+
+// goto Name
+type GotoStat struct {
+	Line int
+	Name string
+}
 
 // while exp do block end
 type WhileStat struct {
@@ -52,7 +62,7 @@ type IfStat struct {
 }
 
 // for Name '=’ exp ',’ exp [',’ exp] do block end
-//需要把关键字for和do所在的行号记录下来，以供代码生成阶段使用
+// 需要把关键字for和do所在的行号记录下来，以供代码生成阶段使用
 type ForNumStat struct {
 	LineOfFor int
 	LineOfDo  int
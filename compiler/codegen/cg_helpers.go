@@ -0,0 +1,91 @@
+package codegen
+
+import . "luago/compiler/ast"
+
+func isVarargOrFuncCall(exp Exp) bool {
+	switch exp.(type) {
+	case *VarargExp, *FuncCallExp:
+		return true
+	}
+	return false
+}
+
+// 表达式开始的行号
+func lineOf(exp Exp) int {
+	switch x := exp.(type) {
+	case *NilExp:
+		return x.Line
+	case *TrueExp:
+		return x.Line
+	case *FalseExp:
+		return x.Line
+	case *IntegerExp:
+		return x.Line
+	case *FloatExp:
+		return x.Line
+	case *StringExp:
+		return x.Line
+	case *VarargExp:
+		return x.Line
+	case *NameExp:
+		return x.Line
+	case *FuncDefExp:
+		return x.Line
+	case *FuncCallExp:
+		return x.Line
+	case *TableConstructorExp:
+		return x.Line
+	case *UnopExp:
+		return x.Line
+	case *TableAccessExp:
+		return lineOf(x.PrefixExp)
+	case *ConcatExp:
+		return lineOf(x.Exps[0])
+	case *BinopExp:
+		return lineOf(x.Exp1)
+	case *ParensExp:
+		return lineOf(x.Exp)
+	default:
+		panic("unreachable!")
+	}
+}
+
+// 表达式结束的行号
+func lastLineOf(exp Exp) int {
+	switch x := exp.(type) {
+	case *NilExp:
+		return x.Line
+	case *TrueExp:
+		return x.Line
+	case *FalseExp:
+		return x.Line
+	case *IntegerExp:
+		return x.Line
+	case *FloatExp:
+		return x.Line
+	case *StringExp:
+		return x.Line
+	case *VarargExp:
+		return x.Line
+	case *NameExp:
+		return x.Line
+	case *FuncDefExp:
+		return x.LastLine
+	case *FuncCallExp:
+		return x.LastLine
+	case *TableConstructorExp:
+		return x.LastLine
+	case *TableAccessExp:
+		return x.LastLine
+	case *ConcatExp:
+		return lastLineOf(x.Exps[len(x.Exps)-1])
+	case *BinopExp:
+		return lastLineOf(x.Exp2)
+	case *UnopExp:
+		return lastLineOf(x.Exp)
+	case *ParensExp:
+		return lastLineOf(x.Exp)
+	default:
+		panic("unreachable!")
+	}
+}
@@ -0,0 +1,374 @@
+package codegen
+
+import . "luago/compiler/ast"
+
+func cgStat(fi *funcInfo, node Stat) {
+	switch stat := node.(type) {
+	case *FuncCallStat:
+		cgFuncCallStat(fi, stat)
+	case *BreakStat:
+		cgBreakStat(fi, stat)
+	case *DoStat:
+		cgDoStat(fi, stat)
+	case *WhileStat:
+		cgWhileStat(fi, stat)
+	case *RepeatStat:
+		cgRepeatStat(fi, stat)
+	case *IfStat:
+		cgIfStat(fi, stat)
+	case *ForNumStat:
+		cgForNumStat(fi, stat)
+	case *ForInStat:
+		cgForInStat(fi, stat)
+	case *AssignStat:
+		cgAssignStat(fi, stat)
+	case *LocalVarDeclStat:
+		cgLocalVarDeclStat(fi, stat)
+	case *LocalFuncDefStat:
+		cgLocalFuncDefStat(fi, stat)
+	case *GotoStat:
+		cgGotoStat(fi, stat)
+	}
+}
+
+// local function f() end => local f; f = function() end
+// 先声明局部变量，函数体里才能通过Upvalue引用自己（递归）
+func cgLocalFuncDefStat(fi *funcInfo, node *LocalFuncDefStat) {
+	r := fi.addLocVar(node.Name, node.Exp.Line, fi.pc()+2)
+	cgFuncDefExp(fi, node.Exp, r)
+}
+
+func cgFuncCallStat(fi *funcInfo, node *FuncCallStat) {
+	r := fi.allocReg()
+	cgFuncCallExp(fi, node, r, 0)
+	fi.freeReg()
+}
+
+func cgBreakStat(fi *funcInfo, node *BreakStat) {
+	fi.addBreakJmp(node.Line)
+}
+
+func cgGotoStat(fi *funcInfo, node *GotoStat) {
+	fi.addGotoJmp(node.Name, node.Line)
+}
+
+func cgDoStat(fi *funcInfo, node *DoStat) {
+	fi.enterScope(false)
+	cgBlock(fi, node.Block)
+	fi.closeOpenUpvals(node.Block.LastLine)
+	fi.exitScope(fi.pc() + 1)
+}
+
+/*
+	  ______________
+	 /  false? jmp  |
+	/               |
+
+while exp do block end <-'
+
+	^           \
+	|___________/
+	     jmp
+*/
+func cgWhileStat(fi *funcInfo, node *WhileStat) {
+	pcBeforeExp := fi.pc()
+
+	oldRegs := fi.usedRegs
+	a, _ := expToOpArg(fi, node.Exp, ARG_REG)
+	fi.usedRegs = oldRegs
+
+	line := lastLineOf(node.Exp)
+	fi.emitTest(line, a, 0)
+	pcJmpToEnd := fi.emitJmp(line, 0, 0)
+
+	fi.enterScope(true)
+	cgBlock(fi, node.Block)
+	fi.closeOpenUpvals(node.Block.LastLine)
+	fi.emitJmp(node.Block.LastLine, 0, pcBeforeExp-fi.pc()-1)
+	fi.exitScope(fi.pc())
+
+	fi.fixSbx(pcJmpToEnd, fi.pc()-pcJmpToEnd)
+}
+
+/*
+        ______________
+       |  false? jmp  |
+       V              /
+repeat block until exp
+*/
+// until后面的表达式可以访问循环体里声明的局部变量
+func cgRepeatStat(fi *funcInfo, node *RepeatStat) {
+	fi.enterScope(true)
+
+	pcBeforeBlock := fi.pc()
+	cgBlock(fi, node.Block)
+
+	oldRegs := fi.usedRegs
+	a, _ := expToOpArg(fi, node.Exp, ARG_REG)
+	fi.usedRegs = oldRegs
+
+	line := lastLineOf(node.Exp)
+	fi.emitTest(line, a, 0)
+	fi.emitJmp(line, fi.getJmpArgA(), pcBeforeBlock-fi.pc()-1)
+	fi.closeOpenUpvals(line)
+
+	fi.exitScope(fi.pc() + 1)
+}
+
+/*
+	  _________________       _________________       _____________
+	 / false? jmp      |     / false? jmp      |     / false? jmp  |
+	/                  V    /                  V    /              V
+
+if exp1 then block1 elseif exp2 then block2 elseif true then block3 end <-.
+
+	\                       \                       \      |
+	 \_______________________\_______________________\_____|
+	 jmp                     jmp                     jmp
+*/
+func cgIfStat(fi *funcInfo, node *IfStat) {
+	pcJmpToEnds := make([]int, len(node.Exps))
+	pcJmpToNextExp := -1
+
+	for i, exp := range node.Exps {
+		if pcJmpToNextExp >= 0 {
+			fi.fixSbx(pcJmpToNextExp, fi.pc()-pcJmpToNextExp)
+		}
+
+		oldRegs := fi.usedRegs
+		a, _ := expToOpArg(fi, exp, ARG_REG)
+		fi.usedRegs = oldRegs
+
+		line := lastLineOf(exp)
+		fi.emitTest(line, a, 0)
+		pcJmpToNextExp = fi.emitJmp(line, 0, 0)
+
+		block := node.Blocks[i]
+		fi.enterScope(false)
+		cgBlock(fi, block)
+		fi.closeOpenUpvals(block.LastLine)
+		fi.exitScope(fi.pc() + 1)
+		if i < len(node.Exps)-1 {
+			pcJmpToEnds[i] = fi.emitJmp(block.LastLine, 0, 0)
+		} else {
+			pcJmpToEnds[i] = pcJmpToNextExp
+		}
+	}
+
+	for _, pc := range pcJmpToEnds {
+		fi.fixSbx(pc, fi.pc()-pc)
+	}
+}
+
+// 数值for循环使用三个隐藏的局部变量，名字里的圆括号保证它们不会和普通变量重名
+func cgForNumStat(fi *funcInfo, node *ForNumStat) {
+	forIndexVar := "(for index)"
+	forLimitVar := "(for limit)"
+	forStepVar := "(for step)"
+
+	fi.enterScope(true)
+
+	cgLocalVarDeclStat(fi, &LocalVarDeclStat{
+		LastLine: node.LineOfFor,
+		NameList: []string{forIndexVar, forLimitVar, forStepVar},
+		ExpList:  []Exp{node.InitExp, node.LimitExp, node.StepExp},
+	})
+	fi.addLocVar(node.VarName, node.LineOfFor, fi.pc()+2)
+
+	a := fi.usedRegs - 4
+	pcForPrep := fi.emitForPrep(node.LineOfDo, a, 0)
+	cgBlock(fi, node.Block)
+	fi.closeOpenUpvals(node.Block.LastLine)
+	pcForLoop := fi.emitForLoop(node.LineOfFor, a, 0)
+
+	fi.fixSbx(pcForPrep, pcForLoop-pcForPrep-1)
+	fi.fixSbx(pcForLoop, pcForPrep-pcForLoop)
+
+	fi.exitScope(fi.pc())
+	fi.fixEndPC(forIndexVar, 1)
+	fi.fixEndPC(forLimitVar, 1)
+	fi.fixEndPC(forStepVar, 1)
+}
+
+// 通用for循环同样使用三个隐藏的局部变量保存迭代器函数、状态和控制变量
+func cgForInStat(fi *funcInfo, node *ForInStat) {
+	forGeneratorVar := "(for generator)"
+	forStateVar := "(for state)"
+	forControlVar := "(for control)"
+
+	fi.enterScope(true)
+
+	cgLocalVarDeclStat(fi, &LocalVarDeclStat{
+		LastLine: node.LineOfDo,
+		NameList: []string{forGeneratorVar, forStateVar, forControlVar},
+		ExpList:  node.ExpList,
+	})
+	for _, name := range node.NameList {
+		fi.addLocVar(name, node.LineOfDo, fi.pc()+2)
+	}
+
+	pcJmpToTFC := fi.emitJmp(node.LineOfDo, 0, 0)
+	cgBlock(fi, node.Block)
+	fi.closeOpenUpvals(node.Block.LastLine)
+	fi.fixSbx(pcJmpToTFC, fi.pc()-pcJmpToTFC)
+
+	line := lineOf(node.ExpList[0])
+	rGenerator := fi.slotOfLocVar(forGeneratorVar)
+	fi.emitTForCall(line, rGenerator, len(node.NameList))
+	fi.emitTForLoop(line, rGenerator+2, pcJmpToTFC-fi.pc()-1)
+
+	fi.exitScope(fi.pc() - 1)
+	fi.fixEndPC(forGeneratorVar, 2)
+	fi.fixEndPC(forStateVar, 2)
+	fi.fixEndPC(forControlVar, 2)
+}
+
+// local namelist [‘=’ explist]
+// 表达式按需多退少补：多余的表达式也要求值，不够的用nil补齐，
+// 最后一个表达式如果是vararg或者函数调用，会展开成多个值
+func cgLocalVarDeclStat(fi *funcInfo, node *LocalVarDeclStat) {
+	exps := node.ExpList
+	nExps := len(exps)
+	nNames := len(node.NameList)
+
+	oldRegs := fi.usedRegs
+	if nExps == nNames {
+		for _, exp := range exps {
+			a := fi.allocReg()
+			cgExp(fi, exp, a, 1)
+		}
+	} else if nExps > nNames {
+		for i, exp := range exps {
+			a := fi.allocReg()
+			if i == nExps-1 && isVarargOrFuncCall(exp) {
+				cgExp(fi, exp, a, 0)
+			} else {
+				cgExp(fi, exp, a, 1)
+			}
+		}
+	} else { // nNames > nExps
+		multRet := false
+		for i, exp := range exps {
+			a := fi.allocReg()
+			if i == nExps-1 && isVarargOrFuncCall(exp) {
+				multRet = true
+				n := nNames - nExps + 1
+				cgExp(fi, exp, a, n)
+				fi.allocRegs(n - 1)
+			} else {
+				cgExp(fi, exp, a, 1)
+			}
+		}
+		if !multRet {
+			n := nNames - nExps
+			a := fi.allocRegs(n)
+			fi.emitLoadNil(node.LastLine, a, n)
+		}
+	}
+
+	fi.usedRegs = oldRegs
+	startPC := fi.pc() + 1
+	for _, name := range node.NameList {
+		fi.addLocVar(name, node.LastLine, startPC)
+	}
+}
+
+// varlist ‘=’ explist
+// 先计算出所有表和键，再计算所有的值，最后再逐个赋值
+func cgAssignStat(fi *funcInfo, node *AssignStat) {
+	exps := node.ExpList
+	nExps := len(exps)
+	nVars := len(node.VarList)
+
+	tRegs := make([]int, nVars)
+	kRegs := make([]int, nVars)
+	vRegs := make([]int, nVars)
+	oldRegs := fi.usedRegs
+
+	for i, exp := range node.VarList {
+		if taExp, ok := exp.(*TableAccessExp); ok {
+			tRegs[i] = fi.allocReg()
+			cgExp(fi, taExp.PrefixExp, tRegs[i], 1)
+			if isAssignedLocal(fi, node.VarList, taExp.KeyExp) {
+				// 键是本语句要赋值的局部变量，先复制一份，
+				// 以免给表赋值时用到的是已经赋过新值的键（参见 §3.3.3）
+				kRegs[i] = fi.allocReg()
+				cgExp(fi, taExp.KeyExp, kRegs[i], 1)
+			} else {
+				kRegs[i], _ = expToOpArg(fi, taExp.KeyExp, ARG_RK)
+			}
+		} else {
+			name := exp.(*NameExp).Name
+			if fi.slotOfLocVar(name) < 0 && fi.indexOfUpval(name) < 0 {
+				// global var
+				key := &StringExp{Line: lineOf(exp), Str: name}
+				kRegs[i], _ = expToOpArg(fi, key, ARG_RK)
+			}
+		}
+	}
+	for i := 0; i < nVars; i++ {
+		vRegs[i] = fi.usedRegs + i
+	}
+
+	if nExps >= nVars {
+		for i, exp := range exps {
+			a := fi.allocReg()
+			if i >= nVars && i == nExps-1 && isVarargOrFuncCall(exp) {
+				cgExp(fi, exp, a, 0)
+			} else {
+				cgExp(fi, exp, a, 1)
+			}
+		}
+	} else { // nVars > nExps
+		multRet := false
+		for i, exp := range exps {
+			a := fi.allocReg()
+			if i == nExps-1 && isVarargOrFuncCall(exp) {
+				multRet = true
+				n := nVars - nExps + 1
+				cgExp(fi, exp, a, n)
+				fi.allocRegs(n - 1)
+			} else {
+				cgExp(fi, exp, a, 1)
+			}
+		}
+		if !multRet {
+			n := nVars - nExps
+			a := fi.allocRegs(n)
+			fi.emitLoadNil(node.LastLine, a, n)
+		}
+	}
+
+	lastLine := node.LastLine
+	for i, exp := range node.VarList {
+		if nameExp, ok := exp.(*NameExp); ok {
+			varName := nameExp.Name
+			if a := fi.slotOfLocVar(varName); a >= 0 {
+				fi.emitMove(lastLine, a, vRegs[i])
+			} else if b := fi.indexOfUpval(varName); b >= 0 {
+				fi.emitSetUpval(lastLine, vRegs[i], b)
+			} else if a := fi.slotOfLocVar("_ENV"); a >= 0 {
+				fi.emitSetTable(lastLine, a, kRegs[i], vRegs[i])
+			} else { // global var
+				a := fi.indexOfUpval("_ENV")
+				fi.emitSetTabUp(lastLine, a, kRegs[i], vRegs[i])
+			}
+		} else {
+			fi.emitSetTable(lastLine, tRegs[i], kRegs[i], vRegs[i])
+		}
+	}
+
+	fi.usedRegs = oldRegs
+}
+
+// exp是不是varList里也要赋值的局部变量
+func isAssignedLocal(fi *funcInfo, varList []Exp, exp Exp) bool {
+	if nameExp, ok := exp.(*NameExp); ok && fi.slotOfLocVar(nameExp.Name) >= 0 {
+		for _, v := range varList {
+			if x, ok := v.(*NameExp); ok && x.Name == nameExp.Name {
+				return true
+			}
+		}
+	}
+	return false
+}
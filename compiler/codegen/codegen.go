@@ -0,0 +1,28 @@
+package codegen
+
+import (
+	"luago/binchunk"
+	. "luago/compiler/ast"
+)
+
+/*代码生成*/
+
+// 代码生成器遍历抽象语法树，为每个函数（包括主函数）生成一个funcInfo，
+// 再把funcInfo转换为二进制chunk里的函数原型
+
+// 主函数被当成一个vararg函数处理，它只有一个Upvalue，也就是_ENV。
+// 为了统一处理，我们把主函数看成是另外一个（虚拟的）外围函数的子函数，
+// _ENV就是这个外围函数的局部变量
+func GenProto(chunk *Block, chunkName string) *binchunk.Prototype {
+	fd := &FuncDefExp{
+		LastLine: chunk.LastLine,
+		IsVararg: true,
+		Block:    chunk,
+	}
+
+	fi := newFuncInfo(nil, fd)
+	fi.chunkName = chunkName
+	fi.addLocVar("_ENV", 0, 0)
+	cgFuncDefExp(fi, fd, 0)
+	return toProto(fi.subFuncs[0])
+}
@@ -0,0 +1,142 @@
+package codegen_test
+
+import (
+	. "luago/api"
+	. "luago/auxlib"
+	"luago/compiler/codegen"
+	"luago/compiler/parser"
+	"luago/state"
+	"luago/stdlib"
+	"strings"
+	"testing"
+)
+
+// 编译一段代码，返回编译错误消息（没有错误时返回空串）
+func compileError(chunk string) (msg string) {
+	defer func() {
+		if r := recover(); r != nil {
+			msg = r.(string)
+		}
+	}()
+	codegen.GenProto(parser.Parse(chunk, "=test"), "=test")
+	return ""
+}
+
+// 编译并运行一段代码，返回第一个返回值转换成的字符串
+func run(t *testing.T, chunk string) string {
+	t.Helper()
+	L := state.New()
+	stdlib.OpenLibs(L)
+	if LoadString(L, chunk) != LUA_OK || L.PCall(0, 1, 0) != LUA_OK {
+		t.Fatalf("%s: %s", chunk, L.ToString(-1))
+	}
+	return ToStringMeta(L, -1)
+}
+
+func names(prefix string, n int) []string {
+	list := make([]string, n)
+	for i := range list {
+		list[i] = prefix + string(rune('a'+i%26)) + strings.Repeat("_", i/26)
+	}
+	return list
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		chunk string
+		want  string
+	}{
+		// 表达式
+		{"return 1 + 2 * 3 - 4 / 2", "5.0"},
+		{"return 7 // 2 .. ' ' .. 7 % 3 .. ' ' .. 2^10", "3 1 1024.0"},
+		{"return 2^-3^2 == 2^(-(3^2))", "true"},
+		{"return 1 << 4 | 3 & 2 ~ 1", "19"},
+		{"local a, b = 1, 2 return tostring(a < b) .. tostring(a >= b) .. tostring(a ~= b)", "truefalsetrue"},
+		{"local a, b = nil, 'x' return (a or b) .. (a and 1 or 'y') .. tostring(b and false)", "xyfalse"},
+		{"local t = {} return not nil and #t == 0 and -#'abc' == -3", "true"},
+		{"local a = 'x' return a .. 1 .. 2.5 .. a", "x12.5x"},
+		{"return ('abc'):upper():lower():len()", "3"},
+		{"return " + strings.Repeat("1 .. ", 100) + "1", strings.Repeat("1", 101)},
+		// 左结合的长运算链不能每层占一个寄存器
+		{"local x = 1 return " + strings.Repeat("x + ", 300) + "x", "301"},
+		{"local x = 2 x = " + strings.Repeat("x * ", 20) + "x - x return x", "2097150"},
+		{"local t = {1} return " + strings.Repeat("t[1] + ", 300) + "t[1]", "301"},
+		// 表构造器
+		{"local function f() return 1, 2, 3 end local t = {f()} return #t", "3"},
+		{"local function f() return 1, 2, 3 end local t = {f(), f()} return #t", "4"},
+		{"local function f() return 1, 2, 3 end local t = {(f())} return #t", "1"},
+		{"local t = {x = 1, ['y'] = 2, 3; 4,} return t.x + t.y + t[1] + t[2]", "10"},
+		{"return #{" + strings.Repeat("0, ", 1000) + "}", "1000"},
+		// 赋值
+		{"local a, b = 1, 2 a, b = b, a return a .. b", "21"},
+		{"local t, i = {}, 1 i, t[i] = i + 1, 20 return t[1] .. ' ' .. tostring(t[2])", "20 nil"},
+		{"local a, b, c = (function() return 1, 2 end)() return tostring(c)", "nil"},
+		{"local a, b = 1 return tostring(b)", "nil"},
+		{"x, y = 1 return tostring(y)", "nil"},
+		// 控制结构
+		{"local s = 0 for i = 10, 1, -2 do s = s + i end return s", "30"},
+		{"local s = 0 for i = 1, 2, 0.5 do s = s + i end return s", "4.5"},
+		{"local s = '' for k, v in ipairs({'a', 'b'}) do s = s .. k .. v end return s", "1a2b"},
+		{"local i = 0 repeat local j = i i = i + 1 until j >= 3 return i", "4"},
+		{"local i = 0 while true do i = i + 1 if i > 5 then break end end return i", "6"},
+		{"local s = 0 for i = 1, 3 do for j = 1, 3 do if j > i then break end s = s + 1 end end return s", "6"},
+		{"local s = 0 for i = 1, 5 do if i % 2 == 0 then goto continue end s = s + i ::continue:: end return s", "9"},
+		{"local x = 5 if x < 3 then return 'a' elseif x < 6 then return 'b' else return 'c' end", "b"},
+		// 闭包
+		{"local fs = {} for i = 1, 3 do fs[i] = function() return i end end return fs[1]() + fs[3]()", "4"},
+		{"local fs = {} local i = 1 while i <= 3 do local j = i fs[i] = function() return j end i = i + 1 end return fs[2]()", "2"},
+		{"local function counter() local n = 0 return function() n = n + 1 return n end end local c = counter() c() return c()", "2"},
+		{"local function fib(n) if n < 2 then return n end return fib(n - 1) + fib(n - 2) end return fib(20)", "6765"},
+		{"local a = 1 local function f() local function g() return a end return g end a = 2 return f()()", "2"},
+		// 可变参数和函数调用
+		{"local function f(...) return select('#', ...) end return f(1, nil, 3, nil)", "4"},
+		{"local function f(...) local a, b = ... return b end return f(1, 2, 3)", "2"},
+		{"local function f(...) return ... end return select('#', f(1, 2), f(3, 4))", "3"},
+		{"local o = {v = 42} function o:get() return self.v end return o:get()", "42"},
+		{"local t = {a = {b = {}}} function t.a.b.f(x) return x * 2 end return t.a.b.f(21)", "42"},
+		{"local function f(n) if n == 0 then return 'done' end return f(n - 1) end return f(1e5)", "done"},
+		// 局部变量的个数上限是200
+		{"local " + strings.Join(names("v", 200), ", ") + " = 1 return va", "1"},
+	}
+	for _, tt := range tests {
+		if got := run(t, tt.chunk); got != tt.want {
+			t.Errorf("%.60q: got %q, want %q", tt.chunk, got, tt.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	var upvals []string
+	for _, name := range names("u", 256) {
+		upvals = append(upvals, "local "+name+" = 1")
+	}
+	useUpvals := "return function() return " + strings.Join(names("u", 256), " + ") + " end"
+
+	tests := []struct {
+		chunk string
+		want  string
+	}{
+		{"goto nolabel", "test:1: no visible label 'nolabel' for <goto> at line 1"},
+		{"do goto l end do ::l:: end", "test:1: no visible label 'l' for <goto> at line 1"},
+		{"break", "test:1: <break> at line 1 not inside a loop"},
+		{"::a:: ::a::", "test:1: label 'a' already defined on line 1"},
+		{"goto a; local x; ::a:: print(x)", "test:1: <goto a> at line 1 jumps into the scope of local 'x'"},
+		{"function f() return ... end", "test:1: cannot use '...' outside a vararg function near '...'"},
+		{"local " + strings.Join(names("v", 201), ", "), "test:1: too many local variables (limit is 200) in main function"},
+		{"local function f()\n local " + strings.Join(names("v", 201), ", ") + "\nend",
+			"test:2: too many local variables (limit is 200) in function at line 1"},
+		{strings.Join(upvals[:128], " ") + " local function f() " + strings.Join(upvals[128:], " ") + " " + useUpvals + " end",
+			"test:1: too many upvalues (limit is 255) in function at line 1"},
+		{"return " + strings.Repeat("a, ", 300) + "a", "test:1: function or expression needs too many registers"},
+		// 没有超出上限的不报错
+		{"do goto l end ::l::", ""},
+		{"local " + strings.Join(names("v", 200), ", "), ""},
+		{strings.Join(upvals[:128], " ") + " local function f() " + strings.Join(upvals[128:255], " ") + " " +
+			"return function() return " + strings.Join(names("u", 255), " + ") + " end end", ""},
+	}
+	for _, tt := range tests {
+		if got := compileError(tt.chunk); got != tt.want {
+			t.Errorf("%.60q: error %q, want %q", tt.chunk, got, tt.want)
+		}
+	}
+}
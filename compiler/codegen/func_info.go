@@ -0,0 +1,662 @@
+package codegen
+
+import (
+	"fmt"
+	. "luago/compiler/ast"
+	. "luago/compiler/lexer"
+	. "luago/vm"
+)
+
+var arithAndBitwiseBinops = map[int]int{
+	TOKEN_OP_ADD:  OP_ADD,
+	TOKEN_OP_SUB:  OP_SUB,
+	TOKEN_OP_MUL:  OP_MUL,
+	TOKEN_OP_MOD:  OP_MOD,
+	TOKEN_OP_POW:  OP_POW,
+	TOKEN_OP_DIV:  OP_DIV,
+	TOKEN_OP_IDIV: OP_IDIV,
+	TOKEN_OP_BAND: OP_BAND,
+	TOKEN_OP_BOR:  OP_BOR,
+	TOKEN_OP_BXOR: OP_BXOR,
+	TOKEN_OP_SHL:  OP_SHL,
+	TOKEN_OP_SHR:  OP_SHR,
+}
+
+// 寄存器索引只有8个比特（操作数A），所以一个函数最多使用255个寄存器
+const maxRegs = 255
+
+// 一个函数里同时活跃的局部变量最多200个（MAXVARS），Upvalue最多255个（MAXUPVAL）
+const maxVars = 200
+const maxUpvals = 255
+
+// 如果Upvalue捕获的是直接外围函数的局部变量，locVarSlot记录该局部变量的寄存器索引，
+// 否则upvalIndex记录它在直接外围函数Upvalue表里的索引；index是Upvalue在本函数中的索引
+type upvalInfo struct {
+	locVarSlot int
+	upvalIndex int
+	index      int
+}
+
+type locVarInfo struct {
+	prev     *locVarInfo // 同名局部变量（被遮蔽的那个）
+	name     string
+	scopeLv  int
+	slot     int
+	startPC  int
+	endPC    int
+	captured bool // 是否被闭包捕获
+}
+
+// 标签和goto语句都记录下出现时的作用域层次和活跃局部变量数量（也就是已用寄存器数量），
+// 用于回填跳转偏移和计算需要闭合的Upvalue
+type labelInfo struct {
+	name    string
+	line    int
+	pc      int
+	scopeLv int
+	nActVar int
+}
+
+type gotoInfo struct {
+	name      string
+	line      int
+	pc        int
+	scopeLv   int
+	nActVar   int
+	needClose bool // 跳出了定义有局部变量的块
+}
+
+// 函数编译过程中的内部状态，最终会被转换为函数原型
+type funcInfo struct {
+	chunkName string
+	parent    *funcInfo
+	subFuncs  []*funcInfo
+	usedRegs  int
+	maxRegs   int
+	scopeLv   int
+	locVars   []*locVarInfo
+	locNames  map[string]*locVarInfo
+	upvalues  map[string]upvalInfo
+	constants map[interface{}]int
+	breaks    [][]int
+	blockRegs []int // 进入每一层作用域时已用的寄存器数量
+	labels    []*labelInfo
+	gotos     []*gotoInfo
+	insts     []uint32
+	lineNums  []uint32
+	line      int
+	lastLine  int
+	numParams int
+	isVararg  bool
+}
+
+func newFuncInfo(parent *funcInfo, fd *FuncDefExp) *funcInfo {
+	chunkName := ""
+	if parent != nil {
+		chunkName = parent.chunkName
+	}
+	return &funcInfo{
+		chunkName: chunkName,
+		parent:    parent,
+		subFuncs:  []*funcInfo{},
+		locVars:   make([]*locVarInfo, 0, 8),
+		locNames:  map[string]*locVarInfo{},
+		upvalues:  map[string]upvalInfo{},
+		constants: map[interface{}]int{},
+		breaks:    make([][]int, 1),
+		blockRegs: make([]int, 1),
+		insts:     make([]uint32, 0, 8),
+		lineNums:  make([]uint32, 0, 8),
+		line:      fd.Line,
+		lastLine:  fd.LastLine,
+		numParams: len(fd.ParList),
+		isVararg:  fd.IsVararg,
+	}
+}
+
+// 和词法分析器的error()方法一样，在错误信息前面加上块名和行号
+func (F *funcInfo) error(line int, f string, a ...interface{}) {
+	err := fmt.Sprintf(f, a...)
+	err = fmt.Sprintf("%s:%d: %s", ChunkID(F.chunkName), line, err)
+	panic(err)
+}
+
+// 和官方实现的errorlimit()一样，报告超出了哪一项限制
+func (F *funcInfo) errorLimit(line, limit int, what string) {
+	where := "main function"
+	if F.line > 0 {
+		where = fmt.Sprintf("function at line %d", F.line)
+	}
+	F.error(line, "too many %s (limit is %d) in %s", what, limit, where)
+}
+
+/* constants */
+
+// 返回常量在常量表中的索引，如果常量不在表中，先把它加进去
+func (F *funcInfo) indexOfConstant(k interface{}) int {
+	if idx, found := F.constants[k]; found {
+		return idx
+	}
+
+	idx := len(F.constants)
+	F.constants[k] = idx
+	return idx
+}
+
+/* registers */
+
+func (F *funcInfo) allocReg() int {
+	F.usedRegs++
+	if F.usedRegs >= maxRegs {
+		F.error(F.lastLine, "function or expression needs too many registers")
+	}
+	if F.usedRegs > F.maxRegs {
+		F.maxRegs = F.usedRegs
+	}
+	return F.usedRegs - 1
+}
+
+func (F *funcInfo) freeReg() {
+	if F.usedRegs <= 0 {
+		panic("usedRegs <= 0 !")
+	}
+	F.usedRegs--
+}
+
+func (F *funcInfo) allocRegs(n int) int {
+	if n <= 0 {
+		panic("n <= 0 !")
+	}
+	for i := 0; i < n; i++ {
+		F.allocReg()
+	}
+	return F.usedRegs - n
+}
+
+func (F *funcInfo) freeRegs(n int) {
+	if n < 0 {
+		panic("n < 0 !")
+	}
+	for i := 0; i < n; i++ {
+		F.freeReg()
+	}
+}
+
+/* lexical scope */
+
+// breakable表示该作用域是否是循环块，只有循环块里的break语句需要跳到块的末尾
+func (F *funcInfo) enterScope(breakable bool) {
+	F.scopeLv++
+	if breakable {
+		F.breaks = append(F.breaks, []int{})
+	} else {
+		F.breaks = append(F.breaks, nil)
+	}
+	F.blockRegs = append(F.blockRegs, F.usedRegs)
+}
+
+// 退出作用域时回填break语句的跳转偏移，让块里的局部变量和标签失效，
+// 并把还没有找到标签的goto语句交给外层作用域处理
+func (F *funcInfo) exitScope(endPC int) {
+	pendingBreakJmps := F.breaks[len(F.breaks)-1]
+	F.breaks = F.breaks[:len(F.breaks)-1]
+	for _, pc := range pendingBreakJmps {
+		F.fixSbx(pc, F.pc()-pc)
+	}
+
+	blockRegs := F.blockRegs[len(F.blockRegs)-1]
+	F.blockRegs = F.blockRegs[:len(F.blockRegs)-1]
+	for _, g := range F.gotos {
+		if g.scopeLv == F.scopeLv {
+			g.scopeLv--
+			if g.nActVar > blockRegs {
+				g.nActVar = blockRegs
+				g.needClose = true
+			}
+		}
+	}
+	for len(F.labels) > 0 && F.labels[len(F.labels)-1].scopeLv == F.scopeLv {
+		F.labels = F.labels[:len(F.labels)-1]
+	}
+
+	F.scopeLv--
+	for _, locVar := range F.locNames {
+		if locVar.scopeLv > F.scopeLv { // out of scope
+			F.removeLocVar(locVar, endPC)
+		}
+	}
+}
+
+func (F *funcInfo) removeLocVar(locVar *locVarInfo, endPC int) {
+	F.freeReg()
+	locVar.endPC = endPC
+	if locVar.prev == nil {
+		delete(F.locNames, locVar.name)
+	} else if locVar.prev.scopeLv == locVar.scopeLv {
+		F.removeLocVar(locVar.prev, endPC)
+	} else {
+		F.locNames[locVar.name] = locVar.prev
+	}
+}
+
+// 在当前作用域里声明一个局部变量，为它分配寄存器并返回寄存器索引
+func (F *funcInfo) addLocVar(name string, line, startPC int) int {
+	// 活跃的局部变量总是占据最低的那些寄存器
+	if F.usedRegs >= maxVars {
+		F.errorLimit(line, maxVars, "local variables")
+	}
+	newVar := &locVarInfo{
+		name:    name,
+		prev:    F.locNames[name],
+		scopeLv: F.scopeLv,
+		slot:    F.allocReg(),
+		startPC: startPC,
+		endPC:   0,
+	}
+
+	F.locVars = append(F.locVars, newVar)
+	F.locNames[name] = newVar
+
+	return newVar.slot
+}
+
+func (F *funcInfo) slotOfLocVar(name string) int {
+	if locVar, found := F.locNames[name]; found {
+		return locVar.slot
+	}
+	return -1
+}
+
+// 查找占用给定寄存器的活跃局部变量
+func (F *funcInfo) locVarAtSlot(slot int) *locVarInfo {
+	for _, locVar := range F.locNames {
+		for v := locVar; v != nil; v = v.prev {
+			if v.slot == slot {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+// 修正for循环隐藏变量的结束PC
+func (F *funcInfo) fixEndPC(name string, delta int) {
+	for i := len(F.locVars) - 1; i >= 0; i-- {
+		locVar := F.locVars[i]
+		if locVar.name == name {
+			locVar.endPC += delta
+			return
+		}
+	}
+}
+
+/* break, label and goto */
+
+// break语句跳出最近的循环块，该块里定义的局部变量都要闭合
+func (F *funcInfo) addBreakJmp(line int) {
+	for i := F.scopeLv; i >= 0; i-- {
+		if F.breaks[i] != nil { // breakable
+			a := 0
+			if F.usedRegs > F.blockRegs[i] {
+				a = F.blockRegs[i] + 1
+			}
+			pc := F.emitJmp(line, a, 0)
+			F.breaks[i] = append(F.breaks[i], pc)
+			return
+		}
+	}
+
+	F.error(line, "<break> at line %d not inside a loop", line)
+}
+
+// 如果标签后面（直到块结束）只剩下标签语句，那么认为它处在块的末尾，
+// 块里的局部变量在这里都已经失效，goto语句可以跳过局部变量的声明到达这里
+func (F *funcInfo) addLabel(name string, line int, atBlockEnd bool) {
+	for _, l := range F.labels {
+		if l.name == name && l.scopeLv == F.scopeLv {
+			F.error(line, "label '%s' already defined on line %d", name, l.line)
+		}
+	}
+
+	nActVar := F.usedRegs
+	if atBlockEnd {
+		nActVar = F.blockRegs[F.scopeLv]
+	}
+	label := &labelInfo{
+		name:    name,
+		line:    line,
+		pc:      F.pc() + 1,
+		scopeLv: F.scopeLv,
+		nActVar: nActVar,
+	}
+	F.labels = append(F.labels, label)
+
+	// 向前跳转的goto语句
+	gotos := F.gotos[:0]
+	for _, g := range F.gotos {
+		if g.name == name && g.scopeLv == F.scopeLv {
+			if g.nActVar < label.nActVar {
+				varName := F.locVarAtSlot(g.nActVar).name
+				F.error(g.line, "<goto %s> at line %d jumps into the scope of local '%s'",
+					name, g.line, varName)
+			}
+			F.fixGotoJmp(g.pc, g.nActVar > label.nActVar || g.needClose, label)
+		} else {
+			gotos = append(gotos, g)
+		}
+	}
+	F.gotos = gotos
+}
+
+func (F *funcInfo) addGotoJmp(name string, line int) {
+	// 向后跳转的goto语句，标签已经可见
+	for i := len(F.labels) - 1; i >= 0; i-- {
+		if label := F.labels[i]; label.name == name {
+			pc := F.emitJmp(line, 0, 0)
+			F.fixGotoJmp(pc, F.usedRegs > label.nActVar, label)
+			return
+		}
+	}
+
+	pc := F.emitJmp(line, 0, 0)
+	F.gotos = append(F.gotos, &gotoInfo{
+		name:    name,
+		line:    line,
+		pc:      pc,
+		scopeLv: F.scopeLv,
+		nActVar: F.usedRegs,
+	})
+}
+
+func (F *funcInfo) fixGotoJmp(pc int, needClose bool, label *labelInfo) {
+	a := 0
+	if needClose {
+		a = label.nActVar + 1
+	}
+	sBx := label.pc - pc - 1
+	F.insts[pc] = uint32((sBx+MAXARG_sBx)<<14 | a<<6 | OP_JMP)
+}
+
+// 函数结束时还有没找到标签的goto语句，说明标签不可见
+func (F *funcInfo) checkPendingGotos() {
+	if len(F.gotos) > 0 {
+		g := F.gotos[0]
+		F.error(g.line, "no visible label '%s' for <goto> at line %d", g.name, g.line)
+	}
+}
+
+/* upvalues */
+
+// 如果名字是直接外围函数的局部变量，或者是外围函数已经捕获的Upvalue，
+// 就把它记录为本函数的Upvalue并返回索引，否则返回-1
+func (F *funcInfo) indexOfUpval(name string) int {
+	if upval, ok := F.upvalues[name]; ok {
+		return upval.index
+	}
+	if F.parent != nil {
+		if locVar, found := F.parent.locNames[name]; found {
+			idx := F.newUpvalIndex()
+			F.upvalues[name] = upvalInfo{locVar.slot, -1, idx}
+			locVar.captured = true
+			return idx
+		}
+		if uvIdx := F.parent.indexOfUpval(name); uvIdx >= 0 {
+			idx := F.newUpvalIndex()
+			F.upvalues[name] = upvalInfo{-1, uvIdx, idx}
+			return idx
+		}
+	}
+	return -1
+}
+
+func (F *funcInfo) newUpvalIndex() int {
+	idx := len(F.upvalues)
+	if idx >= maxUpvals {
+		F.errorLimit(F.lastLine, maxUpvals, "upvalues")
+	}
+	return idx
+}
+
+// 循环体的每次迭代、块的结束处都需要闭合被捕获的局部变量
+func (F *funcInfo) closeOpenUpvals(line int) {
+	a := F.getJmpArgA()
+	if a > 0 {
+		F.emitJmp(line, a, 0)
+	}
+}
+
+func (F *funcInfo) getJmpArgA() int {
+	hasCapturedLocVars := false
+	minSlotOfLocVars := F.maxRegs
+	for _, locVar := range F.locNames {
+		for v := locVar; v != nil && v.scopeLv == F.scopeLv; v = v.prev {
+			if v.captured {
+				hasCapturedLocVars = true
+			}
+			if v.slot < minSlotOfLocVars && v.name[0] != '(' {
+				minSlotOfLocVars = v.slot
+			}
+		}
+	}
+	if hasCapturedLocVars {
+		return minSlotOfLocVars + 1
+	} else {
+		return 0
+	}
+}
+
+/* code */
+
+// 返回最后一条指令的索引
+func (F *funcInfo) pc() int {
+	return len(F.insts) - 1
+}
+
+func (F *funcInfo) fixSbx(pc, sBx int) {
+	i := F.insts[pc]
+	i = i << 18 >> 18                  // clear sBx
+	i = i | uint32(sBx+MAXARG_sBx)<<14 // reset sBx
+	F.insts[pc] = i
+}
+
+func (F *funcInfo) emitABC(line, opcode, a, b, c int) {
+	i := b<<23 | c<<14 | a<<6 | opcode
+	F.insts = append(F.insts, uint32(i))
+	F.lineNums = append(F.lineNums, uint32(line))
+}
+
+func (F *funcInfo) emitABx(line, opcode, a, bx int) {
+	i := bx<<14 | a<<6 | opcode
+	F.insts = append(F.insts, uint32(i))
+	F.lineNums = append(F.lineNums, uint32(line))
+}
+
+func (F *funcInfo) emitAsBx(line, opcode, a, b int) {
+	i := (b+MAXARG_sBx)<<14 | a<<6 | opcode
+	F.insts = append(F.insts, uint32(i))
+	F.lineNums = append(F.lineNums, uint32(line))
+}
+
+func (F *funcInfo) emitAx(line, opcode, ax int) {
+	i := ax<<6 | opcode
+	F.insts = append(F.insts, uint32(i))
+	F.lineNums = append(F.lineNums, uint32(line))
+}
+
+// r[a] = r[b]
+func (F *funcInfo) emitMove(line, a, b int) {
+	F.emitABC(line, OP_MOVE, a, b, 0)
+}
+
+// r[a], r[a+1], ..., r[a+b] = nil
+func (F *funcInfo) emitLoadNil(line, a, n int) {
+	F.emitABC(line, OP_LOADNIL, a, n-1, 0)
+}
+
+// r[a] = (bool)b; if (c) pc++
+func (F *funcInfo) emitLoadBool(line, a, b, c int) {
+	F.emitABC(line, OP_LOADBOOL, a, b, c)
+}
+
+// r[a] = kst[bx]
+func (F *funcInfo) emitLoadK(line, a int, k interface{}) {
+	idx := F.indexOfConstant(k)
+	if idx <= MAXARG_Bx {
+		F.emitABx(line, OP_LOADK, a, idx)
+	} else {
+		F.emitABx(line, OP_LOADKX, a, 0)
+		F.emitAx(line, OP_EXTRAARG, idx)
+	}
+}
+
+// r[a], r[a+1], ..., r[a+b-2] = vararg
+func (F *funcInfo) emitVararg(line, a, n int) {
+	F.emitABC(line, OP_VARARG, a, n+1, 0)
+}
+
+// r[a] = emitClosure(proto[bx])
+func (F *funcInfo) emitClosure(line, a, bx int) {
+	F.emitABx(line, OP_CLOSURE, a, bx)
+}
+
+// r[a] = {}
+func (F *funcInfo) emitNewTable(line, a, nArr, nRec int) {
+	F.emitABC(line, OP_NEWTABLE,
+		a, Int2fb(nArr), Int2fb(nRec))
+}
+
+// r[a][(c-1)*FPF+i] := r[a+i], 1 <= i <= b
+// c超出操作数C的表示范围时，批次数放在后面的EXTRAARG指令里
+func (F *funcInfo) emitSetList(line, a, b, c int) {
+	if c <= 0x1FF {
+		F.emitABC(line, OP_SETLIST, a, b, c)
+	} else {
+		F.emitABC(line, OP_SETLIST, a, b, 0)
+		F.emitAx(line, OP_EXTRAARG, c)
+	}
+}
+
+// r[a] := r[b][rk(c)]
+func (F *funcInfo) emitGetTable(line, a, b, c int) {
+	F.emitABC(line, OP_GETTABLE, a, b, c)
+}
+
+// r[a][rk(b)] = rk(c)
+func (F *funcInfo) emitSetTable(line, a, b, c int) {
+	F.emitABC(line, OP_SETTABLE, a, b, c)
+}
+
+// r[a] = upval[b]
+func (F *funcInfo) emitGetUpval(line, a, b int) {
+	F.emitABC(line, OP_GETUPVAL, a, b, 0)
+}
+
+// upval[b] = r[a]
+func (F *funcInfo) emitSetUpval(line, a, b int) {
+	F.emitABC(line, OP_SETUPVAL, a, b, 0)
+}
+
+// r[a] = upval[b][rk(c)]
+func (F *funcInfo) emitGetTabUp(line, a, b, c int) {
+	F.emitABC(line, OP_GETTABUP, a, b, c)
+}
+
+// upval[a][rk(b)] = rk(c)
+func (F *funcInfo) emitSetTabUp(line, a, b, c int) {
+	F.emitABC(line, OP_SETTABUP, a, b, c)
+}
+
+// r[a], ..., r[a+c-2] = r[a](r[a+1], ..., r[a+b-1])
+func (F *funcInfo) emitCall(line, a, nArgs, nRet int) {
+	F.emitABC(line, OP_CALL, a, nArgs+1, nRet+1)
+}
+
+// return r[a](r[a+1], ... ,r[a+b-1])
+func (F *funcInfo) emitTailCall(line, a, nArgs int) {
+	F.emitABC(line, OP_TAILCALL, a, nArgs+1, 0)
+}
+
+// return r[a], ... ,r[a+b-2]
+func (F *funcInfo) emitReturn(line, a, n int) {
+	F.emitABC(line, OP_RETURN, a, n+1, 0)
+}
+
+// r[a+1] := r[b]; r[a] := r[b][rk(c)]
+func (F *funcInfo) emitSelf(line, a, b, c int) {
+	F.emitABC(line, OP_SELF, a, b, c)
+}
+
+// pc+=sBx; if (a) close all upvalues >= r[a - 1]
+func (F *funcInfo) emitJmp(line, a, sBx int) int {
+	F.emitAsBx(line, OP_JMP, a, sBx)
+	return len(F.insts) - 1
+}
+
+// if not (r[a] <=> c) then pc++
+func (F *funcInfo) emitTest(line, a, c int) {
+	F.emitABC(line, OP_TEST, a, 0, c)
+}
+
+// if (r[b] <=> c) then r[a] := r[b] else pc++
+func (F *funcInfo) emitTestSet(line, a, b, c int) {
+	F.emitABC(line, OP_TESTSET, a, b, c)
+}
+
+func (F *funcInfo) emitForPrep(line, a, sBx int) int {
+	F.emitAsBx(line, OP_FORPREP, a, sBx)
+	return len(F.insts) - 1
+}
+
+func (F *funcInfo) emitForLoop(line, a, sBx int) int {
+	F.emitAsBx(line, OP_FORLOOP, a, sBx)
+	return len(F.insts) - 1
+}
+
+func (F *funcInfo) emitTForCall(line, a, c int) {
+	F.emitABC(line, OP_TFORCALL, a, 0, c)
+}
+
+func (F *funcInfo) emitTForLoop(line, a, sBx int) {
+	F.emitAsBx(line, OP_TFORLOOP, a, sBx)
+}
+
+// r[a] = op r[b]
+func (F *funcInfo) emitUnaryOp(line, op, a, b int) {
+	switch op {
+	case TOKEN_OP_NOT:
+		F.emitABC(line, OP_NOT, a, b, 0)
+	case TOKEN_OP_BNOT:
+		F.emitABC(line, OP_BNOT, a, b, 0)
+	case TOKEN_OP_LEN:
+		F.emitABC(line, OP_LEN, a, b, 0)
+	case TOKEN_OP_UNM:
+		F.emitABC(line, OP_UNM, a, b, 0)
+	}
+}
+
+// r[a] = rk[b] op rk[c]
+// arith & bitwise & relational
+// 比较运算没有直接把结果写入寄存器的指令，需要借助JMP和LOADBOOL指令
+func (F *funcInfo) emitBinaryOp(line, op, a, b, c int) {
+	if opcode, found := arithAndBitwiseBinops[op]; found {
+		F.emitABC(line, opcode, a, b, c)
+	} else {
+		switch op {
+		case TOKEN_OP_EQ:
+			F.emitABC(line, OP_EQ, 1, b, c)
+		case TOKEN_OP_NE:
+			F.emitABC(line, OP_EQ, 0, b, c)
+		case TOKEN_OP_LT:
+			F.emitABC(line, OP_LT, 1, b, c)
+		case TOKEN_OP_GT:
+			F.emitABC(line, OP_LT, 1, c, b)
+		case TOKEN_OP_LE:
+			F.emitABC(line, OP_LE, 1, b, c)
+		case TOKEN_OP_GE:
+			F.emitABC(line, OP_LE, 1, c, b)
+		}
+		F.emitJmp(line, 0, 1)
+		F.emitLoadBool(line, a, 0, 1)
+		F.emitLoadBool(line, a, 1, 0)
+	}
+}
@@ -0,0 +1,11 @@
+package compiler
+
+import "luago/binchunk"
+import "luago/compiler/codegen"
+import "luago/compiler/parser"
+
+// 把Lua源代码编译成函数原型，语法错误会以panic的形式抛出
+func Compile(chunk, chunkName string) *binchunk.Prototype {
+	ast := parser.Parse(chunk, chunkName)
+	return codegen.GenProto(ast, chunkName)
+}
@@ -0,0 +1,41 @@
+package lexer
+
+import "strings"
+
+const _LUA_IDSIZE = 60 /* gives the maximum size for the description of the source */
+
+// 把代码块名转换成适合出现在出错信息里的形式
+// lua-5.3.4/src/lobject.c#luaO_chunkid()
+func ChunkID(source string) string {
+	const rets = "..."
+	const pre = "[string \""
+	const pos = "\"]"
+	bufflen := _LUA_IDSIZE
+	if len(source) > 0 && source[0] == '=' { /* 'literal' source */
+		if len(source) <= bufflen { /* small enough? */
+			return source[1:]
+		}
+		return source[1:bufflen] /* truncate it */
+	} else if len(source) > 0 && source[0] == '@' { /* file name */
+		if len(source) <= bufflen { /* small enough? */
+			return source[1:]
+		}
+		/* add '...' before rest of name */
+		bufflen -= len(rets)
+		return rets + source[len(source)-bufflen+1:]
+	} else { /* string; format as [string "source"] */
+		bufflen -= len(pre+rets+pos) + 1       /* save space for prefix+suffix+'\0' */
+		nl := strings.IndexByte(source, '\n')  /* find first new line (if any) */
+		if len(source) < bufflen && nl == -1 { /* small one-line source? */
+			return pre + source + pos /* keep it */
+		}
+		l := len(source)
+		if nl != -1 {
+			l = nl /* stop at first newline */
+		}
+		if l > bufflen {
+			l = bufflen
+		}
+		return pre + source[:l] + rets + pos
+	}
+}
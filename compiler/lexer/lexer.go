@@ -3,8 +3,9 @@ package lexer
 import (
 	"bytes"
 	"fmt"
+	"luago/number"
+	"luago/utf8"
 	"regexp"
-	"strconv"
 	"strings"
 )
 
@@ -36,14 +37,8 @@ import (
 
 var reNewLine = regexp.MustCompile("\r\n|\n\r|\n|\r")
 var reIdentifier = regexp.MustCompile(`^[_\d\w]+`)
-var reNumber = regexp.MustCompile(`^0[xX][0-9a-fA-F]*(\.[0-9a-fA-F]*)?([pP][+\-]?[0-9]+)?|^[0-9]*(\.[0-9]*)?([eE][+\-]?[0-9]+)?`)
-var reShortStr = regexp.MustCompile(`(?s)(^'(\\\\|\\'|\\\n|\\z\s*|[^'\n])*')|(^"(\\\\|\\"|\\\n|\\z\s*|[^"\n])*")`)
 var reOpeningLongBracket = regexp.MustCompile(`^\[=*\[`)
 
-var reDecEscapeSeq = regexp.MustCompile(`^\\[0-9]{1,3}`)
-var reHexEscapeSeq = regexp.MustCompile(`^\\x[0-9a-fA-F]{2}`)
-var reUnicodeEscapeSeq = regexp.MustCompile(`^\\u\{[0-9a-fA-F]+\}`)
-
 type Lexer struct {
 	chunk         string // source code
 	chunkName     string // source name
@@ -51,26 +46,53 @@ type Lexer struct {
 	nextToken     string
 	nextTokenKind int
 	nextTokenLine int
+	nextTokenText string
+	tokenKind     int    // 最近取出的token的类型
+	tokenText     string // 最近取出的token在源代码里的原文，用于生成错误消息
+	level         int    // 语法分析器当前的嵌套层数
 }
 
+// 语法分析器是递归下降的，嵌套层数要有上限（LUAI_MAXCCALLS），
+// 否则很深的嵌套会耗尽Go的栈
+const maxLevels = 200
+
 func NewLexer(chunk, chunkName string) *Lexer {
-	return &Lexer{chunk, chunkName, 1, "", 0, 0}
+	return &Lexer{chunk: chunk, chunkName: chunkName, line: 1}
 }
 
 func (L *Lexer) Line() int {
 	return L.line
 }
 
+func (L *Lexer) ChunkName() string {
+	return L.chunkName
+}
+
+// 语法分析器进入一层嵌套（块或者子表达式）时调用，超过上限就报告语法错误
+func (L *Lexer) EnterLevel() {
+	L.level++
+	if L.level > maxLevels {
+		L.error("chunk has too many syntax levels")
+	}
+}
+
+func (L *Lexer) LeaveLevel() {
+	L.level--
+}
+
 func (L *Lexer) LookAhead() int {
 	if L.nextTokenLine > 0 {
 		return L.nextTokenKind
 	}
 	currentLine := L.line
+	tokenKind, tokenText := L.tokenKind, L.tokenText
 	line, kind, token := L.NextToken()
 	L.line = currentLine
 	L.nextTokenLine = line
 	L.nextTokenKind = kind
 	L.nextToken = token
+	L.nextTokenText = L.tokenText
+	L.tokenKind, L.tokenText = tokenKind, tokenText
 	return kind
 }
 
@@ -81,11 +103,42 @@ func (L *Lexer) NextIdentifier() (line int, token string) {
 func (L *Lexer) NextTokenOfKind(kind int) (line int, token string) {
 	line, _kind, token := L.NextToken()
 	if kind != _kind {
-		L.error("syntax error near '%s'", token)
+		L.SyntaxError("%s expected", tokenToStr(kind))
 	}
 	return line, token
 }
 
+// 取出和开始token配对的结束token（比如和function配对的end），
+// 两者不在同一行时，错误消息里还要指出开始token在哪一行
+func (L *Lexer) NextClosingToken(what, who, where int) (line int, token string) {
+	line, kind, token := L.NextToken()
+	if kind != what {
+		if line == where {
+			L.SyntaxError("%s expected", tokenToStr(what))
+		} else {
+			L.SyntaxError("%s expected (to close %s at line %d)",
+				tokenToStr(what), tokenToStr(who), where)
+		}
+	}
+	return line, token
+}
+
+// 报告语法错误，和官方实现的luaX_syntaxerror()一样，在消息后面指出出错的token：
+// 如果前瞻过，就是前瞻看到的token，否则就是最近取出的token
+func (L *Lexer) SyntaxError(f string, a ...interface{}) {
+	line, kind, text := L.line, L.tokenKind, L.tokenText
+	if L.nextTokenLine > 0 {
+		line, kind, text = L.nextTokenLine, L.nextTokenKind, L.nextTokenText
+	}
+	near := "<eof>"
+	if kind != TOKEN_EOF {
+		near = "'" + text + "'"
+	}
+	err := fmt.Sprintf(f, a...)
+	err = fmt.Sprintf("%s:%d: %s near %s", ChunkID(L.chunkName), line, err, near)
+	panic(err)
+}
+
 func (L *Lexer) NextToken() (line, kind int, token string) {
 	if L.nextTokenLine > 0 {
 		line = L.nextTokenLine
@@ -93,10 +146,18 @@ func (L *Lexer) NextToken() (line, kind int, token string) {
 		token = L.nextToken
 		L.line = L.nextTokenLine
 		L.nextTokenLine = 0
+		L.tokenKind, L.tokenText = kind, L.nextTokenText
 		return
 	}
 
 	L.skipWhiteSpaces()
+	chunk := L.chunk
+	line, kind, token = L.scanToken()
+	L.tokenKind, L.tokenText = kind, chunk[:len(chunk)-len(L.chunk)]
+	return
+}
+
+func (L *Lexer) scanToken() (line, kind int, token string) {
 	if len(L.chunk) == 0 {
 		return L.line, TOKEN_EOF, "EOF"
 	}
@@ -214,7 +275,7 @@ func (L *Lexer) NextToken() (line, kind int, token string) {
 		}
 	case '[':
 		if L.test("[[") || L.test("[=") {
-			return L.line, TOKEN_STRING, L.scanLongString()
+			return L.line, TOKEN_STRING, L.scanLongString("string")
 		} else {
 			L.next(1)
 			return L.line, TOKEN_SEP_LBRACK, "["
@@ -237,7 +298,11 @@ func (L *Lexer) NextToken() (line, kind int, token string) {
 		}
 	}
 
-	L.error("unexpected symbol near %q", c)
+	// 和官方实现一样，不可打印的字符显示成十进制编码
+	if c >= ' ' && c <= '~' {
+		L.error("unexpected symbol near '%c'", c)
+	}
+	L.error("unexpected symbol near '<\\%d>'", c)
 	return
 }
 
@@ -251,7 +316,7 @@ func (L *Lexer) test(s string) bool {
 
 func (L *Lexer) error(f string, a ...interface{}) {
 	err := fmt.Sprintf(f, a...)
-	err = fmt.Sprintf("%s:%d: %s", L.chunkName, L.line, err)
+	err = fmt.Sprintf("%s:%d: %s", ChunkID(L.chunkName), L.line, err)
 	panic(err)
 }
 
@@ -279,7 +344,7 @@ func (L *Lexer) skipComment() {
 	// long comment ?
 	if L.test("[") {
 		if reOpeningLongBracket.FindString(L.chunk) != "" {
-			L.scanLongString()
+			L.scanLongString("comment")
 			return
 		}
 	}
@@ -294,8 +359,36 @@ func (L *Lexer) scanIdentifier() string {
 	return L.scan(reIdentifier)
 }
 
+// 和官方实现的read_numeral()一样，先读入所有可能属于数字字面量的字符，
+// 再检查格式，所以 3..2 和 1e 这样的写法会被当成一个写错了的数字
 func (L *Lexer) scanNumber() string {
-	return L.scan(reNumber)
+	expo := "Ee"
+	i := 1
+	if L.test("0x") || L.test("0X") {
+		expo = "Pp"
+		i = 2
+	}
+	for i < len(L.chunk) {
+		if c := L.chunk[i]; strings.IndexByte(expo, c) >= 0 {
+			i++
+			if i < len(L.chunk) && (L.chunk[i] == '+' || L.chunk[i] == '-') {
+				i++
+			}
+		} else if isHexDigit(c) || c == '.' {
+			i++
+		} else {
+			break
+		}
+	}
+
+	token := L.chunk[:i]
+	L.next(i)
+	if _, ok := number.ParseInteger(token); !ok {
+		if _, ok := number.ParseFloat(token); !ok {
+			L.error("malformed number near '%s'", token)
+		}
+	}
+	return token
 }
 
 func (L *Lexer) scan(re *regexp.Regexp) string {
@@ -306,17 +399,23 @@ func (L *Lexer) scan(re *regexp.Regexp) string {
 	panic("unreachable!")
 }
 
-func (L *Lexer) scanLongString() string {
+// what是"string"或者"comment"，用于生成错误消息
+func (L *Lexer) scanLongString(what string) string {
 	openingLongBracket := reOpeningLongBracket.FindString(L.chunk)
 	if openingLongBracket == "" {
-		L.error("invalid long string delimiter near '%s'",
-			L.chunk[0:2])
+		n := 1
+		for n < len(L.chunk) && L.chunk[n] == '=' {
+			n++
+		}
+		L.error("invalid long string delimiter near '%s'", L.chunk[:n])
 	}
 
 	closingLongBracket := strings.Replace(openingLongBracket, "[", "]", -1)
 	closingLongBracketIdx := strings.Index(L.chunk, closingLongBracket)
 	if closingLongBracketIdx < 0 {
-		L.error("unfinished long string or comment")
+		line := L.line
+		L.line += len(reNewLine.FindAllString(L.chunk, -1))
+		L.error("unfinished long %s (starting at line %d) near <eof>", what, line)
 	}
 
 	str := L.chunk[len(openingLongBracket):closingLongBracketIdx]
@@ -331,113 +430,162 @@ func (L *Lexer) scanLongString() string {
 	return str
 }
 
+// 逐个字符读入短字符串，顺便处理转义序列。和官方实现的read_string()一样，
+// 缓冲区里保留开头的引号，出错时把已经读到的内容放进错误消息
 func (L *Lexer) scanShortString() string {
-	if str := reShortStr.FindString(L.chunk); str != "" {
-		L.next(len(str))
-		str = str[1 : len(str)-1]
-		if strings.Index(str, `\`) >= 0 {
-			L.line += len(reNewLine.FindAllString(str, -1))
-			str = L.escape(str)
+	var buf bytes.Buffer
+	delimiter := L.chunk[0]
+	buf.WriteByte(delimiter)
+	L.next(1)
+
+	for {
+		if len(L.chunk) == 0 {
+			L.error("unfinished string near <eof>")
+		}
+		switch c := L.chunk[0]; {
+		case c == delimiter:
+			L.next(1)
+			return buf.String()[1:]
+		case isNewLine(c):
+			L.error("unfinished string near '%s'", buf.String())
+		case c == '\\':
+			L.escape(&buf)
+		default:
+			buf.WriteByte(c)
+			L.next(1)
 		}
-		return str
 	}
-	L.error("unfinished string")
-	return ""
 }
 
-func (L *Lexer) escape(str string) string {
-	var buf bytes.Buffer
+// 处理一个转义序列，把转义得到的字符写入缓冲区。
+// 转义序列的原文先写进缓冲区，出错时会出现在错误消息里
+func (L *Lexer) escape(buf *bytes.Buffer) {
+	start := buf.Len()
+	buf.WriteByte('\\')
+	L.next(1)
+	if len(L.chunk) == 0 {
+		L.error("unfinished string near <eof>")
+	}
 
-	for len(str) > 0 {
-		if str[0] != '\\' {
-			buf.WriteByte(str[0])
-			str = str[1:]
-			continue
+	var c byte
+	switch L.chunk[0] {
+	case 'a':
+		c = '\a'
+	case 'b':
+		c = '\b'
+	case 'f':
+		c = '\f'
+	case 'n':
+		c = '\n'
+	case 'r':
+		c = '\r'
+	case 't':
+		c = '\t'
+	case 'v':
+		c = '\v'
+	case '"', '\'', '\\':
+		c = L.chunk[0]
+	case '\n', '\r': // 反斜杠后面直接换行
+		L.skipNewLine()
+		buf.Truncate(start)
+		buf.WriteByte('\n')
+		return
+	case 'x': // \xXX
+		r := L.hexDigit(buf) << 4
+		r += L.hexDigit(buf)
+		c = byte(r)
+	case 'u': // \u{XXX}
+		str := L.utf8Escape(buf)
+		buf.Truncate(start)
+		buf.Write(str)
+		return
+	case 'z': // 跳过后面的空白字符（包括换行）
+		L.next(1)
+		for len(L.chunk) > 0 && isWhiteSpace(L.chunk[0]) {
+			if isNewLine(L.chunk[0]) {
+				L.skipNewLine()
+			} else {
+				L.next(1)
+			}
 		}
-
-		if len(str) == 1 {
-			L.error("unfinished string")
+		buf.Truncate(start)
+		return
+	default: // \ddd
+		if !isDigit(L.chunk[0]) {
+			L.escapeError(buf, "invalid escape sequence")
 		}
+		r := 0
+		for i := 0; i < 3 && len(L.chunk) > 0 && isDigit(L.chunk[0]); i++ {
+			r = r*10 + int(L.chunk[0]-'0')
+			buf.WriteByte(L.chunk[0])
+			L.next(1)
+		}
+		if r > 0xFF {
+			L.escapeError(buf, "decimal escape too large")
+		}
+		buf.Truncate(start)
+		buf.WriteByte(byte(r))
+		return
+	}
+	L.next(1)
+	buf.Truncate(start)
+	buf.WriteByte(c)
+}
 
-		switch str[1] {
-		case 'a':
-			buf.WriteByte('\a')
-			str = str[2:]
-			continue
-		case 'b':
-			buf.WriteByte('\b')
-			str = str[2:]
-			continue
-		case 'f':
-			buf.WriteByte('\f')
-			str = str[2:]
-			continue
-		case 'n', '\n':
-			buf.WriteByte('\n')
-			str = str[2:]
-			continue
-		case 'r':
-			buf.WriteByte('\r')
-			str = str[2:]
-			continue
-		case 't':
-			buf.WriteByte('\t')
-			str = str[2:]
-			continue
-		case 'v':
-			buf.WriteByte('\v')
-			str = str[2:]
-			continue
-		case '"':
-			buf.WriteByte('"')
-			str = str[2:]
-			continue
-		case '\'':
-			buf.WriteByte('\'')
-			str = str[2:]
-			continue
-		case '\\':
-			buf.WriteByte('\\')
-			str = str[2:]
-			continue
-		case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9': // \ddd
-			if found := reDecEscapeSeq.FindString(str); found != "" {
-				d, _ := strconv.ParseInt(found[1:], 10, 32)
-				if d <= 0xFF {
-					buf.WriteByte(byte(d))
-					str = str[len(found):]
-					continue
-				}
-				L.error("decimal escape too large near '%s'", found)
-			}
-		case 'x': // \xXX
-			if found := reHexEscapeSeq.FindString(str); found != "" {
-				d, _ := strconv.ParseInt(found[2:], 16, 32)
-				buf.WriteByte(byte(d))
-				str = str[len(found):]
-				continue
-			}
-		case 'u': // \u{XXX}
-			if found := reUnicodeEscapeSeq.FindString(str); found != "" {
-				d, err := strconv.ParseInt(found[3:len(found)-1], 16, 32)
-				if err == nil && d <= 0x10FFFF {
-					buf.WriteRune(rune(d))
-					str = str[len(found):]
-					continue
-				}
-				L.error("UTF-8 value too large near '%s'", found)
-			}
-		case 'z':
-			str = str[2:]
-			for len(str) > 0 && isWhiteSpace(str[0]) { // todo
-				str = str[1:]
-			}
-			continue
+// 把当前字符写入缓冲区，返回下一个十六进制数字的值
+func (L *Lexer) hexDigit(buf *bytes.Buffer) int {
+	buf.WriteByte(L.chunk[0])
+	L.next(1)
+	if len(L.chunk) == 0 || !isHexDigit(L.chunk[0]) {
+		L.escapeError(buf, "hexadecimal digit expected")
+	}
+	return hexValue(L.chunk[0])
+}
+
+// 和官方实现一样接受最大0x7FFFFFFF的码点，
+// 不能用WriteRune()，它会把无效的码点替换成U+FFFD
+func (L *Lexer) utf8Escape(buf *bytes.Buffer) []byte {
+	buf.WriteByte('u')
+	L.next(1)
+	if len(L.chunk) == 0 || L.chunk[0] != '{' {
+		L.escapeError(buf, "missing '{'")
+	}
+	r := L.hexDigit(buf)
+	for {
+		buf.WriteByte(L.chunk[0])
+		L.next(1)
+		if len(L.chunk) == 0 || !isHexDigit(L.chunk[0]) {
+			break
+		}
+		if r > utf8.MAXUTF>>4 {
+			L.escapeError(buf, "UTF-8 value too large")
 		}
-		L.error("invalid escape sequence near '\\%c'", str[1])
+		r = r<<4 + hexValue(L.chunk[0])
+	}
+	if len(L.chunk) == 0 || L.chunk[0] != '}' {
+		L.escapeError(buf, "missing '}'")
+	}
+	L.next(1)
+	return utf8.Encode(uint32(r))
+}
+
+// 出错的字符也放进错误消息
+func (L *Lexer) escapeError(buf *bytes.Buffer, msg string) {
+	if len(L.chunk) > 0 {
+		buf.WriteByte(L.chunk[0])
+		L.next(1)
 	}
+	L.error("%s near '%s'", msg, buf.String())
+}
 
-	return buf.String()
+// 跳过一个换行符序列（\n、\r、\r\n或者\n\r）
+func (L *Lexer) skipNewLine() {
+	if L.test("\r\n") || L.test("\n\r") {
+		L.next(2)
+	} else {
+		L.next(1)
+	}
+	L.line += 1
 }
 
 func isWhiteSpace(c byte) bool {
@@ -459,3 +607,14 @@ func isDigit(c byte) bool {
 func isLetter(c byte) bool {
 	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
 }
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func hexValue(c byte) int {
+	if isDigit(c) {
+		return int(c - '0')
+	}
+	return int(c|0x20-'a') + 10
+}
@@ -121,3 +121,63 @@ var keywords = map[string]int{
 	"until":    TOKEN_KW_UNTIL,
 	"while":    TOKEN_KW_WHILE,
 }
+
+var tokenSymbols = map[int]string{
+	TOKEN_VARARG:     "...",
+	TOKEN_SEP_SEMI:   ";",
+	TOKEN_SEP_COMMA:  ",",
+	TOKEN_SEP_DOT:    ".",
+	TOKEN_SEP_COLON:  ":",
+	TOKEN_SEP_LABEL:  "::",
+	TOKEN_SEP_LPAREN: "(",
+	TOKEN_SEP_RPAREN: ")",
+	TOKEN_SEP_LBRACK: "[",
+	TOKEN_SEP_RBRACK: "]",
+	TOKEN_SEP_LCURLY: "{",
+	TOKEN_SEP_RCURLY: "}",
+	TOKEN_OP_ASSIGN:  "=",
+	TOKEN_OP_MINUS:   "-",
+	TOKEN_OP_WAVE:    "~",
+	TOKEN_OP_ADD:     "+",
+	TOKEN_OP_MUL:     "*",
+	TOKEN_OP_DIV:     "/",
+	TOKEN_OP_IDIV:    "//",
+	TOKEN_OP_POW:     "^",
+	TOKEN_OP_MOD:     "%",
+	TOKEN_OP_BAND:    "&",
+	TOKEN_OP_BOR:     "|",
+	TOKEN_OP_SHR:     ">>",
+	TOKEN_OP_SHL:     "<<",
+	TOKEN_OP_CONCAT:  "..",
+	TOKEN_OP_LT:      "<",
+	TOKEN_OP_LE:      "<=",
+	TOKEN_OP_GT:      ">",
+	TOKEN_OP_GE:      ">=",
+	TOKEN_OP_EQ:      "==",
+	TOKEN_OP_NE:      "~=",
+	TOKEN_OP_LEN:     "#",
+}
+
+// 错误消息里token类型的写法，和官方实现的luaX_token2str()一样，
+// 符号和关键字加引号，<eof>、<name>这些不加
+func tokenToStr(kind int) string {
+	switch kind {
+	case TOKEN_EOF:
+		return "<eof>"
+	case TOKEN_IDENTIFIER:
+		return "<name>"
+	case TOKEN_NUMBER:
+		return "<number>"
+	case TOKEN_STRING:
+		return "<string>"
+	}
+	if symbol, found := tokenSymbols[kind]; found {
+		return "'" + symbol + "'"
+	}
+	for keyword, k := range keywords {
+		if k == kind {
+			return "'" + keyword + "'"
+		}
+	}
+	panic("unreachable!")
+}
@@ -0,0 +1,222 @@
+package parser
+
+import (
+	. "luago/compiler/ast"
+	. "luago/compiler/lexer"
+	"luago/number"
+	"math"
+)
+
+// 常量折叠：如果运算符两边都是常量，那么在编译期就把结果算出来。
+// 和官方实现一样，结果是NaN或者0.0（避免-0问题）的浮点运算不做折叠，
+// 除数为0的除法和取模也留到运行时处理
+
+// a or b
+func optimizeLogicalOr(exp *BinopExp) Exp {
+	if isTrue(exp.Exp1) {
+		return exp.Exp1 // true or x => true
+	}
+	if isFalse(exp.Exp1) && !isVarargOrFuncCall(exp.Exp2) {
+		return exp.Exp2 // false or x => x
+	}
+	return exp
+}
+
+// a and b
+func optimizeLogicalAnd(exp *BinopExp) Exp {
+	if isFalse(exp.Exp1) {
+		return exp.Exp1 // false and x => false
+	}
+	if isTrue(exp.Exp1) && !isVarargOrFuncCall(exp.Exp2) {
+		return exp.Exp2 // true and x => x
+	}
+	return exp
+}
+
+// & | ~ << >>
+func optimizeBitwiseBinaryOp(exp *BinopExp) Exp {
+	if i, ok := castToInt(exp.Exp1); ok {
+		if j, ok := castToInt(exp.Exp2); ok {
+			switch exp.Op {
+			case TOKEN_OP_BAND:
+				return &IntegerExp{Line: exp.Line, Val: i & j}
+			case TOKEN_OP_BOR:
+				return &IntegerExp{Line: exp.Line, Val: i | j}
+			case TOKEN_OP_BXOR:
+				return &IntegerExp{Line: exp.Line, Val: i ^ j}
+			case TOKEN_OP_SHL:
+				return &IntegerExp{Line: exp.Line, Val: number.ShiftLeft(i, j)}
+			case TOKEN_OP_SHR:
+				return &IntegerExp{Line: exp.Line, Val: number.ShiftRight(i, j)}
+			}
+		}
+	}
+	return exp
+}
+
+// + - * / // %
+func optimizeArithBinaryOp(exp *BinopExp) Exp {
+	if x, ok := exp.Exp1.(*IntegerExp); ok {
+		if y, ok := exp.Exp2.(*IntegerExp); ok {
+			switch exp.Op {
+			case TOKEN_OP_ADD:
+				return &IntegerExp{Line: exp.Line, Val: x.Val + y.Val}
+			case TOKEN_OP_SUB:
+				return &IntegerExp{Line: exp.Line, Val: x.Val - y.Val}
+			case TOKEN_OP_MUL:
+				return &IntegerExp{Line: exp.Line, Val: x.Val * y.Val}
+			case TOKEN_OP_IDIV:
+				if y.Val != 0 {
+					return &IntegerExp{Line: exp.Line, Val: number.IFloorDiv(x.Val, y.Val)}
+				}
+			case TOKEN_OP_MOD:
+				if y.Val != 0 {
+					return &IntegerExp{Line: exp.Line, Val: number.IMod(x.Val, y.Val)}
+				}
+			}
+		}
+	}
+	if f, ok := castToFloat(exp.Exp1); ok {
+		if g, ok := castToFloat(exp.Exp2); ok && g != 0 {
+			var r float64
+			switch exp.Op {
+			case TOKEN_OP_ADD:
+				r = f + g
+			case TOKEN_OP_SUB:
+				r = f - g
+			case TOKEN_OP_MUL:
+				r = f * g
+			case TOKEN_OP_DIV:
+				r = f / g
+			case TOKEN_OP_IDIV:
+				r = number.FFloorDiv(f, g)
+			case TOKEN_OP_MOD:
+				r = number.FMod(f, g)
+			default:
+				return exp
+			}
+			if _isFoldable(r) {
+				return &FloatExp{Line: exp.Line, Val: r}
+			}
+		}
+	}
+	return exp
+}
+
+// a ^ b
+func optimizePow(exp Exp) Exp {
+	if binop, ok := exp.(*BinopExp); ok && binop.Op == TOKEN_OP_POW {
+		if f, ok := castToFloat(binop.Exp1); ok {
+			if g, ok := castToFloat(binop.Exp2); ok {
+				if r := math.Pow(f, g); _isFoldable(r) {
+					return &FloatExp{Line: binop.Line, Val: r}
+				}
+			}
+		}
+	}
+	return exp
+}
+
+// - not ~
+func optimizeUnaryOp(exp *UnopExp) Exp {
+	switch exp.Op {
+	case TOKEN_OP_UNM:
+		return optimizeUnm(exp)
+	case TOKEN_OP_NOT:
+		return optimizeNot(exp)
+	case TOKEN_OP_BNOT:
+		return optimizeBnot(exp)
+	default:
+		return exp
+	}
+}
+
+func optimizeUnm(exp *UnopExp) Exp {
+	switch x := exp.Exp.(type) { // number?
+	case *IntegerExp:
+		x.Val = -x.Val
+		return x
+	case *FloatExp:
+		if _isFoldable(x.Val) {
+			x.Val = -x.Val
+			return x
+		}
+	}
+	return exp
+}
+
+func optimizeNot(exp *UnopExp) Exp {
+	switch exp.Exp.(type) {
+	case *NilExp, *FalseExp: // false
+		return &TrueExp{Line: exp.Line}
+	case *TrueExp, *IntegerExp, *FloatExp, *StringExp: // true
+		return &FalseExp{Line: exp.Line}
+	default:
+		return exp
+	}
+}
+
+func optimizeBnot(exp *UnopExp) Exp {
+	switch x := exp.Exp.(type) { // number?
+	case *IntegerExp:
+		x.Val = ^x.Val
+		return x
+	case *FloatExp:
+		if i, ok := number.FloatToInteger(x.Val); ok {
+			return &IntegerExp{Line: x.Line, Val: ^i}
+		}
+	}
+	return exp
+}
+
+func isFalse(exp Exp) bool {
+	switch exp.(type) {
+	case *FalseExp, *NilExp:
+		return true
+	default:
+		return false
+	}
+}
+
+func isTrue(exp Exp) bool {
+	switch exp.(type) {
+	case *TrueExp, *IntegerExp, *FloatExp, *StringExp:
+		return true
+	default:
+		return false
+	}
+}
+
+func isVarargOrFuncCall(exp Exp) bool {
+	switch exp.(type) {
+	case *VarargExp, *FuncCallExp:
+		return true
+	}
+	return false
+}
+
+func castToInt(exp Exp) (int64, bool) {
+	switch x := exp.(type) {
+	case *IntegerExp:
+		return x.Val, true
+	case *FloatExp:
+		return number.FloatToInteger(x.Val)
+	default:
+		return 0, false
+	}
+}
+
+func castToFloat(exp Exp) (float64, bool) {
+	switch x := exp.(type) {
+	case *IntegerExp:
+		return float64(x.Val), true
+	case *FloatExp:
+		return x.Val, true
+	default:
+		return 0, false
+	}
+}
+
+func _isFoldable(f float64) bool {
+	return !math.IsNaN(f) && f != 0
+}
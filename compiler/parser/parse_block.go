@@ -0,0 +1,64 @@
+package parser
+
+import (
+	. "luago/compiler/ast"
+	. "luago/compiler/lexer"
+)
+
+// block ::= {stat} [retstat]
+func parseBlock(lexer *Lexer) *Block {
+	lexer.EnterLevel()
+	block := &Block{
+		Stats:    parseStats(lexer),
+		RetExps:  parseRetExps(lexer),
+		LastLine: lexer.Line(),
+	}
+	lexer.LeaveLevel()
+	return block
+}
+
+// 循环调用parseStat()解析语句，直到遇到关键字return或者块结束为止
+func parseStats(lexer *Lexer) []Stat {
+	stats := make([]Stat, 0, 8)
+	for !_isReturnOrBlockEnd(lexer.LookAhead()) {
+		stat := parseStat(lexer)
+		if _, ok := stat.(*EmptyStat); !ok {
+			stats = append(stats, stat)
+		}
+	}
+	return stats
+}
+
+func _isReturnOrBlockEnd(tokenKind int) bool {
+	switch tokenKind {
+	case TOKEN_KW_RETURN, TOKEN_EOF, TOKEN_KW_END,
+		TOKEN_KW_ELSE, TOKEN_KW_ELSEIF, TOKEN_KW_UNTIL:
+		return true
+	}
+	return false
+}
+
+// retstat ::= return [explist] [‘;’]
+// explist ::= exp {‘,’ exp}
+// 返回值为nil表示没有返回语句，空切片表示返回语句不带任何表达式
+func parseRetExps(lexer *Lexer) []Exp {
+	if lexer.LookAhead() != TOKEN_KW_RETURN {
+		return nil
+	}
+
+	lexer.NextToken()
+	switch lexer.LookAhead() {
+	case TOKEN_EOF, TOKEN_KW_END,
+		TOKEN_KW_ELSE, TOKEN_KW_ELSEIF, TOKEN_KW_UNTIL:
+		return []Exp{}
+	case TOKEN_SEP_SEMI:
+		lexer.NextToken()
+		return []Exp{}
+	default:
+		exps := parseExpList(lexer)
+		if lexer.LookAhead() == TOKEN_SEP_SEMI {
+			lexer.NextToken()
+		}
+		return exps
+	}
+}
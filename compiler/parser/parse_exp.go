@@ -0,0 +1,344 @@
+package parser
+
+import (
+	. "luago/compiler/ast"
+	. "luago/compiler/lexer"
+	"luago/number"
+)
+
+// 运算符优先级（从低到高）：
+// or
+// and
+// <     >     <=    >=    ~=    ==
+// |
+// ~
+// &
+// <<    >>
+// ..                （右结合）
+// +     -
+// *     /     //    %
+// unary operators (not   #     -     ~)
+// ^                 （右结合）
+// 每一个优先级对应一个parseExpN()函数，N越大优先级越低
+
+// explist ::= exp {‘,’ exp}
+func parseExpList(lexer *Lexer) []Exp {
+	exps := make([]Exp, 0, 4)
+	exps = append(exps, parseExp(lexer))
+	for lexer.LookAhead() == TOKEN_SEP_COMMA {
+		lexer.NextToken()
+		exps = append(exps, parseExp(lexer))
+	}
+	return exps
+}
+
+/*
+exp ::=  nil | false | true | Numeral | LiteralString | ‘...’ | functiondef |
+
+	prefixexp | tableconstructor | exp binop exp | unop exp
+*/
+func parseExp(lexer *Lexer) Exp {
+	return parseExp12(lexer)
+}
+
+// x or y
+func parseExp12(lexer *Lexer) Exp {
+	exp := parseExp11(lexer)
+	for lexer.LookAhead() == TOKEN_OP_OR {
+		line, op, _ := lexer.NextToken()
+		lor := &BinopExp{Line: line, Op: op, Exp1: exp, Exp2: parseExp11(lexer)}
+		exp = optimizeLogicalOr(lor)
+	}
+	return exp
+}
+
+// x and y
+func parseExp11(lexer *Lexer) Exp {
+	exp := parseExp10(lexer)
+	for lexer.LookAhead() == TOKEN_OP_AND {
+		line, op, _ := lexer.NextToken()
+		land := &BinopExp{Line: line, Op: op, Exp1: exp, Exp2: parseExp10(lexer)}
+		exp = optimizeLogicalAnd(land)
+	}
+	return exp
+}
+
+// compare
+func parseExp10(lexer *Lexer) Exp {
+	exp := parseExp9(lexer)
+	for {
+		switch lexer.LookAhead() {
+		case TOKEN_OP_LT, TOKEN_OP_GT, TOKEN_OP_NE,
+			TOKEN_OP_LE, TOKEN_OP_GE, TOKEN_OP_EQ:
+			line, op, _ := lexer.NextToken()
+			exp = &BinopExp{Line: line, Op: op, Exp1: exp, Exp2: parseExp9(lexer)}
+		default:
+			return exp
+		}
+	}
+}
+
+// x | y
+func parseExp9(lexer *Lexer) Exp {
+	exp := parseExp8(lexer)
+	for lexer.LookAhead() == TOKEN_OP_BOR {
+		line, op, _ := lexer.NextToken()
+		bor := &BinopExp{Line: line, Op: op, Exp1: exp, Exp2: parseExp8(lexer)}
+		exp = optimizeBitwiseBinaryOp(bor)
+	}
+	return exp
+}
+
+// x ~ y
+func parseExp8(lexer *Lexer) Exp {
+	exp := parseExp7(lexer)
+	for lexer.LookAhead() == TOKEN_OP_BXOR {
+		line, op, _ := lexer.NextToken()
+		bxor := &BinopExp{Line: line, Op: op, Exp1: exp, Exp2: parseExp7(lexer)}
+		exp = optimizeBitwiseBinaryOp(bxor)
+	}
+	return exp
+}
+
+// x & y
+func parseExp7(lexer *Lexer) Exp {
+	exp := parseExp6(lexer)
+	for lexer.LookAhead() == TOKEN_OP_BAND {
+		line, op, _ := lexer.NextToken()
+		band := &BinopExp{Line: line, Op: op, Exp1: exp, Exp2: parseExp6(lexer)}
+		exp = optimizeBitwiseBinaryOp(band)
+	}
+	return exp
+}
+
+// shift
+func parseExp6(lexer *Lexer) Exp {
+	exp := parseExp5(lexer)
+	for {
+		switch lexer.LookAhead() {
+		case TOKEN_OP_SHL, TOKEN_OP_SHR:
+			line, op, _ := lexer.NextToken()
+			shx := &BinopExp{Line: line, Op: op, Exp1: exp, Exp2: parseExp5(lexer)}
+			exp = optimizeBitwiseBinaryOp(shx)
+		default:
+			return exp
+		}
+	}
+}
+
+// a .. b
+// 拼接运算符是右结合的，而且连续的拼接可以用一条CONCAT指令完成，
+// 所以把连续的拼接表达式收集到一个ConcatExp里
+func parseExp5(lexer *Lexer) Exp {
+	exp := parseExp4(lexer)
+	if lexer.LookAhead() != TOKEN_OP_CONCAT {
+		return exp
+	}
+
+	line := 0
+	exps := []Exp{exp}
+	for lexer.LookAhead() == TOKEN_OP_CONCAT {
+		line, _, _ = lexer.NextToken()
+		exps = append(exps, parseExp4(lexer))
+	}
+	return &ConcatExp{Line: line, Exps: exps}
+}
+
+// x +/- y
+func parseExp4(lexer *Lexer) Exp {
+	exp := parseExp3(lexer)
+	for {
+		switch lexer.LookAhead() {
+		case TOKEN_OP_ADD, TOKEN_OP_SUB:
+			line, op, _ := lexer.NextToken()
+			arith := &BinopExp{Line: line, Op: op, Exp1: exp, Exp2: parseExp3(lexer)}
+			exp = optimizeArithBinaryOp(arith)
+		default:
+			return exp
+		}
+	}
+}
+
+// *, %, /, //
+func parseExp3(lexer *Lexer) Exp {
+	exp := parseExp2(lexer)
+	for {
+		switch lexer.LookAhead() {
+		case TOKEN_OP_MUL, TOKEN_OP_MOD, TOKEN_OP_DIV, TOKEN_OP_IDIV:
+			line, op, _ := lexer.NextToken()
+			arith := &BinopExp{Line: line, Op: op, Exp1: exp, Exp2: parseExp2(lexer)}
+			exp = optimizeArithBinaryOp(arith)
+		default:
+			return exp
+		}
+	}
+}
+
+// unary
+// 圆括号、表构造器、一元运算符和乘方的右操作数嵌套时都会递归回到这里，
+// 所以在这里统计表达式的嵌套层数
+func parseExp2(lexer *Lexer) Exp {
+	lexer.EnterLevel()
+	var exp Exp
+	switch lexer.LookAhead() {
+	case TOKEN_OP_UNM, TOKEN_OP_BNOT, TOKEN_OP_LEN, TOKEN_OP_NOT:
+		line, op, _ := lexer.NextToken()
+		unop := &UnopExp{Line: line, Op: op, Exp: parseExp2(lexer)}
+		exp = optimizeUnaryOp(unop)
+	default:
+		exp = parseExp1(lexer)
+	}
+	lexer.LeaveLevel()
+	return exp
+}
+
+// x ^ y
+// 乘方运算符是右结合的，并且优先级比一元运算符高，
+// 所以右操作数要按一元运算表达式来解析（2^-3 合法）
+func parseExp1(lexer *Lexer) Exp {
+	exp := parseExp0(lexer)
+	if lexer.LookAhead() == TOKEN_OP_POW {
+		line, op, _ := lexer.NextToken()
+		exp = &BinopExp{Line: line, Op: op, Exp1: exp, Exp2: parseExp2(lexer)}
+	}
+	return optimizePow(exp)
+}
+
+func parseExp0(lexer *Lexer) Exp {
+	switch lexer.LookAhead() {
+	case TOKEN_VARARG: // ...
+		line, _, _ := lexer.NextToken()
+		return &VarargExp{Line: line}
+	case TOKEN_KW_NIL: // nil
+		line, _, _ := lexer.NextToken()
+		return &NilExp{Line: line}
+	case TOKEN_KW_TRUE: // true
+		line, _, _ := lexer.NextToken()
+		return &TrueExp{Line: line}
+	case TOKEN_KW_FALSE: // false
+		line, _, _ := lexer.NextToken()
+		return &FalseExp{Line: line}
+	case TOKEN_STRING: // LiteralString
+		line, _, token := lexer.NextToken()
+		return &StringExp{Line: line, Str: token}
+	case TOKEN_NUMBER: // Numeral
+		return parseNumberExp(lexer)
+	case TOKEN_SEP_LCURLY: // tableconstructor
+		return parseTableConstructorExp(lexer)
+	case TOKEN_KW_FUNCTION: // functiondef
+		lexer.NextToken()
+		return parseFuncDefExp(lexer)
+	default: // prefixexp
+		return parsePrefixExp(lexer)
+	}
+}
+
+// 不超出整数范围的数字字面量被解释成整数，否则解释成浮点数
+func parseNumberExp(lexer *Lexer) Exp {
+	line, _, token := lexer.NextToken()
+	if i, ok := number.ParseInteger(token); ok {
+		return &IntegerExp{Line: line, Val: i}
+	} else if f, ok := number.ParseFloat(token); ok {
+		return &FloatExp{Line: line, Val: f}
+	} else { // 词法分析器已经检查过格式
+		lexer.SyntaxError("malformed number")
+		return nil
+	}
+}
+
+// functiondef ::= function funcbody
+// funcbody ::= ‘(’ [parlist] ‘)’ block end
+func parseFuncDefExp(lexer *Lexer) *FuncDefExp {
+	line := lexer.Line()                                                         // function
+	lexer.NextTokenOfKind(TOKEN_SEP_LPAREN)                                      // (
+	parList, isVararg := _parseParList(lexer)                                    // [parlist]
+	lexer.NextTokenOfKind(TOKEN_SEP_RPAREN)                                      // )
+	block := parseBlock(lexer)                                                   // block
+	lastLine, _ := lexer.NextClosingToken(TOKEN_KW_END, TOKEN_KW_FUNCTION, line) // end
+	return &FuncDefExp{Line: line, LastLine: lastLine, ParList: parList, IsVararg: isVararg, Block: block}
+}
+
+// [parlist]
+// parlist ::= namelist [‘,’ ‘...’] | ‘...’
+func _parseParList(lexer *Lexer) (names []string, isVararg bool) {
+	if lexer.LookAhead() == TOKEN_SEP_RPAREN {
+		return nil, false
+	}
+
+	for {
+		switch lexer.LookAhead() {
+		case TOKEN_IDENTIFIER:
+			_, name := lexer.NextIdentifier()
+			names = append(names, name)
+		case TOKEN_VARARG:
+			lexer.NextToken()
+			return names, true
+		default:
+			lexer.SyntaxError("<name> or '...' expected")
+		}
+		if lexer.LookAhead() != TOKEN_SEP_COMMA {
+			return names, false
+		}
+		lexer.NextToken()
+	}
+}
+
+// tableconstructor ::= ‘{’ [fieldlist] ‘}’
+func parseTableConstructorExp(lexer *Lexer) *TableConstructorExp {
+	line, _ := lexer.NextTokenOfKind(TOKEN_SEP_LCURLY)                              // {
+	keyExps, valExps := _parseFieldList(lexer)                                      // [fieldlist]
+	lastLine, _ := lexer.NextClosingToken(TOKEN_SEP_RCURLY, TOKEN_SEP_LCURLY, line) // }
+	return &TableConstructorExp{Line: line, LastLine: lastLine, KeyExps: keyExps, ValExps: valExps}
+}
+
+// fieldlist ::= field {fieldsep field} [fieldsep]
+func _parseFieldList(lexer *Lexer) (ks, vs []Exp) {
+	if lexer.LookAhead() != TOKEN_SEP_RCURLY {
+		k, v := _parseField(lexer)
+		ks = append(ks, k)
+		vs = append(vs, v)
+
+		for _isFieldSep(lexer.LookAhead()) {
+			lexer.NextToken()
+			if lexer.LookAhead() != TOKEN_SEP_RCURLY {
+				k, v := _parseField(lexer)
+				ks = append(ks, k)
+				vs = append(vs, v)
+			} else {
+				break
+			}
+		}
+	}
+	return
+}
+
+// fieldsep ::= ‘,’ | ‘;’
+func _isFieldSep(tokenKind int) bool {
+	return tokenKind == TOKEN_SEP_COMMA || tokenKind == TOKEN_SEP_SEMI
+}
+
+// field ::= ‘[’ exp ‘]’ ‘=’ exp | Name ‘=’ exp | exp
+// 对于 exp 形式的字段，键表达式为nil
+func _parseField(lexer *Lexer) (k, v Exp) {
+	if lexer.LookAhead() == TOKEN_SEP_LBRACK {
+		lexer.NextToken()                       // [
+		k = parseExp(lexer)                     // exp
+		lexer.NextTokenOfKind(TOKEN_SEP_RBRACK) // ]
+		lexer.NextTokenOfKind(TOKEN_OP_ASSIGN)  // =
+		v = parseExp(lexer)                     // exp
+		return
+	}
+
+	exp := parseExp(lexer)
+	if nameExp, ok := exp.(*NameExp); ok {
+		if lexer.LookAhead() == TOKEN_OP_ASSIGN {
+			// Name ‘=’ exp => ‘[’ LiteralString ‘]’ = exp
+			lexer.NextToken()
+			k = &StringExp{Line: nameExp.Line, Str: nameExp.Name}
+			v = parseExp(lexer)
+			return
+		}
+	}
+
+	return nil, exp
+}
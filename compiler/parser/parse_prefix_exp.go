@@ -0,0 +1,110 @@
+package parser
+
+import (
+	. "luago/compiler/ast"
+	. "luago/compiler/lexer"
+)
+
+// prefixexp ::= var | functioncall | ‘(’ exp ‘)’
+// var ::=  Name | prefixexp ‘[’ exp ‘]’ | prefixexp ‘.’ Name
+// functioncall ::=  prefixexp args | prefixexp ‘:’ Name args
+
+// 上面的规则是左递归的，改写一下就可以用递归下降的方式解析了：
+// prefixexp ::= Name
+//
+//	| ‘(’ exp ‘)’
+//	| prefixexp ‘[’ exp ‘]’
+//	| prefixexp ‘.’ Name
+//	| prefixexp [‘:’ Name] args
+//
+// 也就是先解析出Name或者圆括号表达式，然后循环处理后面的表访问和函数调用
+func parsePrefixExp(lexer *Lexer) Exp {
+	var exp Exp
+	switch lexer.LookAhead() {
+	case TOKEN_IDENTIFIER:
+		line, name := lexer.NextIdentifier() // Name
+		exp = &NameExp{Line: line, Name: name}
+	case TOKEN_SEP_LPAREN: // ‘(’ exp ‘)’
+		exp = parseParensExp(lexer)
+	default:
+		lexer.SyntaxError("unexpected symbol")
+	}
+	return _finishPrefixExp(lexer, exp)
+}
+
+func parseParensExp(lexer *Lexer) Exp {
+	line, _ := lexer.NextTokenOfKind(TOKEN_SEP_LPAREN)               // (
+	exp := parseExp(lexer)                                           // exp
+	lexer.NextClosingToken(TOKEN_SEP_RPAREN, TOKEN_SEP_LPAREN, line) // )
+
+	// 圆括号会改变vararg和函数调用的返回值数量（只取第一个），
+	// 也会让var表达式不能再出现在赋值语句的左边，所以这几种情况要保留圆括号
+	switch exp.(type) {
+	case *VarargExp, *FuncCallExp, *NameExp, *TableAccessExp:
+		return &ParensExp{Exp: exp}
+	}
+
+	// no need to keep parens
+	return exp
+}
+
+func _finishPrefixExp(lexer *Lexer, exp Exp) Exp {
+	for {
+		switch lexer.LookAhead() {
+		case TOKEN_SEP_LBRACK: // prefixexp ‘[’ exp ‘]’
+			lexer.NextToken()                       // ‘[’
+			keyExp := parseExp(lexer)               // exp
+			lexer.NextTokenOfKind(TOKEN_SEP_RBRACK) // ‘]’
+			exp = &TableAccessExp{LastLine: lexer.Line(), PrefixExp: exp, KeyExp: keyExp}
+		case TOKEN_SEP_DOT: // prefixexp ‘.’ Name
+			lexer.NextToken()                    // ‘.’
+			line, name := lexer.NextIdentifier() // Name
+			keyExp := &StringExp{Line: line, Str: name}
+			exp = &TableAccessExp{LastLine: line, PrefixExp: exp, KeyExp: keyExp}
+		case TOKEN_SEP_COLON, // prefixexp ‘:’ Name args
+			TOKEN_SEP_LPAREN, TOKEN_SEP_LCURLY, TOKEN_STRING: // prefixexp args
+			exp = _finishFuncCallExp(lexer, exp)
+		default:
+			return exp
+		}
+	}
+}
+
+// functioncall ::=  prefixexp args | prefixexp ‘:’ Name args
+func _finishFuncCallExp(lexer *Lexer, prefixExp Exp) *FuncCallExp {
+	nameExp := _parseNameExp(lexer)
+	line := lexer.Line()
+	args := _parseArgs(lexer)
+	lastLine := lexer.Line()
+	return &FuncCallExp{Line: line, LastLine: lastLine, PrefixExp: prefixExp, NameExp: nameExp, Args: args}
+}
+
+// [‘:’ Name]
+func _parseNameExp(lexer *Lexer) *StringExp {
+	if lexer.LookAhead() == TOKEN_SEP_COLON {
+		lexer.NextToken()
+		line, name := lexer.NextIdentifier()
+		return &StringExp{Line: line, Str: name}
+	}
+	return nil
+}
+
+// args ::=  ‘(’ [explist] ‘)’ | tableconstructor | LiteralString
+func _parseArgs(lexer *Lexer) (args []Exp) {
+	switch lexer.LookAhead() {
+	case TOKEN_SEP_LPAREN: // ‘(’ [explist] ‘)’
+		line, _, _ := lexer.NextToken() // TOKEN_SEP_LPAREN
+		if lexer.LookAhead() != TOKEN_SEP_RPAREN {
+			args = parseExpList(lexer)
+		}
+		lexer.NextClosingToken(TOKEN_SEP_RPAREN, TOKEN_SEP_LPAREN, line)
+	case TOKEN_SEP_LCURLY: // ‘{’ [fieldlist] ‘}’
+		args = []Exp{parseTableConstructorExp(lexer)}
+	case TOKEN_STRING: // LiteralString
+		line, _, str := lexer.NextToken()
+		args = []Exp{&StringExp{Line: line, Str: str}}
+	default: // ‘:’ Name 后面没有参数
+		lexer.SyntaxError("function arguments expected")
+	}
+	return
+}
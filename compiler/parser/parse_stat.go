@@ -0,0 +1,346 @@
+package parser
+
+import (
+	. "luago/compiler/ast"
+	. "luago/compiler/lexer"
+)
+
+var _statEmpty = &EmptyStat{}
+
+/*
+stat ::=  ‘;’
+	| break
+	| ‘::’ Name ‘::’
+	| goto Name
+	| do block end
+	| while exp do block end
+	| repeat block until exp
+	| if exp then block {elseif exp then block} [else block] end
+	| for Name ‘=’ exp ‘,’ exp [‘,’ exp] do block end
+	| for namelist in explist do block end
+	| function funcname funcbody
+	| local function Name funcbody
+	| local namelist [‘=’ explist]
+	| varlist ‘=’ explist
+	| functioncall
+*/
+// 根据前瞻看到的token类型选择具体的解析函数
+func parseStat(lexer *Lexer) Stat {
+	switch lexer.LookAhead() {
+	case TOKEN_SEP_SEMI:
+		return parseEmptyStat(lexer)
+	case TOKEN_KW_BREAK:
+		return parseBreakStat(lexer)
+	case TOKEN_SEP_LABEL:
+		return parseLabelStat(lexer)
+	case TOKEN_KW_GOTO:
+		return parseGotoStat(lexer)
+	case TOKEN_KW_DO:
+		return parseDoStat(lexer)
+	case TOKEN_KW_WHILE:
+		return parseWhileStat(lexer)
+	case TOKEN_KW_REPEAT:
+		return parseRepeatStat(lexer)
+	case TOKEN_KW_IF:
+		return parseIfStat(lexer)
+	case TOKEN_KW_FOR:
+		return parseForStat(lexer)
+	case TOKEN_KW_FUNCTION:
+		return parseFuncDefStat(lexer)
+	case TOKEN_KW_LOCAL:
+		return parseLocalAssignOrFuncDefStat(lexer)
+	default:
+		return parseAssignOrFuncCallStat(lexer)
+	}
+}
+
+// ;
+func parseEmptyStat(lexer *Lexer) *EmptyStat {
+	lexer.NextTokenOfKind(TOKEN_SEP_SEMI)
+	return _statEmpty
+}
+
+// break
+func parseBreakStat(lexer *Lexer) *BreakStat {
+	lexer.NextTokenOfKind(TOKEN_KW_BREAK)
+	return &BreakStat{Line: lexer.Line()}
+}
+
+// ‘::’ Name ‘::’
+func parseLabelStat(lexer *Lexer) *LabelStat {
+	lexer.NextTokenOfKind(TOKEN_SEP_LABEL) // ::
+	line, name := lexer.NextIdentifier()   // name
+	lexer.NextTokenOfKind(TOKEN_SEP_LABEL) // ::
+	return &LabelStat{Line: line, Name: name}
+}
+
+// goto Name
+func parseGotoStat(lexer *Lexer) *GotoStat {
+	line, _ := lexer.NextTokenOfKind(TOKEN_KW_GOTO) // goto
+	_, name := lexer.NextIdentifier()               // name
+	return &GotoStat{Line: line, Name: name}
+}
+
+// do block end
+func parseDoStat(lexer *Lexer) *DoStat {
+	line, _ := lexer.NextTokenOfKind(TOKEN_KW_DO)           // do
+	block := parseBlock(lexer)                              // block
+	lexer.NextClosingToken(TOKEN_KW_END, TOKEN_KW_DO, line) // end
+	return &DoStat{Block: block}
+}
+
+// while exp do block end
+func parseWhileStat(lexer *Lexer) *WhileStat {
+	line, _ := lexer.NextTokenOfKind(TOKEN_KW_WHILE)           // while
+	exp := parseExp(lexer)                                     // exp
+	lexer.NextTokenOfKind(TOKEN_KW_DO)                         // do
+	block := parseBlock(lexer)                                 // block
+	lexer.NextClosingToken(TOKEN_KW_END, TOKEN_KW_WHILE, line) // end
+	return &WhileStat{Exp: exp, Block: block}
+}
+
+// repeat block until exp
+func parseRepeatStat(lexer *Lexer) *RepeatStat {
+	line, _ := lexer.NextTokenOfKind(TOKEN_KW_REPEAT)             // repeat
+	block := parseBlock(lexer)                                    // block
+	lexer.NextClosingToken(TOKEN_KW_UNTIL, TOKEN_KW_REPEAT, line) // until
+	exp := parseExp(lexer)                                        // exp
+	return &RepeatStat{Block: block, Exp: exp}
+}
+
+// if exp then block {elseif exp then block} [else block] end
+// else分支被当成条件为true的elseif分支处理
+func parseIfStat(lexer *Lexer) *IfStat {
+	exps := make([]Exp, 0, 4)
+	blocks := make([]*Block, 0, 4)
+
+	line, _ := lexer.NextTokenOfKind(TOKEN_KW_IF) // if
+	exps = append(exps, parseExp(lexer))          // exp
+	lexer.NextTokenOfKind(TOKEN_KW_THEN)          // then
+	blocks = append(blocks, parseBlock(lexer))    // block
+
+	for lexer.LookAhead() == TOKEN_KW_ELSEIF {
+		lexer.NextToken()                          // elseif
+		exps = append(exps, parseExp(lexer))       // exp
+		lexer.NextTokenOfKind(TOKEN_KW_THEN)       // then
+		blocks = append(blocks, parseBlock(lexer)) // block
+	}
+
+	// else block => elseif true then block
+	if lexer.LookAhead() == TOKEN_KW_ELSE {
+		lexer.NextToken()                                 // else
+		exps = append(exps, &TrueExp{Line: lexer.Line()}) //
+		blocks = append(blocks, parseBlock(lexer))        // block
+	}
+
+	lexer.NextClosingToken(TOKEN_KW_END, TOKEN_KW_IF, line) // end
+	return &IfStat{Exps: exps, Blocks: blocks}
+}
+
+// for Name ‘=’ exp ‘,’ exp [‘,’ exp] do block end
+// for namelist in explist do block end
+// 两种for循环都以 for Name 开头，需要再往前看一个token才能区分
+func parseForStat(lexer *Lexer) Stat {
+	lineOfFor, _ := lexer.NextTokenOfKind(TOKEN_KW_FOR)
+	_, name := lexer.NextIdentifier()
+	switch lexer.LookAhead() {
+	case TOKEN_OP_ASSIGN:
+		return _finishForNumStat(lexer, lineOfFor, name)
+	case TOKEN_SEP_COMMA, TOKEN_KW_IN:
+		return _finishForInStat(lexer, lineOfFor, name)
+	default:
+		lexer.SyntaxError("'=' or 'in' expected")
+		return nil
+	}
+}
+
+// for Name ‘=’ exp ‘,’ exp [‘,’ exp] do block end
+func _finishForNumStat(lexer *Lexer, lineOfFor int, varName string) *ForNumStat {
+	lexer.NextTokenOfKind(TOKEN_OP_ASSIGN) // for name =
+	initExp := parseExp(lexer)             // exp
+	lexer.NextTokenOfKind(TOKEN_SEP_COMMA) // ,
+	limitExp := parseExp(lexer)            // exp
+
+	var stepExp Exp
+	if lexer.LookAhead() == TOKEN_SEP_COMMA {
+		lexer.NextToken()         // ,
+		stepExp = parseExp(lexer) // exp
+	} else {
+		stepExp = &IntegerExp{Line: lexer.Line(), Val: 1}
+	}
+
+	lineOfDo, _ := lexer.NextTokenOfKind(TOKEN_KW_DO)             // do
+	block := parseBlock(lexer)                                    // block
+	lexer.NextClosingToken(TOKEN_KW_END, TOKEN_KW_FOR, lineOfFor) // end
+
+	return &ForNumStat{
+		LineOfFor: lineOfFor,
+		LineOfDo:  lineOfDo,
+		VarName:   varName,
+		InitExp:   initExp,
+		LimitExp:  limitExp,
+		StepExp:   stepExp,
+		Block:     block,
+	}
+}
+
+// for namelist in explist do block end
+// namelist ::= Name {‘,’ Name}
+// explist ::= exp {‘,’ exp}
+func _finishForInStat(lexer *Lexer, lineOfFor int, name0 string) *ForInStat {
+	nameList := _finishNameList(lexer, name0)                     // for namelist
+	lexer.NextTokenOfKind(TOKEN_KW_IN)                            // in
+	expList := parseExpList(lexer)                                // explist
+	lineOfDo, _ := lexer.NextTokenOfKind(TOKEN_KW_DO)             // do
+	block := parseBlock(lexer)                                    // block
+	lexer.NextClosingToken(TOKEN_KW_END, TOKEN_KW_FOR, lineOfFor) // end
+	return &ForInStat{LineOfDo: lineOfDo, NameList: nameList, ExpList: expList, Block: block}
+}
+
+// namelist ::= Name {‘,’ Name}
+func _finishNameList(lexer *Lexer, name0 string) []string {
+	names := []string{name0}
+	for lexer.LookAhead() == TOKEN_SEP_COMMA {
+		lexer.NextToken()                 // ,
+		_, name := lexer.NextIdentifier() // Name
+		names = append(names, name)
+	}
+	return names
+}
+
+// local function Name funcbody
+// local namelist [‘=’ explist]
+func parseLocalAssignOrFuncDefStat(lexer *Lexer) Stat {
+	lexer.NextTokenOfKind(TOKEN_KW_LOCAL)
+	if lexer.LookAhead() == TOKEN_KW_FUNCTION {
+		return _finishLocalFuncDefStat(lexer)
+	} else {
+		return _finishLocalVarDeclStat(lexer)
+	}
+}
+
+/*
+http://www.lua.org/manual/5.3/manual.html#3.4.11
+
+function f() end          =>  f = function() end
+function t.a.b.c.f() end  =>  t.a.b.c.f = function() end
+function t.a.b.c:f() end  =>  t.a.b.c.f = function(self) end
+local function f() end    =>  local f; f = function() end
+
+The statement `local function f () body end`
+translates to `local f; f = function () body end`
+not to `local f = function () body end`
+(This only makes a difference when the body of the function
+ contains references to f.)
+*/
+// local function Name funcbody
+func _finishLocalFuncDefStat(lexer *Lexer) *LocalFuncDefStat {
+	lexer.NextTokenOfKind(TOKEN_KW_FUNCTION) // local function
+	_, name := lexer.NextIdentifier()        // name
+	fdExp := parseFuncDefExp(lexer)          // funcbody
+	return &LocalFuncDefStat{Name: name, Exp: fdExp}
+}
+
+// local namelist [‘=’ explist]
+func _finishLocalVarDeclStat(lexer *Lexer) *LocalVarDeclStat {
+	_, name0 := lexer.NextIdentifier()        // local Name
+	nameList := _finishNameList(lexer, name0) // { , Name }
+	var expList []Exp = nil
+	if lexer.LookAhead() == TOKEN_OP_ASSIGN {
+		lexer.NextToken()             // =
+		expList = parseExpList(lexer) // explist
+	}
+	lastLine := lexer.Line()
+	return &LocalVarDeclStat{LastLine: lastLine, NameList: nameList, ExpList: expList}
+}
+
+// varlist ‘=’ explist
+// functioncall
+// 赋值语句和函数调用语句都以前缀表达式开头，先解析前缀表达式，
+// 后面跟着‘=’或者‘,’就是赋值语句，否则必须是函数调用语句
+func parseAssignOrFuncCallStat(lexer *Lexer) Stat {
+	prefixExp := parsePrefixExp(lexer)
+	switch lexer.LookAhead() {
+	case TOKEN_OP_ASSIGN, TOKEN_SEP_COMMA:
+		return parseAssignStat(lexer, prefixExp)
+	}
+	if fc, ok := prefixExp.(*FuncCallExp); ok {
+		return fc
+	}
+	lexer.SyntaxError("syntax error")
+	return nil
+}
+
+// varlist ‘=’ explist |
+func parseAssignStat(lexer *Lexer, var0 Exp) *AssignStat {
+	varList := _finishVarList(lexer, var0) // varlist
+	lexer.NextTokenOfKind(TOKEN_OP_ASSIGN) // =
+	expList := parseExpList(lexer)         // explist
+	lastLine := lexer.Line()
+	return &AssignStat{LastLine: lastLine, VarList: varList, ExpList: expList}
+}
+
+// varlist ::= var {‘,’ var}
+func _finishVarList(lexer *Lexer, var0 Exp) []Exp {
+	vars := []Exp{_checkVar(lexer, var0)}      // var
+	for lexer.LookAhead() == TOKEN_SEP_COMMA { // {
+		lexer.NextToken()                          // ,
+		exp := parsePrefixExp(lexer)               // var
+		vars = append(vars, _checkVar(lexer, exp)) //
+	} // }
+	return vars
+}
+
+// var ::=  Name | prefixexp ‘[’ exp ‘]’ | prefixexp ‘.’ Name
+func _checkVar(lexer *Lexer, exp Exp) Exp {
+	switch exp.(type) {
+	case *NameExp, *TableAccessExp:
+		return exp
+	}
+	lexer.SyntaxError("syntax error")
+	return nil
+}
+
+// function funcname funcbody
+// funcname ::= Name {‘.’ Name} [‘:’ Name]
+// funcbody ::= ‘(’ [parlist] ‘)’ block end
+// parlist ::= namelist [‘,’ ‘...’] | ‘...’
+// namelist ::= Name {‘,’ Name}
+func parseFuncDefStat(lexer *Lexer) *AssignStat {
+	lexer.NextTokenOfKind(TOKEN_KW_FUNCTION) // function
+	fnExp, hasColon := _parseFuncName(lexer) // funcname
+	fdExp := parseFuncDefExp(lexer)          // funcbody
+	if hasColon {                            // insert self
+		fdExp.ParList = append(fdExp.ParList, "")
+		copy(fdExp.ParList[1:], fdExp.ParList)
+		fdExp.ParList[0] = "self"
+	}
+
+	return &AssignStat{
+		LastLine: fdExp.Line,
+		VarList:  []Exp{fnExp},
+		ExpList:  []Exp{fdExp},
+	}
+}
+
+// funcname ::= Name {‘.’ Name} [‘:’ Name]
+func _parseFuncName(lexer *Lexer) (exp Exp, hasColon bool) {
+	line, name := lexer.NextIdentifier()
+	exp = &NameExp{Line: line, Name: name}
+
+	for lexer.LookAhead() == TOKEN_SEP_DOT {
+		lexer.NextToken()
+		line, name := lexer.NextIdentifier()
+		idx := &StringExp{Line: line, Str: name}
+		exp = &TableAccessExp{LastLine: line, PrefixExp: exp, KeyExp: idx}
+	}
+	if lexer.LookAhead() == TOKEN_SEP_COLON {
+		lexer.NextToken()
+		line, name := lexer.NextIdentifier()
+		idx := &StringExp{Line: line, Str: name}
+		exp = &TableAccessExp{LastLine: line, PrefixExp: exp, KeyExp: idx}
+		hasColon = true
+	}
+
+	return
+}
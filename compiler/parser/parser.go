@@ -1,3 +1,20 @@
 package parser
 
+import (
+	. "luago/compiler/ast"
+	. "luago/compiler/lexer"
+)
+
 /*语法分析*/
+
+// 语法分析器按照Lua语法规则（见ast/block.go里的EBNF描述），把词法分析器
+// 产生的token流组织成抽象语法树（AST）。这里采用递归下降（Recursive Descent）
+// 的方式进行分析，每条语法规则都对应一个parseXxx()函数
+
+// chunk ::= block
+func Parse(chunk, chunkName string) *Block {
+	lexer := NewLexer(chunk, chunkName)
+	block := parseBlock(lexer)
+	lexer.NextTokenOfKind(TOKEN_EOF)
+	return block
+}
@@ -0,0 +1,168 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+// 解析一段代码，返回语法错误消息（没有错误时返回空串）
+func parseError(chunk string) (msg string) {
+	defer func() {
+		if r := recover(); r != nil {
+			msg = r.(string)
+		}
+	}()
+	Parse(chunk, "=test")
+	return ""
+}
+
+// 嵌套太深的代码要报告语法错误，而不是耗尽Go的栈
+func TestNestingLimit(t *testing.T) {
+	const tooDeep = "test:1: chunk has too many syntax levels"
+	tests := []struct {
+		chunk string
+		want  string
+	}{
+		{"return " + strings.Repeat("(", 190) + "1" + strings.Repeat(")", 190), ""},
+		{"return " + strings.Repeat("{", 190) + strings.Repeat("}", 190), ""},
+		{strings.Repeat("do ", 190) + strings.Repeat("end ", 190), ""},
+		{"return " + strings.Repeat("a..", 1000) + "a", ""},
+		{"return " + strings.Repeat("(", 1e6) + "1" + strings.Repeat(")", 1e6), tooDeep},
+		{"return " + strings.Repeat("{", 1e6), tooDeep},
+		{"return " + strings.Repeat("- ", 1e6) + "1", tooDeep},
+		{"return " + strings.Repeat("not ", 1e6) + "1", tooDeep},
+		{"return " + strings.Repeat("2^", 1e6) + "1", tooDeep},
+		{"return " + strings.Repeat("f(", 1e6), tooDeep},
+		{"return " + strings.Repeat("a[", 1e6), tooDeep},
+		{strings.Repeat("do ", 1e6), tooDeep},
+		{strings.Repeat("if x then ", 1e6), tooDeep},
+		{strings.Repeat("function f() ", 1e6), tooDeep},
+	}
+	for _, tt := range tests {
+		if got := parseError(tt.chunk); got != tt.want {
+			t.Errorf("%.30q...: error %q, want %q", tt.chunk, got, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []string{
+		"",
+		";;",
+		"local a, b, c = 1, 2, 3",
+		"local function f(a, b, ...) return ... end",
+		"function a.b.c:d(x) self.x = x end",
+		"a, b.c, d[1] = 1, 2, 3",
+		"f() f{} f'x' f[[x]] f:m() f:m{} f:m'x' f.a.b:c(1)(2)",
+		"for i = 1, 10, 2 do end for k, v in pairs(t) do end",
+		"while x do break end repeat local y until y",
+		"if a then elseif b then else end",
+		"do goto l ::l:: end",
+		"return",
+		"return;",
+		"return 1, 2, 3;",
+		"local t = {[1] = 2, x = 3, 4; 5, f(), ...}",
+		"x = function(...) return select('#', ...) end",
+		"x = 2^-3^2 + -1 .. 'a' .. 'b' < 'c' and not nil or #t ~= 0",
+		"x = 1 // 2 % 3 & 4 | 5 ~ 6 << 7 >> 8",
+		"x = 0x10 + 0xA.8p1 + 1e10 + .5 + 3. + 08",
+		"x = '\\a\\b\\f\\n\\r\\t\\v\\\\\\\"\\'\\65\\x41\\u{41}\\z   \n  x'",
+		"x = 'a\\\nb'",
+		"x = [==[\n]]]=]]==]",
+		"--[[ long\ncomment ]] x = 1 -- short comment",
+	}
+	for _, chunk := range tests {
+		if err := parseError(chunk); err != "" {
+			t.Errorf("%q: %s", chunk, err)
+		}
+	}
+}
+
+// 语法错误消息要和官方实现一致
+func TestSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		chunk string
+		want  string
+	}{
+		// 缺少期望的token
+		{"local 1", "test:1: <name> expected near '1'"},
+		{"local function 1", "test:1: <name> expected near '1'"},
+		{"local x, = 1", "test:1: <name> expected near '='"},
+		{"goto 1", "test:1: <name> expected near '1'"},
+		{"::a:", "test:1: '::' expected near ':'"},
+		{"function f(1) end", "test:1: <name> or '...' expected near '1'"},
+		{"function f(a,) end", "test:1: <name> or '...' expected near ')'"},
+		{"function f(..., a) end", "test:1: ')' expected near ','"},
+		{"function a.b:c.d() end", "test:1: '(' expected near '.'"},
+		{"for i = 1 do end", "test:1: ',' expected near 'do'"},
+		{"for i do end", "test:1: '=' or 'in' expected near 'do'"},
+		{"for a, b = 1, 2 do end", "test:1: 'in' expected near '='"},
+		{"for i = 1, 2, 3, 4 do end", "test:1: 'do' expected near ','"},
+		{"x = t[1", "test:1: ']' expected near <eof>"},
+		{"local function f() end end", "test:1: <eof> expected near 'end'"},
+		{"return 1 x", "test:1: <eof> expected near 'x'"},
+		// 配对的token
+		{"if x then", "test:1: 'end' expected near <eof>"},
+		{"if x then\n\nelse", "test:3: 'end' expected (to close 'if' at line 1) near <eof>"},
+		{"while true do\n", "test:2: 'end' expected (to close 'while' at line 1) near <eof>"},
+		{"for i = 1, 2 do\n", "test:2: 'end' expected (to close 'for' at line 1) near <eof>"},
+		{"do\n", "test:2: 'end' expected (to close 'do' at line 1) near <eof>"},
+		{"x = function()\n", "test:2: 'end' expected (to close 'function' at line 1) near <eof>"},
+		{"repeat x = 1", "test:1: 'until' expected near <eof>"},
+		{"repeat\nx = 1", "test:2: 'until' expected (to close 'repeat' at line 1) near <eof>"},
+		{"x = (1", "test:1: ')' expected near <eof>"},
+		{"f(1, 2\n", "test:2: ')' expected (to close '(' at line 1) near <eof>"},
+		{"x = {1 2}", "test:1: '}' expected near '2'"},
+		{"x = {\n1\n2}", "test:3: '}' expected (to close '{' at line 1) near '2'"},
+		// 语句和表达式
+		{"x", "test:1: syntax error near <eof>"},
+		{"a.b.c", "test:1: syntax error near <eof>"},
+		{"a = 1 b", "test:1: syntax error near <eof>"},
+		{"(x) = 1", "test:1: syntax error near '='"},
+		{"f() = 1", "test:1: syntax error near '='"},
+		{"f{} = 1", "test:1: syntax error near '='"},
+		{"a, f() = 1", "test:1: syntax error near '='"},
+		{"1 = 2", "test:1: unexpected symbol near '1'"},
+		{"x = ", "test:1: unexpected symbol near <eof>"},
+		{"x = }", "test:1: unexpected symbol near '}'"},
+		{"x = y = 1", "test:1: unexpected symbol near '='"},
+		{"return return", "test:1: unexpected symbol near 'return'"},
+		{"local a.b = 1", "test:1: unexpected symbol near '.'"},
+		{"if x then elseif end", "test:1: unexpected symbol near 'end'"},
+		{"x = 'a' .. ", "test:1: unexpected symbol near <eof>"},
+		{"x = 1\n+", "test:2: unexpected symbol near <eof>"},
+		{"x.y:z = 1", "test:1: function arguments expected near '='"},
+		{"f:g.h()", "test:1: function arguments expected near '.'"},
+		{"f:g", "test:1: function arguments expected near <eof>"},
+		// 词法错误
+		{"x = @", "test:1: unexpected symbol near '@'"},
+		{"x = \x01", "test:1: unexpected symbol near '<\\1>'"},
+		{"x = 1e", "test:1: malformed number near '1e'"},
+		{"x = 3..2", "test:1: malformed number near '3..2'"},
+		{"x = 0x", "test:1: malformed number near '0x'"},
+		{"x = 0x1p", "test:1: malformed number near '0x1p'"},
+		{"x = 1.2.3", "test:1: malformed number near '1.2.3'"},
+		{"x = 12abc", "test:1: malformed number near '12abc'"},
+		{"x = \"abc", "test:1: unfinished string near <eof>"},
+		{"x = 'a\nb'", "test:1: unfinished string near ''a'"},
+		{"x = 'a\\", "test:1: unfinished string near <eof>"},
+		{"x = [[abc", "test:1: unfinished long string (starting at line 1) near <eof>"},
+		{"x = [==[\n]=]\n", "test:3: unfinished long string (starting at line 1) near <eof>"},
+		{"--[[ abc\n", "test:2: unfinished long comment (starting at line 1) near <eof>"},
+		{"x = [=x", "test:1: invalid long string delimiter near '[='"},
+		{"x = '\\q'", "test:1: invalid escape sequence near ''\\q'"},
+		{"x = 'ab\\300'", "test:1: decimal escape too large near ''ab\\300''"},
+		{"x = '\\xZZ'", "test:1: hexadecimal digit expected near ''\\xZ'"},
+		{"x = '\\x5'", "test:1: hexadecimal digit expected near ''\\x5''"},
+		{"x = '\\u41'", "test:1: missing '{' near ''\\u4'"},
+		{"x = '\\u{zz}'", "test:1: hexadecimal digit expected near ''\\u{z'"},
+		{"x = '\\u{41'", "test:1: missing '}' near ''\\u{41''"},
+		{"x = '\\u{110000000}'", "test:1: UTF-8 value too large near ''\\u{110000000'"},
+		{"x = '\\z\n\n\\q'", "test:3: invalid escape sequence near ''\\q'"},
+	}
+	for _, tt := range tests {
+		if got := parseError(tt.chunk); got != tt.want {
+			t.Errorf("%q: error %q, want %q", tt.chunk, got, tt.want)
+		}
+	}
+}
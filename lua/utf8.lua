@@ -0,0 +1,88 @@
+-- utf8库和词法分析器\u{XXX}转义的一致性测试，全部断言通过时打印"OK"
+
+local function bytes(s)
+  return table.concat({string.byte(s, 1, -1)}, " ")
+end
+
+local function check(code, escaped, expected)
+  local s = utf8.char(code)
+  assert(s == escaped, string.format("utf8.char(0x%X) ~= escape: %s vs %s",
+    code, bytes(s), bytes(escaped)))
+  assert(bytes(s) == expected, string.format("utf8.char(0x%X): %s", code, bytes(s)))
+end
+
+-- 每种编码长度的边界
+check(0x0, "\u{0}", "0")
+check(0x7F, "\u{7F}", "127")
+check(0x80, "\u{80}", "194 128")
+check(0x7FF, "\u{7FF}", "223 191")
+check(0x800, "\u{800}", "224 160 128")
+check(0xFFFF, "\u{FFFF}", "239 191 191")
+check(0x10000, "\u{10000}", "240 144 128 128")
+check(0x10FFFF, "\u{10FFFF}", "244 143 191 191")
+check(0x110000, "\u{110000}", "244 144 128 128")
+check(0x1FFFFF, "\u{1FFFFF}", "247 191 191 191")
+check(0x200000, "\u{200000}", "248 136 128 128 128")
+check(0x3FFFFFF, "\u{3FFFFFF}", "251 191 191 191 191")
+check(0x4000000, "\u{4000000}", "252 132 128 128 128 128")
+check(0x7FFFFFFF, "\u{7FFFFFFF}", "253 191 191 191 191 191")
+-- 代理对也要正常编码
+check(0xD800, "\u{D800}", "237 160 128")
+check(0xDFFF, "\u{DFFF}", "237 191 191")
+-- 前导零
+assert("\u{0000000041}" == "A")
+
+assert(utf8.char(72, 0x20AC, 0x10348) == "H\u{20AC}\u{10348}")
+assert(utf8.char() == "")
+assert(not pcall(utf8.char, 0x80000000))
+assert(not pcall(utf8.char, -1))
+assert(not load('return "\\u{80000000}"'))
+assert(not load('return "\\u{}"'))
+
+-- utf8.len
+local n, pos
+local s = "h\u{E9}llo \u{4E16}\u{754C}"
+assert(utf8.len(s) == 8)
+assert(utf8.len(s, 4) == 6)
+n, pos = utf8.len(s, 3)               -- 从续字节开始
+assert(n == nil and pos == 3)
+assert(utf8.len(s, -3) == 1)
+assert(utf8.len("") == 0)
+n, pos = utf8.len("ab\xFFcd")
+assert(n == nil and pos == 3)
+n, pos = utf8.len("\xC0\x80")         -- 超长编码
+assert(n == nil and pos == 1)
+n, pos = utf8.len("\u{110000}")       -- 解码时只接受到0x10FFFF
+assert(n == nil and pos == 1)
+assert(not pcall(utf8.len, "abc", 5))
+
+-- utf8.codepoint
+assert(utf8.codepoint(s) == 0x68)
+local a, b, c = utf8.codepoint(s, 1, 4)
+assert(a == 0x68 and b == 0xE9 and c == 0x6C)
+assert(select("#", utf8.codepoint(s, 4, 3)) == 0)
+assert(not pcall(utf8.codepoint, "\xFF"))
+assert(not pcall(utf8.codepoint, s, 1, 100))
+
+-- utf8.offset
+assert(utf8.offset(s, 1) == 1)
+assert(utf8.offset(s, 3) == 4)
+assert(utf8.offset(s, -1) == #s - 2)
+assert(utf8.offset(s, -2) == #s - 5)
+assert(utf8.offset(s, 0, 3) == 2)
+assert(utf8.offset(s, 9) == #s + 1)
+assert(utf8.offset(s, 10) == nil)
+assert(not pcall(utf8.offset, s, 1, 3))
+
+-- utf8.codes
+local t = {}
+for p, cp in utf8.codes("a\u{E9}\u{4E16}") do t[#t + 1] = p .. ":" .. cp end
+assert(table.concat(t, " ") == "1:97 2:233 4:19990")
+assert(not pcall(function() for _ in utf8.codes("a\xFF") do end end))
+
+-- utf8.charpattern
+local count = 0
+for _ in string.gmatch(s, utf8.charpattern) do count = count + 1 end
+assert(count == utf8.len(s))
+
+print("OK")
@@ -1,48 +1,194 @@
 package main
 
+import "flag"
 import "fmt"
 import "io/ioutil"
-import . "luago/compiler/lexer"
+import "os"
+import "luago/binchunk"
+import "luago/compiler"
+import . "luago/api"
+import "luago/auxlib"
+import "luago/state"
+import "luago/stdlib"
+import . "luago/vm"
 
+// 用法：luago [-l] [script]
+// 默认打开标准库并执行脚本；加上-l时只编译脚本，以类似luac -l的格式列出指令
 func main() {
+	listOnly := flag.Bool("l", false, "list the compiled chunk instead of running it")
+	flag.Parse()
+
 	chunkName := "./lua/test.lua"
-	data, err := ioutil.ReadFile(chunkName)
-	if err != nil {
-		panic(err)
+	if flag.NArg() > 0 {
+		chunkName = flag.Arg(0)
+	}
+
+	if *listOnly {
+		data, err := ioutil.ReadFile(chunkName)
+		if err != nil {
+			panic(err)
+		}
+		proto := compiler.Compile(string(data), "@"+chunkName)
+		list(proto)
+		return
+	}
+
+	os.Exit(run(chunkName))
+}
+
+// 和lua.c一样，出错时带上栈回溯打印到标准错误，返回退出码
+func run(chunkName string) int {
+	L := state.New()
+	defer L.Close()
+	stdlib.OpenLibs(L)
+	L.PushGoFunction(msgHandler)
+	if status := auxlib.LoadFile(L, chunkName); status != LUA_OK {
+		fmt.Fprintf(os.Stderr, "luago: %s\n", L.ToString(-1))
+		return 1
+	}
+	if status := L.PCall(0, LUA_MULTRET, 1); status != LUA_OK {
+		fmt.Fprintf(os.Stderr, "luago: %s\n", L.ToString(-1))
+		return 1
+	}
+	return 0
+}
+
+// lua-5.3.4/src/lua.c#msghandler()
+func msgHandler(L LuaState) int {
+	msg, ok := L.ToStringX(1)
+	if !ok { /* is error object not a string? */
+		if auxlib.CallMeta(L, 1, "__tostring") && /* does it have a metamethod */
+			L.Type(-1) == LUA_TSTRING { /* that produces a string? */
+			return 1 /* that is the message */
+		}
+		msg = fmt.Sprintf("(error object is a %s value)", L.TypeName(L.Type(1)))
+	}
+	auxlib.Traceback(L, L, msg, 1) /* append a standard traceback */
+	return 1                       /* return the traceback */
+}
+
+// 以类似luac -l的格式打印函数原型
+func list(f *binchunk.Prototype) {
+	printHeader(f)
+	printCode(f)
+	printDetail(f)
+	for _, p := range f.Protos {
+		list(p)
+	}
+}
+
+func printHeader(f *binchunk.Prototype) {
+	funcType := "main"
+	if f.LineDefined > 0 {
+		funcType = "function"
+	}
+
+	varargFlag := ""
+	if f.IsVararg > 0 {
+		varargFlag = "+"
 	}
 
-	testLexer(string(data), chunkName)
+	fmt.Printf("\n%s <%s:%d,%d> (%d instructions)\n",
+		funcType, f.Source, f.LineDefined, f.LastLineDefined, len(f.Code))
+
+	fmt.Printf("%d%s params, %d slots, %d upvalues, ",
+		f.NumParams, varargFlag, f.MaxStackSize, len(f.Upvalues))
+
+	fmt.Printf("%d locals, %d constants, %d functions\n",
+		len(f.LocVars), len(f.Constants), len(f.Protos))
 }
 
-func testLexer(chunk, chunkName string) {
-	lexer := NewLexer(chunk, chunkName)
-	for {
-		line, kind, token := lexer.NextToken()
-		fmt.Printf("[%2d] [%-10s] %s\n",
-			line, kindToCategory(kind), token)
-		if kind == TOKEN_EOF {
-			break
+func printCode(f *binchunk.Prototype) {
+	for pc, c := range f.Code {
+		line := "-"
+		if len(f.LineInfo) > 0 {
+			line = fmt.Sprintf("%d", f.LineInfo[pc])
+		}
+
+		i := Instruction(c)
+		fmt.Printf("\t%d\t[%s]\t%-9s \t", pc+1, line, i.OpName())
+		printOperands(i)
+		fmt.Printf("\n")
+	}
+}
+
+func printOperands(i Instruction) {
+	switch i.OpMode() {
+	case IABC:
+		a, b, c := i.ABC()
+
+		fmt.Printf("%d", a)
+		if i.BMode() != OpArgN {
+			if b > 0xFF {
+				fmt.Printf(" %d", -1-b&0xFF)
+			} else {
+				fmt.Printf(" %d", b)
+			}
+		}
+		if i.CMode() != OpArgN {
+			if c > 0xFF {
+				fmt.Printf(" %d", -1-c&0xFF)
+			} else {
+				fmt.Printf(" %d", c)
+			}
+		}
+	case IABx:
+		a, bx := i.ABx()
+
+		fmt.Printf("%d", a)
+		if i.BMode() == OpArgK {
+			fmt.Printf(" %d", -1-bx)
+		} else if i.BMode() == OpArgU {
+			fmt.Printf(" %d", bx)
 		}
+	case IAsBx:
+		a, sbx := i.AsBx()
+		fmt.Printf("%d %d", a, sbx)
+	case IAx:
+		ax := i.Ax()
+		fmt.Printf("%d", -1-ax)
 	}
 }
 
-func kindToCategory(kind int) string {
-	switch {
-	case kind < TOKEN_SEP_SEMI:
-		return "other"
-	case kind <= TOKEN_SEP_RCURLY:
-		return "separator"
-	case kind <= TOKEN_OP_NOT:
-		return "operator"
-	case kind <= TOKEN_KW_WHILE:
-		return "keyword"
-	case kind == TOKEN_IDENTIFIER:
-		return "identifier"
-	case kind == TOKEN_NUMBER:
-		return "number"
-	case kind == TOKEN_STRING:
-		return "string"
+func printDetail(f *binchunk.Prototype) {
+	fmt.Printf("constants (%d):\n", len(f.Constants))
+	for i, k := range f.Constants {
+		fmt.Printf("\t%d\t%s\n", i+1, constantToString(k))
+	}
+
+	fmt.Printf("locals (%d):\n", len(f.LocVars))
+	for i, locVar := range f.LocVars {
+		fmt.Printf("\t%d\t%s\t%d\t%d\n",
+			i, locVar.VarName, locVar.StartPC+1, locVar.EndPC+1)
+	}
+
+	fmt.Printf("upvalues (%d):\n", len(f.Upvalues))
+	for i, upval := range f.Upvalues {
+		fmt.Printf("\t%d\t%s\t%d\t%d\n",
+			i, upvalName(f, i), upval.Instack, upval.Idx)
+	}
+}
+
+func constantToString(k interface{}) string {
+	switch k.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return fmt.Sprintf("%t", k)
+	case float64:
+		return fmt.Sprintf("%g", k)
+	case int64:
+		return fmt.Sprintf("%d", k)
+	case string:
+		return fmt.Sprintf("%q", k)
 	default:
-		return "other"
+		return "?"
+	}
+}
+
+func upvalName(f *binchunk.Prototype, idx int) string {
+	if len(f.UpvalueNames) > 0 {
+		return f.UpvalueNames[idx]
 	}
+	return "-"
 }
@@ -0,0 +1,29 @@
+package number
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// 和官方实现的lua_Number2str（"%.14g"）一致，如果结果看起来像整数，
+// 就在后面加上".0"，以便和整数区分开
+func FloatToString(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		if math.Signbit(f) {
+			return "-nan"
+		}
+		return "nan"
+	}
+
+	s := fmt.Sprintf("%.14g", f)
+	if !strings.ContainsAny(s, ".eE") {
+		s += ".0"
+	}
+	return s
+}
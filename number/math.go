@@ -4,10 +4,13 @@ import (
 	"math"
 )
 
-// todo: correct?
+// 只有整数值并且在int64范围内的浮点数才能转换成整数，
+// 超出范围时Go的类型转换结果和平台相关，所以要先检查范围（-2^63 <= f < 2^63）
 func FloatToInteger(f float64) (int64, bool) {
-	i := int64(f)
-	return i, float64(i) == f
+	if f >= -(1<<63) && f < 1<<63 && math.Floor(f) == f {
+		return int64(f), true
+	}
+	return 0, false
 }
 
 // a % b == a - ((a // b) * b)
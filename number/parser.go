@@ -2,14 +2,82 @@ package number
 
 import (
 	"strconv"
+	"strings"
 )
 
+// 支持十进制和十六进制（0x前缀）整数，十六进制整数溢出时回绕（和官方实现一致），
+// 十进制整数溢出则解析失败，交给ParseFloat()按浮点数处理
 func ParseInteger(str string) (int64, bool) {
+	str = strings.TrimSpace(str)
+	neg := false
+	if len(str) > 0 && (str[0] == '-' || str[0] == '+') {
+		neg = str[0] == '-'
+		str = str[1:]
+	}
+	if strings.HasPrefix(str, "0x") || strings.HasPrefix(str, "0X") {
+		return parseHexInteger(str[2:], neg)
+	}
+	if len(str) == 0 || strings.IndexAny(str, "+-_") >= 0 {
+		return 0, false
+	}
 	i, err := strconv.ParseInt(str, 10, 64)
+	if err != nil && neg && str == "9223372036854775808" {
+		return -1 << 63, true
+	}
+	if neg {
+		i = -i
+	}
 	return i, err == nil
 }
 
+func parseHexInteger(str string, neg bool) (int64, bool) {
+	if len(str) == 0 {
+		return 0, false
+	}
+	var i int64
+	for _, c := range []byte(str) {
+		if d, ok := parseHexDigit(c); ok {
+			i = i*16 + d
+		} else {
+			return 0, false
+		}
+	}
+	if neg {
+		i = -i
+	}
+	return i, true
+}
+
+func parseHexDigit(c byte) (int64, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return int64(c - '0'), true
+	case c >= 'a' && c <= 'f':
+		return int64(c-'a') + 10, true
+	case c >= 'A' && c <= 'F':
+		return int64(c-'A') + 10, true
+	}
+	return 0, false
+}
+
+// strconv.ParseFloat()能接受"inf"、"nan"这类Lua不认识的写法，需要先排除掉；
+// 另外Go要求十六进制浮点数必须带p指数，Lua则不要求
 func ParseFloat(str string) (float64, bool) {
-	f, err := strconv.ParseFloat(str, 64)
+	str = strings.TrimSpace(str)
+	s := strings.ToLower(str)
+	if strings.Contains(s, "n") || strings.Contains(s, "_") || s == "" {
+		return 0, false // inf, nan
+	}
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "-0x") || strings.HasPrefix(s, "+0x") {
+		if !strings.Contains(s, "p") {
+			s += "p0"
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		if e, ok := err.(*strconv.NumError); ok && e.Err == strconv.ErrRange {
+			return f, true // ±Inf or 0
+		}
+	}
 	return f, err == nil
 }
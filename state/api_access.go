@@ -3,6 +3,7 @@ package state
 import (
 	"fmt"
 	. "luago/api"
+	"luago/number"
 )
 
 // [-0, +0, –]
@@ -125,8 +126,7 @@ func (L *luaState) ToInteger(idx int) int64 {
 //如果 isnum 不是 NULL， *isnum 会被设为操作是否成功
 func (L *luaState) ToIntegerX(idx int) (int64, bool) {
 	val := L.stack.get(idx)
-	i, ok := val.(int64)
-	return i, ok
+	return convertToInteger(val)
 }
 
 // [-0, +0, –]
@@ -143,14 +143,7 @@ func (L *luaState) ToNumber(idx int) float64 {
 //如果 isnum 不是 NULL， *isnum 会被设为操作是否成功
 func (L *luaState) ToNumberX(idx int) (float64, bool) {
 	val := L.stack.get(idx)
-	switch x := val.(type) {
-	case float64:
-		return x, true
-	case int64:
-		return float64(x), true
-	default:
-		return 0, false
-	}
+	return convertToFloat(val)
 }
 
 // [-0, +0, m]
@@ -166,8 +159,12 @@ func (L *luaState) ToStringX(idx int) (string, bool) {
 	switch x := val.(type) {
 	case string:
 		return x, true
-	case int64, float64:
-		s := fmt.Sprintf("%v", x) // todo
+	case int64:
+		s := fmt.Sprintf("%d", x)
+		L.stack.set(idx, s)
+		return s, true
+	case float64:
+		s := number.FloatToString(x)
 		L.stack.set(idx, s)
 		return s, true
 	default:
@@ -195,6 +192,63 @@ func (L *luaState) ToGoFunction(idx int) GoFunction {
 	return nil
 }
 
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_isuserdata
+// 当给定索引的值是一个用户数据（无论是完全的还是轻量的）时，返回 1 ，否则返回 0
+func (L *luaState) IsUserdata(idx int) bool {
+	t := L.Type(idx)
+	return t == LUA_TUSERDATA || t == LUA_TLIGHTUSERDATA
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_islightuserdata
+// 当给定索引的值是一个轻量用户数据时，返回 1 ，否则返回 0
+func (L *luaState) IsLightUserdata(idx int) bool {
+	return L.Type(idx) == LUA_TLIGHTUSERDATA
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_touserdata
+// 如果给定索引处的值是一个完全用户数据， 函数返回它包装的Go值。
+// 如果值是一个轻量用户数据， 那么就返回它表示的Go值。 否则，返回 nil
+func (L *luaState) ToUserdata(idx int) interface{} {
+	switch x := L.stack.get(idx).(type) {
+	case *userdata:
+		return x.data
+	case lightUserdata:
+		return x.p
+	default:
+		return nil
+	}
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_topointer
+// 把给定索引处的值转换为一般的指针 。 这个值可以是一个用户对象，表 ，线程或是一个函数； 否则， lua_topointer 返回 NULL 。
+// 不同的对象有不同的指针。 不存在把指针再转回原有类型的方法。
+// 这个函数通常只用于调试信息
+func (L *luaState) ToPointer(idx int) interface{} {
+	switch x := L.stack.get(idx).(type) {
+	case *luaTable, *closure, *luaState, *userdata:
+		return x
+	case lightUserdata:
+		return x.p
+	default:
+		return nil
+	}
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_tothread
+// 把给定索引处的值转换为一个 Lua 线程 。 这个值必须是一个线程；否则函数返回 nil
+func (L *luaState) ToThread(idx int) LuaState {
+	val := L.stack.get(idx)
+	if t, ok := val.(*luaState); ok {
+		return t
+	}
+	return nil
+}
+
 // [-0, +0, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_rawlen
 // 返回给定索引处值的固有“长度”： 对于字符串，它指字符串的长度；
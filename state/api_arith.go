@@ -84,6 +84,16 @@ func (L *luaState) Arith(op ArithOp) {
 		a = b
 	}
 
+	// 整数除以0没有意义（Go会直接panic），和luaV_div()、luaV_mod()一样报告错误
+	if op == LUA_OPIDIV || op == LUA_OPMOD {
+		if _, ok := a.(int64); ok && b == int64(0) {
+			if op == LUA_OPIDIV {
+				L.runError("attempt to perform 'n//0'")
+			}
+			L.runError("attempt to perform 'n%%0'")
+		}
+	}
+
 	operator := operators[op]
 	if res := arith(a, b, operator); res != nil {
 		L.stack.push(res)
@@ -96,7 +106,18 @@ func (L *luaState) Arith(op ArithOp) {
 		return
 	}
 
-	panic("arithmetic error!")
+	switch op {
+	case LUA_OPBAND, LUA_OPBOR, LUA_OPBXOR, LUA_OPSHL, LUA_OPSHR, LUA_OPBNOT:
+		_, ok1 := convertToFloat(a)
+		_, ok2 := convertToFloat(b)
+		if ok1 && ok2 {
+			L.toIntError(a, b)
+		} else {
+			L.opIntError(a, b, "perform bitwise operation on")
+		}
+	default:
+		L.opIntError(a, b, "perform arithmetic on")
+	}
 }
 
 func arith(a, b luaValue, op operator) luaValue {
@@ -0,0 +1,98 @@
+package state
+
+import (
+	"context"
+)
+
+/* 执行预算 */
+
+// 嵌入脚本的宿主程序可以用context或者指令预算限制脚本的运行，超出限制时
+// 脚本被中止，PCall返回LUA_ERRABORT。中止是“粘滞”的：限制一旦被突破，
+// 后续的每次检查都会再次中止，所以Lua代码里的pcall没法拦住它。
+// 同一个状态机的所有线程（协程）共享同一份预算
+
+// 每执行这么多条指令检查一次context，避免每条指令都要读通道
+const ctxCheckInterval = 1024
+
+type execBudget struct {
+	ctx       context.Context
+	limited   bool  // 是否设置了指令预算
+	remaining int64 // 剩余的指令数
+	ticks     int   // 上次检查context之后执行的指令数
+}
+
+// 脚本被中止时抛出的错误
+type abortError struct {
+	msg string
+}
+
+func (e *abortError) Error() string {
+	return e.msg
+}
+
+// SetContext 让脚本在ctx被取消或者超时的时候中止，ctx为nil表示不再受context限制
+func (L *luaState) SetContext(ctx context.Context) {
+	L.budget.ctx = ctx
+	L.budget.ticks = 0
+}
+
+// SetInstructionLimit 让脚本从现在开始最多再执行n条指令，n小于等于0表示不限制
+func (L *luaState) SetInstructionLimit(n int64) {
+	L.budget.limited = n > 0
+	L.budget.remaining = n
+}
+
+// ConsumeBudget 从指令预算里扣除n条指令，然后检查是否突破了限制，突破时中止脚本。
+// Go函数里可能运行很久的循环（比如模式匹配）每隔一段就调用一次，这样它们也受预算的限制
+func (L *luaState) ConsumeBudget(n int64) {
+	if L.budget.limited {
+		L.budget.remaining -= n
+	}
+	L.checkBudget()
+}
+
+// 是否设置了任何限制
+func (B *execBudget) armed() bool {
+	return B.limited || B.ctx != nil
+}
+
+// 返回中止的原因，没有突破限制时返回空串
+func (B *execBudget) exceeded() string {
+	if B.limited && B.remaining <= 0 {
+		return "instruction limit exceeded"
+	}
+	if B.ctx != nil {
+		if err := B.ctx.Err(); err != nil {
+			return err.Error()
+		}
+	}
+	return ""
+}
+
+// 每条Lua指令执行之前调用，扣除一条指令的预算，并且定期检查context
+func (L *luaState) tick() {
+	B := L.budget
+	if B.limited {
+		if B.remaining <= 0 {
+			L.abort()
+		}
+		B.remaining--
+	}
+	if B.ctx != nil {
+		if B.ticks++; B.ticks >= ctxCheckInterval {
+			B.ticks = 0
+			L.checkBudget()
+		}
+	}
+}
+
+// 在Go函数边界上检查是否已经突破了限制
+func (L *luaState) checkBudget() {
+	if L.budget.exceeded() != "" {
+		L.abort()
+	}
+}
+
+func (L *luaState) abort() {
+	panic(&abortError{L.budget.exceeded()})
+}
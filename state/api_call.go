@@ -1,9 +1,12 @@
 package state
 
 import (
+	"fmt"
 	. "luago/api"
 	"luago/binchunk"
+	"luago/compiler"
 	"luago/vm"
+	"strings"
 )
 
 // [-0, +1, –]
@@ -30,18 +33,96 @@ import (
 // lua_load 的内部会使用栈， 因此 reader 函数必须永远在每次返回时保留栈的原样。
 
 // 如果返回的函数有上值， 第一个上值会被设置为 保存在注册表（参见 §4.5） LUA_RIDX_GLOBALS 索引处的全局环境。 在加载主代码块时，这个上值是 _ENV 变量（参见 §2.2）。 其它上值均被初始化为 nil
-func (L *luaState) Load(chunk []byte, chunkName, mode string) int {
-	proto := binchunk.Undump(chunk)
+
+// 除了"b"、"t"和"bt"，mode里还可以包含"v"（比如"bv"），要求在加载二进制代码块
+// 之前用binchunk.Verify检查字节码，加载来源不可信的代码块时应该打开这个选项。
+// 校验失败同样返回 LUA_ERRSYNTAX。
+func (L *luaState) Load(chunk []byte, chunkName, mode string) (status int) {
+	if mode == "" {
+		mode = "bt"
+	}
+
+	// 词法分析、语法分析和代码生成阶段的错误都以panic的形式抛出，
+	// 这里把它们转换成错误消息，压入栈顶并返回LUA_ERRSYNTAX。
+	// 内存超过上限时加载好的函数已经压栈了，把它换成错误消息，返回LUA_ERRMEM
+	defer func() {
+		if err := recover(); err != nil {
+			status = LUA_ERRSYNTAX
+			if _, ok := err.(*memError); ok {
+				L.stack.pop()
+				status = LUA_ERRMEM
+			}
+			L.stack.push(fmt.Sprintf("%v", err))
+		}
+	}()
+
+	var proto *binchunk.Prototype
+	if binchunk.IsBinaryChunk(chunk) {
+		checkMode(mode, "binary")
+		var err error
+		if proto, err = binchunk.Undump(chunk); err != nil {
+			panic(fmt.Sprintf("%s: %s", binaryChunkName(chunkName), err))
+		}
+		if strings.Contains(mode, "v") {
+			if err := binchunk.Verify(proto); err != nil {
+				panic(fmt.Sprintf("%s: %s", binaryChunkName(chunkName), err))
+			}
+		}
+	} else {
+		checkMode(mode, "text")
+		proto = compiler.Compile(string(chunk), chunkName)
+	}
+
 	c := newLuaClosure(proto)
 	L.stack.push(c)
 	// 如果需要，那么第一个Upvalue（对于主函数来说就是_ENV）会被初始化
 	// 成全局环境，其他Upvalue会被初始化成nil
+	for i := range c.upvals {
+		c.upvals[i] = &upvalue{new(luaValue)}
+	}
 	if len(proto.Upvalues) > 0 {
 		// 设置 _ENV
 		env := L.registry.get(LUA_RIDX_GLOBALS)
 		c.upvals[0] = &upvalue{&env}
 	}
-	return 0
+	L.allocate(closureSize(c) + protoSize(proto))
+	return LUA_OK
+}
+
+// 和lundump.c一样，报告二进制chunk的错误时去掉块名前面的'@'或'='
+func binaryChunkName(chunkName string) string {
+	if strings.HasPrefix(chunkName, "@") || strings.HasPrefix(chunkName, "=") {
+		return chunkName[1:]
+	}
+	if binchunk.IsBinaryChunk([]byte(chunkName)) {
+		return "binary string"
+	}
+	return chunkName
+}
+
+// mode可以是"b"（只允许二进制代码块）、"t"（只允许文本代码块）或者"bt"（两者都可以）
+func checkMode(mode, kind string) {
+	if !strings.Contains(mode, kind[:1]) {
+		mode = strings.Replace(mode, "v", "", -1) /* 'v' is not part of the standard modes */
+		panic(fmt.Sprintf("attempt to load a %s chunk (mode is '%s')", kind, mode))
+	}
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_dump
+// int lua_dump (lua_State *L, lua_Writer writer, void *data, int strip);
+// 把函数导出成二进制代码块 。 函数接收栈顶的 Lua 函数做参数， 然后生成它的二进制代码块。
+// 若被导出的东西被再次加载， 加载的结果就相当于原来的函数。
+
+// 如果 strip 为真， 二进制代码块将不包含该函数的调试信息。
+
+// 这里直接返回二进制代码块，如果栈顶不是Lua函数则返回nil。
+// 该函数不会把 Lua 函数弹出堆栈。
+func (L *luaState) Dump(strip bool) []byte {
+	if c, ok := L.stack.get(-1).(*closure); ok && c.proto != nil {
+		return binchunk.Dump(c.proto, strip)
+	}
+	return nil
 }
 
 // [-(nargs+1), +nresults, e]
@@ -71,28 +152,31 @@ func (L *luaState) Load(chunk []byte, chunkName, mode string) int {
 //      lua_setglobal(L, "a");                         /* set global 'a' */
 // 注意上面这段代码是 平衡 的： 到了最后，堆栈恢复成原有的配置。 这是一种良好的编程习惯
 func (L *luaState) Call(nArgs, nResults int) {
-	val := L.stack.get(-(nArgs + 1))
-	c, ok := val.(*closure)
-	if !ok {
-		//查找是否有 元方法 TODO:??
-		if mf := getMetafield(val, "__call", L); mf != nil {
-			if c, ok = mf.(*closure); ok {
-				L.stack.push(val)
-				L.Insert(-(nArgs + 2))
-				nArgs++
-			}
-		}
+	c, nArgs := L.tryFuncTM(nArgs)
+	if c.proto != nil {
+		L.callLuaClosure(nArgs, nResults, c)
+	} else {
+		L.callGoClosure(nArgs, nResults, c)
 	}
+}
 
-	if ok {
-		if c.proto != nil {
-			L.callLuaClosure(nArgs, nResults, c)
-		} else {
-			L.callGoClosure(nArgs, nResults, c)
-		}
-	} else {
-		panic("not Closure!")
+// 被调对象不是函数时查找它的__call元方法，把元方法插到被调对象下面，
+// 被调对象本身成为第一个参数。返回真正要调用的闭包和参数个数
+// lua-5.3.4/src/ldo.c#tryfuncTM()
+func (L *luaState) tryFuncTM(nArgs int) (*closure, int) {
+	val := L.stack.get(-(nArgs + 1))
+	if c, ok := val.(*closure); ok {
+		return c, nArgs
 	}
+	if c, ok := getMetafield(val, "__call", L).(*closure); ok {
+		L.stack.check(1)
+		L.stack.push(val)
+		L.Insert(-(nArgs + 2))
+		L.stack.set(-(nArgs + 2), c)
+		return c, nArgs + 1
+	}
+	L.typeError(val, "call")
+	return nil, 0
 }
 
 // 先创建新的调用帧，然后把参数值从主调帧里弹出，推入被调帧。Go闭包直接从主调帧里弹出
@@ -113,12 +197,22 @@ func (L *luaState) callGoClosure(nArgs, nResults int, c *closure) {
 
 	// run closure
 	L.pushLuaStack(newStack)
+	if L.budget.armed() {
+		L.checkBudget()
+	}
+	if L.hookMask&LUA_MASKCALL != 0 {
+		L.callCallHook(LUA_HOOKCALL)
+	}
 	r := c.goFunc(L)
+	if L.hookMask&(LUA_MASKRET|LUA_MASKLINE) != 0 {
+		L.callRetHook()
+	}
 	L.popLuaStack()
 
 	// return results
 	if nResults != 0 {
 		results := newStack.popN(r)
+		L.stack.check(len(results))
 		L.stack.pushN(results, nResults)
 	}
 }
@@ -143,28 +237,107 @@ func (L *luaState) callLuaClosure(nArgs, nResults int, c *closure) {
 
 	// run closure
 	L.pushLuaStack(newStack)
+	if L.hookMask&LUA_MASKCALL != 0 {
+		L.callCallHook(LUA_HOOKCALL)
+	}
 	L.runLuaClosure()
+	if L.hookMask&(LUA_MASKRET|LUA_MASKLINE) != 0 {
+		L.callRetHook()
+	}
 	L.popLuaStack()
 
 	// return results
 	//把全部返回值从被调帧栈顶弹出， 然后根据期望的返回值数量多退少补，推入当前帧栈顶
+	//尾调用可能已经替换了帧里的闭包，返回值在新闭包的寄存器之上（Go函数没有寄存器）
 	if nResults != 0 {
+		nRegs = 0
+		if p := newStack.closure.proto; p != nil {
+			nRegs = int(p.MaxStackSize)
+		}
 		results := newStack.popN(newStack.top - nRegs)
 		L.stack.check(len(results))
 		L.stack.pushN(results, nResults)
 	}
 }
 
+// 执行当前帧里的Lua函数，直到RETURN指令，或者尾调用的Go函数返回。
+// 打开了行钩子或者计数钩子时，每条指令执行之前都要先跟踪一下
 func (L *luaState) runLuaClosure() {
 	for {
 		inst := vm.Instruction(L.Fetch())
+		if L.budget.armed() {
+			L.tick()
+		}
+		if L.hookMask&(LUA_MASKLINE|LUA_MASKCOUNT) != 0 {
+			L.traceExec()
+		}
 		inst.Execute(L)
-		if inst.Opcode() == vm.OP_RETURN {
+		if inst.Opcode() == vm.OP_RETURN || L.stack.closure.proto == nil {
 			break
 		}
 	}
 }
 
+// 尾调用：关闭当前帧的Upvalue，然后用被调函数和参数替换当前帧里的闭包、
+// 变长参数和寄存器，这样尾调用不会让调用栈变深。
+// 被调函数是Lua函数时，由runLuaClosure接着执行它的指令；
+// 是Go函数时直接执行，返回值留在帧里，就像是当前函数返回的一样。
+// 当前函数的调用帧已经不在了，所以出错信息和栈回溯里看不到它（和尾调用Lua函数一样）
+// lua-5.3.4/src/lvm.c#OP_TAILCALL
+func (L *luaState) TailCall(nArgs int) {
+	c, nArgs := L.tryFuncTM(nArgs)
+	isGo := c.proto == nil
+	if isGo && L.nCcalls >= maxCCalls {
+		L.checkCallDepth(true)
+	}
+
+	ci := L.stack
+	args := ci.popN(nArgs)
+	ci.pop() // pop func
+	L.CloseUpvalues(1)
+
+	// reuse the frame
+	for i := range ci.slots {
+		ci.slots[i] = nil
+	}
+	ci.top = 0
+	ci.closure = c
+	ci.varargs = nil
+	ci.pc = 0
+	ci.tailcall = true
+
+	if isGo {
+		// 调用帧变成了Go函数的帧，和pushLuaStack()一样计入Go调用深度，
+		// popLuaStack()弹出它时会减掉
+		L.nCcalls++
+		ci.check(nArgs + LUA_MINSTACK)
+		ci.pushN(args, nArgs)
+		if L.budget.armed() {
+			L.checkBudget()
+		}
+		if L.hookMask&LUA_MASKCALL != 0 {
+			L.callCallHook(LUA_HOOKTAILCALL)
+		}
+		r := c.goFunc(L)
+		results := ci.popN(r)
+		ci.popN(ci.top)
+		ci.pushN(results, r)
+		return
+	}
+
+	nRegs := int(c.proto.MaxStackSize)
+	nParams := int(c.proto.NumParams)
+	ci.check(nRegs + LUA_MINSTACK)
+	ci.pushN(args, nParams)
+	ci.top = nRegs
+	if nArgs > nParams && c.proto.IsVararg == 1 {
+		ci.varargs = args[nParams:]
+	}
+	if L.hookMask&LUA_MASKCALL != 0 {
+		L.callCallHook(LUA_HOOKTAILCALL)
+	}
+}
+
 // Calls a function in protected mode.
 // http://www.lua.org/manual/5.3/manual.html#lua_pcall
 // 以保护模式调用一个函数。
@@ -180,30 +353,91 @@ func (L *luaState) runLuaClosure() {
 
 // LUA_OK (0): 成功。
 // LUA_ERRRUN: 运行时错误。
-// LUA_ERRMEM: 内存分配错误。对于这种错，Lua 不会调用错误处理函数。
+// LUA_ERRMEM: 内存分配错误（超过了SetMemoryLimit设置的上限）。对于这种错，Lua 不会调用错误处理函数。
 // LUA_ERRERR: 在运行错误处理函数时发生的错误。
 // LUA_ERRGCMM: 在运行 __gc 元方法时发生的错误。 （这个错误和被调用的函数无关。）
+// LUA_ERRABORT: context被取消或者超过了指令预算（参见SetContext和SetInstructionLimit），
+// 错误消息是中止的原因。对于这种错，也不会调用错误处理函数。
 func (L *luaState) PCall(nArgs, nResults, msgh int) (status int) {
 	caller := L.stack
+	base := caller.top - (nArgs + 1) // 被调函数下面的值不受影响
+	var handler luaValue
+	if msgh != 0 {
+		handler = caller.get(msgh)
+	}
+	allowHook := L.allowHook
 	status = LUA_ERRRUN
 
 	//catch error
 	// 使用Go语言内置的panic（）函数抛出错误，那么自然就需要使用 defer-recover机制来捕获异常
 	// 调用Go语言内置的recover（）函数从错误中恢复，然后从调用栈顶依次弹
 	// 出调用帧，直到到达发起调用的调用帧为止，然后把错误对象推入栈顶，返回 LUA_ERRRUN
+	// 如果有错误处理函数，要在弹出调用帧之前（也就是在出错的地方）调用它，
+	// 这样它才能收集到栈回溯之类的信息
 	defer func() {
-		if err := recover(); err != nil {
-			if msgh != 0 {
-				panic(err)
-			}
-			for L.stack != caller {
-				L.popLuaStack()
-			}
-			L.stack.push(err)
+		r := recover()
+		if status == LUA_OK {
+			return
+		}
+		if _, ok := r.(closeSignal); ok {
+			panic(r) /* coroutine is being closed */
 		}
+		err := toLuaError(r)
+		if reason := L.budget.exceeded(); reason != "" {
+			err, status = reason, LUA_ERRABORT
+		} else if _, ok := r.(*memError); ok {
+			status = LUA_ERRMEM /* no message handler for memory errors */
+		} else if handler != nil {
+			err, status = L.callErrorHandler(handler, err)
+		}
+		L.allowHook = allowHook
+		for L.stack != caller {
+			L.popLuaStack()
+		}
+		L.SetTop(base)
+		L.stack.push(err)
 	}()
 
 	L.Call(nArgs, nResults)
 	status = LUA_OK
 	return
 }
+
+// 以错误对象为参数调用错误处理函数，它的返回值就是最终的错误对象。
+// 如果错误处理函数本身出错，返回LUA_ERRERR
+func (L *luaState) callErrorHandler(handler, err luaValue) (result luaValue, status int) {
+	stack := L.stack
+	top := stack.top
+	defer func() {
+		if r := recover(); r != nil || status != LUA_ERRRUN {
+			for L.stack != stack {
+				L.popLuaStack()
+			}
+			stack.top = top
+			result, status = "error in error handling", LUA_ERRERR
+		}
+	}()
+
+	status = LUA_ERRERR
+	stack.check(2)
+	stack.push(handler)
+	stack.push(err)
+	L.Call(1, 1)
+	result = stack.pop()
+	status = LUA_ERRRUN
+	return
+}
+
+// Lua代码里的错误对象可以是任意Lua值，原样保留即可。其他的panic来自Go代码，
+// 比如Go运行时错误（数组越界、访问nil map等），把它们转换成错误消息
+func toLuaError(r interface{}) luaValue {
+	switch x := r.(type) {
+	case nil, bool, int64, float64, string,
+		*luaTable, *closure, *luaState, *userdata, lightUserdata:
+		return x
+	case error:
+		return x.Error()
+	default:
+		return fmt.Sprintf("%v", x)
+	}
+}
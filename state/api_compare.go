@@ -82,6 +82,13 @@ func eq(a, b luaValue, L *luaState) bool {
 			}
 		}
 		return a == b
+	case *userdata:
+		if y, ok := b.(*userdata); ok && x != y && L != nil {
+			if res, ok := callMetamethod(x, y, "__eq", L); ok {
+				return convertToBoolean(res)
+			}
+		}
+		return a == b
 	default:
 		return a == b
 	}
@@ -112,7 +119,8 @@ func lt(a, b luaValue, L *luaState) bool {
 	if res, ok := callMetamethod(a, b, "__lt", L); ok {
 		return convertToBoolean(res)
 	}
-	panic("comparison error!")
+	L.orderError(a, b)
+	return false
 }
 
 func le(a, b luaValue, L *luaState) bool {
@@ -141,5 +149,6 @@ func le(a, b luaValue, L *luaState) bool {
 	} else if res, ok := callMetamethod(b, a, "__lt", L); ok {
 		return !convertToBoolean(res)
 	}
-	panic("comparison error!")
+	L.orderError(a, b)
+	return false
 }
@@ -0,0 +1,157 @@
+package state
+
+import (
+	. "luago/api"
+)
+
+/*协程*/
+
+// 每个协程都在单独的goroutine里执行，恢复协程的线程和被恢复的协程通过协程的coChan
+// 交替执行：同一时刻只有一个线程在运行，其他线程都阻塞在通道上，所以不需要额外的同步。
+// 挂起的协程如果变得不可达，收集垃圾时会把它关闭，让它的goroutine退出（参见closeThread）
+
+// [-0, +1, m]
+// http://www.lua.org/manual/5.3/manual.html#lua_newthread
+// lua_State *lua_newthread (lua_State *L);
+// 创建一条新线程，并将其压栈， 并返回维护这个线程的 lua_State 指针。
+// 这个函数返回的新线程共享原线程的全局环境， 但是它有独立的运行栈。
+func (L *luaState) NewThread() LuaState {
+	t := &luaState{registry: L.registry, allowHook: true, budget: L.budget, mem: L.mem}
+	/* inherit hook from creator */
+	t.hook = L.hook
+	t.hookMask = L.hookMask
+	t.baseHookCount = L.baseHookCount
+	t.hookCount = L.baseHookCount
+	L.stack.push(t)
+	L.allocate(sizeThread)
+	t.pushLuaStack(newLuaStack(LUA_MINSTACK, t))
+	L.checkGC()
+	return t
+}
+
+// [-?, +?, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_resume
+// int lua_resume (lua_State *L, lua_State *from, int nargs);
+// 在给定线程中启动或延续一条协程 。
+// 要启动一个协程的话， 你需要把主函数以及它需要的参数压入线程栈； 然后调用 lua_resume ， nargs 为参数的个数。
+// 这次调用会在协程挂起时或是结束运行后返回。 当函数返回时，堆栈中会有传给 lua_yield 的所有值， 或是主函数的所有返回值。
+// 当协程让出， lua_resume 返回 LUA_YIELD ， 若协程结束运行且没有任何错误时，返回 0 。 如果有错则返回错误代码（参见 lua_pcall ）。
+// 在发生错误的情况下， 堆栈没有展开， 因此你可以使用调试 API 来处理它。 错误消息放在栈顶在。
+// 要延续一个协程， 你需要清除上次 lua_yield 遗留下的所有结果， 你把需要传给 yield 作结果的值压栈， 然后调用 lua_resume 。
+// 参数 from 表示协程从哪个协程中来延续 L 的。 如果不存在这样一个协程，这个参数可以是 NULL 。
+func (L *luaState) Resume(from LuaState, nArgs int) int {
+	caller, _ := from.(*luaState)
+
+	switch L.coStatus {
+	case LUA_OK: // 启动协程
+		if L.stack.prev != nil {
+			return L.resumeError("cannot resume non-suspended coroutine")
+		}
+		L.coCaller = caller
+		L.coChan = make(chan struct{})
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					if _, ok := r.(closeSignal); !ok {
+						panic(r)
+					}
+				}
+				L.coCaller = nil
+				L.coChan <- struct{}{}
+			}()
+			status := L.PCall(nArgs, LUA_MULTRET, 0)
+			L.coStatus = status
+		}()
+	case LUA_YIELD: // 延续协程
+		delete(L.mem.suspended, L)
+		L.coStatus = LUA_OK
+		L.coCaller = caller
+		L.coChan <- struct{}{}
+	default:
+		return L.resumeError("cannot resume dead coroutine")
+	}
+
+	<-L.coChan // 等待协程挂起或者结束
+	return L.coStatus
+}
+
+func (L *luaState) resumeError(msg string) int {
+	L.stack.push(msg)
+	return LUA_ERRRUN
+}
+
+// [-?, +?, e]
+// http://www.lua.org/manual/5.3/manual.html#lua_yield
+// int lua_yield (lua_State *L, int nresults);
+// 让出协程（线程）。
+// 参数 nresults 指栈上需返回给 lua_resume 的返回值的个数。
+// 当协程被再次延续时，传给 lua_resume 的参数会留在栈上，Yield返回这些参数的个数，
+// Go函数一般直接 return L.Yield(n) ，把它们作为自己的返回值。
+func (L *luaState) Yield(nResults int) int {
+	if L.coCaller == nil {
+		panic("attempt to yield from outside a coroutine")
+	}
+
+	// 只把栈顶的nResults个值交给恢复者
+	if n := L.GetTop() - nResults; n > 0 {
+		vals := L.stack.popN(nResults)
+		L.SetTop(0)
+		L.stack.pushN(vals, nResults)
+	}
+
+	L.coStatus = LUA_YIELD
+	L.mem.suspended[L] = true
+	L.coChan <- struct{}{}
+	<-L.coChan // 等待被恢复（或者被关闭）
+	if L.coClosing {
+		panic(closeSignal{})
+	}
+	return L.GetTop()
+}
+
+// 关闭协程时在Yield里抛出，PCall不捕获它，它会一直展开到协程的入口
+type closeSignal struct{}
+
+// 关闭一个挂起的协程：唤醒阻塞在Yield里的goroutine，让它展开调用栈然后退出。
+// 协程已经不可达了，所以不用清理它的调用帧
+func (L *luaState) closeThread() {
+	delete(L.mem.suspended, L)
+	L.coStatus = LUA_ERRRUN
+	L.coClosing = true
+	L.coChan <- struct{}{}
+	<-L.coChan // 等待goroutine退出
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_status
+// int lua_status (lua_State *L);
+// 返回线程 L 的状态。
+// 正常的线程状态是 0 （LUA_OK）。 当线程用 lua_resume 执行完毕并抛出了一个错误时， 状态值是错误码。 如果线程被挂起，状态为 LUA_YIELD 。
+// 你只能在状态为 LUA_OK 的线程中调用函数。 你可以延续一个状态为 LUA_OK 的线程 （用于开始新协程）或是状态为 LUA_YIELD 的线程 （用于延续协程）。
+func (L *luaState) Status() int {
+	return L.coStatus
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_isyieldable
+// int lua_isyieldable (lua_State *L);
+// 如果给定的协程可以让出，返回 1 ，否则返回 0 。
+func (L *luaState) IsYieldable() bool {
+	return L.coCaller != nil
+}
+
+// [-?, +?, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_xmove
+// void lua_xmove (lua_State *from, lua_State *to, int n);
+// 交换同一个状态机下不同线程中的值。
+// 这个函数会从 from 的栈上弹出 n 个值， 然后把它们压入 to 的栈上。
+func (L *luaState) XMove(to LuaState, n int) {
+	t := to.(*luaState)
+	vals := L.stack.popN(n)
+	t.stack.check(n)
+	t.stack.pushN(vals, n)
+}
+
+func (L *luaState) isMainThread() bool {
+	return L.registry.get(LUA_RIDX_MAINTHREAD) == L
+}
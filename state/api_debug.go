@@ -0,0 +1,280 @@
+package state
+
+import (
+	. "luago/api"
+	"strings"
+)
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_getstack
+// int lua_getstack (lua_State *L, int level, lua_Debug *ar);
+// 获取解释器的运行时栈的信息。
+// 这个函数用正在运行中的指定层次处函数的 活动记录 来填写 lua_Debug 结构的一部分。
+// 0 层表示当前运行的函数， n+1 层的函数就是调用第 n 层 （尾调用例外，它不算在栈层次中） 函数的那一个。
+// 如果没有错误， lua_getstack 返回 1 ； 当调用传入的层次大于堆栈深度的时候，返回 0
+func (L *luaState) GetStack(level int, ar *LuaDebug) bool {
+	if level < 0 {
+		return false
+	}
+	// 最底层的栈不属于任何函数
+	for stack := L.stack; stack.prev != nil; stack = stack.prev {
+		if level == 0 {
+			ar.CallInfo = stack
+			return true
+		}
+		level--
+	}
+	return false
+}
+
+// [-(0|1), +(0|1|2), e]
+// http://www.lua.org/manual/5.3/manual.html#lua_getinfo
+// int lua_getinfo (lua_State *L, const char *what, lua_Debug *ar);
+// 返回一个指定的函数或函数调用的信息。
+// 当用于取得一次函数调用的信息时， 参数 ar 必须是一个有效的活动的记录。
+// 这条记录可以是前一次调用 lua_getstack 得到的， 或是一个钩子 （参见 lua_Hook ）得到的参数。
+// 用于获取一个函数的信息时， 可以把这个函数压入堆栈， 然后把 what 字符串以字符 '>' 起头。
+// （这会让 lua_getinfo 从栈顶上弹出函数。）
+// what 字符串中的每个字符都筛选出结构 ar 结构中一些域用于填充， 或是把一个值压入堆栈：
+// 'n': 填充 name 及 namewhat 域；
+// 'S': 填充 source ， short_src ， linedefined ， lastlinedefined ，以及 what 域；
+// 'l': 填充 currentline 域；
+// 't': 填充 istailcall 域；
+// 'u': 填充 nups， nparams 及 isvararg 域；
+// 'f': 把正在运行中指定层次处函数压栈；
+// 'L': 将一张表压栈，这张表的整数索引用于描述函数中哪些行是有效行。
+// （有效行指有实际代码的行，即你可以置入断点的行。 无效行包括空行和只有注释的行。）
+// 如果这个选项和选项 'f' 同时使用， 这张表在函数之后压栈。
+// 这个函数出错会返回 0 （例如，what 中有一个非法选项）。
+func (L *luaState) GetInfo(what string, ar *LuaDebug) bool {
+	var ci *luaStack
+	var fn luaValue
+	if strings.HasPrefix(what, ">") {
+		fn = L.stack.pop()
+		what = what[1:] /* skip the '>' */
+	} else {
+		ci = ar.CallInfo.(*luaStack)
+		fn = ci.closure
+	}
+	c, _ := fn.(*closure)
+	status := true
+	for _, option := range what {
+		switch option {
+		case 'S':
+			funcInfo(ar, c)
+		case 'l':
+			ar.CurrentLine = -1
+			if ci != nil && ci.closure != nil && ci.closure.proto != nil {
+				ar.CurrentLine = currentLine(ci)
+			}
+		case 'u':
+			if c == nil || c.proto == nil {
+				ar.NUps = 0
+				ar.IsVararg = true
+				ar.NParams = 0
+			} else {
+				ar.NUps = len(c.upvals)
+				ar.IsVararg = c.proto.IsVararg == 1
+				ar.NParams = int(c.proto.NumParams)
+			}
+		case 't':
+			ar.IsTailCall = ci != nil && ci.tailcall
+		case 'n':
+			ar.NameWhat, ar.Name = getFuncName(ci)
+		case 'L', 'f': /* handled below */
+		default:
+			status = false /* invalid option */
+		}
+	}
+	if strings.ContainsRune(what, 'f') {
+		L.stack.check(1)
+		L.stack.push(fn)
+	}
+	if strings.ContainsRune(what, 'L') {
+		L.collectValidLines(c)
+	}
+	return status
+}
+
+// [-0, +(0|1), –]
+// http://www.lua.org/manual/5.3/manual.html#lua_getlocal
+// const char *lua_getlocal (lua_State *L, const lua_Debug *ar, int n);
+// 从给定活动记录或从一个函数中获取一个局部变量的信息。
+// 对于第一种情况， 参数 ar 必须是一个有效的活动的记录。
+// 这条记录可以是前一次调用 lua_getstack 得到的， 或是一个钩子 （参见 lua_Hook ）的参数。
+// 索引 n 用于选择要检阅哪个局部变量； 参见 debug.getlocal 中关于变量的索引和名字的介绍。
+// lua_getlocal 将变量的值压栈，并返回其名字。
+// 对于第二种情况，ar 必须填 NULL （这里是nil）。 需要探知的函数必须放在栈顶。
+// 对于这种情况，只有 Lua 函数的形参是可见的 （没有关于还有哪些活动变量的信息） 也不会有值压栈。
+// 当索引大于活动的局部变量的数量， 返回 NULL （以及false）， 无任何压栈
+func (L *luaState) GetLocal(ar *LuaDebug, n int) (string, bool) {
+	if ar == nil { /* information about non-active function? */
+		c, ok := L.stack.get(-1).(*closure)
+		if !ok || c.proto == nil { /* not a Lua function? */
+			return "", false
+		}
+		/* information about non-active function */
+		name := getLocalName(c.proto, n, 0)
+		return name, name != ""
+	}
+	/* active function; get information through 'ar' */
+	name, pos := findLocal(ar.CallInfo.(*luaStack), n)
+	if pos == nil {
+		return "", false
+	}
+	L.stack.check(1)
+	L.stack.push(*pos)
+	return name, true
+}
+
+// [-(0|1), +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_setlocal
+// const char *lua_setlocal (lua_State *L, const lua_Debug *ar, int n);
+// 设置给定活动记录中的局部变量的值。 参数 ar 与 n 和 lua_getlocal 中的一样。
+// lua_setlocal 把栈顶的值赋给变量然后返回变量的名字。 它会将值从栈顶弹出。
+// 当索引大于活动的局部变量的数量， 返回 NULL （以及false） 且不弹出任何东西
+func (L *luaState) SetLocal(ar *LuaDebug, n int) (string, bool) {
+	name, pos := findLocal(ar.CallInfo.(*luaStack), n)
+	if pos == nil {
+		return "", false
+	}
+	*pos = L.stack.pop()
+	return name, true
+}
+
+// [-0, +(0|1), –]
+// http://www.lua.org/manual/5.3/manual.html#lua_getupvalue
+// const char *lua_getupvalue (lua_State *L, int funcindex, int n);
+// 获取一个闭包的上值信息。 （对于 Lua 函数，上值是函数需要使用的外部局部变量，
+// 因此这些变量被包含在闭包中。） lua_getupvalue 获取第 n 个上值， 把这个上值的值压栈， 并且返回它的名字。
+// funcindex 指向闭包在栈上的位置。 （因为上值在整个函数中都有效，所以它们没有特别的次序。 因此，它们以字母次序来编号。）
+// 当索引号比上值数量大的时候， 返回 NULL（以及false）（而且不会压入任何东西）。
+// C 函数（这里是Go函数）的上值名字是空串，没有调试信息的Lua函数的上值名字是"(*no name)"
+func (L *luaState) GetUpvalue(funcIdx, n int) (string, bool) {
+	c, ok := L.stack.get(funcIdx).(*closure)
+	if !ok || n < 1 || n > len(c.upvals) {
+		return "", false
+	}
+	var val luaValue
+	if uv := c.upvals[n-1]; uv != nil {
+		val = *uv.val
+	}
+	L.stack.check(1)
+	L.stack.push(val)
+	return upvalueName(c, n-1), true
+}
+
+// [-(0|1), +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_setupvalue
+// const char *lua_setupvalue (lua_State *L, int funcindex, int n);
+// 设置闭包上值的值。 它把栈顶的值弹出并赋于上值并返回上值的名字。
+// 参数 funcindex 与 n 和 lua_getupvalue 中的一样。
+// 当索引大于上值的个数时，返回 NULL （以及 0 ），并且不弹出任何东西。
+// C 函数（这里是Go函数）的上值名字是空串，没有调试信息的Lua函数的上值名字是"(*no name)"
+func (L *luaState) SetUpvalue(funcIdx, n int) (string, bool) {
+	c, ok := L.stack.get(funcIdx).(*closure)
+	if !ok || n < 1 || n > len(c.upvals) {
+		return "", false
+	}
+	val := L.stack.pop()
+	if c.upvals[n-1] == nil {
+		c.upvals[n-1] = &upvalue{&val}
+	} else {
+		*c.upvals[n-1].val = val
+	}
+	return upvalueName(c, n-1), true
+}
+
+func upvalueName(c *closure, idx int) string {
+	if c.proto == nil {
+		return ""
+	}
+	if idx < len(c.proto.UpvalueNames) {
+		return c.proto.UpvalueNames[idx]
+	}
+	return "(*no name)"
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_upvalueid
+// void *lua_upvalueid (lua_State *L, int funcindex, int n);
+// 在索引 funcindex 处的闭包中返回第 n 个上值的唯一标识符。 参数 funcindex 与 n 和 lua_getupvalue 中的一样 （但是 n 不可以大于上值的数量）。
+// 这些唯一标识符可以用于检测不同的闭包是否共享了相同的上值。
+// 共享同一个上值的 Lua 闭包（即它们指的同一个外部局部变量） 会针对这个上值返回相同的标识。
+// 索引无效时返回nil
+func (L *luaState) UpvalueID(funcIdx, n int) interface{} {
+	c, ok := L.stack.get(funcIdx).(*closure)
+	if !ok || n < 1 || n > len(c.upvals) {
+		return nil
+	}
+	if c.upvals[n-1] == nil { /* Go函数的上值在第一次设置时才创建 */
+		c.upvals[n-1] = &upvalue{new(luaValue)}
+	}
+	return c.upvals[n-1]
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_upvaluejoin
+// void lua_upvaluejoin (lua_State *L, int funcindex1, int n1, int funcindex2, int n2);
+// 让索引 funcindex1 处的 Lua 闭包的第 n1 个上值 引用索引 funcindex2 处的 Lua 闭包的第 n2 个上值。
+func (L *luaState) UpvalueJoin(funcIdx1, n1, funcIdx2, n2 int) {
+	c1, ok1 := L.stack.get(funcIdx1).(*closure)
+	c2, ok2 := L.stack.get(funcIdx2).(*closure)
+	if !ok1 || !ok2 || c1.proto == nil || c2.proto == nil {
+		panic("Lua function expected")
+	}
+	if n1 < 1 || n1 > len(c1.upvals) || n2 < 1 || n2 > len(c2.upvals) {
+		panic("invalid upvalue index")
+	}
+	c1.upvals[n1-1] = c2.upvals[n2-1]
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_sethook
+// void lua_sethook (lua_State *L, lua_Hook f, int mask, int count);
+// 设置一个调试用钩子函数。
+// 参数 f 是钩子函数。 mask 指定在哪些事件时会调用：它由下列一组位常量构成
+// LUA_MASKCALL， LUA_MASKRET， LUA_MASKLINE， LUA_MASKCOUNT。
+// 参数 count 只在掩码中包含有 LUA_MASKCOUNT 才有意义。 对于每个事件，钩子被调用的情况解释如下：
+// call hook: 在解释器调用一个函数时被调用。 钩子将于 Lua 进入一个新函数后， 函数获取参数前被调用。
+// return hook: 在解释器从一个函数中返回时调用。 钩子将于 Lua 离开函数之前的那一刻被调用。 没有标准方法来访问被函数返回的那些值。
+// line hook: 在解释器准备开始执行新的一行代码时， 或是跳转到这行代码中时（即使在同一行内跳转）被调用。 （这个事件仅仅在 Lua 执行一个 Lua 函数时发生。）
+// count hook: 在解释器每执行 count 条指令后被调用。 （这个事件仅仅在 Lua 执行一个 Lua 函数时发生。）
+// 钩子可以通过设置 mask 为零屏蔽。
+func (L *luaState) SetHook(f LuaHook, mask, count int) {
+	if f == nil || mask == 0 { /* turn off hooks? */
+		mask = 0
+		f = nil
+	}
+	if ci := L.stack; ci.closure != nil && ci.closure.proto != nil {
+		L.oldPC = currentPC(ci)
+	}
+	L.hook = f
+	L.baseHookCount = count
+	L.hookCount = count
+	L.hookMask = mask
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_gethook
+// lua_Hook lua_gethook (lua_State *L);
+// 返回当前的钩子函数。
+func (L *luaState) GetHook() LuaHook {
+	return L.hook
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_gethookmask
+// int lua_gethookmask (lua_State *L);
+// 返回当前的钩子掩码。
+func (L *luaState) GetHookMask() int {
+	return L.hookMask
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_gethookcount
+// int lua_gethookcount (lua_State *L);
+// 返回当前的钩子计数。
+func (L *luaState) GetHookCount() int {
+	return L.baseHookCount
+}
@@ -0,0 +1,217 @@
+package state
+
+import (
+	"fmt"
+	. "luago/api"
+	"sort"
+)
+
+/* 内存统计和垃圾收集 */
+
+// 同一个状态机的所有线程（协程）共享的内存账本
+type memStats struct {
+	total   int64 // 估计的内存用量（字节）
+	limit   int64 // 内存上限，0表示不限制
+	stopped bool  // collectgarbage("stop")
+	pause   int   // 内存用量达到上次收集后的pause%时开始下一次收集
+	stepMul int   // 收集器不是增量的，这个参数只是记录下来
+	// 内存用量超过这个值时自动收集垃圾
+	threshold int64
+	// 挂起的协程，它们的goroutine阻塞在Yield里，不可达时要关闭
+	suspended map[*luaState]bool
+	/* finalizers */
+	finobj  map[luaValue]int64 // 元表里有__gc字段的对象，值是登记的顺序
+	finSeq  int64
+	tobefnz []luaValue // 已经不可达、等待调用__gc元方法的对象
+}
+
+func newMemStats() *memStats {
+	return &memStats{
+		pause:     200,
+		stepMul:   200,
+		suspended: map[*luaState]bool{},
+		finobj:    map[luaValue]int64{},
+	}
+}
+
+// 内存超过上限时抛出的错误
+type memError struct{}
+
+func (e *memError) Error() string {
+	return "not enough memory"
+}
+
+// 记录新分配（n为负数时是释放）的内存。超过上限时先重新统计一次可达对象，
+// 仍然超过上限就抛出内存错误，PCall返回LUA_ERRMEM。
+// 新分配的对象应该已经可以从栈或者其他对象里访问到了
+func (L *luaState) allocate(n int64) {
+	m := L.mem
+	m.total += n
+	if n > 0 && m.limit > 0 && m.total > m.limit {
+		m.total = L.traceMemory()
+		if m.total > m.limit {
+			panic(&memError{})
+		}
+	}
+}
+
+// 分配n字节之前先检查内存上限，这样超过上限时就不会真的去分配。
+// 这里只检查不记账，对象分配好之后还要调用allocate
+func (L *luaState) checkMemory(n int64) {
+	m := L.mem
+	if m.limit > 0 && m.total+n > m.limit {
+		m.total = L.traceMemory()
+		if m.total+n > m.limit {
+			panic(&memError{})
+		}
+	}
+}
+
+// SetMemoryLimit 设置状态机可以使用的内存上限（字节），超过上限时PCall返回LUA_ERRMEM。
+// n小于等于0表示不限制。内存用量只是粗略的估计，参见GC的LUA_GCCOUNT选项
+func (L *luaState) SetMemoryLimit(n int64) {
+	if n < 0 {
+		n = 0
+	}
+	L.mem.limit = n
+}
+
+// [-0, +0, m]
+// http://www.lua.org/manual/5.3/manual.html#lua_gc
+// int lua_gc (lua_State *L, int what, int data);
+// 控制垃圾收集器。
+// 这个函数根据其参数 what 发起几种不同的任务：
+// LUA_GCSTOP: 停止垃圾收集器。
+// LUA_GCRESTART: 重启垃圾收集器。
+// LUA_GCCOLLECT: 发起一次完整的垃圾收集循环。
+// LUA_GCCOUNT: 返回 Lua 使用的内存总量（以 K 字节为单位）。
+// LUA_GCCOUNTB: 返回当前内存使用量除以 1024 的余数。
+// LUA_GCSTEP: 发起一步增量垃圾收集。
+// LUA_GCSETPAUSE: 把 data 设为 垃圾收集器间歇率 （参见 §2.5）， 并返回之前设置的值。
+// LUA_GCSETSTEPMUL: 把 data 设为 垃圾收集器步进倍率 （参见 §2.5）， 并返回之前设置的值。
+// LUA_GCISRUNNING: 返回收集器是否在运行（即没有停止）。
+// 对象实际上由Go的垃圾收集器回收，这里的内存总量是估计值：收集垃圾时会重新统计可达对象，
+// 同时从弱表里删除已经不可达的键和值（参见 §2.5.2），并调用不可达对象的__gc元方法（参见 §2.5.1）
+func (L *luaState) GC(what, data int) int {
+	m := L.mem
+	switch what {
+	case LUA_GCSTOP:
+		m.stopped = true
+	case LUA_GCRESTART:
+		m.stopped = false
+	case LUA_GCCOLLECT:
+		L.fullGC()
+	case LUA_GCCOUNT:
+		/* GC values are expressed in Kbytes: #bytes/2^10 */
+		return int(m.total >> 10)
+	case LUA_GCCOUNTB:
+		return int(m.total & 0x3ff)
+	case LUA_GCSTEP:
+		L.fullGC()
+		return 1 /* cycle finished */
+	case LUA_GCSETPAUSE:
+		res := m.pause
+		m.pause = data
+		return res
+	case LUA_GCSETSTEPMUL:
+		res := m.stepMul
+		m.stepMul = data
+		return res
+	case LUA_GCISRUNNING:
+		if m.stopped {
+			return 0
+		}
+		return 1
+	default:
+		return -1 /* invalid option */
+	}
+	return 0
+}
+
+// 重新统计可达对象并清理弱表，然后调用__gc元方法。不可达的对象由Go的垃圾收集器
+// 自己择时回收，这里不调用runtime.GC()：它会暂停整个进程，不能让脚本随意触发
+func (L *luaState) fullGC() {
+	m := L.mem
+	m.total = L.traceMemory()
+	m.threshold = m.total / 100 * int64(m.pause)
+	L.callAllPendingFinalizers(true)
+}
+
+// 新的对象压栈以后调用。内存用量超过阈值时自动做一次完整的收集，
+// 这样不调用collectgarbage弱表也会被清理，__gc元方法也会被调用
+// lua-5.3.4/src/lgc.h#luaC_checkGC()
+func (L *luaState) checkGC() {
+	if m := L.mem; !m.stopped && m.total > m.threshold {
+		L.fullGC()
+	}
+}
+
+// 设置的元表里有__gc字段时，把对象登记下来，它变得不可达时收集器会调用__gc元方法
+// lua-5.3.4/src/lgc.c#luaC_checkfinalizer()
+func (L *luaState) checkFinalizer(obj luaValue, mt *luaTable) {
+	m := L.mem
+	if mt == nil || mt.get("__gc") == nil {
+		return /* or has no finalizer */
+	}
+	if _, ok := m.finobj[obj]; !ok { /* not yet marked for finalization? */
+		m.finSeq++
+		m.finobj[obj] = m.finSeq
+	}
+}
+
+// 把不可达的登记对象移到待调用队列里。后登记的对象先调用__gc
+// lua-5.3.4/src/lgc.c#separatetobefnz()
+func (m *memStats) separateToBeFnz(reachable func(luaValue) bool) {
+	var objs []luaValue
+	for obj := range m.finobj {
+		if !reachable(obj) {
+			objs = append(objs, obj)
+		}
+	}
+	sort.Slice(objs, func(i, j int) bool {
+		return m.finobj[objs[i]] > m.finobj[objs[j]]
+	})
+	for _, obj := range objs {
+		delete(m.finobj, obj)
+	}
+	m.tobefnz = append(m.tobefnz, objs...)
+}
+
+// 依次调用待调用队列里的对象的__gc元方法。元方法执行期间不触发钩子，
+// 出错时把错误包装一下继续往外抛，剩下的对象留到下次收集时再调用。
+// 关闭状态机时propagateErrors为false，忽略__gc元方法里的错误
+// lua-5.3.4/src/lgc.c#GCTM()
+func (L *luaState) callAllPendingFinalizers(propagateErrors bool) {
+	m := L.mem
+	for len(m.tobefnz) > 0 {
+		obj := m.tobefnz[0]
+		m.tobefnz = m.tobefnz[1:]
+		tm, ok := getMetafield(obj, "__gc", L).(*closure)
+		if !ok { /* is there a finalizer? */
+			continue
+		}
+		allowHook := L.allowHook
+		L.allowHook = false /* stop debug hooks during GC metamethod */
+		L.stack.check(2)
+		L.stack.push(tm)
+		L.stack.push(obj)
+		status := L.PCall(1, 0, 0)
+		L.allowHook = allowHook
+		if status != LUA_OK { /* error while running __gc? */
+			err := L.stack.pop()
+			if !propagateErrors {
+				continue
+			}
+			if status == LUA_ERRRUN { /* is there an error object? */
+				msg, ok := err.(string)
+				if !ok {
+					msg = "no message"
+				}
+				err = fmt.Sprintf("error in __gc metamethod (%s)", msg)
+			} else if status == LUA_ERRMEM {
+				err = &memError{}
+			}
+			panic(err) /* re-throw error */
+		}
+	}
+}
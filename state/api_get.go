@@ -18,8 +18,12 @@ func (L *luaState) NewTable() {
 // 创建一张新的空表压栈。 参数 narr 建议了这张表作为序列使用时会有多少个元素； 参数 nrec 建议了这张表可能拥有多少序列之外的元素。
 // Lua 会使用这些建议来预分配这张新表。 如果你知道这张表用途的更多信息，预分配可以提高性能。 否则，你可以使用函数 lua_newtable
 func (L *luaState) CreateTable(nArr, nRec int) {
+	L.checkMemory(sizeTable + int64(sizeHint(nArr))*sizeValue +
+		int64(sizeHint(nRec))*sizeMapEntry)
 	t := newLuaTable(nArr, nRec)
 	L.stack.push(t)
+	L.allocate(tableSize(t))
+	L.checkGC()
 }
 
 // [-1, +1, e]
@@ -51,6 +55,12 @@ func (L *luaState) GetField(idx int, k string) LuaType {
 // 返回压入值的类型
 func (L *luaState) GetI(idx int, i int64) LuaType {
 	t := L.stack.get(idx)
+	if tbl, ok := t.(*luaTable); ok { // 快速路径：值存在或者没有__index元方法时直接访问
+		if v := tbl.getInt(i); v != nil || !tbl.hasMetafield("__index") {
+			L.stack.push(v)
+			return typeOf(v)
+		}
+	}
 	return L.getTable(t, i, false)
 }
 
@@ -76,9 +86,39 @@ func (L *luaState) RawGet(idx int) LuaType {
 // 把 t[n] 的值压栈， 这里的 t 是指给定索引处的表。 这是一次直接访问；就是说，它不会触发元方法。返回入栈值的类型
 func (L *luaState) RawGetI(idx int, i int64) LuaType {
 	t := L.stack.get(idx)
+	if tbl, ok := t.(*luaTable); ok {
+		v := tbl.getInt(i)
+		L.stack.push(v)
+		return typeOf(v)
+	}
 	return L.getTable(t, i, true)
 }
 
+// [-0, +1, m]
+// http://www.lua.org/manual/5.3/manual.html#lua_newuserdata
+// void *lua_newuserdata (lua_State *L, size_t size);
+// 这个函数分配一块指定大小的内存块， 把内存块地址作为一个完全用户数据压栈， 并返回这个地址。 宿主程序可以随意使用这块内存。
+// 这里不分配内存，而是把任意的Go值包装成完全用户数据压栈，用ToUserdata可以取回这个Go值
+func (L *luaState) NewUserdata(data interface{}) {
+	L.stack.push(newUserdata(data))
+	L.allocate(sizeUserdata)
+	L.checkGC()
+}
+
+// [-0, +1, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_getuservalue
+// int lua_getuservalue (lua_State *L, int index);
+// 将给定索引处的用户数据所关联的 Lua 值压栈。
+// 返回压入值的类型
+func (L *luaState) GetUserValue(idx int) LuaType {
+	val := L.stack.get(idx)
+	if u, ok := val.(*userdata); ok {
+		L.stack.push(u.uservalue)
+		return typeOf(u.uservalue)
+	}
+	panic("full userdata expected!")
+}
+
 // [-0, +(0|1), –]
 // http://www.lua.org/manual/5.3/manual.html#lua_getmetatable
 // int lua_getmetatable (lua_State *L, int index);
@@ -93,31 +133,38 @@ func (L *luaState) GetMetatable(idx int) bool {
 	return false
 }
 
-//
+// __index和__newindex元方法链的最大长度，超过以后认为出现了循环
+const maxTagLoop = 2000 // MAXTAGLOOP
+
 // raw true，表示需要忽略元方法
+// 和luaV_finishget()一样循环沿着__index链查找，而不是递归
 func (L *luaState) getTable(t, k luaValue, raw bool) LuaType {
-	if tbl, ok := t.(*luaTable); ok {
-		v := tbl.get(k)
-		if raw || v != nil || !tbl.hasMetafield("__index") {
-			L.stack.push(v)
-			return typeOf(v)
-		}
-	}
-
-	if !raw {
-		if mf := getMetafield(t, "__index", L); mf != nil {
-			switch x := mf.(type) {
-			case *luaTable:
-				return L.getTable(x, k, false)
-			case *closure:
-				L.stack.push(mf)
-				L.stack.push(t)
-				L.stack.push(k)
-				L.Call(2, 1)
-				v := L.stack.get(-1)
+	for loop := 0; loop < maxTagLoop; loop++ {
+		if tbl, ok := t.(*luaTable); ok {
+			v := tbl.get(k)
+			if raw || v != nil || !tbl.hasMetafield("__index") {
+				L.stack.push(v)
 				return typeOf(v)
 			}
 		}
+
+		var mf luaValue
+		if !raw {
+			mf = getMetafield(t, "__index", L)
+		}
+		if mf == nil {
+			L.typeError(t, "index") /* no metamethod */
+		}
+		if _, ok := mf.(*closure); ok {
+			L.stack.push(mf)
+			L.stack.push(t)
+			L.stack.push(k)
+			L.Call(2, 1)
+			v := L.stack.get(-1)
+			return typeOf(v)
+		}
+		t = mf /* else try to access 'mf[k]' */
 	}
-	panic("not a table!")
+	L.runError("'__index' chain too long; possibly a loop")
+	return LUA_TNONE
 }
@@ -1,5 +1,7 @@
 package state
 
+import . "luago/api"
+
 // [-0, +0, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_gettop
 // 返回栈顶元素的索引。 因为索引是从 1 开始编号的， 所以这个结果等于栈上的元素个数； 特别指出，0 表示栈为空
@@ -19,7 +21,11 @@ func (L *luaState) AbsIndex(idx int) int {
 //确保堆栈上至少有 n 个额外空位。 如果不能把堆栈扩展到相应的尺寸，函数返回假。
 //失败的原因包括将把栈扩展到比固定最大尺寸还大 （至少是几千个元素）或分配内存失败。
 //这个函数永远不会缩小堆栈； 如果堆栈已经比需要的大了，那么就保持原样
+// 栈的大小不能超过LUAI_MAXSTACK，否则负数索引会和伪索引重叠
 func (L *luaState) CheckStack(n int) bool {
+	if n < 0 || L.stack.top > LUAI_MAXSTACK-n {
+		return false
+	}
 	L.stack.check(n)
 	return true
 }
@@ -1,5 +1,7 @@
 package state
 
+import "luago/number"
+
 // [-0, +1, e]
 // http://www.lua.org/manual/5.3/manual.html#lua_len
 // void lua_len (lua_State *L, int index);
@@ -14,7 +16,7 @@ func (L *luaState) Len(idx int) {
 	} else if t, ok := val.(*luaTable); ok {
 		L.stack.push(int64(t.len()))
 	} else {
-		panic("length error!")
+		L.typeError(val, "get length of")
 	}
 }
 
@@ -35,18 +37,20 @@ func (L *luaState) Concat(n int) {
 				L.stack.pop()
 				L.stack.pop()
 				L.stack.push(s1 + s2)
+				L.allocate(stringSize(s1) + int64(len(s2)))
 				continue
 			}
 			// 如果 不是 字符串 尝试进行 元方法
-			a := L.stack.pop()
 			b := L.stack.pop()
+			a := L.stack.pop()
 			if res, ok := callMetamethod(a, b, "__concat", L); ok {
 				L.stack.push(res)
 				continue
 			}
-			panic("concatenation error!")
+			L.concatError(a, b)
 		}
 	}
+	L.checkGC()
 }
 
 // [-1, +(2|0), e]
@@ -74,3 +78,22 @@ func (L *luaState) Error() int {
 	err := L.stack.pop()
 	panic(err)
 }
+
+// [-0, +1, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_stringtonumber
+// size_t lua_stringtonumber (lua_State *L, const char *s);
+// 将一个零结尾的字符串 s 转换为一个数字， 将这个数字压栈，并返回字符串的总长度（即长度加一）。
+// 转换的结果可能是整数也可能是浮点数， 这取决于 Lua 的转换语法（参见 §3.1）。
+// 这个字符串可以有前置和后置的空格以及符号。 如果字符串并非一个有效的数字，返回 0 并不把任何东西压栈。
+// 这里返回是否转换成功
+func (L *luaState) StringToNumber(s string) bool {
+	if n, ok := number.ParseInteger(s); ok {
+		L.stack.push(n)
+		return true
+	}
+	if n, ok := number.ParseFloat(s); ok {
+		L.stack.push(n)
+		return true
+	}
+	return false
+}
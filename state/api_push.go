@@ -39,12 +39,17 @@ func (L *luaState) PushNumber(n float64) {
 //返回内部副本的指针。如果 s 为 NULL，将 nil 压栈并返回 NULL
 func (L *luaState) PushString(s string) {
 	L.stack.push(s)
+	L.allocate(stringSize(s))
+	L.checkGC()
 }
 
 // [-0, +1, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_pushcfunction
 func (L *luaState) PushGoFunction(f GoFunction) {
-	L.stack.push(newGoClosure(f, 0))
+	c := newGoClosure(f, 0)
+	L.stack.push(c)
+	L.allocate(closureSize(c))
+	L.checkGC()
 }
 
 // [-n, +1, m]
@@ -60,9 +65,11 @@ func (L *luaState) PushGoClosure(f GoFunction, n int) {
 	c := newGoClosure(f, n)
 	for i := n; i > 0; i-- {
 		val := L.stack.pop()
-		c.upvals[n-1] = &upvalue{&val}
+		c.upvals[i-1] = &upvalue{&val}
 	}
 	L.stack.push(c)
+	L.allocate(closureSize(c))
+	L.checkGC()
 }
 
 // [-0, +1, –]
@@ -72,3 +79,21 @@ func (L *luaState) PushGlobalTable() {
 	global := L.registry.get(LUA_RIDX_GLOBALS)
 	L.stack.push(global)
 }
+
+// [-0, +1, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_pushlightuserdata
+// 把一个轻量用户数据压栈。
+// 用户数据是保留在 Lua 中的 C 值。 轻量用户数据 表示一个指针 void*。 它是一个像数字一样的值： 你不需要专门创建它，它也没有独立的元表，而且也不会被收集（因为从来不需要创建）。
+// 只要表示的 C 地址相同，两个轻量用户数据就相等。
+// 这里p可以是任意可比较的Go值，一般是指针
+func (L *luaState) PushLightUserdata(p interface{}) {
+	L.stack.push(lightUserdata{p})
+}
+
+// [-0, +1, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_pushthread
+// 把 L 表示的线程压栈。 如果这个线程是当前状态机的主线程的话，返回 1
+func (L *luaState) PushThread() bool {
+	L.stack.push(L)
+	return L.isMainThread()
+}
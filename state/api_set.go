@@ -2,6 +2,7 @@ package state
 
 import (
 	. "luago/api"
+	"math"
 )
 
 // [-2, +0, e]
@@ -35,6 +36,11 @@ func (L *luaState) SetField(idx int, k string) {
 func (L *luaState) SetI(idx int, i int64) {
 	t := L.stack.get(idx)
 	v := L.stack.pop()
+	if tbl, ok := t.(*luaTable); ok { // 快速路径：覆盖数组部分里已有的元素不会触发__newindex元方法
+		if tbl.putIntFast(i, v) {
+			return
+		}
+	}
 	L.setTable(t, i, v, false)
 }
 
@@ -64,6 +70,9 @@ func (L *luaState) RawSet(idx int) {
 func (L *luaState) RawSetI(idx int, i int64) {
 	t := L.stack.get(idx)
 	v := L.stack.pop()
+	if tbl, ok := t.(*luaTable); ok && tbl.putIntFast(i, v) {
+		return
+	}
 	L.setTable(t, i, v, true)
 }
 
@@ -75,6 +84,20 @@ func (L *luaState) Register(name string, f GoFunction) {
 	L.SetGlobal(name)
 }
 
+// [-1, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_setuservalue
+// void lua_setuservalue (lua_State *L, int index);
+// 从栈上弹出一个值并将其设为给定索引处用户数据的关联值
+func (L *luaState) SetUserValue(idx int) {
+	val := L.stack.get(idx)
+	v := L.stack.pop()
+	if u, ok := val.(*userdata); ok {
+		u.uservalue = v
+		return
+	}
+	panic("full userdata expected!")
+}
+
 // [-1, +0, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_setmetatable
 //把一张表弹出栈，并将其设为给定索引处的值的元表
@@ -91,30 +114,39 @@ func (L *luaState) SetMetatable(idx int) {
 }
 
 // t[k]=v
+// 和luaV_finishset()一样循环沿着__newindex链查找，而不是递归
 func (L *luaState) setTable(t, k, v luaValue, raw bool) {
-	if tbl, ok := t.(*luaTable); ok {
-		if raw || tbl.get(k) != nil || !tbl.hasMetafield("__newindex") {
-			tbl.put(k, v)
-			return
-		}
-	}
-
-	if !raw {
-		if mf := getMetafield(t, "__newindex", L); mf != nil {
-			switch x := mf.(type) {
-			case *luaTable:
-				L.setTable(x, k, v, false)
-				return
-			case *closure:
-				L.stack.push(mf)
-				L.stack.push(t)
-				L.stack.push(k)
-				L.stack.push(v)
-				L.Call(3, 0)
+	for loop := 0; loop < maxTagLoop; loop++ {
+		if tbl, ok := t.(*luaTable); ok {
+			if raw || tbl.get(k) != nil || !tbl.hasMetafield("__newindex") {
+				if k == nil {
+					L.runError("table index is nil")
+				} else if f, ok := k.(float64); ok && math.IsNaN(f) {
+					L.runError("table index is NaN")
+				}
+				size := tableSize(tbl)
+				tbl.put(k, v)
+				L.allocate(tableSize(tbl) - size)
 				return
 			}
 		}
-	}
 
-	panic("not a table!")
+		var mf luaValue
+		if !raw {
+			mf = getMetafield(t, "__newindex", L)
+		}
+		if mf == nil {
+			L.typeError(t, "index") /* no metamethod */
+		}
+		if _, ok := mf.(*closure); ok {
+			L.stack.push(mf)
+			L.stack.push(t)
+			L.stack.push(k)
+			L.stack.push(v)
+			L.Call(3, 0)
+			return
+		}
+		t = mf /* else repeat assignment over 'mf' */
+	}
+	L.runError("'__newindex' chain too long; possibly a loop")
 }
@@ -76,14 +76,15 @@ func (L *luaState) LoadProto(idx int) {
 			if openuv, found := stk.openuvs[uvIdx]; found {
 				c.upvals[i] = openuv
 			} else {
-				//********************//
 				c.upvals[i] = &upvalue{&stk.slots[uvIdx]}
-				stk.openuvs[uvIdx] = &upvalue{&stk.slots[i]}
+				stk.openuvs[uvIdx] = c.upvals[i]
 			}
 		} else {
 			c.upvals[i] = stk.closure.upvals[uvIdx]
 		}
 	}
+	L.allocate(closureSize(c))
+	L.checkGC()
 }
 
 func (L *luaState) CloseUpvalues(a int) {
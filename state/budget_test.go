@@ -0,0 +1,106 @@
+package state_test
+
+import (
+	"context"
+	. "luago/api"
+	"testing"
+	"time"
+)
+
+func TestInstructionLimit(t *testing.T) {
+	const aborted = "instruction limit exceeded"
+	tests := []struct {
+		code   string
+		limit  int64
+		status int
+		result string
+	}{
+		{"local x = 0 for i = 1, 10 do x = x + i end return x", 1000, LUA_OK, "55"},
+		{"while true do end", 1000, LUA_ERRABORT, aborted},
+		{"local function f() return f() end return f()", 1000, LUA_ERRABORT, aborted},
+		// Lua代码里的pcall和xpcall拦不住中止
+		{"pcall(function() while true do end end) return 'caught'", 1000, LUA_ERRABORT, aborted},
+		{"xpcall(function() while true do end end, print) return 'caught'", 1000, LUA_ERRABORT, aborted},
+		{"while true do pcall(error) end", 1000, LUA_ERRABORT, aborted},
+		{"coroutine.wrap(function() while true do end end)()", 1000, LUA_ERRABORT, aborted},
+		{"local co = coroutine.wrap(function() while true do coroutine.yield() end end) while true do co() end",
+			1000, LUA_ERRABORT, aborted},
+		{"table.sort({3, 2, 1, 5, 4}, function(a, b) while true do end end)", 1000, LUA_ERRABORT, aborted},
+		{"string.gsub(string.rep('x', 100), 'x', function() while true do end end)", 1000, LUA_ERRABORT, aborted},
+		// 标准库里运行很久的Go循环也要扣除预算
+		{"string.find(string.rep('a', 5000), '.-.-.-.-b')", 1000, LUA_ERRABORT, aborted},
+		{"string.gsub(string.rep('a', 1e5), 'a*b', '')", 1000, LUA_ERRABORT, aborted},
+		{"table.move({}, 1, 1e15, 2)", 1000, LUA_ERRABORT, aborted},
+		{"table.insert(setmetatable({}, {__len = function() return 1e15 end}), 1, 'x')", 1000, LUA_ERRABORT, aborted},
+		{"return (string.find(string.rep('a', 100), '.-.-b'))", 1e6, LUA_OK, "nil"},
+	}
+	for _, tt := range tests {
+		L := newState()
+		L.SetInstructionLimit(tt.limit)
+		status, result := doString(L, tt.code)
+		if status != tt.status || result != tt.result {
+			t.Errorf("%s: got (%d, %q), want (%d, %q)", tt.code, status, result, tt.status, tt.result)
+		}
+	}
+}
+
+// 中止是粘滞的，直到宿主程序重新设置预算
+func TestInstructionLimitSticky(t *testing.T) {
+	L := newState()
+	L.SetInstructionLimit(100)
+	if status, _ := doString(L, "while true do end"); status != LUA_ERRABORT {
+		t.Fatalf("status = %d, want LUA_ERRABORT", status)
+	}
+	if status, _ := doString(L, "return 1"); status != LUA_ERRABORT {
+		t.Errorf("status = %d after abort, want LUA_ERRABORT", status)
+	}
+	L.SetInstructionLimit(0)
+	if status, result := doString(L, "return 1"); status != LUA_OK || result != "1" {
+		t.Errorf("got (%d, %q) after reset, want (LUA_OK, \"1\")", status, result)
+	}
+}
+
+func TestContext(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		code   string
+		ctx    func() (context.Context, context.CancelFunc)
+		status int
+		result string
+	}{
+		{"return 1 + 1", func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), time.Minute)
+		}, LUA_OK, "2"},
+		{"while true do end", func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 20*time.Millisecond)
+		}, LUA_ERRABORT, context.DeadlineExceeded.Error()},
+		{"while true do pcall(string.rep, 'x', 10) end", func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 20*time.Millisecond)
+		}, LUA_ERRABORT, context.DeadlineExceeded.Error()},
+		{"coroutine.wrap(function() while true do end end)()", func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 20*time.Millisecond)
+		}, LUA_ERRABORT, context.DeadlineExceeded.Error()},
+		{"string.find(string.rep('a', 5000), '.-.-.-.-b')", func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 500*time.Millisecond)
+		}, LUA_ERRABORT, context.DeadlineExceeded.Error()},
+		{"table.move({}, 1, 1e15, 2)", func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 20*time.Millisecond)
+		}, LUA_ERRABORT, context.DeadlineExceeded.Error()},
+		// 已经取消的context在Go函数边界上就能发现
+		{"print('unreachable')", func() (context.Context, context.CancelFunc) {
+			return canceled, func() {}
+		}, LUA_ERRABORT, context.Canceled.Error()},
+	}
+	for _, tt := range tests {
+		L := newState()
+		ctx, cancel := tt.ctx()
+		L.SetContext(ctx)
+		status, result := doString(L, tt.code)
+		cancel()
+		if status != tt.status || result != tt.result {
+			t.Errorf("%s: got (%d, %q), want (%d, %q)", tt.code, status, result, tt.status, tt.result)
+		}
+	}
+}
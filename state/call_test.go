@@ -0,0 +1,38 @@
+package state_test
+
+import (
+	. "luago/api"
+	"testing"
+)
+
+// 尾调用复用调用者的帧，被调函数是Lua函数还是Go函数都一样
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		code   string
+		status int
+		result string
+	}{
+		{"local function f(n) if n == 0 then return 'done' end return f(n - 1) end return f(1e6)", LUA_OK, "done"},
+		{"local function f() return debug.getinfo(1, 't').istailcall end local function g() return f() end return g()",
+			LUA_OK, "true"},
+		{"local function f() return debug.getinfo(0, 't') end return f().istailcall", LUA_OK, "true"},
+		{"local function f() return debug.getinfo(1, 't').istailcall end return tostring((f()))", LUA_OK, "false"},
+		// Go函数的返回值原样返回给调用者的调用者
+		{"local function f() return math.max(1, 5, 3) end return f()", LUA_OK, "5"},
+		{"local function f() return table.unpack({1, nil, 3, nil}, 1, 4) end return select('#', f())", LUA_OK, "4"},
+		{"local function f() return select('#') end return f()", LUA_OK, "0"},
+		{"local t = setmetatable({}, {__call = function(self, x) return x * 2 end}) local function f() return t(21) end return f()",
+			LUA_OK, "42"},
+		// 调用帧已经被复用，出错位置是调用者的调用者（这里是pcall）
+		{"local function f() return error('boom') end return select(2, pcall(f))", LUA_OK, "boom"},
+		// 出错时弹出的Go帧不能让Go调用深度越积越多
+		{"for i = 1, 1000 do pcall(function() return error('x') end) end return 'ok'", LUA_OK, "ok"},
+	}
+	for _, tt := range tests {
+		L := newState()
+		status, result := doString(L, tt.code)
+		if status != tt.status || result != tt.result {
+			t.Errorf("%s: got (%d, %q), want (%d, %q)", tt.code, status, result, tt.status, tt.result)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package state_test
+
+import (
+	. "luago/api"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCoroutines(t *testing.T) {
+	tests := []struct {
+		code   string
+		status int
+		result string
+	}{
+		{`local co = coroutine.create(function(a, b)
+            local c = coroutine.yield(a + b)
+            local d, e = coroutine.yield(c * 2)
+            return d + e
+          end)
+          local r = {}
+          for _, args in ipairs({{1, 2}, {10}, {3, 4}}) do
+            local ok, v = coroutine.resume(co, table.unpack(args))
+            r[#r + 1] = tostring(ok) .. ':' .. tostring(v)
+          end
+          return table.concat(r, ' ')`, LUA_OK, "true:3 true:20 true:7"},
+		{`local co = coroutine.create(function() coroutine.yield() end)
+          local s1 = coroutine.status(co)
+          coroutine.resume(co)
+          local s2 = coroutine.status(co)
+          coroutine.resume(co)
+          return s1 .. ' ' .. s2 .. ' ' .. coroutine.status(co)`, LUA_OK, "suspended suspended dead"},
+		{`local co
+          co = coroutine.create(function() return coroutine.status(co) end)
+          return select(2, coroutine.resume(co))`, LUA_OK, "running"},
+		{`local outer
+          outer = coroutine.create(function()
+            local inner = coroutine.create(function() return coroutine.status(outer) end)
+            return select(2, coroutine.resume(inner))
+          end)
+          return select(2, coroutine.resume(outer))`, LUA_OK, "normal"},
+		{`local co = coroutine.create(function() end)
+          coroutine.resume(co)
+          return select(2, coroutine.resume(co))`, LUA_OK, "cannot resume dead coroutine"},
+		{`local co = coroutine.create(function() error('boom', 0) end)
+          local ok, err = coroutine.resume(co)
+          return tostring(ok) .. ' ' .. err .. ' ' .. coroutine.status(co)`, LUA_OK, "false boom dead"},
+		// 在Lua函数之间的嵌套调用里也可以让出
+		{`local function deep(n) if n == 0 then return coroutine.yield('deep') end return deep(n - 1) end
+          local co = coroutine.wrap(function() return deep(100) + 1 end)
+          return co() .. ' ' .. co(41)`, LUA_OK, "deep 42"},
+		{`local gen = coroutine.wrap(function() for i = 1, 3 do coroutine.yield(i) end end)
+          return gen() + gen() + gen()`, LUA_OK, "6"},
+		{`local co = coroutine.wrap(function() error('boom', 0) end)
+          return select(2, pcall(co))`, LUA_OK, "boom"},
+		{`local main, ismain = coroutine.running()
+          local co = coroutine.wrap(function() return select(2, coroutine.running()) end)
+          return tostring(ismain) .. ' ' .. tostring(co()) .. ' ' .. type(main)`, LUA_OK, "true false thread"},
+		{`return tostring(coroutine.isyieldable()) .. ' ' .. tostring(coroutine.wrap(coroutine.isyieldable)())`,
+			LUA_OK, "false true"},
+		{"return select(2, pcall(coroutine.yield))", LUA_OK, "attempt to yield from outside a coroutine"},
+		{"return select('#', coroutine.wrap(function(...) return ... end)(1, nil, 3, nil))", LUA_OK, "4"},
+	}
+	for _, tt := range tests {
+		L := newState()
+		status, result := doString(L, tt.code)
+		if status != tt.status || result != tt.result {
+			t.Errorf("%s: got (%d, %q), want (%d, %q)", tt.code, status, result, tt.status, tt.result)
+		}
+		L.Close()
+	}
+}
+
+// 挂起的协程各自占着一个goroutine，关闭状态机时要让它们都退出
+func TestCloseSuspended(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		L := newState()
+		mustDoString(t, L, `cos = {}
+          for i = 1, 100 do
+            cos[i] = coroutine.wrap(function() coroutine.yield() end)
+            cos[i]()
+          end`)
+		L.Close()
+	}
+	// goroutine发出信号以后才真正退出，稍等一下
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%d goroutines left after Close, want %d", n, before)
+	}
+}
+
+func TestCloseFinalizers(t *testing.T) {
+	L := newState()
+	mustDoString(t, L, `log = {}
+      setmetatable({}, {__gc = function() log[#log + 1] = 'a' end})
+      keep = setmetatable({}, {__gc = function() log[#log + 1] = 'b' end})
+      setmetatable({}, {__gc = function() error('ignored') end})
+      co = coroutine.create(function() coroutine.yield() end)
+      coroutine.resume(co)`)
+	L.GetGlobal("log")
+	L.Close()
+	// 后登记的对象先调用__gc，__gc里的错误被忽略
+	L.GetI(-1, 1)
+	L.GetI(-2, 2)
+	if a, b := L.ToString(-2), L.ToString(-1); a != "b" || b != "a" {
+		t.Errorf("finalizers ran as %q, %q, want \"b\", \"a\"", a, b)
+	}
+	L.GetGlobal("co")
+	if status := L.ToThread(-1).Status(); status == LUA_YIELD {
+		t.Errorf("coroutine still suspended after Close")
+	}
+}
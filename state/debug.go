@@ -0,0 +1,521 @@
+package state
+
+import (
+	"fmt"
+	. "luago/api"
+	"luago/binchunk"
+	"luago/compiler/lexer"
+	"luago/vm"
+)
+
+/* 调试信息，参见ldebug.c */
+
+// 填充函数的源码信息
+// lua-5.3.4/src/ldebug.c#funcinfo()
+func funcInfo(ar *LuaDebug, c *closure) {
+	if c == nil || c.proto == nil {
+		ar.Source = "=[Go]"
+		ar.LineDefined = -1
+		ar.LastLineDefined = -1
+		ar.What = "Go"
+	} else {
+		p := c.proto
+		ar.Source = p.Source
+		if ar.Source == "" {
+			ar.Source = "=?"
+		}
+		ar.LineDefined = int(p.LineDefined)
+		ar.LastLineDefined = int(p.LastLineDefined)
+		if ar.LineDefined == 0 {
+			ar.What = "main"
+		} else {
+			ar.What = "Lua"
+		}
+	}
+	ar.ShortSrc = lexer.ChunkID(ar.Source)
+}
+
+// 正在执行的指令，调用帧里的pc总是指向下一条指令
+func currentPC(ci *luaStack) int {
+	return ci.pc - 1
+}
+
+// lua-5.3.4/src/ldebug.c#currentline()
+func currentLine(ci *luaStack) int {
+	return funcLine(ci.closure.proto, currentPC(ci))
+}
+
+// lua-5.3.4/src/ldebug.h#getfuncline()
+func funcLine(p *binchunk.Prototype, pc int) int {
+	if pc < 0 || pc >= len(p.LineInfo) {
+		return -1
+	}
+	return int(p.LineInfo[pc])
+}
+
+// 找到调用帧ci里的第n个局部变量，返回它的名字和所在的位置。
+// n为负数时表示第-n个变长参数，没有调试信息的寄存器叫做临时变量
+// lua-5.3.4/src/ldebug.c#findlocal()
+func findLocal(ci *luaStack, n int) (string, *luaValue) {
+	isLua := ci.closure != nil && ci.closure.proto != nil
+	if isLua {
+		if n < 0 { /* access to vararg values? */
+			return findVararg(ci, n)
+		}
+		if name := getLocalName(ci.closure.proto, n, currentPC(ci)); name != "" {
+			return name, &ci.slots[n-1]
+		}
+	}
+	if n > 0 && n <= stackLimit(ci) { /* is 'n' inside 'ci' stack? */
+		if isLua {
+			return "(*temporary)", &ci.slots[n-1]
+		}
+		return "(*Go temporary)", &ci.slots[n-1]
+	}
+	return "", nil /* no name */
+}
+
+// 调用帧里可以当作临时变量访问的寄存器个数。正在调用其他函数的Lua帧
+// 到被调函数所在的寄存器为止，其他情况到栈顶为止
+func stackLimit(ci *luaStack) int {
+	if ci == ci.L.stack || ci.closure == nil || ci.closure.proto == nil {
+		return ci.top
+	}
+	p := ci.closure.proto
+	if pc := currentPC(ci); pc >= 0 && pc < len(p.Code) {
+		i := vm.Instruction(p.Code[pc])
+		a, _, _ := i.ABC()
+		switch i.Opcode() {
+		case vm.OP_CALL, vm.OP_TAILCALL:
+			return a
+		case vm.OP_TFORCALL:
+			return a + 3
+		}
+	}
+	return ci.top
+}
+
+// lua-5.3.4/src/ldebug.c#findvararg()
+func findVararg(ci *luaStack, n int) (string, *luaValue) {
+	if -n > len(ci.varargs) {
+		return "", nil /* no such vararg */
+	}
+	return "(*vararg)", &ci.varargs[-n-1] /* generic name for any vararg */
+}
+
+// 把函数的有效行（有对应指令的行）收集到一张表里压入栈顶，Go函数压入nil
+// lua-5.3.4/src/ldebug.c#collectvalidlines()
+func (L *luaState) collectValidLines(c *closure) {
+	L.stack.check(1)
+	if c == nil || c.proto == nil {
+		L.stack.push(nil)
+		return
+	}
+	lineInfo := c.proto.LineInfo
+	t := newLuaTable(0, len(lineInfo))
+	for _, line := range lineInfo {
+		t.put(int64(line), true)
+	}
+	L.stack.push(t)
+}
+
+// 根据主调函数正在执行的指令推断被调函数的名字
+// lua-5.3.4/src/ldebug.c#getfuncname()
+func getFuncName(ci *luaStack) (nameWhat, name string) {
+	if ci == nil || ci.prev == nil || ci.tailcall { /* no caller information for tail calls */
+		return "", ""
+	}
+	caller := ci.prev
+	if caller.closure == nil || caller.closure.proto == nil { /* calling function is not a Lua function? */
+		return "", ""
+	}
+	return funcNameFromCode(caller)
+}
+
+// lua-5.3.4/src/ldebug.c#funcnamefromcode()
+func funcNameFromCode(ci *luaStack) (nameWhat, name string) {
+	p := ci.closure.proto
+	pc := currentPC(ci)
+	if pc < 0 || pc >= len(p.Code) {
+		return "", ""
+	}
+	if ci.hooked { /* was it called inside a hook? */
+		return "hook", "?"
+	}
+	i := vm.Instruction(p.Code[pc])
+	var tm string
+	switch op := i.Opcode(); op {
+	case vm.OP_CALL, vm.OP_TAILCALL:
+		a, _, _ := i.ABC()
+		return getObjName(p, pc, a) /* get function name */
+	case vm.OP_TFORCALL: /* for iterator */
+		return "for iterator", "for iterator"
+	/* other instructions can do calls through metamethods */
+	case vm.OP_SELF, vm.OP_GETTABUP, vm.OP_GETTABLE:
+		tm = "index"
+	case vm.OP_SETTABUP, vm.OP_SETTABLE:
+		tm = "newindex"
+	case vm.OP_ADD, vm.OP_SUB, vm.OP_MUL, vm.OP_MOD, vm.OP_POW, vm.OP_DIV, vm.OP_IDIV,
+		vm.OP_BAND, vm.OP_BOR, vm.OP_BXOR, vm.OP_SHL, vm.OP_SHR:
+		tm = arithEvents[op-vm.OP_ADD]
+	case vm.OP_UNM:
+		tm = "unm"
+	case vm.OP_BNOT:
+		tm = "bnot"
+	case vm.OP_LEN:
+		tm = "len"
+	case vm.OP_CONCAT:
+		tm = "concat"
+	case vm.OP_EQ:
+		tm = "eq"
+	case vm.OP_LT:
+		tm = "lt"
+	case vm.OP_LE:
+		tm = "le"
+	default:
+		return "", "" /* cannot find a reasonable name */
+	}
+	return "metamethod", tm
+}
+
+/* 和OP_ADD到OP_SHR的顺序一致 */
+var arithEvents = []string{
+	"add", "sub", "mul", "mod", "pow", "div", "idiv",
+	"band", "bor", "bxor", "shl", "shr",
+}
+
+// 查找第n个（从1开始）在pc处活跃的局部变量的名字
+// lua-5.3.4/src/lfunc.c#luaF_getlocalname()
+func getLocalName(p *binchunk.Prototype, n, pc int) string {
+	for _, locVar := range p.LocVars {
+		if int(locVar.StartPC) > pc {
+			break
+		}
+		if pc < int(locVar.EndPC) { /* is variable active? */
+			n--
+			if n == 0 {
+				return locVar.VarName
+			}
+		}
+	}
+	return "" /* not found */
+}
+
+func protoUpvalueName(p *binchunk.Prototype, uv int) string {
+	if uv < len(p.UpvalueNames) {
+		return p.UpvalueNames[uv]
+	}
+	return "?"
+}
+
+// 推断寄存器reg里的值在lastpc处的名字
+// lua-5.3.4/src/ldebug.c#getobjname()
+func getObjName(p *binchunk.Prototype, lastpc, reg int) (nameWhat, name string) {
+	if name = getLocalName(p, reg+1, lastpc); name != "" {
+		return "local", name
+	}
+	/* else try symbolic execution */
+	pc := findSetReg(p, lastpc, reg)
+	if pc == -1 { /* could not find instruction? */
+		return "", ""
+	}
+	i := vm.Instruction(p.Code[pc])
+	switch op := i.Opcode(); op {
+	case vm.OP_MOVE:
+		a, b, _ := i.ABC()
+		if b < a {
+			return getObjName(p, pc, b) /* get name for 'b' */
+		}
+	case vm.OP_GETTABUP, vm.OP_GETTABLE:
+		_, t, k := i.ABC() /* table index, key index */
+		var vn string
+		if op == vm.OP_GETTABLE {
+			vn = getLocalName(p, t+1, pc)
+		} else {
+			vn = protoUpvalueName(p, t)
+		}
+		name = kName(p, pc, k)
+		if vn == "_ENV" {
+			return "global", name
+		}
+		return "field", name
+	case vm.OP_GETUPVAL:
+		_, b, _ := i.ABC()
+		return "upvalue", protoUpvalueName(p, b)
+	case vm.OP_LOADK, vm.OP_LOADKX:
+		_, b := i.ABx()
+		if op == vm.OP_LOADKX {
+			b = vm.Instruction(p.Code[pc+1]).Ax()
+		}
+		if s, ok := p.Constants[b].(string); ok {
+			return "constant", s
+		}
+	case vm.OP_SELF:
+		_, _, k := i.ABC()
+		return "method", kName(p, pc, k)
+	}
+	return "", "" /* could not find reasonable name */
+}
+
+// 找到RK(c)对应的名字
+// lua-5.3.4/src/ldebug.c#kname()
+func kName(p *binchunk.Prototype, pc, c int) string {
+	if c > 0xFF { /* is 'c' a constant? */
+		if s, ok := p.Constants[c&0xFF].(string); ok { /* literal constant? */
+			return s /* it is its own name */
+		}
+		/* else no reasonable name found */
+	} else { /* 'c' is a register */
+		if what, name := getObjName(p, pc, c); what == "constant" { /* found a constant name? */
+			return name /* 'name' already filled */
+		}
+		/* else no reasonable name found */
+	}
+	return "?" /* no reasonable name found */
+}
+
+func filterPC(pc, jmptarget int) int {
+	if pc < jmptarget { /* is code conditional (inside a jump)? */
+		return -1 /* cannot know who sets that register */
+	}
+	return pc /* current position sets that register */
+}
+
+// 找到最后一条修改寄存器reg的指令
+// lua-5.3.4/src/ldebug.c#findsetreg()
+func findSetReg(p *binchunk.Prototype, lastpc, reg int) int {
+	setreg := -1   /* keep last instruction that changed 'reg' */
+	jmptarget := 0 /* any code before this address is conditional */
+	for pc := 0; pc < lastpc; pc++ {
+		i := vm.Instruction(p.Code[pc])
+		a, b, _ := i.ABC()
+		switch i.Opcode() {
+		case vm.OP_LOADNIL:
+			if a <= reg && reg <= a+b { /* set registers from 'a' to 'a+b' */
+				setreg = filterPC(pc, jmptarget)
+			}
+		case vm.OP_TFORCALL:
+			if reg >= a+2 { /* affect all regs above its base */
+				setreg = filterPC(pc, jmptarget)
+			}
+		case vm.OP_CALL, vm.OP_TAILCALL:
+			if reg >= a { /* affect all registers above base */
+				setreg = filterPC(pc, jmptarget)
+			}
+		case vm.OP_JMP:
+			_, sbx := i.AsBx()
+			dest := pc + 1 + sbx
+			/* jump is forward and do not skip 'lastpc'? */
+			if pc < dest && dest <= lastpc {
+				if dest > jmptarget {
+					jmptarget = dest /* update 'jmptarget' */
+				}
+			}
+		default:
+			if i.SetsA() && reg == a { /* any instruction that set A */
+				setreg = filterPC(pc, jmptarget)
+			}
+		}
+	}
+	return setreg
+}
+
+/* 钩子 */
+
+// 在当前调用帧里调用钩子函数，钩子函数执行期间不会再触发钩子
+// lua-5.3.4/src/ldo.c#luaD_hook()
+func (L *luaState) callHook(event, line int) {
+	if L.hook == nil || !L.allowHook {
+		return
+	}
+	ci := L.stack
+	top := ci.top
+	ar := &LuaDebug{Event: event, CurrentLine: line, CallInfo: ci}
+	ci.check(LUA_MINSTACK) /* ensure minimum stack size */
+	L.allowHook = false    /* cannot call hooks inside a hook */
+	ci.hooked = true
+	L.hook(L, ar)
+	// 钩子出错时这里不会执行，由PCall恢复allowHook，出错的调用帧会被弹出
+	L.allowHook = true
+	ci.hooked = false
+	for ci.top > top {
+		ci.pop()
+	}
+}
+
+// 调用钩子。Lua函数的pc此时还指向第一条指令，临时加一让钩子能拿到正确的行号
+// lua-5.3.4/src/ldo.c#callhook()
+func (L *luaState) callCallHook(event int) {
+	ci := L.stack
+	if ci.closure.proto != nil {
+		ci.pc++ /* hooks assume 'pc' is already incremented */
+		defer func() { ci.pc-- }()
+	}
+	L.callHook(event, -1)
+}
+
+// 返回钩子，调用帧还没有弹出，主调函数恢复执行时不应该再触发同一行的行事件
+// lua-5.3.4/src/ldo.c#luaD_poscall()
+func (L *luaState) callRetHook() {
+	if L.hookMask&LUA_MASKRET != 0 {
+		L.callHook(LUA_HOOKRET, -1)
+	}
+	if caller := L.stack.prev; caller != nil && caller.closure != nil && caller.closure.proto != nil {
+		L.oldPC = currentPC(caller) /* 'oldPC' for caller function */
+	}
+}
+
+// 每条指令执行之前调用，负责计数事件和行事件
+// lua-5.3.4/src/ldebug.c#luaG_traceexec()
+func (L *luaState) traceExec() {
+	ci := L.stack
+	mask := L.hookMask
+	L.hookCount--
+	countHook := L.hookCount == 0 && mask&LUA_MASKCOUNT != 0
+	if countHook {
+		L.hookCount = L.baseHookCount /* reset count */
+	} else if mask&LUA_MASKLINE == 0 {
+		return /* no line hook and count != 0; nothing to be done */
+	}
+	if countHook {
+		L.callHook(LUA_HOOKCOUNT, -1) /* call count hook */
+	}
+	if mask&LUA_MASKLINE != 0 {
+		p := ci.closure.proto
+		npc := currentPC(ci)
+		newLine := funcLine(p, npc)
+		if npc == 0 || /* call linehook when enter a new function, */
+			npc <= L.oldPC || /* when jump back (loop), or when */
+			newLine != funcLine(p, L.oldPC) { /* enter a new line */
+			L.callHook(LUA_HOOKLINE, newLine) /* call line hook */
+		}
+	}
+	L.oldPC = currentPC(ci)
+}
+
+/* 运行时错误 */
+
+// 抛出运行时错误，如果正在执行的是Lua函数，出错信息前面加上"chunkname:currentline:"
+// lua-5.3.4/src/ldebug.c#luaG_runerror()
+func (L *luaState) runError(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	if ci := L.stack; ci.closure != nil && ci.closure.proto != nil { /* if Lua function, add source:line information */
+		msg = fmt.Sprintf("%s:%d: %s", lexer.ChunkID(ci.closure.proto.Source), currentLine(ci), msg)
+	}
+	panic(msg)
+}
+
+// 值的类型名，表和完全用户数据优先使用元表里的__name字段
+// lua-5.3.4/src/ltm.c#luaT_objtypename()
+func (L *luaState) objTypeName(val luaValue) string {
+	switch val.(type) {
+	case *luaTable, *userdata:
+		if name, ok := getMetafield(val, "__name", L).(string); ok { /* is '__name' a string? */
+			return name /* use it as type name */
+		}
+	}
+	return L.TypeName(typeOf(val)) /* else use standard type name */
+}
+
+// 在当前指令的操作数里查找val，推断它的名字，返回" (local 'x')"这样的描述。
+// 值在栈上是以副本的形式传递的，所以这里和指令的操作数逐个比较，
+// 找到第一个和val相等的寄存器（或者上值）
+// lua-5.3.4/src/ldebug.c#varinfo()
+func (L *luaState) varInfo(val luaValue) string {
+	ci := L.stack
+	if ci.closure == nil || ci.closure.proto == nil {
+		return "" /* no information */
+	}
+	p := ci.closure.proto
+	pc := currentPC(ci)
+	if pc < 0 || pc >= len(p.Code) {
+		return ""
+	}
+	i := vm.Instruction(p.Code[pc])
+	a, b, c := i.ABC()
+	var regs []int
+	switch op := i.Opcode(); op {
+	case vm.OP_GETTABUP: /* indexed value is an upvalue */
+		return L.upvalInfo(ci, b, val)
+	case vm.OP_SETTABUP:
+		return L.upvalInfo(ci, a, val)
+	case vm.OP_GETTABLE, vm.OP_SELF, vm.OP_UNM, vm.OP_BNOT, vm.OP_LEN:
+		regs = []int{b}
+	case vm.OP_SETTABLE, vm.OP_CALL, vm.OP_TAILCALL:
+		regs = []int{a}
+	case vm.OP_CONCAT:
+		for r := b; r <= c; r++ {
+			regs = append(regs, r)
+		}
+	default:
+		if op >= vm.OP_ADD && op <= vm.OP_SHR {
+			for _, rk := range []int{b, c} {
+				if rk <= 0xFF { /* constants are not in the stack */
+					regs = append(regs, rk)
+				}
+			}
+		}
+	}
+	for _, reg := range regs {
+		if eq(ci.get(reg+1), val, nil) {
+			if kind, name := getObjName(p, pc, reg); kind != "" {
+				return fmt.Sprintf(" (%s '%s')", kind, name)
+			}
+			break
+		}
+	}
+	return ""
+}
+
+// lua-5.3.4/src/ldebug.c#getupvalname()
+func (L *luaState) upvalInfo(ci *luaStack, uv int, val luaValue) string {
+	c := ci.closure
+	if uv < len(c.upvals) && eq(*c.upvals[uv].val, val, nil) {
+		return fmt.Sprintf(" (upvalue '%s')", protoUpvalueName(c.proto, uv))
+	}
+	return ""
+}
+
+// lua-5.3.4/src/ldebug.c#luaG_typeerror()
+func (L *luaState) typeError(val luaValue, op string) {
+	t := L.objTypeName(val)
+	L.runError("attempt to %s a %s value%s", op, t, L.varInfo(val))
+}
+
+// 两个操作数里找出出错的那个，第一个操作数不能转换成数字时就是它
+// lua-5.3.4/src/ldebug.c#luaG_opinterror()
+func (L *luaState) opIntError(p1, p2 luaValue, msg string) {
+	if _, ok := convertToFloat(p1); !ok { /* first operand is wrong? */
+		p2 = p1 /* now second is wrong too */
+	}
+	L.typeError(p2, msg)
+}
+
+// 位运算的操作数是数字但是不能转换成整数
+// lua-5.3.4/src/ldebug.c#luaG_tointerror()
+func (L *luaState) toIntError(p1, p2 luaValue) {
+	if _, ok := convertToInteger(p1); !ok {
+		p2 = p1
+	}
+	L.runError("number%s has no integer representation", L.varInfo(p2))
+}
+
+// lua-5.3.4/src/ldebug.c#luaG_concaterror()
+func (L *luaState) concatError(p1, p2 luaValue) {
+	switch p1.(type) {
+	case string, int64, float64:
+		p1 = p2
+	}
+	L.typeError(p1, "concatenate")
+}
+
+// lua-5.3.4/src/ldebug.c#luaG_ordererror()
+func (L *luaState) orderError(p1, p2 luaValue) {
+	t1 := L.objTypeName(p1)
+	t2 := L.objTypeName(p2)
+	if t1 == t2 {
+		L.runError("attempt to compare two %s values", t1)
+	} else {
+		L.runError("attempt to compare %s with %s", t1, t2)
+	}
+}
@@ -0,0 +1,50 @@
+package state_test
+
+import (
+	. "luago/api"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		code   string
+		status int
+		result string
+	}{
+		{"error('boom', 0)", LUA_ERRRUN, "boom"},
+		{"error('boom')", LUA_ERRRUN, `[string "error('boom')"]:1: boom`},
+		// 不是字符串的错误对象原样传递
+		{"local e = {} local ok, err = pcall(error, e) return rawequal(err, e)", LUA_OK, "true"},
+		{"return select(2, xpcall(error, function(m) return 'handled: ' .. m end, 'boom', 0))",
+			LUA_OK, "handled: boom"},
+		{"local t = nil return t.x", LUA_ERRRUN, `[string "local t = nil return t.x"]:1: attempt to index a nil value (local 't')`},
+		// 整数除以0是运行时错误，浮点数除以0得到inf
+		{"local a, b = 1, 0 return a // b", LUA_ERRRUN, `[string "local a, b = 1, 0 return a // b"]:1: attempt to perform 'n//0'`},
+		{"local a, b = 1, 0 return a % b", LUA_ERRRUN, `[string "local a, b = 1, 0 return a % b"]:1: attempt to perform 'n%0'`},
+		{"return select(2, pcall(function() return 1 // 0 end)):match(':1: (.*)')", LUA_OK, "attempt to perform 'n//0'"},
+		{"return select(2, pcall(function() return 1 % 0 end)):match(':1: (.*)')", LUA_OK, "attempt to perform 'n%0'"},
+		{"return 1 // 0.0 .. ' ' .. -1 // 0.0 .. ' ' .. 1 / 0", LUA_OK, "inf -inf inf"},
+		{"return math.mininteger // -1 == math.mininteger and math.mininteger % -1 == 0", LUA_OK, "true"},
+		// __index和__newindex形成的环不能无限循环下去
+		{"local t = {} setmetatable(t, {__index = t}) return select(2, pcall(function() return t.x end)):match(':1: (.*)')",
+			LUA_OK, "'__index' chain too long; possibly a loop"},
+		{"local t = {} setmetatable(t, {__newindex = t}) return select(2, pcall(function() t.x = 1 end)):match(':1: (.*)')",
+			LUA_OK, "'__newindex' chain too long; possibly a loop"},
+		{"local a, b = {}, {} setmetatable(a, {__index = b}) setmetatable(b, {__index = a}) return (pcall(rawlen, a.x))",
+			LUA_ERRRUN, `[string "local a, b = {}, {} setmetatable(a, {__index ..."]:1: '__index' chain too long; possibly a loop`},
+		// 长度不超过上限的链可以正常访问
+		{"local t = {x = 'found'} for i = 1, 1000 do t = setmetatable({}, {__index = t}) end return t.x",
+			LUA_OK, "found"},
+		{"local base = {} local t = base for i = 1, 1000 do t = setmetatable({}, {__newindex = t}) end t.x = 'set' return base.x",
+			LUA_OK, "set"},
+		{"local s = setmetatable({}, {__index = function(t, k) return k .. '!' end}) return s.hi", LUA_OK, "hi!"},
+		{"return ('x'):rep(3)", LUA_OK, "xxx"},
+	}
+	for _, tt := range tests {
+		L := newState()
+		status, result := doString(L, tt.code)
+		if status != tt.status || result != tt.result {
+			t.Errorf("%s: got (%d, %q), want (%d, %q)", tt.code, status, result, tt.status, tt.result)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package state
+
+import (
+	"luago/binchunk"
+	"reflect"
+	"unsafe"
+)
+
+/* 内存统计 */
+
+// 对象的内存由Go的垃圾收集器管理，这里只是粗略地估算Lua对象占用了多少内存：
+// 分配对象时把估计的大小记到账上，超过上限（或者显式地收集垃圾）时再从根对象出发
+// 遍历所有可达的对象，用它们的大小之和校正账面上的数字。
+// 弱表也在这次遍历里处理：只通过弱引用可达的对象不会被标记，遍历结束后把弱表里
+// 引用了这些对象的键值对删掉，之后Go的垃圾收集器就可以回收它们了。
+// 需要调用__gc元方法的不可达对象会被复活，直到元方法调用完之后才真正变成垃圾
+
+// 各种对象的估计大小（字节），按64位平台计算
+const (
+	sizeValue    = 16 // 一个luaValue（interface{}）
+	sizeMapEntry = 40 // 哈希部分的一个键值对
+	sizeTable    = 96
+	sizeClosure  = 48
+	sizeUpvalue  = 32 // 上值的指针和它指向的值
+	sizeStack    = 96
+	sizeUserdata = 64
+	sizeThread   = 128
+	sizeString   = 16
+	sizeProto    = 128
+)
+
+func tableSize(t *luaTable) int64 {
+	return sizeTable + int64(cap(t.arr))*sizeValue + int64(len(t.mp))*sizeMapEntry
+}
+
+func closureSize(c *closure) int64 {
+	return sizeClosure + int64(len(c.upvals))*sizeUpvalue
+}
+
+// 变长参数在调用帧之外单独统计
+func stackSize(s *luaStack) int64 {
+	return sizeStack + int64(len(s.slots))*sizeValue
+}
+
+func stringSize(s string) int64 {
+	return sizeString + int64(len(s))
+}
+
+// 函数原型（包括子函数原型）的大小
+func protoSize(p *binchunk.Prototype) int64 {
+	n := int64(sizeProto)
+	n += int64(len(p.Code)) * 4
+	n += int64(len(p.Constants)) * sizeValue
+	n += int64(len(p.LineInfo)) * 4
+	n += int64(len(p.Upvalues)) * 2
+	n += int64(len(p.LocVars)) * 24
+	for _, locVar := range p.LocVars {
+		n += int64(len(locVar.VarName))
+	}
+	for _, name := range p.UpvalueNames {
+		n += stringSize(name)
+	}
+	n += int64(len(p.Source))
+	for _, subProto := range p.Protos {
+		n += protoSize(subProto)
+	}
+	return n
+}
+
+// 字符串的身份是它底层字节数组的地址和长度。内容相同但是分别分配的字符串
+// 要分别计算，否则脚本可以用很多份相同内容的大字符串绕过内存上限
+type stringID struct {
+	data uintptr
+	len  int
+}
+
+func idOfString(s string) stringID {
+	h := (*reflect.StringHeader)(unsafe.Pointer(&s))
+	return stringID{h.Data, h.Len}
+}
+
+// 从根对象出发遍历所有可达的对象，统计它们的大小
+type tracer struct {
+	visited   map[interface{}]bool // 已经遍历过的对象
+	strings   map[stringID]bool    // 同一个字符串对象只算一次
+	gray      []interface{}        // 已经标记但是还没有遍历的对象
+	weak      []*luaTable          // 弱值表
+	ephemeron []*luaTable          // 弱键表
+	allweak   []*luaTable          // 键和值都是弱引用的表
+	size      int64
+}
+
+func newTracer() *tracer {
+	return &tracer{
+		visited: map[interface{}]bool{},
+		strings: map[stringID]bool{},
+	}
+}
+
+// 标记一个值，需要遍历的对象放进gray
+func (T *tracer) mark(val luaValue) {
+	switch x := val.(type) {
+	case string:
+		if id := idOfString(x); !T.strings[id] {
+			T.strings[id] = true
+			T.size += stringSize(x)
+		}
+	case *luaTable, *closure, *userdata, *luaState:
+		if !T.visited[x] {
+			T.visited[x] = true
+			T.gray = append(T.gray, x)
+		}
+	}
+}
+
+// 遍历所有已经标记的对象，直到没有新的对象被标记
+func (T *tracer) propagate() {
+	for len(T.gray) > 0 {
+		obj := T.gray[len(T.gray)-1]
+		T.gray = T.gray[:len(T.gray)-1]
+		switch x := obj.(type) {
+		case *luaTable:
+			T.traverseTable(x)
+		case *closure:
+			T.traverseClosure(x)
+		case *userdata:
+			T.size += sizeUserdata
+			T.markTable(x.metatable)
+			T.mark(x.uservalue)
+		case *luaState:
+			T.traverseThread(x)
+		}
+	}
+}
+
+func (T *tracer) markTable(t *luaTable) {
+	if t != nil {
+		T.mark(t)
+	}
+}
+
+func (T *tracer) traverseTable(t *luaTable) {
+	T.size += tableSize(t)
+	T.markTable(t.metatable)
+	weakKey, weakValue := t.weakMode()
+	switch {
+	case weakKey && weakValue: /* nothing to traverse now */
+		T.allweak = append(T.allweak, t)
+	case weakKey:
+		T.traverseEphemeron(t)
+		T.ephemeron = append(T.ephemeron, t)
+	case weakValue:
+		for k := range t.mp {
+			T.mark(k)
+		}
+		T.weak = append(T.weak, t)
+	default:
+		for _, v := range t.arr {
+			T.mark(v)
+		}
+		for k, v := range t.mp {
+			T.mark(k)
+			T.mark(v)
+		}
+	}
+}
+
+// 弱键表（ephemeron）：只有键已经被标记了，才标记对应的值。
+// 数组部分的键都是整数，值总是要标记的。返回是否有新的对象需要遍历
+func (T *tracer) traverseEphemeron(t *luaTable) bool {
+	n := len(T.gray)
+	for _, v := range t.arr {
+		T.mark(v)
+	}
+	for k, v := range t.mp {
+		if !T.isCleared(k) {
+			T.mark(v)
+		}
+	}
+	return len(T.gray) > n
+}
+
+// 值的可达性可能依赖于别的弱键表里的键，所以要反复遍历弱键表，直到没有新的对象被标记
+func (T *tracer) convergeEphemerons() {
+	for changed := true; changed; {
+		changed = false
+		for i := 0; i < len(T.ephemeron); i++ {
+			if T.traverseEphemeron(T.ephemeron[i]) {
+				T.propagate()
+				changed = true
+			}
+		}
+	}
+}
+
+// 弱表里的键或者值是否应该被清除：只有没被标记的表、函数、用户数据和线程会被清除。
+// 字符串和数字、布尔值一样不会从弱表里清除
+func (T *tracer) isCleared(val luaValue) bool {
+	switch val.(type) {
+	case *luaTable, *closure, *userdata, *luaState:
+		return !T.visited[val]
+	case string:
+		T.mark(val) /* strings are 'values', so are never weak */
+	}
+	return false
+}
+
+// 删除弱键表里键没被标记的键值对
+func (T *tracer) clearKeys() {
+	for _, t := range T.ephemeron {
+		T.clearByKeys(t)
+	}
+	for _, t := range T.allweak {
+		T.clearByKeys(t)
+	}
+}
+
+// 删除弱值表里值没被标记的键值对
+func (T *tracer) clearValues() {
+	for _, t := range T.weak {
+		T.clearByValues(t)
+	}
+	for _, t := range T.allweak {
+		T.clearByValues(t)
+	}
+}
+
+func (T *tracer) clearByKeys(t *luaTable) {
+	for k := range t.mp {
+		if T.isCleared(k) {
+			delete(t.mp, k)
+			T.size -= sizeMapEntry
+		}
+	}
+}
+
+func (T *tracer) clearByValues(t *luaTable) {
+	for i := len(t.arr); i >= 1; i-- { // 从后往前删，数组部分末尾的nil会被收缩掉
+		if i <= len(t.arr) && T.isCleared(t.arr[i-1]) {
+			t.put(int64(i), nil)
+		}
+	}
+	for k, v := range t.mp {
+		if T.isCleared(v) {
+			delete(t.mp, k)
+			T.size -= sizeMapEntry
+		}
+	}
+}
+
+func (T *tracer) traverseClosure(c *closure) {
+	T.size += closureSize(c)
+	if p := c.proto; p != nil && !T.visited[p] {
+		T.visited[p] = true
+		T.size += protoSize(p)
+		T.markProtoConstants(p)
+	}
+	for _, uv := range c.upvals {
+		if uv != nil {
+			T.mark(*uv.val)
+		}
+	}
+}
+
+func (T *tracer) markProtoConstants(p *binchunk.Prototype) {
+	for _, k := range p.Constants {
+		T.mark(k)
+	}
+	for _, subProto := range p.Protos {
+		T.markProtoConstants(subProto)
+	}
+}
+
+// 线程的每个调用帧：寄存器、变长参数和正在执行的闭包
+func (T *tracer) traverseThread(L *luaState) {
+	T.size += sizeThread
+	for stack := L.stack; stack != nil; stack = stack.prev {
+		T.size += stackSize(stack) + int64(len(stack.varargs))*sizeValue
+		for _, v := range stack.slots[:stackLimit(stack)] { /* dead registers are not roots */
+			T.mark(v)
+		}
+		for _, v := range stack.varargs {
+			T.mark(v)
+		}
+		if stack.closure != nil {
+			T.mark(stack.closure)
+		}
+	}
+}
+
+// 根对象是注册表、正在运行的线程（以及恢复它的那些线程）和等待调用__gc的对象。
+// 统计的同时清理弱表、找出需要调用__gc的对象、关闭不可达的协程，返回可达对象的估计大小
+// lua-5.3.4/src/lgc.c#atomic()
+func (L *luaState) traceMemory() int64 {
+	T := newTracer()
+	T.mark(L.registry)
+	for t := L; t != nil; t = t.coCaller {
+		T.mark(t)
+	}
+	for _, obj := range L.mem.tobefnz {
+		T.mark(obj)
+	}
+	T.propagate()
+	T.convergeEphemerons()
+	/* at this point, all strongly accessible objects are marked. */
+	/* Clear values from weak tables, before checking finalizers */
+	T.clearValues()
+	/* separate objects to be finalized and resurrect them */
+	L.mem.separateToBeFnz(func(obj luaValue) bool { return T.visited[obj] })
+	for _, obj := range L.mem.tobefnz {
+		T.mark(obj)
+	}
+	T.propagate()
+	T.convergeEphemerons()
+	/* at this point, all resurrected objects are marked. */
+	T.clearKeys()
+	T.clearValues()
+	for t := range L.mem.suspended {
+		if !T.visited[t] {
+			t.closeThread()
+		}
+	}
+	return T.size
+}
@@ -0,0 +1,106 @@
+package state_test
+
+import (
+	. "luago/api"
+	. "luago/binchunk"
+	"luago/compiler"
+	. "luago/vm"
+	"testing"
+)
+
+func TestMemoryLimit(t *testing.T) {
+	const nomem = "not enough memory"
+	tests := []struct {
+		code   string
+		status int
+		result string
+	}{
+		{"local t = {} for i = 1, 1e7 do t[i] = i end", LUA_ERRMEM, nomem},
+		{"local t = {} for i = 1, 1e7 do t['k' .. i] = true end", LUA_ERRMEM, nomem},
+		{"local t = {} while true do t = {t} end", LUA_ERRMEM, nomem},
+		{"local s = 'x' while true do s = s .. s end", LUA_ERRMEM, nomem},
+		{"return string.rep('x', 1 << 30)", LUA_ERRMEM, nomem},
+		// 栈扩容之前就要检查内存上限
+		{"return select('#', table.unpack({}, 1, 999000))", LUA_ERRMEM, nomem},
+		{"return table.concat({string.rep('x', 1 << 19), string.rep('y', 1 << 19)})", LUA_ERRMEM, nomem},
+		// 内容相同的字符串，每次分配都要计入
+		{"local s, t = string.rep('x', 1 << 16), {} for i = 1, 300 do t[i] = s:upper() end",
+			LUA_ERRMEM, nomem},
+		{"local f = function() end local t = {} for i = 1, 1e6 do t[i] = function() return i end end",
+			LUA_ERRMEM, nomem},
+		// pcall能捕获内存错误，不可达的对象不再计入
+		{"local ok, err = pcall(function() local t = {} while true do t[#t + 1] = {} end end) " +
+			"return tostring(ok) .. ' ' .. err", LUA_OK, "false " + nomem},
+		{"for i = 1, 1e5 do local t = {i, i, i} end return 'ok'", LUA_OK, "ok"},
+		{"local s = '' for i = 1, 1e4 do s = string.rep('x', 1000) .. i end return #s", LUA_OK, "1005"},
+	}
+	for _, tt := range tests {
+		L := newState()
+		L.SetMemoryLimit(1 << 20)
+		status, result := doString(L, tt.code)
+		if status != tt.status || result != tt.result {
+			t.Errorf("%s: got (%d, %q), want (%d, %q)", tt.code, status, result, tt.status, tt.result)
+		}
+	}
+}
+
+// 没有校验的二进制chunk可以在NEWTABLE里给出巨大的大小提示，
+// 分配之前就要检查内存上限，而不是先分配一个巨大的表
+func TestMemoryLimitTableHint(t *testing.T) {
+	proto := compiler.Compile("return {}", "=test")
+	for pc, code := range proto.Code {
+		if i := Instruction(code); i.Opcode() == OP_NEWTABLE {
+			a, _, _ := i.ABC()
+			proto.Code[pc] = uint32(0xff<<23 | 0xff<<14 | a<<6 | OP_NEWTABLE)
+		}
+	}
+
+	L := newState()
+	L.SetMemoryLimit(1 << 20)
+	if L.Load(Dump(proto, false), "=test", "b") != LUA_OK {
+		t.Fatal(L.ToString(-1))
+	}
+	if status := L.PCall(0, 0, 0); status != LUA_ERRMEM {
+		t.Errorf("status = %d, want LUA_ERRMEM", status)
+	}
+	if L.Load(Dump(proto, false), "=test", "bv") != LUA_ERRSYNTAX {
+		t.Errorf("Load() with verification accepted a huge size hint")
+	}
+}
+
+func TestMemoryCount(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"return collectgarbage('count') > 0", "true"},
+		{"return math.type(collectgarbage('count'))", "float"},
+		{`local before = collectgarbage('count')
+          local t = {} for i = 1, 1e5 do t[i] = i end
+          return collectgarbage('count') - before > 1000`, "true"},
+		{`local t = {} for i = 1, 1e5 do t[i] = i end
+          local mid = collectgarbage('count')
+          t = nil collectgarbage()
+          return collectgarbage('count') < mid - 1000`, "true"},
+		{`local t = {} for i = 1, 100 do t[i] = string.rep('x', 1e4) end
+          collectgarbage()
+          return collectgarbage('count') > 1000`, "true"},
+		{"collectgarbage('stop') local r = collectgarbage('isrunning') collectgarbage('restart') return r", "false"},
+		{"return collectgarbage('setpause', 100) .. ' ' .. collectgarbage('setpause', 200)", "200 100"},
+		{"return (pcall(collectgarbage, 'bogus'))", "false"},
+	}
+	for _, tt := range tests {
+		L := newState()
+		if got := mustDoString(t, L, tt.code); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+
+	L := newState()
+	if n := L.GC(LUA_GCCOUNT, 0); n <= 0 {
+		t.Errorf("GC(LUA_GCCOUNT) = %d", n)
+	}
+	if n := L.GC(LUA_GCCOUNTB, 0); n < 0 || n >= 1024 {
+		t.Errorf("GC(LUA_GCCOUNTB) = %d", n)
+	}
+}
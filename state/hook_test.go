@@ -0,0 +1,133 @@
+package state_test
+
+import (
+	"fmt"
+	. "luago/api"
+	. "luago/auxlib"
+	"strings"
+	"testing"
+)
+
+// 用Go钩子记录事件，行事件记录行号，调用和返回事件记录函数名（主函数没有名字）
+func TestHookEvents(t *testing.T) {
+	code := `local function f(x)
+  return x + 1
+end
+local y = 0
+for i = 1, 2 do
+  y = f(y)
+end
+return y`
+
+	tests := []struct {
+		mask int
+		want string
+	}{
+		{LUA_MASKLINE, "l3 l4 l5 l6 l2 l5 l6 l2 l5 l8"},
+		{LUA_MASKCALL, "c: c:f c:f"},
+		{LUA_MASKRET, "r:f r:f r:"},
+		{LUA_MASKCALL | LUA_MASKRET, "c: c:f r:f c:f r:f r:"},
+	}
+	for _, tt := range tests {
+		L := newState()
+		if LoadString(L, code) != LUA_OK {
+			t.Fatal(L.ToString(-1))
+		}
+		var events []string
+		L.SetHook(func(L LuaState, ar *LuaDebug) {
+			switch ar.Event {
+			case LUA_HOOKLINE:
+				events = append(events, fmt.Sprintf("l%d", ar.CurrentLine))
+			case LUA_HOOKCALL, LUA_HOOKRET:
+				L.GetInfo("n", ar)
+				events = append(events, fmt.Sprintf("%c:%s", "cr"[ar.Event], ar.Name))
+			}
+		}, tt.mask, 0)
+		if status := L.PCall(0, 1, 0); status != LUA_OK {
+			t.Fatalf("mask %d: %s", tt.mask, L.ToString(-1))
+		}
+		L.SetHook(nil, 0, 0)
+		if got := strings.Join(events, " "); got != tt.want {
+			t.Errorf("mask %d: events = %q, want %q", tt.mask, got, tt.want)
+		}
+		if n := L.ToInteger(-1); n != 2 {
+			t.Errorf("mask %d: result = %d, want 2", tt.mask, n)
+		}
+	}
+}
+
+// 计数钩子每执行count条指令调用一次，可以在钩子里抛出错误中止死循环
+func TestHookCount(t *testing.T) {
+	tests := []struct {
+		count int
+		limit int // 钩子被调用这么多次以后抛出错误
+	}{
+		{1, 1000},
+		{100, 10},
+		{1000, 1},
+	}
+	for _, tt := range tests {
+		L := newState()
+		calls := 0
+		L.SetHook(func(L LuaState, ar *LuaDebug) {
+			if ar.Event != LUA_HOOKCOUNT {
+				t.Errorf("count %d: event = %d", tt.count, ar.Event)
+			}
+			if calls++; calls == tt.limit {
+				L.PushString("stop")
+				L.Error()
+			}
+		}, LUA_MASKCOUNT, tt.count)
+		if L.GetHookMask() != LUA_MASKCOUNT || L.GetHookCount() != tt.count || L.GetHook() == nil {
+			t.Errorf("count %d: GetHookMask() = %d, GetHookCount() = %d",
+				tt.count, L.GetHookMask(), L.GetHookCount())
+		}
+		status, msg := doString(L, "while true do end")
+		if status != LUA_ERRRUN || msg != "stop" || calls != tt.limit {
+			t.Errorf("count %d: status %d, %q after %d calls", tt.count, status, msg, calls)
+		}
+	}
+}
+
+func TestDebugSetHook(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{`local t = {}
+          debug.sethook(function(e, l) t[#t + 1] = l end, "l")
+          local x = 1
+          x = x + 1
+          debug.sethook()
+          return table.concat(t, " ")`, "3 4 5"},
+		{`local n = 0
+          debug.sethook(function(e) n = n + 1 end, "", 10)
+          for i = 1, 100 do end
+          debug.sethook()
+          return n > 0`, "true"},
+		{`local t = {}
+          local function f() end
+          debug.sethook(function(e) t[#t + 1] = e end, "cr")
+          f()
+          debug.sethook()
+          return table.concat(t, " ")`, "return call return call"},
+		{`local h = function() end
+          debug.sethook(h, "crl", 7)
+          local f, mask, count = debug.gethook()
+          debug.sethook()
+          return tostring(f == h) .. mask .. count`, "truecrl7"},
+		{`return debug.gethook()`, "nil"},
+		// 钩子运行期间不会再触发钩子
+		{`local n = 0
+          debug.sethook(function() n = n + 1; local a = 1; a = a + 1 end, "l")
+          local x = 1
+          debug.sethook()
+          return n`, "2"},
+	}
+	for _, tt := range tests {
+		L := newState()
+		if got := mustDoString(t, L, tt.code); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
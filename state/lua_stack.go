@@ -8,11 +8,13 @@ type luaStack struct {
 	slots []luaValue
 	top   int
 	/* call info*/
-	L       *luaState
-	closure *closure
-	varargs []luaValue
-	openuvs map[int]*upvalue
-	pc      int
+	L        *luaState
+	closure  *closure
+	varargs  []luaValue
+	openuvs  map[int]*upvalue
+	pc       int
+	tailcall bool // 是否由尾调用调起（复用了主调函数的调用帧）
+	hooked   bool // 是否正在执行钩子函数
 	/* linked list*/
 	prev *luaStack
 }
@@ -48,9 +50,28 @@ func (S *luaStack) absIndex(idx int) int {
 
 func (S *luaStack) check(n int) {
 	free := len(S.slots) - S.top
-	for i := free; i < n; i++ {
-		S.slots = append(S.slots, nil)
+	if free >= n {
+		return
 	}
+	// 和luaD_growstack()一样按两倍扩容，至少满足这次的需要。
+	// 先检查内存上限再一次分配到位，免得超过上限的内存已经分配出去了
+	size := 2 * len(S.slots)
+	if size > LUAI_MAXSTACK {
+		size = LUAI_MAXSTACK
+	}
+	if needed := S.top + n; size < needed {
+		size = needed
+	}
+	grown := int64(size-len(S.slots)) * sizeValue
+	S.L.checkMemory(grown)
+	slots := make([]luaValue, size)
+	copy(slots, S.slots)
+	S.slots = slots
+	// 扩容后slots指向了新的数组，处于开放状态的Upvalue要重新指向新数组里的寄存器
+	for i, openuv := range S.openuvs {
+		openuv.val = &S.slots[i]
+	}
+	S.L.allocate(grown)
 }
 
 func (S *luaStack) pop() luaValue {
@@ -119,7 +140,7 @@ func (S *luaStack) set(idx int, val luaValue) {
 	if idx < LUA_REGISTRYINDEX {
 		uvIdx := LUA_REGISTRYINDEX - idx - 1
 		c := S.closure
-		if c != nil || uvIdx < len(c.upvals) {
+		if c != nil && uvIdx < len(c.upvals) {
 			*(c.upvals[uvIdx].val) = val
 		}
 		return
@@ -7,23 +7,104 @@ import (
 type luaState struct {
 	registry *luaTable //register table
 	stack    *luaStack // lua stack
+	/* coroutine */
+	coStatus  int           // 线程状态，LUA_YIELD表示挂起，错误码表示因出错而终止
+	coCaller  *luaState     // 最近一次恢复本线程的线程
+	coChan    chan struct{} // 线程和恢复它的线程通过这个通道交替执行
+	coClosing bool          // 协程已经不可达，正在被关闭
+	/* debug hook */
+	hook          LuaHook
+	hookMask      int
+	baseHookCount int
+	hookCount     int
+	allowHook     bool // 钩子函数执行期间不再触发钩子
+	oldPC         int  // 最后一次跟踪的指令，用来判断是否进入了新的一行
+	/* call depth */
+	nCalls   int  // 调用栈的深度
+	nCcalls  int  // 调用栈里Go函数的个数
+	overflow bool // 已经报告了栈溢出，正在处理这个错误
+	/* execution budget */
+	budget *execBudget // 所有线程共享
+	mem    *memStats   // 所有线程共享
 }
 
+// 主线程负责创建注册表，其他线程（协程）和主线程共享注册表
 func New() *luaState {
 	registry := newLuaTable(0, 0)
+	L := &luaState{registry: registry, allowHook: true, budget: &execBudget{}, mem: newMemStats()}
+	registry.put(LUA_RIDX_MAINTHREAD, L)
 	registry.put(LUA_RIDX_GLOBALS, newLuaTable(0, 0))
-	L := &luaState{registry: registry}
 	L.pushLuaStack(newLuaStack(LUA_MINSTACK, L))
 	return L
 }
 
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_close
+// void lua_close (lua_State *L);
+// 销毁指定 Lua 状态机中的所有对象（如果有垃圾收集相关的元方法的话，会调用它们）。
+// 对象的内存由Go回收，这里调用所有登记过的__gc元方法，然后关闭所有挂起的协程，
+// 让阻塞在Yield里的goroutine退出。不再使用的状态机要调用Close，否则这些goroutine会一直泄漏
+// lua-5.3.4/src/lstate.c#close_state()
+func (L *luaState) Close() {
+	L = L.registry.get(LUA_RIDX_MAINTHREAD).(*luaState) /* only the main thread can be closed */
+	m := L.mem
+	m.separateToBeFnz(func(luaValue) bool { return false }) /* separate all objects with finalizers */
+	L.callAllPendingFinalizers(false)
+	for t := range m.suspended {
+		t.closeThread()
+	}
+}
+
+// 每一层Lua调用都对应着一层Go函数调用，所以要限制调用深度，
+// 否则无穷递归会耗尽goroutine的栈，让整个进程崩溃。
+// 和C函数一样，Go函数的嵌套深度限制得更严格一些（pcall、元方法等的递归）
+const (
+	maxCalls   = 200000
+	extraCalls = 200 // 处理栈溢出错误时（比如调用错误处理函数）额外允许的深度
+	maxCCalls  = 200 // LUAI_MAXCCALLS
+)
+
 func (L *luaState) pushLuaStack(stack *luaStack) {
+	isGo := stack.closure != nil && stack.closure.proto == nil
+	if L.nCalls >= maxCalls || isGo && L.nCcalls >= maxCCalls {
+		L.checkCallDepth(isGo)
+	}
+	L.nCalls++
+	if isGo {
+		L.nCcalls++
+	}
 	stack.prev = L.stack
 	L.stack = stack
+	L.allocate(stackSize(stack))
 }
 
+// 调用帧总是后进先出的，弹出时就可以把它占用的内存从账上减掉
 func (L *luaState) popLuaStack() {
 	stack := L.stack
 	L.stack = stack.prev
 	stack.prev = nil
+	L.allocate(-stackSize(stack))
+	L.nCalls--
+	if stack.closure != nil && stack.closure.proto == nil {
+		L.nCcalls--
+	}
+	if L.overflow && L.nCalls < maxCalls && L.nCcalls < maxCCalls {
+		L.overflow = false
+	}
+}
+
+// 第一次超过上限时抛出栈溢出错误，然后再多给一点空间用来处理这个错误
+// lua-5.3.4/src/ldo.c#stackerror()
+func (L *luaState) checkCallDepth(isGo bool) {
+	if L.overflow {
+		if L.nCalls >= maxCalls+extraCalls || L.nCcalls >= maxCCalls+(maxCCalls>>3) {
+			L.runError("error while handling stack overflow")
+		}
+		return
+	}
+	L.overflow = true
+	if isGo && L.nCcalls >= maxCCalls {
+		L.runError("C stack overflow")
+	}
+	L.runError("stack overflow")
 }
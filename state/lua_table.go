@@ -3,6 +3,7 @@ package state
 import (
 	"luago/number"
 	"math"
+	"strings"
 )
 
 type luaTable struct {
@@ -15,7 +16,19 @@ type luaTable struct {
 	changed bool
 }
 
+// 预分配的大小只是提示，超过这个值的部分等真正用到的时候再分配，
+// 以免一个很大的提示（比如恶意chunk里的NEWTABLE）一下子耗尽内存
+const maxSizeHint = 1 << 16
+
+func sizeHint(n int) int {
+	if n > maxSizeHint {
+		return maxSizeHint
+	}
+	return n
+}
+
 func newLuaTable(nArr, nRec int) *luaTable {
+	nArr, nRec = sizeHint(nArr), sizeHint(nRec)
 	t := &luaTable{}
 	if nArr > 0 {
 		t.arr = make([]luaValue, 0, nArr)
@@ -30,6 +43,17 @@ func (T *luaTable) hasMetafield(filedName string) bool {
 	return T.metatable != nil && T.metatable.get(filedName) != nil
 }
 
+// 元表的__mode字段包含'k'表示键是弱引用，包含'v'表示值是弱引用
+func (T *luaTable) weakMode() (weakKey, weakValue bool) {
+	if T.metatable != nil {
+		if mode, ok := T.metatable.get("__mode").(string); ok {
+			weakKey = strings.IndexByte(mode, 'k') >= 0
+			weakValue = strings.IndexByte(mode, 'v') >= 0
+		}
+	}
+	return
+}
+
 func (T *luaTable) len() int {
 	return len(T.arr)
 }
@@ -44,6 +68,26 @@ func (T *luaTable) get(key luaValue) luaValue {
 	return T.mp[key]
 }
 
+// 整数键的快速路径，省去了键的类型转换
+func (T *luaTable) getInt(i int64) luaValue {
+	if i >= 1 && i <= int64(len(T.arr)) {
+		return T.arr[i-1]
+	}
+	return T.mp[i]
+}
+
+// 只处理数组部分里已经存在的元素，返回false表示需要走通用路径
+// （数组部分可能需要伸缩，或者可能需要触发__newindex元方法）
+func (T *luaTable) putIntFast(i int64, val luaValue) bool {
+	arrLen := int64(len(T.arr))
+	if i < 1 || i > arrLen || (i == arrLen && val == nil) ||
+		(T.arr[i-1] == nil && T.metatable != nil) {
+		return false
+	}
+	T.arr[i-1] = val
+	return true
+}
+
 func floatToInteger(key luaValue) luaValue {
 	if f, ok := key.(float64); ok {
 		if i, ok := number.FloatToInteger(f); ok {
@@ -95,9 +139,10 @@ func (T *luaTable) put(key, val luaValue) {
 
 func (T *luaTable) shrinkArray() {
 	for i := len(T.arr) - 1; i >= 0; i-- {
-		if T.arr[i] == nil {
-			T.arr = T.arr[0:i]
+		if T.arr[i] != nil {
+			break
 		}
+		T.arr = T.arr[0:i]
 	}
 }
 
@@ -120,7 +165,12 @@ func (T *luaTable) nextKey(key luaValue) luaValue {
 		T.initKeys()
 		T.changed = false
 	}
-	return T.keys[key]
+	// 遍历过程中键值对可能被删掉了（比如弱表里的对象被回收了），跳过这些键
+	nextKey := T.keys[key]
+	for nextKey != nil && T.get(nextKey) == nil {
+		nextKey = T.keys[nextKey]
+	}
+	return nextKey
 }
 
 func (T *luaTable) initKeys() {
@@ -0,0 +1,19 @@
+package state
+
+// 完全用户数据包装任意的Go值，每个完全用户数据都可以有自己的元表，
+// 还可以关联一个Lua值（user value）
+type userdata struct {
+	data      interface{}
+	metatable *luaTable
+	uservalue luaValue
+}
+
+func newUserdata(data interface{}) *userdata {
+	return &userdata{data: data}
+}
+
+// 轻量用户数据只是一个Go值（一般是指针），按值比较，没有自己的元表。
+// 为了能和其他类型区分开（也为了能作为表的键），需要包装一下
+type lightUserdata struct {
+	p interface{}
+}
@@ -22,6 +22,12 @@ func typeOf(val luaValue) LuaType {
 		return LUA_TTABLE
 	case *closure:
 		return LUA_TFUNCTION
+	case *luaState:
+		return LUA_TTHREAD
+	case *userdata:
+		return LUA_TUSERDATA
+	case lightUserdata:
+		return LUA_TLIGHTUSERDATA
 	default:
 		panic("TODO luaValue")
 	}
@@ -78,11 +84,14 @@ func stringToInteger(s string) (int64, bool) {
 
 /* metatable */
 
-// 先判断值是否是表，如果是，直接返回其元表字段即可；否则的话，
+// 先判断值是否是表或者完全用户数据，如果是，直接返回其元表字段即可；否则的话，
 // 根据值的类型从注册表里取出与该类型关联的元表并返回，如果值没有元表与之关联，返回值就是nil
 func getMetatable(val luaValue, L *luaState) *luaTable {
-	if t, ok := val.(*luaTable); ok {
-		return t.metatable
+	switch x := val.(type) {
+	case *luaTable:
+		return x.metatable
+	case *userdata:
+		return x.metatable
 	}
 	key := fmt.Sprintf("_MT%d", typeOf(val))
 	if mt := L.registry.get(key); mt != nil {
@@ -91,18 +100,28 @@ func getMetatable(val luaValue, L *luaState) *luaTable {
 	return nil
 }
 
-// 先判断值是否是表，如果是，直接修改其元表字段即可。否则的话，根据
+// 先判断值是否是表或者完全用户数据，如果是，直接修改其元表字段即可。否则的话，根据
 // 变量类型把元表存储在注册表里，这样就达到了按类型共享元表的目的
 // 虽然注册表也是一个普通的表，不过按照约定，下划线开头后跟大写字母的字段名是保
 // 留给Lua实现使用的，所以我们使用了“_MT1”这样的字段名，以免和用户（通过
 // API）放在注册表里的数据产生冲突。另外，如果传递给函数的元表是nil值，效果就相当于删除元表
 func setMetatable(val luaValue, mt *luaTable, L *luaState) {
-	if t, ok := val.(*luaTable); ok {
-		t.metatable = mt
+	switch x := val.(type) {
+	case *luaTable:
+		x.metatable = mt
+		L.checkFinalizer(x, mt)
+		return
+	case *userdata:
+		x.metatable = mt
+		L.checkFinalizer(x, mt)
 		return
 	}
 	key := fmt.Sprintf("_MT%d", typeOf(val))
-	L.registry.put(key, val)
+	if mt == nil {
+		L.registry.put(key, nil)
+	} else {
+		L.registry.put(key, mt)
+	}
 }
 
 func getMetafield(val luaValue, fieldName string, L *luaState) luaValue {
@@ -0,0 +1,55 @@
+package state_test
+
+import (
+	. "luago/api"
+	"luago/state"
+	"testing"
+)
+
+func TestCheckStack(t *testing.T) {
+	tests := []struct {
+		n    int
+		want bool
+	}{
+		{0, true},
+		{LUA_MINSTACK, true},
+		{100000, true},
+		{LUAI_MAXSTACK - 10, true},
+		{LUAI_MAXSTACK + 1, false},
+		{1 << 40, false},
+		{-1, false},
+	}
+	for _, tt := range tests {
+		L := state.New()
+		L.PushInteger(1)
+		if got := L.CheckStack(tt.n); got != tt.want {
+			t.Errorf("CheckStack(%d) = %v, want %v", tt.n, got, tt.want)
+		}
+	}
+}
+
+// 栈不能无限扩展，放不下的时候报告各个函数自己的错误消息
+func TestStackLimit(t *testing.T) {
+	tests := []struct {
+		code   string
+		status int
+		result string
+	}{
+		{"return select('#', table.unpack({}, 1, 1e5))", LUA_OK, "100000"},
+		{"return select(2, pcall(table.unpack, {}, 1, 2e6))", LUA_OK, "too many results to unpack"},
+		{"return select(2, pcall(table.unpack, {}, 1, 2^31 - 2))", LUA_OK, "too many results to unpack"},
+		{"return select(2, pcall(string.byte, string.rep('x', 2e6), 1, -1))", LUA_OK, "string slice too long"},
+		// 语法分析器的递归深度也有上限
+		{"return select(2, load(string.rep('(', 1e7) .. '1' .. string.rep(')', 1e7), '=deep'))",
+			LUA_OK, "deep:1: chunk has too many syntax levels"},
+		{"return select(2, load('return ' .. string.rep('{', 1e7), '=deep'))",
+			LUA_OK, "deep:1: chunk has too many syntax levels"},
+	}
+	for _, tt := range tests {
+		L := newState()
+		status, result := doString(L, tt.code)
+		if status != tt.status || result != tt.result {
+			t.Errorf("%s: got (%d, %q), want (%d, %q)", tt.code, status, result, tt.status, tt.result)
+		}
+	}
+}
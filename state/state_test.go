@@ -0,0 +1,38 @@
+package state_test
+
+import (
+	. "luago/api"
+	. "luago/auxlib"
+	"luago/state"
+	"luago/stdlib"
+	"testing"
+)
+
+// 新建一个打开了所有标准库的状态机
+func newState() LuaState {
+	L := state.New()
+	stdlib.OpenLibs(L)
+	return L
+}
+
+// 运行一段代码，返回状态码和第一个返回值（出错时是错误消息）转换成的字符串
+func doString(L LuaState, code string) (int, string) {
+	top := L.GetTop()
+	status := LoadString(L, code)
+	if status == LUA_OK {
+		status = L.PCall(0, 1, 0)
+	}
+	result := ToStringMeta(L, -1)
+	L.SetTop(top)
+	return status, result
+}
+
+// 运行一段代码，出错时让测试失败
+func mustDoString(t *testing.T, L LuaState, code string) string {
+	t.Helper()
+	status, result := doString(L, code)
+	if status != LUA_OK {
+		t.Fatalf("%s: status %d: %s", code, status, result)
+	}
+	return result
+}
@@ -0,0 +1,160 @@
+package state_test
+
+import (
+	"testing"
+)
+
+// 每段代码前面都加上count函数，用来统计表里的键值对个数
+const countFunc = `local function count(t)
+  local n = 0
+  for _ in pairs(t) do n = n + 1 end
+  return n
+end
+`
+
+func TestWeakTables(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+		want string
+	}{
+		{"weak values", `
+local t = setmetatable({}, {__mode = "v"})
+local keep = {}
+t[1], t[2], t[3], t[4] = {}, keep, "str", 42
+collectgarbage()
+return tostring(t[1]) .. " " .. tostring(t[2] == keep) .. " " .. t[3] .. " " .. t[4]`,
+			"nil true str 42"},
+		{"weak keys", `
+local t = setmetatable({}, {__mode = "k"})
+local keep = {}
+t[{}], t[keep], t["str"], t[function() end] = 1, 2, 3, 4
+collectgarbage()
+return count(t) .. " " .. t[keep] .. " " .. t.str`,
+			"2 2 3"},
+		{"weak keys and values", `
+local t = setmetatable({}, {__mode = "kv"})
+local k, v = {}, {}
+t[k], t[{}], t[1] = {}, v, v
+collectgarbage()
+return count(t) .. " " .. tostring(t[1] == v)`,
+			"1 true"},
+		{"strong table", `
+local t = {}
+t[{}] = {}
+collectgarbage()
+return count(t)`,
+			"1"},
+		// 值只通过键引用自己的话，键不可达时整个键值对都要删掉
+		{"ephemeron", `
+local t = setmetatable({}, {__mode = "k"})
+do local k = {} t[k] = {k} end
+local keep = {}
+t[keep] = {keep}
+collectgarbage()
+return count(t) .. " " .. tostring(t[keep][1] == keep)`,
+			"1 true"},
+		{"ephemeron chain", `
+local t = setmetatable({}, {__mode = "k"})
+local k1 = {}
+local k = k1
+for i = 1, 10 do local nk = {} t[k] = nk k = nk end
+collectgarbage()
+local n1 = count(t)
+k1, k = nil, nil
+collectgarbage()
+return n1 .. " " .. count(t)`,
+			"10 0"},
+		{"ephemeron across tables", `
+local a = setmetatable({}, {__mode = "k"})
+local b = setmetatable({}, {__mode = "k"})
+local k1, k2 = {}, {}
+a[k1], b[k2] = k2, {}
+k2 = nil
+collectgarbage()
+local n1 = count(a) + count(b)
+k1 = nil
+collectgarbage()
+return n1 .. " " .. count(a) + count(b)`,
+			"2 0"},
+		// 栈、上值、注册表以及可达的协程的栈里的对象都不会被删除
+		{"roots", `
+local t = setmetatable({}, {__mode = "v"})
+local f
+do
+  local up = {}
+  f = function() return up end
+  t[1] = up
+end
+local co = coroutine.create(function(x) coroutine.yield() return x end)
+local arg = {}
+coroutine.resume(co, arg)
+debug.getregistry().test = {}
+t[2], t[3], t[4] = arg, debug.getregistry().test, {}
+arg = nil
+collectgarbage()
+return count(t)`,
+			"3"},
+		{"unreachable coroutine", `
+local t = setmetatable({}, {__mode = "v"})
+local co = coroutine.create(function(x) coroutine.yield() end)
+local arg = {}
+coroutine.resume(co, arg)
+t[1], arg = arg, nil
+co = nil
+collectgarbage()
+return count(t)`,
+			"0"},
+		// 等待__gc的对象先从弱值里删除，弱键等到对象真正被回收以后才删除
+		{"resurrection", `
+local k = setmetatable({}, {__mode = "k"})
+local v = setmetatable({}, {__mode = "v"})
+local finalized
+do
+  local o = setmetatable({}, {__gc = function(o) finalized = o end})
+  k[o], v[1] = true, o
+end
+collectgarbage()
+local r = count(k) .. " " .. count(v) .. " " .. tostring(k[finalized])
+finalized = nil
+collectgarbage()
+return r .. " " .. count(k)`,
+			"1 0 true 0"},
+		// 不调用collectgarbage，内存用量增长到一定程度时也会自动收集
+		{"automatic collection", `
+local t = setmetatable({}, {__mode = "k"})
+for i = 1, 200000 do t[{}] = i end
+return count(t) < 100000`,
+			"true"},
+		{"automatic finalizers", `
+local n = 0
+for i = 1, 10000 do setmetatable({}, {__gc = function() n = n + 1 end}) end
+return n > 0`,
+			"true"},
+		{"stopped collector", `
+collectgarbage("stop")
+local t = setmetatable({}, {__mode = "k"})
+for i = 1, 10000 do t[{}] = i end
+local n1 = count(t)
+collectgarbage("restart")
+for i = 1, 100000 do local _ = {} end
+return n1 .. " " .. count(t)`,
+			"10000 0"},
+		{"mode change", `
+local mt = {}
+local t = setmetatable({}, mt)
+t[1] = {}
+collectgarbage()
+local n1 = count(t)
+mt.__mode = "v"
+collectgarbage()
+return n1 .. " " .. count(t)`,
+			"1 0"},
+	}
+	for _, tt := range tests {
+		L := newState()
+		if got := mustDoString(t, L, countFunc+tt.code); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,493 @@
+package stdlib
+
+import (
+	"fmt"
+	. "luago/api"
+	. "luago/auxlib"
+	"strings"
+)
+
+var baseFuncs = map[string]GoFunction{
+	"print":          basePrint,
+	"assert":         baseAssert,
+	"error":          baseError,
+	"select":         baseSelect,
+	"ipairs":         baseIPairs,
+	"pairs":          basePairs,
+	"next":           baseNext,
+	"load":           baseLoad,
+	"loadfile":       baseLoadFile,
+	"dofile":         baseDoFile,
+	"pcall":          basePCall,
+	"xpcall":         baseXPCall,
+	"getmetatable":   baseGetMetatable,
+	"setmetatable":   baseSetMetatable,
+	"rawequal":       baseRawEqual,
+	"rawlen":         baseRawLen,
+	"rawget":         baseRawGet,
+	"rawset":         baseRawSet,
+	"type":           baseType,
+	"tostring":       baseToString,
+	"tonumber":       baseToNumber,
+	"collectgarbage": baseCollectGarbage,
+}
+
+// lua-5.3.4/src/lbaselib.c#luaopen_base()
+func OpenBase(L LuaState) int {
+	/* open lib into global table */
+	L.PushGlobalTable()
+	SetFuncs(L, baseFuncs, 0)
+	/* set global _G */
+	L.PushValue(-1)
+	L.SetField(-2, "_G")
+	/* set global _VERSION */
+	L.PushString("Lua 5.3")
+	L.SetField(-2, "_VERSION")
+	return 1
+}
+
+// print (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-print
+// lua-5.3.4/src/lbaselib.c#luaB_print()
+func basePrint(L LuaState) int {
+	n := L.GetTop() // number of arguments
+	for i := 1; i <= n; i++ {
+		s := ToStringMeta(L, i)
+		if i > 1 {
+			fmt.Print("\t")
+		}
+		fmt.Print(s)
+		L.Pop(1) // pop result
+	}
+	fmt.Println()
+	return 0
+}
+
+// assert (v [, message])
+// http://www.lua.org/manual/5.3/manual.html#pdf-assert
+// lua-5.3.4/src/lbaselib.c#luaB_assert()
+func baseAssert(L LuaState) int {
+	if L.ToBoolean(1) { // condition is true?
+		return L.GetTop() // return all arguments
+	} else {
+		CheckAny(L, 1) // there must be a condition
+		L.Remove(1)    // remove it
+		L.PushString("assertion failed!")
+		L.SetTop(1)         // leave only message (default if no other one)
+		return baseError(L) // call 'error'
+	}
+}
+
+// error (message [, level])
+// http://www.lua.org/manual/5.3/manual.html#pdf-error
+// lua-5.3.4/src/lbaselib.c#luaB_error()
+func baseError(L LuaState) int {
+	level := int(OptInteger(L, 2, 1))
+	L.SetTop(1)
+	if L.Type(1) == LUA_TSTRING && level > 0 {
+		Where(L, level) /* add extra information */
+		L.PushValue(1)
+		L.Concat(2)
+	}
+	return L.Error()
+}
+
+// select (n, ···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-select
+// lua-5.3.4/src/lbaselib.c#luaB_select()
+func baseSelect(L LuaState) int {
+	n := int64(L.GetTop())
+	if L.Type(1) == LUA_TSTRING && L.ToString(1) == "#" {
+		L.PushInteger(n - 1)
+		return 1
+	} else {
+		i := CheckInteger(L, 1)
+		if i < 0 {
+			i = n + i
+		} else if i > n {
+			i = n
+		}
+		if i < 1 {
+			ArgError(L, 1, "index out of range")
+		}
+		return int(n - i)
+	}
+}
+
+// ipairs (t)
+// http://www.lua.org/manual/5.3/manual.html#pdf-ipairs
+// lua-5.3.4/src/lbaselib.c#luaB_ipairs()
+func baseIPairs(L LuaState) int {
+	CheckAny(L, 1)
+	L.PushGoFunction(iPairsAux) /* iteration function */
+	L.PushValue(1)              /* state */
+	L.PushInteger(0)            /* initial value */
+	return 3
+}
+
+// 'ipairs' function，遵守__index元方法
+func iPairsAux(L LuaState) int {
+	i := L.ToInteger(2) + 1
+	L.PushInteger(i)
+	if L.GetI(1, i) == LUA_TNIL {
+		return 1
+	} else {
+		return 2
+	}
+}
+
+// pairs (t)
+// http://www.lua.org/manual/5.3/manual.html#pdf-pairs
+// lua-5.3.4/src/lbaselib.c#luaB_pairs()
+func basePairs(L LuaState) int {
+	CheckAny(L, 1)
+	if GetMetafield(L, 1, "__pairs") == LUA_TNIL { /* no metamethod? */
+		L.PushGoFunction(baseNext) /* will return generator, */
+		L.PushValue(1)             /* state, */
+		L.PushNil()                /* and initial value */
+	} else {
+		L.PushValue(1) /* argument 'self' to metamethod */
+		L.Call(1, 3)   /* get 3 values from metamethod */
+	}
+	return 3
+}
+
+// next (table [, index])
+// http://www.lua.org/manual/5.3/manual.html#pdf-next
+// lua-5.3.4/src/lbaselib.c#luaB_next()
+func baseNext(L LuaState) int {
+	CheckType(L, 1, LUA_TTABLE)
+	L.SetTop(2) /* create a 2nd argument if there isn't one */
+	if L.Next(1) {
+		return 2
+	} else {
+		L.PushNil()
+		return 1
+	}
+}
+
+// load (chunk [, chunkname [, mode [, env]]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-load
+// lua-5.3.4/src/lbaselib.c#luaB_load()
+func baseLoad(L LuaState) int {
+	var status int
+	chunk, isStr := L.ToStringX(1)
+	mode := verifyMode(OptString(L, 3, "bt"))
+	env := 0 /* 'env' index or 0 if no 'env' */
+	if !L.IsNone(4) {
+		env = 4
+	}
+	if isStr { /* loading a string? */
+		chunkname := OptString(L, 2, chunk)
+		status = L.Load([]byte(chunk), chunkname, mode)
+	} else { /* loading from a reader function */
+		chunkname := OptString(L, 2, "=(load)")
+		CheckType(L, 1, LUA_TFUNCTION)
+		if data, ok := readChunk(L); ok {
+			status = L.Load(data, chunkname, mode)
+		} else {
+			status = LUA_ERRSYNTAX
+		}
+	}
+	return loadAux(L, status, env)
+}
+
+// 脚本加载的二进制代码块总是先用binchunk.Verify校验，
+// 否则精心构造的字节码可以破坏虚拟机（参见LuaState.Load的mode参数）
+func verifyMode(mode string) string {
+	if mode == "" {
+		mode = "bt"
+	}
+	return mode + "v"
+}
+
+// 反复调用读取函数，把它返回的字符串拼接起来，直到它返回nil或者空串
+func readChunk(L LuaState) ([]byte, bool) {
+	var sb strings.Builder
+	for {
+		L.PushValue(1) /* get function */
+		L.Call(0, 1)   /* call it */
+		if L.IsNil(-1) {
+			L.Pop(1) /* pop result */
+			return []byte(sb.String()), true
+		} else if !L.IsString(-1) {
+			L.Pop(1)
+			L.PushString("reader function must return a string")
+			return nil, false
+		}
+		s := L.ToString(-1)
+		L.Pop(1)
+		if s == "" {
+			return []byte(sb.String()), true
+		}
+		sb.WriteString(s)
+	}
+}
+
+// lua-5.3.4/src/lbaselib.c#load_aux()
+func loadAux(L LuaState, status, envIdx int) int {
+	if status == LUA_OK {
+		if envIdx != 0 { /* 'env' parameter? */
+			L.PushValue(envIdx)                    /* environment for loaded function */
+			if _, ok := L.SetUpvalue(-2, 1); !ok { /* set it as 1st upvalue */
+				L.Pop(1) /* remove 'env' if not used by previous call */
+			}
+		}
+		return 1
+	} else { /* error (message is on top of the stack) */
+		L.PushNil()
+		L.Insert(-2) /* put before error message */
+		return 2     /* return nil plus error message */
+	}
+}
+
+// loadfile ([filename [, mode [, env]]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-loadfile
+// lua-5.3.4/src/lbaselib.c#luaB_loadfile()
+func baseLoadFile(L LuaState) int {
+	fname := OptString(L, 1, "")
+	mode := verifyMode(OptString(L, 2, ""))
+	env := 0 /* 'env' index or 0 if no 'env' */
+	if !L.IsNone(3) {
+		env = 3
+	}
+	status := LoadFileX(L, fname, mode)
+	return loadAux(L, status, env)
+}
+
+// dofile ([filename])
+// http://www.lua.org/manual/5.3/manual.html#pdf-dofile
+// lua-5.3.4/src/lbaselib.c#luaB_dofile()
+func baseDoFile(L LuaState) int {
+	fname := OptString(L, 1, "")
+	L.SetTop(1)
+	if LoadFileX(L, fname, verifyMode("bt")) != LUA_OK {
+		return L.Error()
+	}
+	L.Call(0, LUA_MULTRET)
+	return L.GetTop() - 1
+}
+
+// pcall (f [, arg1, ···])
+// http://www.lua.org/manual/5.3/manual.html#pdf-pcall
+// lua-5.3.4/src/lbaselib.c#luaB_pcall()
+func basePCall(L LuaState) int {
+	CheckAny(L, 1)
+	L.PushBoolean(true) /* first result if no errors */
+	L.Insert(1)
+	status := L.PCall(L.GetTop()-2, LUA_MULTRET, 0)
+	return finishPCall(L, status, 0)
+}
+
+// xpcall (f, msgh [, arg1, ···])
+// http://www.lua.org/manual/5.3/manual.html#pdf-xpcall
+// lua-5.3.4/src/lbaselib.c#luaB_xpcall()
+func baseXPCall(L LuaState) int {
+	n := L.GetTop()
+	CheckType(L, 2, LUA_TFUNCTION) /* check error function */
+	L.PushBoolean(true)            /* first result */
+	L.PushValue(1)                 /* function */
+	L.Rotate(3, 2)                 /* move them below function's arguments */
+	status := L.PCall(n-2, LUA_MULTRET, 2)
+	return finishPCall(L, status, 2)
+}
+
+// lua-5.3.4/src/lbaselib.c#finishpcall()
+func finishPCall(L LuaState, status, extra int) int {
+	if status == LUA_ERRABORT { /* aborted scripts cannot catch the abort */
+		return L.Error()
+	}
+	if status != LUA_OK && status != LUA_YIELD { /* error? */
+		L.PushBoolean(false) /* first result (false) */
+		L.PushValue(-2)      /* error message */
+		return 2             /* return false, msg */
+	}
+	return L.GetTop() - extra /* return all results */
+}
+
+// getmetatable (object)
+// http://www.lua.org/manual/5.3/manual.html#pdf-getmetatable
+// lua-5.3.4/src/lbaselib.c#luaB_getmetatable()
+func baseGetMetatable(L LuaState) int {
+	CheckAny(L, 1)
+	if !L.GetMetatable(1) {
+		L.PushNil()
+		return 1 /* no metatable */
+	}
+	GetMetafield(L, 1, "__metatable")
+	return 1 /* returns either __metatable field (if present) or metatable */
+}
+
+// setmetatable (table, metatable)
+// http://www.lua.org/manual/5.3/manual.html#pdf-setmetatable
+// lua-5.3.4/src/lbaselib.c#luaB_setmetatable()
+func baseSetMetatable(L LuaState) int {
+	t := L.Type(2)
+	CheckType(L, 1, LUA_TTABLE)
+	if t != LUA_TNIL && t != LUA_TTABLE {
+		TypeError(L, 2, "nil or table")
+	}
+	if GetMetafield(L, 1, "__metatable") != LUA_TNIL {
+		return Error(L, "cannot change a protected metatable")
+	}
+	L.SetTop(2)
+	L.SetMetatable(1)
+	return 1
+}
+
+// rawequal (v1, v2)
+// http://www.lua.org/manual/5.3/manual.html#pdf-rawequal
+// lua-5.3.4/src/lbaselib.c#luaB_rawequal()
+func baseRawEqual(L LuaState) int {
+	CheckAny(L, 1)
+	CheckAny(L, 2)
+	L.PushBoolean(L.RawEqual(1, 2))
+	return 1
+}
+
+// rawlen (v)
+// http://www.lua.org/manual/5.3/manual.html#pdf-rawlen
+// lua-5.3.4/src/lbaselib.c#luaB_rawlen()
+func baseRawLen(L LuaState) int {
+	t := L.Type(1)
+	if t != LUA_TTABLE && t != LUA_TSTRING {
+		ArgError(L, 1, "table or string expected")
+	}
+	L.PushInteger(int64(L.RawLen(1)))
+	return 1
+}
+
+// rawget (table, index)
+// http://www.lua.org/manual/5.3/manual.html#pdf-rawget
+// lua-5.3.4/src/lbaselib.c#luaB_rawget()
+func baseRawGet(L LuaState) int {
+	CheckType(L, 1, LUA_TTABLE)
+	CheckAny(L, 2)
+	L.SetTop(2)
+	L.RawGet(1)
+	return 1
+}
+
+// rawset (table, index, value)
+// http://www.lua.org/manual/5.3/manual.html#pdf-rawset
+// lua-5.3.4/src/lbaselib.c#luaB_rawset()
+func baseRawSet(L LuaState) int {
+	CheckType(L, 1, LUA_TTABLE)
+	CheckAny(L, 2)
+	CheckAny(L, 3)
+	L.SetTop(3)
+	L.RawSet(1)
+	return 1
+}
+
+// type (v)
+// http://www.lua.org/manual/5.3/manual.html#pdf-type
+// lua-5.3.4/src/lbaselib.c#luaB_type()
+func baseType(L LuaState) int {
+	t := L.Type(1)
+	if t == LUA_TNONE {
+		ArgError(L, 1, "value expected")
+	}
+	L.PushString(L.TypeName(t))
+	return 1
+}
+
+// tostring (v)
+// http://www.lua.org/manual/5.3/manual.html#pdf-tostring
+// lua-5.3.4/src/lbaselib.c#luaB_tostring()
+func baseToString(L LuaState) int {
+	CheckAny(L, 1)
+	ToStringMeta(L, 1)
+	return 1
+}
+
+// tonumber (e [, base])
+// http://www.lua.org/manual/5.3/manual.html#pdf-tonumber
+// lua-5.3.4/src/lbaselib.c#luaB_tonumber()
+func baseToNumber(L LuaState) int {
+	if L.IsNoneOrNil(2) { /* standard conversion? */
+		if L.Type(1) == LUA_TNUMBER { /* already a number? */
+			L.SetTop(1) /* yes; return it */
+			return 1
+		} else {
+			if s, ok := L.ToStringX(1); ok && L.StringToNumber(s) {
+				return 1 /* successful conversion to number */
+			}
+			/* else not a number */
+			CheckAny(L, 1) /* (but there must be some parameter) */
+		}
+	} else {
+		base := CheckInteger(L, 2)
+		CheckType(L, 1, LUA_TSTRING) /* no numbers as strings */
+		s := strings.ToLower(strings.TrimSpace(L.ToString(1)))
+		if base < 2 || base > 36 {
+			ArgError(L, 2, "base out of range")
+		}
+		if n, ok := stringToInteger(s, int(base)); ok {
+			L.PushInteger(n)
+			return 1
+		}
+	}
+	L.PushNil() /* not a number */
+	return 1
+}
+
+// 按给定的进制把字符串转换成整数，溢出时回绕
+// lua-5.3.4/src/lbaselib.c#l_str2int()
+func stringToInteger(s string, base int) (int64, bool) {
+	neg := false
+	if len(s) > 0 && (s[0] == '-' || s[0] == '+') {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+	if len(s) == 0 {
+		return 0, false
+	}
+
+	var n int64
+	for _, c := range []byte(s) {
+		var digit int
+		switch {
+		case c >= '0' && c <= '9':
+			digit = int(c - '0')
+		case c >= 'a' && c <= 'z':
+			digit = int(c-'a') + 10
+		default:
+			return 0, false
+		}
+		if digit >= base {
+			return 0, false /* invalid numeral */
+		}
+		n = n*int64(base) + int64(digit)
+	}
+	if neg {
+		n = -n
+	}
+	return n, true
+}
+
+// collectgarbage ([opt [, arg]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-collectgarbage
+// lua-5.3.4/src/lbaselib.c#luaB_collectgarbage()
+// 内存由Go的垃圾收集器管理，"count"返回的是状态机估计的内存用量
+func baseCollectGarbage(L LuaState) int {
+	opts := []string{"stop", "restart", "collect",
+		"count", "step", "setpause", "setstepmul",
+		"isrunning"}
+	optsNum := []int{LUA_GCSTOP, LUA_GCRESTART, LUA_GCCOLLECT,
+		LUA_GCCOUNT, LUA_GCSTEP, LUA_GCSETPAUSE, LUA_GCSETSTEPMUL,
+		LUA_GCISRUNNING}
+	o := optsNum[CheckOption(L, 1, "collect", opts)]
+	ex := int(OptInteger(L, 2, 0))
+	res := L.GC(o, ex)
+	switch o {
+	case LUA_GCCOUNT:
+		b := L.GC(LUA_GCCOUNTB, 0)
+		L.PushNumber(float64(res) + float64(b)/1024)
+	case LUA_GCSTEP, LUA_GCISRUNNING:
+		L.PushBoolean(res != 0)
+	default:
+		L.PushInteger(int64(res))
+	}
+	return 1
+}
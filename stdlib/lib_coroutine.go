@@ -0,0 +1,150 @@
+package stdlib
+
+import (
+	. "luago/api"
+	. "luago/auxlib"
+)
+
+var coFuncs = map[string]GoFunction{
+	"create":      coCreate,
+	"resume":      coResume,
+	"yield":       coYield,
+	"status":      coStatus,
+	"isyieldable": coYieldable,
+	"running":     coRunning,
+	"wrap":        coWrap,
+}
+
+func OpenCoroutine(L LuaState) int {
+	NewLib(L, coFuncs)
+	return 1
+}
+
+// coroutine.create (f)
+// http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.create
+// lua-5.3.4/src/lcorolib.c#luaB_cocreate()
+func coCreate(L LuaState) int {
+	if !L.IsFunction(1) {
+		return ArgError(L, 1, "function expected")
+	}
+	co := L.NewThread()
+	L.PushValue(1) // move function to top
+	L.XMove(co, 1) // move function from L to co
+	return 1
+}
+
+// coroutine.resume (co [, val1, ···])
+// http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.resume
+// lua-5.3.4/src/lcorolib.c#luaB_coresume()
+func coResume(L LuaState) int {
+	co := getCo(L)
+	r := auxResume(L, co, L.GetTop()-1)
+	if r < 0 {
+		L.PushBoolean(false)
+		L.Insert(-2)
+		return 2 // return false + error message
+	}
+	L.PushBoolean(true)
+	L.Insert(-(r + 1))
+	return r + 1 // return true + 'resume' returns
+}
+
+// 返回值小于0表示出错，错误消息留在L的栈顶
+func auxResume(L, co LuaState, narg int) int {
+	if co.Status() == LUA_OK && co.GetTop() == 0 {
+		L.PushString("cannot resume dead coroutine")
+		return -1 // error flag
+	}
+	L.XMove(co, narg)
+	status := co.Resume(L, narg)
+	if status == LUA_OK || status == LUA_YIELD {
+		nres := co.GetTop()
+		co.XMove(L, nres) // move yielded values
+		return nres
+	} else {
+		co.XMove(L, 1) // move error message
+		if status == LUA_ERRABORT {
+			L.Error() // the whole state is aborted, not only the coroutine
+		}
+		return -1 // error flag
+	}
+}
+
+// coroutine.yield (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.yield
+// lua-5.3.4/src/lcorolib.c#luaB_yield()
+func coYield(L LuaState) int {
+	return L.Yield(L.GetTop())
+}
+
+// coroutine.status (co)
+// http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.status
+// lua-5.3.4/src/lcorolib.c#luaB_costatus()
+func coStatus(L LuaState) int {
+	co := getCo(L)
+	if L == co {
+		L.PushString("running")
+	} else {
+		switch co.Status() {
+		case LUA_YIELD:
+			L.PushString("suspended")
+		case LUA_OK:
+			if co.GetStack(0, &LuaDebug{}) { // does it have frames?
+				L.PushString("normal") // it is running
+			} else if co.GetTop() == 0 {
+				L.PushString("dead")
+			} else {
+				L.PushString("suspended") // initial state
+			}
+		default: // some error occurred
+			L.PushString("dead")
+		}
+	}
+	return 1
+}
+
+// coroutine.isyieldable ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.isyieldable
+func coYieldable(L LuaState) int {
+	L.PushBoolean(L.IsYieldable())
+	return 1
+}
+
+// coroutine.running ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.running
+func coRunning(L LuaState) int {
+	isMain := L.PushThread()
+	L.PushBoolean(isMain)
+	return 2
+}
+
+// coroutine.wrap (f)
+// http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.wrap
+// lua-5.3.4/src/lcorolib.c#luaB_cowrap()
+func coWrap(L LuaState) int {
+	coCreate(L)
+	L.PushGoClosure(auxWrap, 1)
+	return 1
+}
+
+func auxWrap(L LuaState) int {
+	co := L.ToThread(LuaUpvalueIndex(1))
+	r := auxResume(L, co, L.GetTop())
+	if r < 0 {
+		if L.Type(-1) == LUA_TSTRING { /* error object is a string? */
+			Where(L, 1) /* get extra info */
+			L.Insert(-2)
+			L.Concat(2)
+		}
+		return L.Error() /* propagate error */
+	}
+	return r
+}
+
+func getCo(L LuaState) LuaState {
+	co := L.ToThread(1)
+	if co == nil {
+		ArgError(L, 1, "coroutine expected")
+	}
+	return co
+}
@@ -0,0 +1,477 @@
+package stdlib
+
+import (
+	"bufio"
+	"fmt"
+	. "luago/api"
+	. "luago/auxlib"
+	"os"
+	"reflect"
+	"strings"
+)
+
+var dbLib = map[string]GoFunction{
+	"debug":        dbDebug,
+	"getuservalue": dbGetUserValue,
+	"gethook":      dbGetHook,
+	"getinfo":      dbGetInfo,
+	"getlocal":     dbGetLocal,
+	"getregistry":  dbGetRegistry,
+	"getmetatable": dbGetMetatable,
+	"getupvalue":   dbGetUpvalue,
+	"upvaluejoin":  dbUpvalueJoin,
+	"upvalueid":    dbUpvalueID,
+	"setuservalue": dbSetUserValue,
+	"sethook":      dbSetHook,
+	"setlocal":     dbSetLocal,
+	"setmetatable": dbSetMetatable,
+	"setupvalue":   dbSetUpvalue,
+	"traceback":    dbTraceback,
+}
+
+// lua-5.3.4/src/ldblib.c#luaopen_debug()
+func OpenDebug(L LuaState) int {
+	NewLib(L, dbLib)
+	return 1
+}
+
+// debug.getregistry ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getregistry
+// lua-5.3.4/src/ldblib.c#db_getregistry()
+func dbGetRegistry(L LuaState) int {
+	L.PushValue(LUA_REGISTRYINDEX)
+	return 1
+}
+
+// debug.getmetatable (value)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getmetatable
+// lua-5.3.4/src/ldblib.c#db_getmetatable()
+func dbGetMetatable(L LuaState) int {
+	CheckAny(L, 1)
+	if !L.GetMetatable(1) {
+		L.PushNil() /* no metatable */
+	}
+	return 1
+}
+
+// debug.setmetatable (value, table)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.setmetatable
+// lua-5.3.4/src/ldblib.c#db_setmetatable()
+func dbSetMetatable(L LuaState) int {
+	t := L.Type(2)
+	ArgCheck(L, t == LUA_TNIL || t == LUA_TTABLE, 2, "nil or table expected")
+	L.SetTop(2)
+	L.SetMetatable(1)
+	return 1 /* return 1st argument */
+}
+
+// debug.getuservalue (u)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getuservalue
+// lua-5.3.4/src/ldblib.c#db_getuservalue()
+func dbGetUserValue(L LuaState) int {
+	if L.Type(1) != LUA_TUSERDATA {
+		L.PushNil()
+	} else {
+		L.GetUserValue(1)
+	}
+	return 1
+}
+
+// debug.setuservalue (udata, value)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.setuservalue
+// lua-5.3.4/src/ldblib.c#db_setuservalue()
+func dbSetUserValue(L LuaState) int {
+	CheckType(L, 1, LUA_TUSERDATA)
+	CheckAny(L, 2)
+	L.SetTop(2)
+	L.SetUserValue(1)
+	return 1
+}
+
+// 如果第一个参数是线程，调试函数作用于这个线程，其余参数依次后移一位
+// lua-5.3.4/src/ldblib.c#getthread()
+func getThread(L LuaState) (L1 LuaState, arg int) {
+	if L.IsThread(1) {
+		return L.ToThread(1), 1
+	}
+	return L, 0 /* function will operate over current thread */
+}
+
+// 确保L1的栈上有n个空闲位置，L1和L是同一个线程时由调用者自己负责
+// lua-5.3.4/src/ldblib.c#checkstack()
+func checkStack(L, L1 LuaState, n int) {
+	if L != L1 && !L1.CheckStack(n) {
+		Error(L, "stack overflow")
+	}
+}
+
+// 把L1（或者L）栈顶的值放进L栈顶的表里，键是fname
+// lua-5.3.4/src/ldblib.c#treatstackoption()
+func treatStackOption(L, L1 LuaState, fname string) {
+	if L == L1 {
+		L.Rotate(-2, 1) /* exchange object and table */
+	} else {
+		L1.XMove(L, 1) /* move object to the "main" stack */
+	}
+	L.SetField(-2, fname) /* put object into table */
+}
+
+// 和lua_pushstring(L, NULL)一样，空串压入nil
+func setTabSS(L LuaState, k, v string) {
+	if v == "" {
+		L.PushNil()
+	} else {
+		L.PushString(v)
+	}
+	L.SetField(-2, k)
+}
+
+func setTabSI(L LuaState, k string, v int) {
+	L.PushInteger(int64(v))
+	L.SetField(-2, k)
+}
+
+func setTabSB(L LuaState, k string, v bool) {
+	L.PushBoolean(v)
+	L.SetField(-2, k)
+}
+
+// debug.getinfo ([thread,] f [, what])
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getinfo
+// lua-5.3.4/src/ldblib.c#db_getinfo()
+func dbGetInfo(L LuaState) int {
+	var ar LuaDebug
+	L1, arg := getThread(L)
+	options := OptString(L, arg+2, "flnStu")
+	checkStack(L, L1, 3)
+	if L.IsFunction(arg + 1) { /* info about a function? */
+		options = ">" + options /* add '>' to 'options' */
+		L.PushValue(arg + 1)    /* move function to 'L1' stack */
+		L.XMove(L1, 1)
+	} else { /* stack level */
+		if !L1.GetStack(int(CheckInteger(L, arg+1)), &ar) {
+			L.PushNil() /* level out of range */
+			return 1
+		}
+	}
+	if !L1.GetInfo(options, &ar) {
+		return ArgError(L, arg+2, "invalid option")
+	}
+	L.NewTable() /* table to collect results */
+	if strings.ContainsRune(options, 'S') {
+		setTabSS(L, "source", ar.Source)
+		setTabSS(L, "short_src", ar.ShortSrc)
+		setTabSI(L, "linedefined", ar.LineDefined)
+		setTabSI(L, "lastlinedefined", ar.LastLineDefined)
+		setTabSS(L, "what", ar.What)
+	}
+	if strings.ContainsRune(options, 'l') {
+		setTabSI(L, "currentline", ar.CurrentLine)
+	}
+	if strings.ContainsRune(options, 'u') {
+		setTabSI(L, "nups", ar.NUps)
+		setTabSI(L, "nparams", ar.NParams)
+		setTabSB(L, "isvararg", ar.IsVararg)
+	}
+	if strings.ContainsRune(options, 'n') {
+		setTabSS(L, "name", ar.Name)
+		L.PushString(ar.NameWhat)
+		L.SetField(-2, "namewhat")
+	}
+	if strings.ContainsRune(options, 't') {
+		setTabSB(L, "istailcall", ar.IsTailCall)
+	}
+	if strings.ContainsRune(options, 'L') {
+		treatStackOption(L, L1, "activelines")
+	}
+	if strings.ContainsRune(options, 'f') {
+		treatStackOption(L, L1, "func")
+	}
+	return 1 /* return table */
+}
+
+// debug.getlocal ([thread,] f, local)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getlocal
+// lua-5.3.4/src/ldblib.c#db_getlocal()
+func dbGetLocal(L LuaState) int {
+	var ar LuaDebug
+	L1, arg := getThread(L)
+	nvar := int(CheckInteger(L, arg+2)) /* local-variable index */
+	if L.IsFunction(arg + 1) {          /* function argument? */
+		L.PushValue(arg + 1) /* push function */
+		if name, ok := L.GetLocal(nil, nvar); ok {
+			L.PushString(name) /* push local name */
+		} else {
+			L.PushNil()
+		}
+		return 1 /* return only name (there is no value) */
+	}
+	/* stack-level argument */
+	level := int(CheckInteger(L, arg+1))
+	if !L1.GetStack(level, &ar) { /* out of range? */
+		return ArgError(L, arg+1, "level out of range")
+	}
+	checkStack(L, L1, 1)
+	if name, ok := L1.GetLocal(&ar, nvar); ok {
+		L1.XMove(L, 1)     /* move local value */
+		L.PushString(name) /* push name */
+		L.Rotate(-2, 1)    /* re-order */
+		return 2
+	}
+	L.PushNil() /* no name (nor value) */
+	return 1
+}
+
+// debug.setlocal ([thread,] level, local, value)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.setlocal
+// lua-5.3.4/src/ldblib.c#db_setlocal()
+func dbSetLocal(L LuaState) int {
+	var ar LuaDebug
+	L1, arg := getThread(L)
+	level := int(CheckInteger(L, arg+1))
+	nvar := int(CheckInteger(L, arg+2))
+	if !L1.GetStack(level, &ar) { /* out of range? */
+		return ArgError(L, arg+1, "level out of range")
+	}
+	CheckAny(L, arg+3)
+	L.SetTop(arg + 3)
+	checkStack(L, L1, 1)
+	L.XMove(L1, 1)
+	if name, ok := L1.SetLocal(&ar, nvar); ok {
+		L.PushString(name)
+	} else {
+		L1.Pop(1) /* pop value (if not popped by 'SetLocal') */
+		L.PushNil()
+	}
+	return 1
+}
+
+// get为true时获取上值，否则设置上值
+// lua-5.3.4/src/ldblib.c#auxupvalue()
+func auxUpvalue(L LuaState, get bool) int {
+	n := int(CheckInteger(L, 2))   /* upvalue index */
+	CheckType(L, 1, LUA_TFUNCTION) /* closure */
+	if get {
+		name, ok := L.GetUpvalue(1, n)
+		if !ok {
+			return 0
+		}
+		L.PushString(name)
+		L.Insert(-2)
+		return 2
+	}
+	name, ok := L.SetUpvalue(1, n)
+	if !ok {
+		return 0
+	}
+	L.PushString(name)
+	return 1
+}
+
+// debug.getupvalue (f, up)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getupvalue
+// lua-5.3.4/src/ldblib.c#db_getupvalue()
+func dbGetUpvalue(L LuaState) int {
+	return auxUpvalue(L, true)
+}
+
+// debug.setupvalue (f, up, value)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.setupvalue
+// lua-5.3.4/src/ldblib.c#db_setupvalue()
+func dbSetUpvalue(L LuaState) int {
+	CheckAny(L, 3)
+	return auxUpvalue(L, false)
+}
+
+// 检查argnup处的上值索引对argf处的函数是否有效
+// lua-5.3.4/src/ldblib.c#checkupval()
+func checkUpval(L LuaState, argf, argnup int) int {
+	nup := int(CheckInteger(L, argnup)) /* upvalue index */
+	CheckType(L, argf, LUA_TFUNCTION)   /* closure */
+	_, ok := L.GetUpvalue(argf, nup)
+	ArgCheck(L, ok, argnup, "invalid upvalue index")
+	L.Pop(1) /* remove upvalue value */
+	return nup
+}
+
+// debug.upvalueid (f, n)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.upvalueid
+// lua-5.3.4/src/ldblib.c#db_upvalueid()
+func dbUpvalueID(L LuaState) int {
+	n := checkUpval(L, 1, 2)
+	L.PushLightUserdata(L.UpvalueID(1, n))
+	return 1
+}
+
+// debug.upvaluejoin (f1, n1, f2, n2)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.upvaluejoin
+// lua-5.3.4/src/ldblib.c#db_upvaluejoin()
+func dbUpvalueJoin(L LuaState) int {
+	n1 := checkUpval(L, 1, 2)
+	n2 := checkUpval(L, 3, 4)
+	ArgCheck(L, !L.IsGoFunction(1), 1, "Lua function expected")
+	ArgCheck(L, !L.IsGoFunction(3), 3, "Lua function expected")
+	L.UpvalueJoin(1, n1, 3, n2)
+	return 0
+}
+
+/* 钩子 */
+
+// 注册表里的钩子表，键是线程，值是这个线程的Lua钩子函数
+const _HOOKKEY = "_HKEY"
+
+var hookNames = []string{"call", "return", "line", "count", "tail call"}
+
+// 调用线程对应的Lua钩子函数，参数是事件名和行号
+// lua-5.3.4/src/ldblib.c#hookf()
+func hookF(L LuaState, ar *LuaDebug) {
+	L.GetField(LUA_REGISTRYINDEX, _HOOKKEY)
+	L.PushThread()
+	if L.RawGet(-2) == LUA_TFUNCTION { /* is there a hook function? */
+		L.PushString(hookNames[ar.Event]) /* push event name */
+		if ar.CurrentLine >= 0 {
+			L.PushInteger(int64(ar.CurrentLine)) /* push current line */
+		} else {
+			L.PushNil()
+		}
+		L.GetInfo("lS", ar)
+		L.Call(2, 0) /* call hook function */
+	}
+}
+
+// 把字符串形式的掩码转换成钩子掩码
+// lua-5.3.4/src/ldblib.c#makemask()
+func makeMask(smask string, count int) int {
+	mask := 0
+	if strings.ContainsRune(smask, 'c') {
+		mask |= LUA_MASKCALL
+	}
+	if strings.ContainsRune(smask, 'r') {
+		mask |= LUA_MASKRET
+	}
+	if strings.ContainsRune(smask, 'l') {
+		mask |= LUA_MASKLINE
+	}
+	if count > 0 {
+		mask |= LUA_MASKCOUNT
+	}
+	return mask
+}
+
+// 把钩子掩码转换成字符串形式
+// lua-5.3.4/src/ldblib.c#unmakemask()
+func unmakeMask(mask int) string {
+	smask := ""
+	if mask&LUA_MASKCALL != 0 {
+		smask += "c"
+	}
+	if mask&LUA_MASKRET != 0 {
+		smask += "r"
+	}
+	if mask&LUA_MASKLINE != 0 {
+		smask += "l"
+	}
+	return smask
+}
+
+// 判断钩子是不是debug.sethook设置的
+func isHookF(hook LuaHook) bool {
+	return reflect.ValueOf(hook).Pointer() == reflect.ValueOf(hookF).Pointer()
+}
+
+// debug.sethook ([thread,] hook, mask [, count])
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.sethook
+// lua-5.3.4/src/ldblib.c#db_sethook()
+func dbSetHook(L LuaState) int {
+	var mask, count int
+	var fn LuaHook
+	L1, arg := getThread(L)
+	if L.IsNoneOrNil(arg + 1) { /* no hook? */
+		L.SetTop(arg + 1)
+		fn, mask, count = nil, 0, 0 /* turn off hooks */
+	} else {
+		smask := CheckString(L, arg+2)
+		CheckType(L, arg+1, LUA_TFUNCTION)
+		count = int(OptInteger(L, arg+3, 0))
+		fn, mask = hookF, makeMask(smask, count)
+	}
+	if L.GetField(LUA_REGISTRYINDEX, _HOOKKEY) == LUA_TNIL {
+		L.Pop(1)
+		L.CreateTable(0, 2) /* create a hook table */
+		L.PushValue(-1)
+		L.SetField(LUA_REGISTRYINDEX, _HOOKKEY) /* set it in position */
+		L.PushString("k")
+		L.SetField(-2, "__mode") /* hooktable.__mode = "k" */
+		L.PushValue(-1)
+		L.SetMetatable(-2) /* setmetatable(hooktable) = hooktable */
+	}
+	checkStack(L, L1, 1)
+	L1.PushThread()
+	L1.XMove(L, 1)       /* key (thread) */
+	L.PushValue(arg + 1) /* value (hook function) */
+	L.RawSet(-3)         /* hooktable[L1] = new Lua hook */
+	L1.SetHook(fn, mask, count)
+	return 0
+}
+
+// debug.gethook ([thread])
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.gethook
+// lua-5.3.4/src/ldblib.c#db_gethook()
+func dbGetHook(L LuaState) int {
+	L1, _ := getThread(L)
+	mask := L1.GetHookMask()
+	hook := L1.GetHook()
+	if hook == nil { /* no hook? */
+		L.PushNil()
+	} else if !isHookF(hook) { /* external hook? */
+		L.PushString("external hook")
+	} else { /* hook table must exist */
+		L.GetField(LUA_REGISTRYINDEX, _HOOKKEY)
+		checkStack(L, L1, 1)
+		L1.PushThread()
+		L1.XMove(L, 1)
+		L.RawGet(-2) /* 1st result = hooktable[L1] */
+		L.Remove(-2) /* remove hook table */
+	}
+	L.PushString(unmakeMask(mask))          /* 2nd result = mask */
+	L.PushInteger(int64(L1.GetHookCount())) /* 3rd result = count */
+	return 3
+}
+
+// debug.traceback ([thread,] [message [, level]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.traceback
+// lua-5.3.4/src/ldblib.c#db_traceback()
+func dbTraceback(L LuaState) int {
+	L1, arg := getThread(L)
+	msg, ok := L.ToStringX(arg + 1)
+	if !ok && !L.IsNoneOrNil(arg+1) { /* non-string 'msg'? */
+		L.PushValue(arg + 1) /* return it untouched */
+	} else {
+		level := 0
+		if L == L1 {
+			level = 1
+		}
+		Traceback(L, L1, msg, int(OptInteger(L, arg+2, int64(level))))
+	}
+	return 1
+}
+
+// debug.debug ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.debug
+// lua-5.3.4/src/ldblib.c#db_debug()
+// 逐行读取标准输入并执行，直到读到"cont"或者输入结束
+func dbDebug(L LuaState) int {
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Fprint(os.Stderr, "lua_debug> ")
+		line, err := reader.ReadString('\n')
+		if (err != nil && line == "") || line == "cont\n" {
+			return 0
+		}
+		if LoadBuffer(L, []byte(line), "=(debug command)") != LUA_OK ||
+			L.PCall(0, 0, 0) != LUA_OK {
+			fmt.Fprintf(os.Stderr, "%s\n", L.ToString(-1))
+		}
+		L.SetTop(0) /* remove eventual returns */
+	}
+}
@@ -0,0 +1,796 @@
+package stdlib
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	. "luago/api"
+	. "luago/auxlib"
+	"math"
+	"os"
+	"strings"
+)
+
+const (
+	_LUA_FILEHANDLE  = "FILE*"
+	_IO_PREFIX       = "_IO_"
+	_IO_INPUT        = _IO_PREFIX + "input"
+	_IO_OUTPUT       = _IO_PREFIX + "output"
+	_LUAL_BUFFERSIZE = 4096
+	_L_MAXLENNUM     = 200 /* maximum length of a numeral */
+	_MAXARGLINE      = 250 /* maximum number of arguments to 'lines' */
+)
+
+/*
+ * 文件句柄是包装了*luaStream的完全用户数据。
+ * 读操作经过bufio.Reader（read("n")需要预读），写操作在全缓冲和行缓冲模式下经过bufio.Writer，
+ * 两者切换时需要先把缓冲区里的数据冲刷掉或者退回去，和C标准库的FILE是一样的
+ */
+type luaStream struct {
+	f       File
+	closef  GoFunction /* to close stream (nil for closed streams) */
+	r       *bufio.Reader
+	w       *bufio.Writer /* nil when unbuffered */
+	lineBuf bool
+}
+
+func (s *luaStream) isClosed() bool {
+	return s.closef == nil
+}
+
+func (s *luaStream) flush() error {
+	if s.w != nil && s.w.Buffered() > 0 {
+		return s.w.Flush()
+	}
+	return nil
+}
+
+// 丢弃读缓冲区，并把文件位置退回到缓冲区开始之前（不支持Seek的文件只能丢弃）
+func (s *luaStream) dropReadBuffer() {
+	if s.r != nil && s.r.Buffered() > 0 {
+		s.f.Seek(-int64(s.r.Buffered()), io.SeekCurrent)
+		s.r.Reset(s.f)
+	}
+}
+
+func (s *luaStream) reader() *bufio.Reader {
+	s.flush()
+	if s.r == nil {
+		s.r = bufio.NewReader(s.f)
+	}
+	return s.r
+}
+
+func (s *luaStream) write(p []byte) error {
+	s.dropReadBuffer()
+	if s.w == nil {
+		_, err := s.f.Write(p)
+		return err
+	}
+	if _, err := s.w.Write(p); err != nil {
+		return err
+	}
+	if s.lineBuf && bytes.IndexByte(p, '\n') >= 0 {
+		return s.w.Flush()
+	}
+	return nil
+}
+
+func (s *luaStream) seek(offset int64, whence int) (int64, error) {
+	if err := s.flush(); err != nil {
+		return 0, err
+	}
+	if s.r != nil {
+		if whence == io.SeekCurrent {
+			offset -= int64(s.r.Buffered())
+		}
+		s.r.Reset(s.f)
+	}
+	return s.f.Seek(offset, whence)
+}
+
+func (s *luaStream) setvbuf(mode string, size int) error {
+	err := s.flush()
+	switch mode {
+	case "no":
+		s.w = nil
+	case "full", "line":
+		s.w = bufio.NewWriterSize(s.f, size)
+	}
+	s.lineBuf = mode == "line"
+	return err
+}
+
+var ioLib = map[string]GoFunction{
+	"close":   ioClose,
+	"flush":   ioFlush,
+	"input":   ioInput,
+	"lines":   ioLines,
+	"open":    ioOpen,
+	"output":  ioOutput,
+	"read":    ioRead,
+	"tmpfile": ioTmpFile,
+	"type":    ioType,
+	"write":   ioWrite,
+}
+
+/* methods for file handles */
+var fLib = map[string]GoFunction{
+	"close":      ioClose,
+	"flush":      fFlush,
+	"lines":      fLines,
+	"read":       fRead,
+	"seek":       fSeek,
+	"setvbuf":    fSetvbuf,
+	"write":      fWrite,
+	"__gc":       fGc,
+	"__tostring": fToString,
+}
+
+// lua-5.3.4/src/liolib.c#luaopen_io()
+func OpenIO(L LuaState) int {
+	NewLib(L, ioLib) /* new module */
+	createMeta(L)
+	/* create (and set) default files */
+	createStdFile(L, os.Stdin, _IO_INPUT, "stdin")
+	createStdFile(L, os.Stdout, _IO_OUTPUT, "stdout")
+	createStdFile(L, os.Stderr, "", "stderr")
+	return 1
+}
+
+// lua-5.3.4/src/liolib.c#createmeta()
+func createMeta(L LuaState) {
+	NewMetatable(L, _LUA_FILEHANDLE) /* create metatable for file handles */
+	L.PushValue(-1)                  /* push metatable */
+	L.SetField(-2, "__index")        /* metatable.__index = metatable */
+	SetFuncs(L, fLib, 0)             /* add file methods to new metatable */
+	L.Pop(1)                         /* pop new metatable */
+}
+
+// lua-5.3.4/src/liolib.c#createstdfile()
+func createStdFile(L LuaState, f File, k, fname string) {
+	p := newPreFile(L)
+	p.f = f
+	p.closef = ioNoClose
+	if k != "" {
+		L.PushValue(-1)
+		L.SetField(LUA_REGISTRYINDEX, k) /* add file to registry */
+	}
+	L.SetField(-2, fname) /* add file to module */
+}
+
+/*
+ * When creating file handles, always creates a 'closed' file handle
+ * before opening the actual file; so, if there is a memory error, the
+ * handle is in a consistent state.
+ */
+// lua-5.3.4/src/liolib.c#newprefile()
+func newPreFile(L LuaState) *luaStream {
+	p := &luaStream{} /* mark file handle as 'closed' */
+	L.NewUserdata(p)
+	L.GetField(LUA_REGISTRYINDEX, _LUA_FILEHANDLE)
+	L.SetMetatable(-2)
+	return p
+}
+
+// lua-5.3.4/src/liolib.c#newfile()
+func newFile(L LuaState) *luaStream {
+	p := newPreFile(L)
+	p.closef = ioFClose
+	return p
+}
+
+// lua-5.3.4/src/liolib.c#tolstream()
+func toLStream(L LuaState) *luaStream {
+	return CheckUdata(L, 1, _LUA_FILEHANDLE).(*luaStream)
+}
+
+// lua-5.3.4/src/liolib.c#tofile()
+func toFile(L LuaState) *luaStream {
+	p := toLStream(L)
+	if p.isClosed() {
+		Error(L, "attempt to use a closed file")
+	}
+	return p
+}
+
+/*
+ * function to close regular files
+ */
+// lua-5.3.4/src/liolib.c#io_fclose()
+func ioFClose(L LuaState) int {
+	p := toLStream(L)
+	err := p.flush()
+	if cerr := p.f.Close(); err == nil {
+		err = cerr
+	}
+	return FileResult(L, err, "")
+}
+
+/*
+ * function to (not) close the standard files stdin, stdout, and stderr
+ */
+// lua-5.3.4/src/liolib.c#io_noclose()
+func ioNoClose(L LuaState) int {
+	p := toLStream(L)
+	p.closef = ioNoClose /* keep file opened */
+	L.PushNil()
+	L.PushString("cannot close standard file")
+	return 2
+}
+
+/*
+ * Calls the 'close' function from a file handle.
+ */
+// lua-5.3.4/src/liolib.c#aux_close()
+func auxClose(L LuaState) int {
+	p := toLStream(L)
+	cf := p.closef
+	p.closef = nil /* mark stream as closed */
+	return cf(L)   /* close it */
+}
+
+// io.close ([file])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.close
+// lua-5.3.4/src/liolib.c#io_close()
+func ioClose(L LuaState) int {
+	if L.IsNone(1) { /* no argument? */
+		L.GetField(LUA_REGISTRYINDEX, _IO_OUTPUT) /* use standard output */
+	}
+	toFile(L) /* make sure argument is an open stream */
+	return auxClose(L)
+}
+
+// lua-5.3.4/src/liolib.c#f_gc()
+func fGc(L LuaState) int {
+	p := toLStream(L)
+	if !p.isClosed() && p.f != nil {
+		auxClose(L) /* ignore closed and incompletely open files */
+	}
+	return 0
+}
+
+// lua-5.3.4/src/liolib.c#f_tostring()
+func fToString(L LuaState) int {
+	p := toLStream(L)
+	if p.isClosed() {
+		L.PushString("file (closed)")
+	} else {
+		L.PushString(fmt.Sprintf("file (%p)", p))
+	}
+	return 1
+}
+
+// 把C风格的模式字符串转换成os.OpenFile()的标志
+// lua-5.3.4/src/liolib.c#l_checkmode()
+func checkModeFlag(mode string) (int, bool) {
+	m := strings.TrimRight(mode, "b") /* skip if char is 'b' */
+	if m == "" {
+		return 0, false
+	}
+	plus := len(m) == 2 && m[1] == '+'
+	if len(m) > 2 || len(m) == 2 && !plus {
+		return 0, false
+	}
+	flag := 0
+	switch m[0] {
+	case 'r':
+		flag = os.O_RDONLY
+	case 'w':
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	case 'a':
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	default:
+		return 0, false
+	}
+	if plus {
+		flag = flag&^os.O_WRONLY | os.O_RDWR
+	}
+	return flag, true
+}
+
+// 打开文件，写操作默认是全缓冲的
+func openStream(L LuaState, p *luaStream, fname string, flag int) error {
+	f, err := GetFileSystem(L).OpenFile(fname, flag, 0666)
+	if err != nil {
+		return err
+	}
+	p.f = f
+	p.w = bufio.NewWriterSize(f, _LUAL_BUFFERSIZE)
+	return nil
+}
+
+// io.open (filename [, mode])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.open
+// lua-5.3.4/src/liolib.c#io_open()
+func ioOpen(L LuaState) int {
+	filename := CheckString(L, 1)
+	mode := OptString(L, 2, "r")
+	flag, ok := checkModeFlag(mode)
+	if !ok {
+		ArgError(L, 2, "invalid mode")
+	}
+	p := newFile(L)
+	if err := openStream(L, p, filename, flag); err != nil {
+		p.closef = nil
+		return FileResult(L, err, filename)
+	}
+	return 1
+}
+
+/*
+ * function to close 'tmpfile' files
+ */
+// io.tmpfile ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.tmpfile
+// lua-5.3.4/src/liolib.c#io_tmpfile()
+func ioTmpFile(L LuaState) int {
+	p := newFile(L)
+	fs := GetFileSystem(L)
+	name, err := fs.TempName()
+	if err == nil {
+		err = openStream(L, p, name, os.O_RDWR|os.O_TRUNC)
+	}
+	if err != nil {
+		p.closef = nil
+		return FileResult(L, err, "")
+	}
+	p.closef = func(L LuaState) int { /* 关闭后删除临时文件 */
+		n := ioFClose(L)
+		fs.Remove(name)
+		return n
+	}
+	return 1
+}
+
+// lua-5.3.4/src/liolib.c#opencheckfile()
+func openCheckFile(L LuaState, fname string, flag int) {
+	p := newFile(L)
+	if err := openStream(L, p, fname, flag); err != nil {
+		p.closef = nil
+		msg, _ := StrError(err)
+		Error(L, "cannot open file '%s' (%s)", fname, msg)
+	}
+}
+
+// lua-5.3.4/src/liolib.c#getiofile()
+func getIOFile(L LuaState, findex string) *luaStream {
+	L.GetField(LUA_REGISTRYINDEX, findex)
+	p := L.ToUserdata(-1).(*luaStream)
+	if p.isClosed() {
+		Error(L, "standard %s file is closed", findex[len(_IO_PREFIX):])
+	}
+	return p
+}
+
+// lua-5.3.4/src/liolib.c#g_iofile()
+func gIOFile(L LuaState, f string, flag int) int {
+	if !L.IsNoneOrNil(1) {
+		if filename, ok := L.ToStringX(1); ok {
+			openCheckFile(L, filename, flag)
+		} else {
+			toFile(L) /* check that it's a valid file handle */
+			L.PushValue(1)
+		}
+		L.SetField(LUA_REGISTRYINDEX, f)
+	}
+	/* return current value */
+	L.GetField(LUA_REGISTRYINDEX, f)
+	return 1
+}
+
+// io.input ([file])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.input
+// lua-5.3.4/src/liolib.c#io_input()
+func ioInput(L LuaState) int {
+	return gIOFile(L, _IO_INPUT, os.O_RDONLY)
+}
+
+// io.output ([file])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.output
+// lua-5.3.4/src/liolib.c#io_output()
+func ioOutput(L LuaState) int {
+	return gIOFile(L, _IO_OUTPUT, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+}
+
+// io.type (obj)
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.type
+// lua-5.3.4/src/liolib.c#io_type()
+func ioType(L LuaState) int {
+	CheckAny(L, 1)
+	if p, ok := TestUdata(L, 1, _LUA_FILEHANDLE).(*luaStream); !ok {
+		L.PushNil() /* not a file */
+	} else if p.isClosed() {
+		L.PushString("closed file")
+	} else {
+		L.PushString("file")
+	}
+	return 1
+}
+
+/*
+ * Return an iteration function for 'lines'. The function keeps
+ * the file, the number of formats and the formats as upvalues.
+ */
+// lua-5.3.4/src/liolib.c#aux_lines()
+func auxLines(L LuaState, toClose bool) {
+	n := L.GetTop() - 1 /* number of arguments to read */
+	if n > _MAXARGLINE {
+		ArgError(L, _MAXARGLINE+2, "too many arguments")
+	}
+	L.PushInteger(int64(n)) /* number of arguments to read */
+	L.PushBoolean(toClose)  /* close/not close file when finished */
+	L.Rotate(2, 2)          /* move 'n' and 'toclose' to their positions */
+	L.PushGoClosure(ioReadLine, 3+n)
+}
+
+// file:lines (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:lines
+// lua-5.3.4/src/liolib.c#f_lines()
+func fLines(L LuaState) int {
+	toFile(L) /* check that it's a valid file handle */
+	auxLines(L, false)
+	return 1
+}
+
+// io.lines ([filename, ···])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.lines
+// lua-5.3.4/src/liolib.c#io_lines()
+func ioLines(L LuaState) int {
+	var toClose bool
+	if L.IsNone(1) {
+		L.PushNil() /* at least one argument */
+	}
+	if L.IsNil(1) { /* no file name? */
+		L.GetField(LUA_REGISTRYINDEX, _IO_INPUT) /* get default input */
+		L.Replace(1)                             /* put it at index 1 */
+		toFile(L)                                /* check that it's a valid file handle */
+		toClose = false                          /* do not close it after iteration */
+	} else { /* open a new file */
+		filename := CheckString(L, 1)
+		openCheckFile(L, filename, os.O_RDONLY)
+		L.Replace(1)   /* put file at index 1 */
+		toClose = true /* close it after iteration */
+	}
+	auxLines(L, toClose)
+	return 1
+}
+
+/*
+ * Read a number: first reads a valid prefix of a numeral into a buffer.
+ * Then it calls 'StringToNumber' to check whether the format is correct
+ * and to convert it to a Lua number
+ */
+type numReader struct {
+	r   *bufio.Reader
+	c   int    /* current character (look ahead) */
+	buf []byte /* numeral being read */
+}
+
+func (rn *numReader) getc() int {
+	if b, err := rn.r.ReadByte(); err == nil {
+		return int(b)
+	}
+	return -1
+}
+
+/*
+ * Add current char to buffer (if not out of space) and read next one
+ */
+// lua-5.3.4/src/liolib.c#nextc()
+func (rn *numReader) nextc() bool {
+	if len(rn.buf) >= _L_MAXLENNUM { /* buffer overflow? */
+		rn.buf = rn.buf[:0] /* invalidate result */
+		return false        /* fail */
+	}
+	rn.buf = append(rn.buf, byte(rn.c)) /* save current char */
+	rn.c = rn.getc()                    /* read next one */
+	return true
+}
+
+/*
+ * Accept current char if it is in 'set' (of size 2)
+ */
+// lua-5.3.4/src/liolib.c#test2()
+func (rn *numReader) test2(set string) bool {
+	if rn.c == int(set[0]) || rn.c == int(set[1]) {
+		return rn.nextc()
+	}
+	return false
+}
+
+/*
+ * Read a sequence of (hex)digits
+ */
+// lua-5.3.4/src/liolib.c#readdigits()
+func (rn *numReader) readDigits(hex bool) int {
+	count := 0
+	for rn.c >= 0 && (isDigit(byte(rn.c)) || hex && isHexDigit(byte(rn.c))) && rn.nextc() {
+		count++
+	}
+	return count
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c|0x20 >= 'a' && c|0x20 <= 'f')
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || (c >= '\t' && c <= '\r')
+}
+
+// lua-5.3.4/src/liolib.c#read_number()
+func readNumber(L LuaState, p *luaStream) bool {
+	rn := &numReader{r: p.reader()}
+	count := 0
+	hex := false
+	for rn.c = rn.getc(); rn.c >= 0 && isSpace(byte(rn.c)); rn.c = rn.getc() {
+		/* skip spaces */
+	}
+	rn.test2("-+")      /* optional signal */
+	if rn.test2("00") { /* leading '0'? */
+		if rn.test2("xX") {
+			hex = true /* numeral is hexadecimal */
+		} else {
+			count = 1 /* count initial '0' as a valid digit */
+		}
+	}
+	count += rn.readDigits(hex) /* integral part */
+	if rn.test2("..") {         /* decimal point? */
+		count += rn.readDigits(hex) /* fractional part */
+	}
+	exp := "eE"
+	if hex {
+		exp = "pP"
+	}
+	if count > 0 && rn.test2(exp) { /* exponent mark? */
+		rn.test2("-+")       /* exponent signal */
+		rn.readDigits(false) /* exponent digits */
+	}
+	if rn.c >= 0 {
+		rn.r.UnreadByte() /* unread look-ahead char */
+	}
+	if L.StringToNumber(string(rn.buf)) {
+		return true /* ok */
+	} else { /* invalid format */
+		L.PushNil()  /* "result" to be removed */
+		return false /* read fails */
+	}
+}
+
+// lua-5.3.4/src/liolib.c#test_eof()
+func testEOF(L LuaState, p *luaStream) bool {
+	_, err := p.reader().Peek(1)
+	L.PushString("")
+	return err == nil
+}
+
+// lua-5.3.4/src/liolib.c#read_line()
+func readLine(L LuaState, p *luaStream, chop bool) (bool, error) {
+	line, err := p.reader().ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	hasNL := strings.HasSuffix(line, "\n")
+	if chop && hasNL {
+		line = line[:len(line)-1] /* remove '\n' */
+	}
+	L.PushString(line)
+	/* return ok if read something (either a newline or something else) */
+	return hasNL || len(line) > 0, nil
+}
+
+// lua-5.3.4/src/liolib.c#read_all()
+func readAll(L LuaState, p *luaStream) error {
+	data, err := ioutil.ReadAll(p.reader())
+	L.PushString(string(data))
+	return err
+}
+
+// lua-5.3.4/src/liolib.c#read_chars()
+func readChars(L LuaState, p *luaStream, n int64) (bool, error) {
+	var b bytes.Buffer
+	_, err := io.CopyN(&b, p.reader(), n)
+	if err == io.EOF {
+		err = nil
+	}
+	L.PushString(b.String())
+	return b.Len() > 0, err /* true iff read something */
+}
+
+// lua-5.3.4/src/liolib.c#g_read()
+func gRead(L LuaState, p *luaStream, first int) int {
+	nargs := L.GetTop() - 1
+	var success bool
+	var err error
+	var n int
+	if nargs == 0 { /* no arguments? */
+		success, err = readLine(L, p, true)
+		n = first + 1 /* to return 1 result */
+	} else { /* ensure stack space for all results and for auxlib's buffer */
+		if !L.CheckStack(nargs + LUA_MINSTACK) {
+			Error(L, "too many arguments")
+		}
+		success = true
+		for n = first; nargs > 0 && success && err == nil; n++ {
+			nargs--
+			if L.Type(n) == LUA_TNUMBER {
+				l := CheckInteger(L, n)
+				if l == 0 {
+					success = testEOF(L, p)
+				} else {
+					success, err = readChars(L, p, l)
+				}
+			} else {
+				format := CheckString(L, n)
+				format = strings.TrimPrefix(format, "*") /* skip optional '*' (for compatibility) */
+				if format == "" {
+					return ArgError(L, n, "invalid format")
+				}
+				switch format[0] {
+				case 'n': /* number */
+					success = readNumber(L, p)
+				case 'l': /* line */
+					success, err = readLine(L, p, true)
+				case 'L': /* line with end-of-line */
+					success, err = readLine(L, p, false)
+				case 'a': /* file */
+					err = readAll(L, p) /* read entire file */
+					success = true      /* always success */
+				default:
+					return ArgError(L, n, "invalid format")
+				}
+			}
+		}
+	}
+	if err != nil {
+		return FileResult(L, err, "")
+	}
+	if !success {
+		L.Pop(1)    /* remove last result */
+		L.PushNil() /* push nil instead */
+	}
+	return n - first
+}
+
+// io.read (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.read
+// lua-5.3.4/src/liolib.c#io_read()
+func ioRead(L LuaState) int {
+	return gRead(L, getIOFile(L, _IO_INPUT), 1)
+}
+
+// file:read (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:read
+// lua-5.3.4/src/liolib.c#f_read()
+func fRead(L LuaState) int {
+	return gRead(L, toFile(L), 2)
+}
+
+// lua-5.3.4/src/liolib.c#io_readline()
+func ioReadLine(L LuaState) int {
+	p := L.ToUserdata(LuaUpvalueIndex(1)).(*luaStream)
+	n := int(L.ToInteger(LuaUpvalueIndex(2)))
+	if p.isClosed() { /* file is already closed? */
+		return Error(L, "file is already closed")
+	}
+	L.SetTop(1)
+	if !L.CheckStack(n) {
+		return Error(L, "too many arguments")
+	}
+	for i := 1; i <= n; i++ { /* push arguments to 'g_read' */
+		L.PushValue(LuaUpvalueIndex(3 + i))
+	}
+	n = gRead(L, p, 2)   /* 'n' is number of results */
+	if L.ToBoolean(-n) { /* read at least one value? */
+		return n /* return them */
+	} else { /* first result is nil: EOF or error */
+		if n > 1 { /* is there error information? */
+			/* 2nd result is error message */
+			return Error(L, "%s", L.ToString(-n+1))
+		}
+		if L.ToBoolean(LuaUpvalueIndex(3)) { /* generate error? */
+			L.SetTop(0)
+			L.PushValue(LuaUpvalueIndex(1))
+			auxClose(L) /* close it */
+		}
+		return 0
+	}
+}
+
+// lua-5.3.4/src/liolib.c#g_write()
+func gWrite(L LuaState, p *luaStream, arg int) int {
+	nargs := L.GetTop() - arg
+	var err error
+	for ; nargs > 0; nargs-- {
+		var s string
+		if L.Type(arg) == LUA_TNUMBER {
+			/* optimization: could be done exactly as for strings */
+			if L.IsInteger(arg) {
+				s = fmt.Sprintf("%d", L.ToInteger(arg))
+			} else {
+				s = formatNumber(L.ToNumber(arg))
+			}
+		} else {
+			s = CheckString(L, arg)
+		}
+		if err == nil {
+			err = p.write([]byte(s))
+		}
+		arg++
+	}
+	if err == nil {
+		return 1 /* file handle already on stack top */
+	}
+	return FileResult(L, err, "")
+}
+
+// 和C的fprintf(f, "%.14g", n)一样（不会加上".0"）
+func formatNumber(n float64) string {
+	if math.IsInf(n, 0) || math.IsNaN(n) {
+		return formatNonFinite("%", 'g', n)
+	}
+	return fmt.Sprintf("%.14g", n)
+}
+
+// io.write (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.write
+// lua-5.3.4/src/liolib.c#io_write()
+func ioWrite(L LuaState) int {
+	return gWrite(L, getIOFile(L, _IO_OUTPUT), 1)
+}
+
+// file:write (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:write
+// lua-5.3.4/src/liolib.c#f_write()
+func fWrite(L LuaState) int {
+	p := toFile(L)
+	L.PushValue(1) /* push file at the stack top (to be returned) */
+	return gWrite(L, p, 2)
+}
+
+// file:seek ([whence [, offset]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:seek
+// lua-5.3.4/src/liolib.c#f_seek()
+func fSeek(L LuaState) int {
+	p := toFile(L)
+	mode := []int{io.SeekStart, io.SeekCurrent, io.SeekEnd}
+	op := CheckOption(L, 2, "cur", []string{"set", "cur", "end"})
+	offset := OptInteger(L, 3, 0)
+	pos, err := p.seek(offset, mode[op])
+	if err != nil {
+		return FileResult(L, err, "") /* error */
+	}
+	L.PushInteger(pos)
+	return 1
+}
+
+// file:setvbuf (mode [, size])
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:setvbuf
+// lua-5.3.4/src/liolib.c#f_setvbuf()
+func fSetvbuf(L LuaState) int {
+	p := toFile(L)
+	modeNames := []string{"no", "full", "line"}
+	mode := modeNames[CheckOption(L, 2, "", modeNames)]
+	size := OptInteger(L, 3, _LUAL_BUFFERSIZE)
+	if size <= 0 {
+		size = _LUAL_BUFFERSIZE
+	}
+	return FileResult(L, p.setvbuf(mode, int(size)), "")
+}
+
+// io.flush ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.flush
+// lua-5.3.4/src/liolib.c#io_flush()
+func ioFlush(L LuaState) int {
+	return FileResult(L, getIOFile(L, _IO_OUTPUT).flush(), "")
+}
+
+// file:flush ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:flush
+// lua-5.3.4/src/liolib.c#f_flush()
+func fFlush(L LuaState) int {
+	return FileResult(L, toFile(L).flush(), "")
+}
@@ -0,0 +1,388 @@
+package stdlib
+
+import (
+	. "luago/api"
+	. "luago/auxlib"
+	"luago/number"
+	"math"
+	"math/rand"
+)
+
+var mathLib = map[string]GoFunction{
+	"abs":       mathAbs,
+	"ceil":      mathCeil,
+	"floor":     mathFloor,
+	"fmod":      mathFmod,
+	"modf":      mathModf,
+	"sqrt":      mathSqrt,
+	"exp":       mathExp,
+	"log":       mathLog,
+	"sin":       mathSin,
+	"cos":       mathCos,
+	"tan":       mathTan,
+	"asin":      mathAsin,
+	"acos":      mathAcos,
+	"atan":      mathAtan,
+	"tointeger": mathToInt,
+	"type":      mathType,
+	"ult":       mathUlt,
+	"max":       mathMax,
+	"min":       mathMin,
+}
+
+// lua-5.3.4/src/lmathlib.c#luaopen_math()
+func OpenMath(L LuaState) int {
+	NewLib(L, mathLib)
+	setRandomFuncs(L)
+	L.PushNumber(math.Pi)
+	L.SetField(-2, "pi")
+	L.PushNumber(math.Inf(1))
+	L.SetField(-2, "huge")
+	L.PushInteger(math.MaxInt64)
+	L.SetField(-2, "maxinteger")
+	L.PushInteger(math.MinInt64)
+	L.SetField(-2, "mininteger")
+	return 1
+}
+
+/* random */
+
+// 每个状态都有自己的随机数生成器，random和randomseed通过Go闭包共享它，
+// 和官方实现一样，调用randomseed之前生成的序列是固定的
+func setRandomFuncs(L LuaState) {
+	rng := rand.New(rand.NewSource(1))
+
+	// math.random ([m [, n]])
+	// http://www.lua.org/manual/5.3/manual.html#pdf-math.random
+	// lua-5.3.4/src/lmathlib.c#math_random()
+	random := func(L LuaState) int {
+		var low, up int64
+		r := rng.Float64()  /* Number between 0 and 1 */
+		switch L.GetTop() { /* check number of arguments */
+		case 0: /* no arguments */
+			L.PushNumber(r) /* Number between 0 and 1 */
+			return 1
+		case 1: /* only upper limit */
+			low = 1
+			up = CheckInteger(L, 1)
+		case 2: /* lower and upper limits */
+			low = CheckInteger(L, 1)
+			up = CheckInteger(L, 2)
+		default:
+			return Error(L, "wrong number of arguments")
+		}
+		/* random integer in the interval [low, up] */
+		if low > up {
+			ArgError(L, 1, "interval is empty")
+		}
+		if !(low >= 0 || up <= math.MaxInt64+low) {
+			ArgError(L, 1, "interval too large")
+		}
+		r *= float64(up-low) + 1.0
+		L.PushInteger(int64(r) + low)
+		return 1
+	}
+
+	// math.randomseed (x)
+	// http://www.lua.org/manual/5.3/manual.html#pdf-math.randomseed
+	// lua-5.3.4/src/lmathlib.c#math_randomseed()
+	randomSeed := func(L LuaState) int {
+		x := CheckNumber(L, 1)
+		rng.Seed(int64(x))
+		rng.Float64() /* discard first value to avoid undesirable correlations */
+		return 0
+	}
+
+	L.PushGoFunction(random)
+	L.SetField(-2, "random")
+	L.PushGoFunction(randomSeed)
+	L.SetField(-2, "randomseed")
+}
+
+/* max & min */
+
+// math.max (x, ···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.max
+// lua-5.3.4/src/lmathlib.c#math_max()
+func mathMax(L LuaState) int {
+	n := L.GetTop() /* number of arguments */
+	imax := 1       /* index of current maximum value */
+	if n < 1 {
+		ArgError(L, 1, "value expected")
+	}
+	for i := 2; i <= n; i++ {
+		if L.Compare(imax, i, LUA_OPLT) {
+			imax = i
+		}
+	}
+	L.PushValue(imax)
+	return 1
+}
+
+// math.min (x, ···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.min
+// lua-5.3.4/src/lmathlib.c#math_min()
+func mathMin(L LuaState) int {
+	n := L.GetTop() /* number of arguments */
+	imin := 1       /* index of current minimum value */
+	if n < 1 {
+		ArgError(L, 1, "value expected")
+	}
+	for i := 2; i <= n; i++ {
+		if L.Compare(i, imin, LUA_OPLT) {
+			imin = i
+		}
+	}
+	L.PushValue(imin)
+	return 1
+}
+
+/* exponentiation and logarithms */
+
+// math.exp (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.exp
+// lua-5.3.4/src/lmathlib.c#math_exp()
+func mathExp(L LuaState) int {
+	x := CheckNumber(L, 1)
+	L.PushNumber(math.Exp(x))
+	return 1
+}
+
+// math.log (x [, base])
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.log
+// lua-5.3.4/src/lmathlib.c#math_log()
+func mathLog(L LuaState) int {
+	x := CheckNumber(L, 1)
+	var res float64
+
+	if L.IsNoneOrNil(2) {
+		res = math.Log(x)
+	} else {
+		base := CheckNumber(L, 2)
+		if base == 2.0 {
+			res = math.Log2(x)
+		} else if base == 10.0 {
+			res = math.Log10(x)
+		} else {
+			res = math.Log(x) / math.Log(base)
+		}
+	}
+
+	L.PushNumber(res)
+	return 1
+}
+
+/* trigonometric functions */
+
+// math.sin (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.sin
+// lua-5.3.4/src/lmathlib.c#math_sin()
+func mathSin(L LuaState) int {
+	x := CheckNumber(L, 1)
+	L.PushNumber(math.Sin(x))
+	return 1
+}
+
+// math.cos (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.cos
+// lua-5.3.4/src/lmathlib.c#math_cos()
+func mathCos(L LuaState) int {
+	x := CheckNumber(L, 1)
+	L.PushNumber(math.Cos(x))
+	return 1
+}
+
+// math.tan (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.tan
+// lua-5.3.4/src/lmathlib.c#math_tan()
+func mathTan(L LuaState) int {
+	x := CheckNumber(L, 1)
+	L.PushNumber(math.Tan(x))
+	return 1
+}
+
+// math.asin (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.asin
+// lua-5.3.4/src/lmathlib.c#math_asin()
+func mathAsin(L LuaState) int {
+	x := CheckNumber(L, 1)
+	L.PushNumber(math.Asin(x))
+	return 1
+}
+
+// math.acos (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.acos
+// lua-5.3.4/src/lmathlib.c#math_acos()
+func mathAcos(L LuaState) int {
+	x := CheckNumber(L, 1)
+	L.PushNumber(math.Acos(x))
+	return 1
+}
+
+// math.atan (y [, x])
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.atan
+// lua-5.3.4/src/lmathlib.c#math_atan()
+func mathAtan(L LuaState) int {
+	y := CheckNumber(L, 1)
+	x := OptNumber(L, 2, 1.0)
+	L.PushNumber(math.Atan2(y, x))
+	return 1
+}
+
+/* rounding functions */
+
+// math.ceil (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.ceil
+// lua-5.3.4/src/lmathlib.c#math_ceil()
+func mathCeil(L LuaState) int {
+	if L.IsInteger(1) {
+		L.SetTop(1) /* integer is its own ceil */
+	} else {
+		x := CheckNumber(L, 1)
+		pushNumInt(L, math.Ceil(x))
+	}
+	return 1
+}
+
+// math.floor (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.floor
+// lua-5.3.4/src/lmathlib.c#math_floor()
+func mathFloor(L LuaState) int {
+	if L.IsInteger(1) {
+		L.SetTop(1) /* integer is its own floor */
+	} else {
+		x := CheckNumber(L, 1)
+		pushNumInt(L, math.Floor(x))
+	}
+	return 1
+}
+
+// 能用整数表示就压入整数，否则压入浮点数
+// lua-5.3.4/src/lmathlib.c#pushnumint()
+func pushNumInt(L LuaState, d float64) {
+	if i, ok := number.FloatToInteger(d); ok { /* does 'd' fit in an integer? */
+		L.PushInteger(i) /* result is integer */
+	} else {
+		L.PushNumber(d) /* result is float */
+	}
+}
+
+// math.fmod (x, y)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.fmod
+// lua-5.3.4/src/lmathlib.c#math_fmod()
+func mathFmod(L LuaState) int {
+	if L.IsInteger(1) && L.IsInteger(2) {
+		d := L.ToInteger(2)
+		if uint64(d)+1 <= 1 { /* special cases: -1 or 0 */
+			if d == 0 {
+				ArgError(L, 2, "zero")
+			}
+			L.PushInteger(0) /* avoid overflow with 0x80000... / -1 */
+		} else {
+			L.PushInteger(L.ToInteger(1) % d) /* 和C一样向零取整 */
+		}
+	} else {
+		x := CheckNumber(L, 1)
+		y := CheckNumber(L, 2)
+		L.PushNumber(math.Mod(x, y))
+	}
+	return 1
+}
+
+// math.modf (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.modf
+// lua-5.3.4/src/lmathlib.c#math_modf()
+/*
+ * next function does not use 'modf', avoiding problems with 'double*'
+ * (which is not compatible with 'float*') when lua_Number is not
+ * 'double'.
+ */
+func mathModf(L LuaState) int {
+	if L.IsInteger(1) {
+		L.SetTop(1)     /* number is its own integer part */
+		L.PushNumber(0) /* no fractional part */
+	} else {
+		x := CheckNumber(L, 1)
+		/* integer part (rounds toward zero) */
+		var ip float64
+		if x < 0 {
+			ip = math.Ceil(x)
+		} else {
+			ip = math.Floor(x)
+		}
+		L.PushNumber(ip)
+		/* fractional part (test needed for inf/-inf) */
+		if x == ip {
+			L.PushNumber(0.0)
+		} else {
+			L.PushNumber(x - ip)
+		}
+	}
+	return 2
+}
+
+// math.sqrt (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.sqrt
+// lua-5.3.4/src/lmathlib.c#math_sqrt()
+func mathSqrt(L LuaState) int {
+	x := CheckNumber(L, 1)
+	L.PushNumber(math.Sqrt(x))
+	return 1
+}
+
+// math.ult (m, n)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.ult
+// lua-5.3.4/src/lmathlib.c#math_ult()
+func mathUlt(L LuaState) int {
+	m := CheckInteger(L, 1)
+	n := CheckInteger(L, 2)
+	L.PushBoolean(uint64(m) < uint64(n))
+	return 1
+}
+
+// math.abs (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.abs
+// lua-5.3.4/src/lmathlib.c#math_abs()
+func mathAbs(L LuaState) int {
+	if L.IsInteger(1) {
+		x := L.ToInteger(1)
+		if x < 0 {
+			x = -x /* 和C实现一样，mininteger的绝对值是它本身 */
+		}
+		L.PushInteger(x)
+	} else {
+		x := CheckNumber(L, 1)
+		L.PushNumber(math.Abs(x))
+	}
+	return 1
+}
+
+// math.tointeger (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.tointeger
+// lua-5.3.4/src/lmathlib.c#math_toint()
+func mathToInt(L LuaState) int {
+	if i, ok := L.ToIntegerX(1); ok {
+		L.PushInteger(i)
+	} else {
+		CheckAny(L, 1)
+		L.PushNil() /* value is not convertible to integer */
+	}
+	return 1
+}
+
+// math.type (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.type
+// lua-5.3.4/src/lmathlib.c#math_type()
+func mathType(L LuaState) int {
+	if L.Type(1) == LUA_TNUMBER {
+		if L.IsInteger(1) {
+			L.PushString("integer")
+		} else {
+			L.PushString("float")
+		}
+	} else {
+		CheckAny(L, 1)
+		L.PushNil()
+	}
+	return 1
+}
@@ -0,0 +1,307 @@
+package stdlib
+
+import (
+	"fmt"
+	. "luago/api"
+	. "luago/auxlib"
+	"os"
+	"strings"
+	"time"
+)
+
+var sysLib = map[string]GoFunction{
+	"clock":    osClock,
+	"date":     osDate,
+	"difftime": osDiffTime,
+	"exit":     osExit,
+	"getenv":   osGetEnv,
+	"remove":   osRemove,
+	"rename":   osRename,
+	"time":     osTime,
+	"tmpname":  osTmpName,
+}
+
+// lua-5.3.4/src/loslib.c#luaopen_os()
+func OpenOS(L LuaState) int {
+	NewLib(L, sysLib)
+	return 1
+}
+
+// os.clock ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-os.clock
+// lua-5.3.4/src/loslib.c#os_clock()
+// Go没有可移植的获取进程CPU时间的方法，这里返回程序启动以来经过的时间
+func osClock(L LuaState) int {
+	L.PushNumber(time.Since(startTime).Seconds())
+	return 1
+}
+
+var startTime = time.Now()
+
+// os.difftime (t2, t1)
+// http://www.lua.org/manual/5.3/manual.html#pdf-os.difftime
+// lua-5.3.4/src/loslib.c#os_difftime()
+func osDiffTime(L LuaState) int {
+	t2 := CheckInteger(L, 1)
+	t1 := CheckInteger(L, 2)
+	L.PushNumber(float64(t2 - t1))
+	return 1
+}
+
+// os.exit ([code [, close]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-os.exit
+// lua-5.3.4/src/loslib.c#os_exit()
+func osExit(L LuaState) int {
+	var status int
+	if L.IsBoolean(1) {
+		if L.ToBoolean(1) {
+			status = 0 // EXIT_SUCCESS
+		} else {
+			status = 1 // EXIT_FAILURE
+		}
+	} else {
+		status = int(OptInteger(L, 1, 0))
+	}
+	os.Exit(status)
+	return 0
+}
+
+// os.getenv (varname)
+// http://www.lua.org/manual/5.3/manual.html#pdf-os.getenv
+// lua-5.3.4/src/loslib.c#os_getenv()
+func osGetEnv(L LuaState) int {
+	key := CheckString(L, 1)
+	if env, ok := os.LookupEnv(key); ok {
+		L.PushString(env)
+	} else {
+		L.PushNil()
+	}
+	return 1
+}
+
+// os.remove (filename)
+// http://www.lua.org/manual/5.3/manual.html#pdf-os.remove
+// lua-5.3.4/src/loslib.c#os_remove()
+func osRemove(L LuaState) int {
+	filename := CheckString(L, 1)
+	return FileResult(L, GetFileSystem(L).Remove(filename), filename)
+}
+
+// os.rename (oldname, newname)
+// http://www.lua.org/manual/5.3/manual.html#pdf-os.rename
+// lua-5.3.4/src/loslib.c#os_rename()
+func osRename(L LuaState) int {
+	fromname := CheckString(L, 1)
+	toname := CheckString(L, 2)
+	return FileResult(L, GetFileSystem(L).Rename(fromname, toname), "")
+}
+
+// os.tmpname ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-os.tmpname
+// lua-5.3.4/src/loslib.c#os_tmpname()
+func osTmpName(L LuaState) int {
+	name, err := GetFileSystem(L).TempName()
+	if err != nil {
+		return Error(L, "unable to generate a unique filename")
+	}
+	L.PushString(name)
+	return 1
+}
+
+/*
+** {======================================================
+** Time/Date operations
+** { year=%Y, month=%m, day=%d, hour=%H, min=%M, sec=%S,
+**   wday=%w+1, yday=%j, isdst=? }
+** =======================================================
+ */
+
+// lua-5.3.4/src/loslib.c#setfield()
+func setField(L LuaState, key string, value int) {
+	L.PushInteger(int64(value))
+	L.SetField(-2, key)
+}
+
+// lua-5.3.4/src/loslib.c#setallfields()
+func setAllFields(L LuaState, t time.Time) {
+	setField(L, "sec", t.Second())
+	setField(L, "min", t.Minute())
+	setField(L, "hour", t.Hour())
+	setField(L, "day", t.Day())
+	setField(L, "month", int(t.Month()))
+	setField(L, "year", t.Year())
+	setField(L, "wday", int(t.Weekday())+1)
+	setField(L, "yday", t.YearDay())
+	L.PushBoolean(false) /* Go的time包不提供夏令时信息 */
+	L.SetField(-2, "isdst")
+}
+
+// 读取日期表里的字段，d < 0表示该字段是必需的
+// lua-5.3.4/src/loslib.c#getfield()
+func getField(L LuaState, key string, d int64) int {
+	t := L.GetField(-1, key)
+	res, isNum := L.ToIntegerX(-1)
+	if !isNum { /* field is not an integer? */
+		if t != LUA_TNIL { /* some other value? */
+			Error(L, "field '%s' is not an integer", key)
+		} else if d < 0 { /* absent field; no default? */
+			Error(L, "field '%s' missing in date table", key)
+		}
+		res = d
+	} else {
+		/* 和C的struct tm一样，字段必须能放进int里 */
+		if res > 1<<31-1 || res < -(1<<31) {
+			Error(L, "field '%s' out-of-bound", key)
+		}
+	}
+	L.Pop(1)
+	return int(res)
+}
+
+// os.date ([format [, time]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-os.date
+// lua-5.3.4/src/loslib.c#os_date()
+func osDate(L LuaState) int {
+	format := OptString(L, 1, "%c")
+	t := time.Now()
+	if !L.IsNoneOrNil(2) {
+		t = time.Unix(CheckInteger(L, 2), 0)
+	}
+
+	if strings.HasPrefix(format, "!") { /* UTC? */
+		format = format[1:] /* skip '!' */
+		t = t.UTC()
+	} else {
+		t = t.Local()
+	}
+
+	if format == "*t" {
+		L.CreateTable(0, 9) /* 9 = number of fields */
+		setAllFields(L, t)
+	} else {
+		L.PushString(strftime(L, format, t))
+	}
+	return 1
+}
+
+// os.time ([table])
+// http://www.lua.org/manual/5.3/manual.html#pdf-os.time
+// lua-5.3.4/src/loslib.c#os_time()
+func osTime(L LuaState) int {
+	var t time.Time
+	if L.IsNoneOrNil(1) { /* called without args? */
+		t = time.Now() /* get current time */
+	} else {
+		CheckType(L, 1, LUA_TTABLE)
+		L.SetTop(1) /* make sure table is at the top */
+		sec := getField(L, "sec", 0)
+		min := getField(L, "min", 0)
+		hour := getField(L, "hour", 12)
+		day := getField(L, "day", -1)
+		month := getField(L, "month", -1)
+		year := getField(L, "year", -1)
+		/* time.Date()和mktime()一样会规范化超出范围的字段 */
+		t = time.Date(year, time.Month(month), day, hour, min, sec, 0, time.Local)
+		setAllFields(L, t) /* update fields with normalized values */
+	}
+	L.PushInteger(t.Unix())
+	return 1
+}
+
+// 按照C locale实现strftime()
+// http://man7.org/linux/man-pages/man3/strftime.3.html
+func strftime(L LuaState, format string, t time.Time) string {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			b.WriteByte(format[i])
+			continue
+		}
+		i++
+		if i < len(format) && (format[i] == 'E' || format[i] == 'O') {
+			i++ /* C99的修饰符，在C locale下没有效果 */
+		}
+		if i >= len(format) {
+			ArgError(L, 1, "invalid conversion specifier '%'")
+		}
+		switch c := format[i]; c {
+		case 'a':
+			b.WriteString(t.Format("Mon"))
+		case 'A':
+			b.WriteString(t.Format("Monday"))
+		case 'b', 'h':
+			b.WriteString(t.Format("Jan"))
+		case 'B':
+			b.WriteString(t.Format("January"))
+		case 'c':
+			b.WriteString(t.Format("Mon Jan _2 15:04:05 2006"))
+		case 'C':
+			fmt.Fprintf(&b, "%02d", t.Year()/100)
+		case 'd':
+			fmt.Fprintf(&b, "%02d", t.Day())
+		case 'D':
+			b.WriteString(t.Format("01/02/06"))
+		case 'e':
+			fmt.Fprintf(&b, "%2d", t.Day())
+		case 'F':
+			b.WriteString(t.Format("2006-01-02"))
+		case 'g':
+			year, _ := t.ISOWeek()
+			fmt.Fprintf(&b, "%02d", year%100)
+		case 'G':
+			year, _ := t.ISOWeek()
+			fmt.Fprintf(&b, "%d", year)
+		case 'H':
+			fmt.Fprintf(&b, "%02d", t.Hour())
+		case 'I':
+			fmt.Fprintf(&b, "%02d", (t.Hour()+11)%12+1)
+		case 'j':
+			fmt.Fprintf(&b, "%03d", t.YearDay())
+		case 'm':
+			fmt.Fprintf(&b, "%02d", int(t.Month()))
+		case 'M':
+			fmt.Fprintf(&b, "%02d", t.Minute())
+		case 'n':
+			b.WriteByte('\n')
+		case 'p':
+			b.WriteString(t.Format("PM"))
+		case 'r':
+			b.WriteString(t.Format("03:04:05 PM"))
+		case 'R':
+			b.WriteString(t.Format("15:04"))
+		case 'S':
+			fmt.Fprintf(&b, "%02d", t.Second())
+		case 't':
+			b.WriteByte('\t')
+		case 'T', 'X':
+			b.WriteString(t.Format("15:04:05"))
+		case 'u':
+			fmt.Fprintf(&b, "%d", (int(t.Weekday())+6)%7+1)
+		case 'U':
+			fmt.Fprintf(&b, "%02d", (t.YearDay()+6-int(t.Weekday()))/7)
+		case 'V':
+			_, week := t.ISOWeek()
+			fmt.Fprintf(&b, "%02d", week)
+		case 'w':
+			fmt.Fprintf(&b, "%d", int(t.Weekday()))
+		case 'W':
+			fmt.Fprintf(&b, "%02d", (t.YearDay()+6-(int(t.Weekday())+6)%7)/7)
+		case 'x':
+			b.WriteString(t.Format("01/02/06"))
+		case 'y':
+			fmt.Fprintf(&b, "%02d", t.Year()%100)
+		case 'Y':
+			fmt.Fprintf(&b, "%d", t.Year())
+		case 'z':
+			b.WriteString(t.Format("-0700"))
+		case 'Z':
+			b.WriteString(t.Format("MST"))
+		case '%':
+			b.WriteByte('%')
+		default:
+			ArgError(L, 1,
+				fmt.Sprintf("invalid conversion specifier '%%%c'", c))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,284 @@
+package stdlib
+
+import (
+	"fmt"
+	. "luago/api"
+	. "luago/auxlib"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	_LUA_DIRSEP    = string(os.PathSeparator)
+	_LUA_PATH_SEP  = ";"
+	_LUA_PATH_MARK = "?"
+	_LUA_EXEC_DIR  = "!"
+	_LUA_IGMARK    = "-"
+	_LUA_LSUBSEP   = _LUA_DIRSEP
+
+	_LUA_ROOT         = "/usr/local/"
+	_LUA_LDIR         = _LUA_ROOT + "share/lua/5.3/"
+	_LUA_CDIR         = _LUA_ROOT + "lib/lua/5.3/"
+	_LUA_PATH_DEFAULT = _LUA_LDIR + "?.lua;" + _LUA_LDIR + "?/init.lua;" +
+		_LUA_CDIR + "?.lua;" + _LUA_CDIR + "?/init.lua;" + "./?.lua;" + "./?/init.lua"
+	_LUA_PATH_VAR         = "LUA_PATH"
+	_LUA_VERSUFFIX        = "_5_3"
+	_AUXMARK              = "\x01" /* auxiliary mark */
+	_LUA_GO_MODULE_PREFIX = "go:"
+)
+
+/*
+ * Go模块注册表，所有状态共享。
+ * package库用Go模块搜索器代替了官方实现里的C库搜索器（以及package.cpath），
+ * require找不到预加载的模块和Lua文件时，就会在这里查找
+ */
+var goModules = struct {
+	sync.RWMutex
+	m map[string]GoFunction
+}{m: map[string]GoFunction{}}
+
+// 注册Go模块，脚本里的require(name)会以模块名为参数调用opener，opener的返回值就是模块的值
+func RegisterModule(name string, opener GoFunction) {
+	goModules.Lock()
+	defer goModules.Unlock()
+	goModules.m[name] = opener
+}
+
+func lookupModule(name string) (GoFunction, bool) {
+	goModules.RLock()
+	defer goModules.RUnlock()
+	opener, ok := goModules.m[name]
+	return opener, ok
+}
+
+var pkLib = map[string]GoFunction{
+	"searchpath": pkgSearchPath,
+	/* placeholders */
+	"preload":   nil,
+	"path":      nil,
+	"searchers": nil,
+	"loaded":    nil,
+}
+
+var llFuncs = map[string]GoFunction{
+	"require": pkgRequire,
+}
+
+// lua-5.3.4/src/loadlib.c#luaopen_package()
+func OpenPackage(L LuaState) int {
+	NewLib(L, pkLib) /* create 'package' table */
+	createSearchersTable(L)
+	/* set paths */
+	setPath(L, "path", _LUA_PATH_VAR, _LUA_PATH_DEFAULT)
+	/* store config information */
+	L.PushString(_LUA_DIRSEP + "\n" + _LUA_PATH_SEP + "\n" + _LUA_PATH_MARK + "\n" +
+		_LUA_EXEC_DIR + "\n" + _LUA_IGMARK + "\n")
+	L.SetField(-2, "config")
+	/* set field 'loaded' */
+	GetSubTable(L, LUA_REGISTRYINDEX, LUA_LOADED_TABLE)
+	L.SetField(-2, "loaded")
+	/* set field 'preload' */
+	GetSubTable(L, LUA_REGISTRYINDEX, LUA_PRELOAD_TABLE)
+	L.SetField(-2, "preload")
+	L.PushGlobalTable()
+	L.PushValue(-2)         /* set 'package' as upvalue for next lib */
+	SetFuncs(L, llFuncs, 1) /* open lib into global table */
+	L.Pop(1)                /* pop global table */
+	return 1                /* return 'package' table */
+}
+
+// lua-5.3.4/src/loadlib.c#createsearcherstable()
+func createSearchersTable(L LuaState) {
+	searchers := []GoFunction{
+		preloadSearcher,
+		luaSearcher,
+		goSearcher,
+	}
+	/* create 'searchers' table */
+	L.CreateTable(len(searchers), 0)
+	/* fill it with predefined searchers */
+	for i, searcher := range searchers {
+		L.PushValue(-2) /* set 'package' as upvalue for all searchers */
+		L.PushGoClosure(searcher, 1)
+		L.RawSetI(-2, int64(i+1))
+	}
+	L.SetField(-2, "searchers") /* put it in field 'searchers' */
+}
+
+/*
+ * Set a path. 环境变量LUA_PATH_5_3或LUA_PATH里的";;"会被替换成默认路径
+ */
+// lua-5.3.4/src/loadlib.c#setpath()
+func setPath(L LuaState, fieldname, envname, dft string) {
+	path, ok := os.LookupEnv(envname + _LUA_VERSUFFIX)
+	if !ok {
+		path, ok = os.LookupEnv(envname)
+	}
+	if !ok { /* no environment variable? */
+		L.PushString(dft) /* use default */
+	} else {
+		/* replace ";;" by ";AUXMARK;" and then AUXMARK by default path */
+		path = strings.Replace(path, _LUA_PATH_SEP+_LUA_PATH_SEP,
+			_LUA_PATH_SEP+_AUXMARK+_LUA_PATH_SEP, -1)
+		L.PushString(strings.Replace(path, _AUXMARK, dft, -1))
+	}
+	L.SetField(-2, fieldname) /* package[fieldname] = path value */
+}
+
+// require (modname)
+// http://www.lua.org/manual/5.3/manual.html#pdf-require
+// lua-5.3.4/src/loadlib.c#ll_require()
+func pkgRequire(L LuaState) int {
+	name := CheckString(L, 1)
+	L.SetTop(1) /* LOADED table will be at index 2 */
+	L.GetField(LUA_REGISTRYINDEX, LUA_LOADED_TABLE)
+	L.GetField(2, name)  /* LOADED[name] */
+	if L.ToBoolean(-1) { /* is it there? */
+		return 1 /* package is already loaded */
+	}
+	/* else must load package */
+	L.Pop(1) /* remove 'getfield' result */
+	findLoader(L, name)
+	L.PushString(name) /* pass name as argument to module loader */
+	L.Insert(-2)       /* name is 1st argument (before search data) */
+	L.Call(2, 1)       /* run loader to load module */
+	if !L.IsNil(-1) {  /* non-nil return? */
+		L.SetField(2, name) /* LOADED[name] = returned value */
+	}
+	if L.GetField(2, name) == LUA_TNIL { /* module set no value? */
+		L.PushBoolean(true) /* use true as result */
+		L.PushValue(-1)     /* extra copy to be returned */
+		L.SetField(2, name) /* LOADED[name] = true */
+	}
+	return 1
+}
+
+// 依次调用package.searchers里的搜索器，直到找到模块的加载器
+// lua-5.3.4/src/loadlib.c#findloader()
+func findLoader(L LuaState, name string) {
+	var msg strings.Builder /* to build error message */
+	/* push 'package.searchers' to index 3 in the stack */
+	if L.GetField(LuaUpvalueIndex(1), "searchers") != LUA_TTABLE {
+		Error(L, "'package.searchers' must be a table")
+	}
+	/*  iterate over available searchers to find a loader */
+	for i := int64(1); ; i++ {
+		if L.RawGetI(3, i) == LUA_TNIL { /* no more searchers? */
+			L.Pop(1) /* remove nil */
+			Error(L, "module '%s' not found:%s", name, msg.String())
+		}
+		L.PushString(name)
+		L.Call(1, 2)          /* call it */
+		if L.IsFunction(-2) { /* did it find a loader? */
+			return /* module loader found */
+		} else if L.IsString(-2) { /* searcher returned error message? */
+			L.Pop(1)                        /* remove extra return */
+			msg.WriteString(L.ToString(-1)) /* concatenate error message */
+			L.Pop(1)
+		} else {
+			L.Pop(2) /* remove both returns */
+		}
+	}
+}
+
+// lua-5.3.4/src/loadlib.c#searcher_preload()
+func preloadSearcher(L LuaState) int {
+	name := CheckString(L, 1)
+	L.GetField(LUA_REGISTRYINDEX, LUA_PRELOAD_TABLE)
+	if L.GetField(-1, name) == LUA_TNIL { /* not found? */
+		L.PushString(fmt.Sprintf("\n\tno field package.preload['%s']", name))
+	}
+	return 1
+}
+
+// lua-5.3.4/src/loadlib.c#searcher_Lua()
+func luaSearcher(L LuaState) int {
+	name := CheckString(L, 1)
+	filename, ok := findFile(L, name, "path", _LUA_LSUBSEP)
+	if !ok {
+		return 1 /* module not found in this path */
+	}
+	return checkLoad(L, LoadFileX(L, filename, verifyMode("bt")) == LUA_OK, filename)
+}
+
+// Go模块搜索器，在RegisterModule()注册的模块里查找，找到时把opener作为加载器返回
+func goSearcher(L LuaState) int {
+	name := CheckString(L, 1)
+	opener, ok := lookupModule(name)
+	if !ok {
+		L.PushString(fmt.Sprintf("\n\tno Go module '%s'", name))
+		return 1
+	}
+	L.PushGoFunction(opener)
+	L.PushString(_LUA_GO_MODULE_PREFIX + name) /* will be 2nd argument to module */
+	return 2
+}
+
+// lua-5.3.4/src/loadlib.c#checkload()
+func checkLoad(L LuaState, stat bool, filename string) int {
+	if stat { /* module loaded successfully? */
+		L.PushString(filename) /* will be 2nd argument to module */
+		return 2               /* return open function and file name */
+	}
+	return Error(L, "error loading module '%s' from file '%s':\n\t%s", L.ToString(1), filename, L.ToString(-1))
+}
+
+// lua-5.3.4/src/loadlib.c#findfile()
+func findFile(L LuaState, name, pname, dirsep string) (string, bool) {
+	L.GetField(LuaUpvalueIndex(1), pname)
+	path, ok := L.ToStringX(-1)
+	if !ok {
+		Error(L, "'package.%s' must be a string", pname)
+	}
+	return searchPath(L, name, path, ".", dirsep)
+}
+
+// 在path里查找name，找不到时把错误消息压入栈顶
+// lua-5.3.4/src/loadlib.c#searchpath()
+func searchPath(L LuaState, name, path, sep, dirsep string) (string, bool) {
+	var msg strings.Builder /* to build error message */
+	if sep != "" {          /* non-empty separator? */
+		name = strings.Replace(name, sep, dirsep, -1) /* replace it by 'dirsep' */
+	}
+	for _, template := range strings.Split(path, _LUA_PATH_SEP) {
+		if template == "" {
+			continue
+		}
+		filename := strings.Replace(template, _LUA_PATH_MARK, name, -1)
+		if readable(L, filename) { /* does file exist and is readable? */
+			return filename, true /* return that file name */
+		}
+		fmt.Fprintf(&msg, "\n\tno file '%s'", filename)
+	}
+	L.PushString(msg.String()) /* create error message */
+	return "", false           /* not found */
+}
+
+// lua-5.3.4/src/loadlib.c#readable()
+func readable(L LuaState, filename string) bool {
+	f, err := GetFileSystem(L).OpenFile(filename, os.O_RDONLY, 0)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
+
+// package.searchpath (name, path [, sep [, rep]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-package.searchpath
+// lua-5.3.4/src/loadlib.c#ll_searchpath()
+func pkgSearchPath(L LuaState) int {
+	name := CheckString(L, 1)
+	path := CheckString(L, 2)
+	sep := OptString(L, 3, ".")
+	rep := OptString(L, 4, _LUA_DIRSEP)
+	if filename, ok := searchPath(L, name, path, sep, rep); ok {
+		L.PushString(filename)
+		return 1
+	}
+	/* error message is on top of the stack */
+	L.PushNil()
+	L.Insert(-2)
+	return 2 /* return nil + error message */
+}
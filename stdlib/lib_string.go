@@ -0,0 +1,431 @@
+package stdlib
+
+import (
+	. "luago/api"
+	. "luago/auxlib"
+	"strings"
+)
+
+var strLib = map[string]GoFunction{
+	"len":      strLen,
+	"rep":      strRep,
+	"reverse":  strReverse,
+	"lower":    strLower,
+	"upper":    strUpper,
+	"sub":      strSub,
+	"byte":     strByte,
+	"char":     strChar,
+	"dump":     strDump,
+	"format":   strFormat,
+	"find":     strFind,
+	"match":    strMatch,
+	"gmatch":   strGmatch,
+	"gsub":     strGsub,
+	"pack":     strPack,
+	"packsize": strPackSize,
+	"unpack":   strUnpack,
+}
+
+// lua-5.3.4/src/lstrlib.c#luaopen_string()
+func OpenString(L LuaState) int {
+	NewLib(L, strLib)
+	createMetatable(L)
+	return 1
+}
+
+// 所有字符串共享同一个元表，其__index字段指向string库，这样就可以用s:upper()这种方式调用库函数了
+// lua-5.3.4/src/lstrlib.c#createmetatable()
+func createMetatable(L LuaState) {
+	L.CreateTable(0, 1)       /* table to be metatable for strings */
+	L.PushString("")          /* dummy string */
+	L.PushValue(-2)           /* copy table */
+	L.SetMetatable(-2)        /* set table as metatable for strings */
+	L.Pop(1)                  /* pop dummy string */
+	L.PushValue(-2)           /* get string library */
+	L.SetField(-2, "__index") /* metatable.__index = string */
+	L.Pop(1)                  /* pop metatable */
+}
+
+/* translate a relative string position: negative means back from end */
+// lua-5.3.4/src/lstrlib.c#posrelat()
+func posRelat(pos int64, _len int) int64 {
+	if pos >= 0 {
+		return pos
+	} else if pos < -int64(_len) {
+		return 0
+	} else {
+		return int64(_len) + pos + 1
+	}
+}
+
+// string.len (s)
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.len
+// lua-5.3.4/src/lstrlib.c#str_len()
+func strLen(L LuaState) int {
+	s := CheckString(L, 1)
+	L.PushInteger(int64(len(s)))
+	return 1
+}
+
+// string.rep (s, n [, sep])
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.rep
+// lua-5.3.4/src/lstrlib.c#str_rep()
+func strRep(L LuaState) int {
+	s := CheckString(L, 1)
+	n := CheckInteger(L, 2)
+	sep := OptString(L, 3, "")
+
+	if n <= 0 {
+		L.PushString("")
+	} else if int64(len(s)+len(sep)) > maxStringSize/n { /* may overflow? */
+		return Error(L, "resulting string too large")
+	} else {
+		/* 结果是s后面跟着n-1个sep..s。按二进制位翻倍地拼接，每一步都经过Concat
+		   计入内存用量，这样超过内存上限时可以尽早出错，而不是先生成巨大的字符串 */
+		result := L.GetTop() + 1
+		L.PushString(s)
+		L.PushString(sep + s) /* unit */
+		for m := n - 1; m > 0; m >>= 1 {
+			if m&1 != 0 {
+				L.PushValue(result)
+				L.PushValue(result + 1)
+				L.Concat(2)
+				L.Replace(result) /* result = result .. unit */
+			}
+			if m > 1 {
+				L.PushValue(-1)
+				L.Concat(2) /* unit = unit .. unit */
+			}
+		}
+		L.Pop(1)
+	}
+	return 1
+}
+
+// 结果字符串的最大长度
+const maxStringSize = 1<<31 - 1
+
+// string.reverse (s)
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.reverse
+// lua-5.3.4/src/lstrlib.c#str_reverse()
+func strReverse(L LuaState) int {
+	s := CheckString(L, 1)
+	a := []byte(s)
+	for i, j := 0, len(a)-1; i < j; i, j = i+1, j-1 {
+		a[i], a[j] = a[j], a[i]
+	}
+	L.PushString(string(a))
+	return 1
+}
+
+// string.lower (s)
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.lower
+// lua-5.3.4/src/lstrlib.c#str_lower()
+// 和C locale下的tolower()一样，只转换ASCII字母
+func strLower(L LuaState) int {
+	s := CheckString(L, 1)
+	a := []byte(s)
+	for i, c := range a {
+		if c >= 'A' && c <= 'Z' {
+			a[i] = c + ('a' - 'A')
+		}
+	}
+	L.PushString(string(a))
+	return 1
+}
+
+// string.upper (s)
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.upper
+// lua-5.3.4/src/lstrlib.c#str_upper()
+func strUpper(L LuaState) int {
+	s := CheckString(L, 1)
+	a := []byte(s)
+	for i, c := range a {
+		if c >= 'a' && c <= 'z' {
+			a[i] = c - ('a' - 'A')
+		}
+	}
+	L.PushString(string(a))
+	return 1
+}
+
+// string.sub (s, i [, j])
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.sub
+// lua-5.3.4/src/lstrlib.c#str_sub()
+func strSub(L LuaState) int {
+	s := CheckString(L, 1)
+	sLen := len(s)
+	i := posRelat(CheckInteger(L, 2), sLen)
+	j := posRelat(OptInteger(L, 3, -1), sLen)
+
+	if i < 1 {
+		i = 1
+	}
+	if j > int64(sLen) {
+		j = int64(sLen)
+	}
+
+	if i <= j {
+		L.PushString(s[i-1 : j])
+	} else {
+		L.PushString("")
+	}
+	return 1
+}
+
+// string.byte (s [, i [, j]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.byte
+// lua-5.3.4/src/lstrlib.c#str_byte()
+func strByte(L LuaState) int {
+	s := CheckString(L, 1)
+	sLen := len(s)
+	i := posRelat(OptInteger(L, 2, 1), sLen)
+	j := posRelat(OptInteger(L, 3, i), sLen)
+
+	if i < 1 {
+		i = 1
+	}
+	if j > int64(sLen) {
+		j = int64(sLen)
+	}
+
+	if i > j {
+		return 0 /* empty interval; return no values */
+	}
+	n := int(j - i + 1)
+	if !L.CheckStack(n) {
+		return Error(L, "string slice too long")
+	}
+	for k := 0; k < n; k++ {
+		L.PushInteger(int64(s[int(i)+k-1]))
+	}
+	return n
+}
+
+// string.char (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.char
+// lua-5.3.4/src/lstrlib.c#str_char()
+func strChar(L LuaState) int {
+	nArgs := L.GetTop()
+	s := make([]byte, nArgs)
+	for i := 1; i <= nArgs; i++ {
+		c := CheckInteger(L, i)
+		if c < 0 || c > 255 {
+			ArgError(L, i, "value out of range")
+		}
+		s[i-1] = byte(c)
+	}
+	L.PushString(string(s))
+	return 1
+}
+
+// string.dump (function [, strip])
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.dump
+// lua-5.3.4/src/lstrlib.c#str_dump()
+func strDump(L LuaState) int {
+	strip := L.ToBoolean(2)
+	CheckType(L, 1, LUA_TFUNCTION)
+	L.SetTop(1)
+	chunk := L.Dump(strip)
+	if chunk == nil {
+		return Error(L, "unable to dump given function")
+	}
+	L.PushString(string(chunk))
+	return 1
+}
+
+// string.find (s, pattern [, init [, plain]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.find
+// lua-5.3.4/src/lstrlib.c#str_find()
+func strFind(L LuaState) int {
+	return strFindAux(L, true)
+}
+
+// string.match (s, pattern [, init])
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.match
+// lua-5.3.4/src/lstrlib.c#str_match()
+func strMatch(L LuaState) int {
+	return strFindAux(L, false)
+}
+
+// lua-5.3.4/src/lstrlib.c#str_find_aux()
+func strFindAux(L LuaState, find bool) int {
+	s := CheckString(L, 1)
+	p := CheckString(L, 2)
+	init := posRelat(OptInteger(L, 3, 1), len(s))
+	if init < 1 {
+		init = 1
+	}
+	if init > int64(len(s))+1 { /* start after string's end? */
+		L.PushNil() /* cannot find anything */
+		return 1
+	}
+
+	/* explicit request or no special characters? */
+	if find && (L.ToBoolean(4) || noSpecials(p)) {
+		/* do a plain search */
+		if idx := strings.Index(s[init-1:], p); idx >= 0 {
+			start := int(init) + idx
+			L.PushInteger(int64(start))
+			L.PushInteger(int64(start + len(p) - 1))
+			return 2
+		}
+	} else {
+		ms := newMatchState(L, s, p)
+		anchor := len(p) > 0 && p[0] == '^'
+		pi := 0
+		if anchor {
+			pi = 1 /* skip anchor character */
+		}
+		for s1 := int(init) - 1; ; s1++ {
+			ms.reprepState()
+			if e := ms.doMatch(s1, pi); e != -1 {
+				if find {
+					L.PushInteger(int64(s1 + 1)) /* start */
+					L.PushInteger(int64(e))      /* end */
+					return ms.pushCaptures(-1, 0) + 2
+				} else {
+					return ms.pushCaptures(s1, e)
+				}
+			}
+			if s1 >= len(s) || anchor {
+				break
+			}
+		}
+	}
+	L.PushNil() /* not found */
+	return 1
+}
+
+// 检查模式里是否有特殊字符
+// lua-5.3.4/src/lstrlib.c#nospecials()
+func noSpecials(p string) bool {
+	return !strings.ContainsAny(p, _SPECIALS)
+}
+
+// string.gmatch (s, pattern)
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.gmatch
+// lua-5.3.4/src/lstrlib.c#gmatch()
+func strGmatch(L LuaState) int {
+	s := CheckString(L, 1)
+	p := CheckString(L, 2)
+	pos, lastMatch := 0, -1
+
+	// 迭代器通过Go闭包记住当前位置和上一次匹配的结束位置
+	// lua-5.3.4/src/lstrlib.c#gmatch_aux()
+	gmatchAux := func(L LuaState) int {
+		ms := newMatchState(L, s, p)
+		for src := pos; src <= len(s); src++ {
+			ms.reprepState()
+			if e := ms.doMatch(src, 0); e != -1 && e != lastMatch {
+				pos, lastMatch = e, e
+				return ms.pushCaptures(src, e)
+			}
+		}
+		pos = len(s) + 1
+		return 0 /* not found */
+	}
+
+	L.PushGoFunction(gmatchAux)
+	return 1
+}
+
+// string.gsub (s, pattern, repl [, n])
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.gsub
+// lua-5.3.4/src/lstrlib.c#str_gsub()
+func strGsub(L LuaState) int {
+	src := CheckString(L, 1)
+	p := CheckString(L, 2)
+	tr := L.Type(3)                             /* replacement type */
+	maxS := OptInteger(L, 4, int64(len(src)+1)) /* max replacements */
+
+	if tr != LUA_TNUMBER && tr != LUA_TSTRING &&
+		tr != LUA_TFUNCTION && tr != LUA_TTABLE {
+		TypeError(L, 3, "string/function/table")
+	}
+
+	anchor := len(p) > 0 && p[0] == '^'
+	pi := 0
+	if anchor {
+		pi = 1 /* skip anchor character */
+	}
+
+	ms := newMatchState(L, src, p)
+	var b strings.Builder
+	s, lastMatch := 0, -1
+	n := int64(0)
+	for n < maxS {
+		ms.reprepState()
+		if e := ms.doMatch(s, pi); e != -1 && e != lastMatch { /* match? */
+			n++
+			ms.addValue(&b, s, e, tr) /* add replacement to buffer */
+			s, lastMatch = e, e
+		} else if s < len(src) { /* otherwise, skip one character */
+			b.WriteByte(src[s])
+			s++
+		} else {
+			break /* end of subject */
+		}
+		if anchor {
+			break
+		}
+	}
+	b.WriteString(src[s:])
+	L.PushString(b.String())
+	L.PushInteger(n) /* number of substitutions */
+	return 2
+}
+
+// 把一次匹配的替换结果追加到b
+// lua-5.3.4/src/lstrlib.c#add_value()
+func (ms *matchState) addValue(b *strings.Builder, s, e int, tr LuaType) {
+	L := ms.L
+	switch tr {
+	case LUA_TFUNCTION: /* call the function */
+		L.PushValue(3)
+		n := ms.pushCaptures(s, e) /* all captures as arguments */
+		L.Call(n, 1)
+	case LUA_TTABLE: /* index the table */
+		ms.pushOneCapture(0, s, e) /* first capture is the index */
+		L.GetTable(3)
+	default: /* LUA_TNUMBER or LUA_TSTRING */
+		ms.addS(b, s, e) /* add value to the buffer */
+		return
+	}
+	if !L.ToBoolean(-1) { /* nil or false? */
+		L.Pop(1)
+		b.WriteString(ms.src[s:e]) /* keep original text */
+		return
+	} else if !L.IsString(-1) {
+		Error(L, "invalid replacement value (a %s)", L.TypeName(L.Type(-1)))
+	}
+	b.WriteString(L.ToString(-1)) /* add result to accumulator */
+	L.Pop(1)
+}
+
+// 替换字符串里的%0~%9表示对应的捕获，%%表示%本身
+// lua-5.3.4/src/lstrlib.c#add_s()
+func (ms *matchState) addS(b *strings.Builder, s, e int) {
+	L := ms.L
+	news := L.ToString(3)
+	for i := 0; i < len(news); i++ {
+		if news[i] != _L_ESC {
+			b.WriteByte(news[i])
+			continue
+		}
+		i++ /* skip ESC */
+		if i < len(news) && news[i] == _L_ESC {
+			b.WriteByte(news[i]) /* %% */
+		} else if i < len(news) && isDigit(news[i]) {
+			if news[i] == '0' {
+				b.WriteString(ms.src[s:e])
+			} else {
+				ms.pushOneCapture(int(news[i]-'1'), s, e)
+				b.WriteString(ToStringMeta(L, -1)) /* if number, convert it to string */
+				L.Pop(2)                           /* remove original value and its string */
+			}
+		} else {
+			Error(L, "invalid use of '%c' in replacement string", _L_ESC)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package stdlib
+
+import (
+	"fmt"
+	. "luago/api"
+	. "luago/auxlib"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/* valid flags in a format specification */
+const _L_FMTFLAGS = "-+ #0"
+
+// string.format (formatstring, ···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.format
+// lua-5.3.4/src/lstrlib.c#str_format()
+// 转换说明的解析和C实现一致，具体的格式化交给fmt.Sprintf()，
+// 对Go和C行为不一致的地方（%g的默认精度、%a、inf和nan等）单独处理
+func strFormat(L LuaState) int {
+	top := L.GetTop()
+	arg := 1
+	strfrmt := CheckString(L, arg)
+	var b strings.Builder
+
+	for i := 0; i < len(strfrmt); {
+		if strfrmt[i] != '%' {
+			b.WriteByte(strfrmt[i])
+			i++
+			continue
+		} else if i+1 < len(strfrmt) && strfrmt[i+1] == '%' {
+			b.WriteByte('%') /* %% */
+			i += 2
+			continue
+		}
+
+		/* format item */
+		i++ /* skip '%' */
+		arg++
+		if arg > top { /* too many format specifiers? */
+			ArgError(L, arg, "no value")
+		}
+		spec, conv, next := scanFormat(L, strfrmt, i)
+		i = next
+		switch conv {
+		case 'c':
+			s := string([]byte{byte(CheckInteger(L, arg))})
+			b.WriteString(fmt.Sprintf(spec+"s", s))
+		case 'd', 'i':
+			n := CheckInteger(L, arg)
+			b.WriteString(fmt.Sprintf(spec+"d", n))
+		case 'u':
+			n := CheckInteger(L, arg)
+			b.WriteString(fmt.Sprintf(spec+"d", uint64(n)))
+		case 'o', 'x', 'X': /* 和C一样把整数当成无符号数 */
+			n := CheckInteger(L, arg)
+			b.WriteString(fmt.Sprintf(spec+string(conv), uint64(n)))
+		case 'a', 'A':
+			n := CheckNumber(L, arg)
+			b.WriteString(formatHexFloat(spec, conv, n))
+		case 'e', 'E', 'f', 'F', 'g', 'G':
+			n := CheckNumber(L, arg)
+			b.WriteString(formatFloat(spec, conv, n))
+		case 'q':
+			addLiteral(L, &b, arg)
+		case 's':
+			s := ToStringMeta(L, arg)
+			if !strings.Contains(spec, ".") && len(s) >= 100 {
+				/* no precision and string is too long to be formatted */
+				b.WriteString(s) /* keep entire string */
+			} else {
+				b.WriteString(fmt.Sprintf(spec+"s", s))
+			}
+			L.Pop(1) /* remove result from 'toStringMeta' */
+		default: /* also treat cases 'pnLlh' */
+			return Error(L, "invalid option '%%%c' to 'format'", conv)
+		}
+	}
+
+	L.PushString(b.String())
+	return 1
+}
+
+// 读取一个转换说明（不含最后的转换字符），返回说明、转换字符和下一个位置
+// lua-5.3.4/src/lstrlib.c#scanformat()
+func scanFormat(L LuaState, strfrmt string, i int) (string, byte, int) {
+	p := i
+	for p < len(strfrmt) && strings.IndexByte(_L_FMTFLAGS, strfrmt[p]) >= 0 {
+		p++ /* skip flags */
+	}
+	if p-i >= len(_L_FMTFLAGS)+1 {
+		Error(L, "invalid format (repeated flags)")
+	}
+	p = skipDigits(strfrmt, p) /* skip width (2 digits at most) */
+	if p < len(strfrmt) && strfrmt[p] == '.' {
+		p++
+		p = skipDigits(strfrmt, p) /* skip precision (2 digits at most) */
+	}
+	if p < len(strfrmt) && isDigit(strfrmt[p]) {
+		Error(L, "invalid format (width or precision too long)")
+	}
+	var conv byte
+	if p < len(strfrmt) {
+		conv = strfrmt[p]
+	}
+	return "%" + strfrmt[i:p], conv, p + 1
+}
+
+func skipDigits(s string, p int) int {
+	for n := 0; n < 2 && p < len(s) && isDigit(s[p]); n++ {
+		p++
+	}
+	return p
+}
+
+// %e %f %g，C里的%g默认精度是6，而Go的%g默认使用最短表示，所以需要补上精度
+func formatFloat(spec string, conv byte, n float64) string {
+	if math.IsInf(n, 0) || math.IsNaN(n) {
+		return formatNonFinite(spec, conv, n)
+	}
+	if (conv == 'g' || conv == 'G') && !strings.Contains(spec, ".") {
+		spec += ".6"
+	}
+	return fmt.Sprintf(spec+string(conv), n)
+}
+
+// C的printf()把无穷大和NaN格式化成inf、-inf、nan（%E %F %G %A时为大写）
+func formatNonFinite(spec string, conv byte, n float64) string {
+	var s string
+	switch {
+	case math.IsNaN(n):
+		s = "nan"
+		if math.Signbit(n) {
+			s = "-nan"
+		}
+	case n > 0:
+		s = "inf"
+	default:
+		s = "-inf"
+	}
+	if conv >= 'A' && conv <= 'Z' {
+		s = strings.ToUpper(s)
+	}
+	return padNumber(spec, s)
+}
+
+// %a，Go的十六进制浮点数格式的指数至少有两位，而C没有这个限制
+func formatHexFloat(spec string, conv byte, n float64) string {
+	if math.IsInf(n, 0) || math.IsNaN(n) {
+		return formatNonFinite(spec, conv, n)
+	}
+	prec := -1
+	if idx := strings.IndexByte(spec, '.'); idx >= 0 {
+		prec, _ = strconv.Atoi(spec[idx+1:])
+	}
+	s := strconv.FormatFloat(n, 'x', prec, 64)
+	if idx := strings.IndexByte(s, 'p'); idx >= 0 {
+		exp := s[idx+2:]
+		for len(exp) > 1 && exp[0] == '0' {
+			exp = exp[1:]
+		}
+		s = s[:idx+2] + exp
+	}
+	if conv == 'A' {
+		s = strings.ToUpper(s)
+	}
+	return padNumber(spec, s)
+}
+
+// 按照转换说明里的'+'、' '、'-'标志和宽度处理已经格式化好的数字
+func padNumber(spec, s string) string {
+	rest := strings.TrimLeft(spec[1:], _L_FMTFLAGS)
+	flags := spec[1 : len(spec)-len(rest)]
+	if !strings.HasPrefix(s, "-") {
+		if strings.Contains(flags, "+") {
+			s = "+" + s
+		} else if strings.Contains(flags, " ") {
+			s = " " + s
+		}
+	}
+	if idx := strings.IndexByte(rest, '.'); idx >= 0 {
+		rest = rest[:idx]
+	}
+	width, _ := strconv.Atoi(rest)
+	if strings.Contains(flags, "-") {
+		return fmt.Sprintf("%-*s", width, s)
+	}
+	return fmt.Sprintf("%*s", width, s)
+}
+
+// %q，把值格式化成可以被Lua安全读回的字面量
+// lua-5.3.4/src/lstrlib.c#addliteral()
+func addLiteral(L LuaState, b *strings.Builder, arg int) {
+	switch L.Type(arg) {
+	case LUA_TSTRING:
+		addQuoted(b, L.ToString(arg))
+	case LUA_TNUMBER:
+		if !L.IsInteger(arg) { /* float? */
+			n := L.ToNumber(arg)
+			switch {
+			case math.IsInf(n, 1):
+				b.WriteString("1e9999")
+			case math.IsInf(n, -1):
+				b.WriteString("-1e9999")
+			case math.IsNaN(n):
+				b.WriteString("(0/0)")
+			default:
+				/* for the fixed representations */
+				b.WriteString(formatHexFloat("%", 'a', n))
+			}
+		} else { /* integers */
+			n := L.ToInteger(arg)
+			if n == math.MinInt64 { /* corner case? */
+				b.WriteString("0x8000000000000000")
+			} else {
+				b.WriteString(strconv.FormatInt(n, 10))
+			}
+		}
+	case LUA_TNIL, LUA_TBOOLEAN:
+		b.WriteString(ToStringMeta(L, arg))
+		L.Pop(1)
+	default:
+		ArgError(L, arg, "value has no literal form")
+	}
+}
+
+// lua-5.3.4/src/lstrlib.c#addquoted()
+func addQuoted(b *strings.Builder, s string) {
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '"' || c == '\\' || c == '\n' {
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		} else if c < 32 || c == 127 { /* control characters */
+			if i+1 < len(s) && isDigit(s[i+1]) {
+				b.WriteString(fmt.Sprintf("\\%03d", c))
+			} else {
+				b.WriteString(fmt.Sprintf("\\%d", c))
+			}
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+}
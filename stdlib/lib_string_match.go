@@ -0,0 +1,421 @@
+package stdlib
+
+import (
+	. "luago/api"
+	. "luago/auxlib"
+)
+
+/*
+ * Lua模式匹配，照搬lstrlib.c里的回溯实现。
+ * 所有位置都是src或pat里的字节下标，-1相当于C实现里的NULL，表示匹配失败
+ */
+
+const (
+	_LUA_MAXCAPTURES = 32
+	_MAXCCALLS       = 200 /* maximum recursion depth for 'match' */
+	_CAP_UNFINISHED  = -1
+	_CAP_POSITION    = -2
+	_L_ESC           = '%'
+	_SPECIALS        = "^$*+?.([%-"
+)
+
+type matchState struct {
+	L          LuaState
+	src        string /* subject */
+	pat        string /* pattern */
+	matchDepth int    /* control for recursive depth (to avoid Go stack overflow) */
+	level      int    /* total number of captures (finished or unfinished) */
+	steps      int64  /* number of steps taken, for the execution budget */
+	capture    [_LUA_MAXCAPTURES]struct {
+		init int
+		len  int
+	}
+}
+
+func newMatchState(L LuaState, src, pat string) *matchState {
+	return &matchState{L: L, src: src, pat: pat}
+}
+
+// lua-5.3.4/src/lstrlib.c#reprepstate()
+func (ms *matchState) reprepState() {
+	ms.level = 0
+	ms.matchDepth = _MAXCCALLS
+}
+
+// 回溯匹配的步数可能是字符串长度的多项式，每走一步调用一次
+func (ms *matchState) step() {
+	ms.steps++
+	checkBudget(ms.L, ms.steps)
+}
+
+func (ms *matchState) error(format string, a ...interface{}) {
+	Error(ms.L, format, a...)
+}
+
+// lua-5.3.4/src/lstrlib.c#check_capture()
+func (ms *matchState) checkCapture(l byte) int {
+	i := int(l) - '1'
+	if i < 0 || i >= ms.level || ms.capture[i].len == _CAP_UNFINISHED {
+		ms.error("invalid capture index %%%d", i+1)
+	}
+	return i
+}
+
+// lua-5.3.4/src/lstrlib.c#capture_to_close()
+func (ms *matchState) captureToClose() int {
+	level := ms.level - 1
+	for ; level >= 0; level-- {
+		if ms.capture[level].len == _CAP_UNFINISHED {
+			return level
+		}
+	}
+	ms.error("invalid pattern capture")
+	return 0
+}
+
+// 返回单个字符类（如%a、[a-z]、.）之后的位置
+// lua-5.3.4/src/lstrlib.c#classEnd()
+func (ms *matchState) classEnd(p int) int {
+	c := ms.pat[p]
+	p++
+	switch c {
+	case _L_ESC:
+		if p >= len(ms.pat) {
+			ms.error("malformed pattern (ends with '%%')")
+		}
+		return p + 1
+	case '[':
+		if p < len(ms.pat) && ms.pat[p] == '^' {
+			p++
+		}
+		for { /* look for a ']' */
+			if p >= len(ms.pat) {
+				ms.error("malformed pattern (missing ']')")
+			}
+			c := ms.pat[p]
+			p++
+			if c == _L_ESC && p < len(ms.pat) {
+				p++ /* skip escapes (e.g. '%]') */
+			}
+			if p < len(ms.pat) && ms.pat[p] == ']' {
+				return p + 1
+			}
+		}
+	default:
+		return p
+	}
+}
+
+// 按C locale的字符分类判断c是否属于%cl表示的字符类
+// lua-5.3.4/src/lstrlib.c#match_class()
+func matchClass(c, cl byte) bool {
+	var res bool
+	switch cl | 0x20 { /* tolower */
+	case 'a':
+		res = isAlpha(c)
+	case 'c':
+		res = c < 32 || c == 127
+	case 'd':
+		res = isDigit(c)
+	case 'g':
+		res = c > 32 && c < 127
+	case 'l':
+		res = c >= 'a' && c <= 'z'
+	case 'p':
+		res = c > 32 && c < 127 && !isAlpha(c) && !isDigit(c)
+	case 's':
+		res = c == ' ' || (c >= '\t' && c <= '\r')
+	case 'u':
+		res = c >= 'A' && c <= 'Z'
+	case 'w':
+		res = isAlpha(c) || isDigit(c)
+	case 'x':
+		res = isDigit(c) || (c|0x20 >= 'a' && c|0x20 <= 'f')
+	default:
+		return cl == c
+	}
+	if cl >= 'A' && cl <= 'Z' {
+		return !res
+	}
+	return res
+}
+
+func isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// p指向'['，ec指向对应的']'
+// lua-5.3.4/src/lstrlib.c#matchbracketclass()
+func (ms *matchState) matchBracketClass(c byte, p, ec int) bool {
+	sig := true
+	if ms.pat[p+1] == '^' {
+		sig = false
+		p++ /* skip the '^' */
+	}
+	for p++; p < ec; p++ {
+		if ms.pat[p] == _L_ESC {
+			p++
+			if matchClass(c, ms.pat[p]) {
+				return sig
+			}
+		} else if ms.pat[p+1] == '-' && p+2 < ec {
+			p += 2
+			if ms.pat[p-2] <= c && c <= ms.pat[p] {
+				return sig
+			}
+		} else if ms.pat[p] == c {
+			return sig
+		}
+	}
+	return !sig
+}
+
+// lua-5.3.4/src/lstrlib.c#singlematch()
+func (ms *matchState) singleMatch(s, p, ep int) bool {
+	if s >= len(ms.src) {
+		return false
+	}
+	c := ms.src[s]
+	switch ms.pat[p] {
+	case '.':
+		return true /* matches any char */
+	case _L_ESC:
+		return matchClass(c, ms.pat[p+1])
+	case '[':
+		return ms.matchBracketClass(c, p, ep-1)
+	default:
+		return ms.pat[p] == c
+	}
+}
+
+// %bxy
+// lua-5.3.4/src/lstrlib.c#matchbalance()
+func (ms *matchState) matchBalance(s, p int) int {
+	if p+1 >= len(ms.pat) {
+		ms.error("malformed pattern (missing arguments to '%%b')")
+	}
+	if s >= len(ms.src) || ms.src[s] != ms.pat[p] {
+		return -1
+	}
+	b, e := ms.pat[p], ms.pat[p+1]
+	cont := 1
+	for s++; s < len(ms.src); s++ {
+		ms.step()
+		if ms.src[s] == e {
+			if cont--; cont == 0 {
+				return s + 1
+			}
+		} else if ms.src[s] == b {
+			cont++
+		}
+	}
+	return -1 /* string ends out of balance */
+}
+
+// lua-5.3.4/src/lstrlib.c#max_expand()
+func (ms *matchState) maxExpand(s, p, ep int) int {
+	i := 0 /* counts maximum expand for item */
+	for ms.singleMatch(s+i, p, ep) {
+		ms.step()
+		i++
+	}
+	/* keeps trying to match with the maximum repetitions */
+	for ; i >= 0; i-- {
+		if res := ms.doMatch(s+i, ep+1); res != -1 {
+			return res
+		}
+	}
+	return -1
+}
+
+// lua-5.3.4/src/lstrlib.c#min_expand()
+func (ms *matchState) minExpand(s, p, ep int) int {
+	for {
+		if res := ms.doMatch(s, ep+1); res != -1 {
+			return res
+		} else if ms.singleMatch(s, p, ep) {
+			s++ /* try with one more repetition */
+		} else {
+			return -1
+		}
+	}
+}
+
+// lua-5.3.4/src/lstrlib.c#start_capture()
+func (ms *matchState) startCapture(s, p, what int) int {
+	if ms.level >= _LUA_MAXCAPTURES {
+		ms.error("too many captures")
+	}
+	ms.capture[ms.level].init = s
+	ms.capture[ms.level].len = what
+	ms.level++
+	res := ms.doMatch(s, p)
+	if res == -1 { /* match failed? */
+		ms.level-- /* undo capture */
+	}
+	return res
+}
+
+// lua-5.3.4/src/lstrlib.c#end_capture()
+func (ms *matchState) endCapture(s, p int) int {
+	l := ms.captureToClose()
+	ms.capture[l].len = s - ms.capture[l].init /* close capture */
+	res := ms.doMatch(s, p)
+	if res == -1 { /* match failed? */
+		ms.capture[l].len = _CAP_UNFINISHED /* undo capture */
+	}
+	return res
+}
+
+// %1~%9
+// lua-5.3.4/src/lstrlib.c#match_capture()
+func (ms *matchState) matchCapture(s int, l byte) int {
+	i := ms.checkCapture(l)
+	init, n := ms.capture[i].init, ms.capture[i].len
+	if len(ms.src)-s >= n && ms.src[init:init+n] == ms.src[s:s+n] {
+		return s + n
+	}
+	return -1
+}
+
+// 从src的s处开始匹配pat的p处，成功则返回匹配结束的位置，否则返回-1
+// lua-5.3.4/src/lstrlib.c#do_match()
+func (ms *matchState) doMatch(s, p int) int {
+	if ms.matchDepth == 0 {
+		ms.error("pattern too complex")
+	}
+	ms.matchDepth--
+	defer func() { ms.matchDepth++ }()
+
+	for p < len(ms.pat) { /* end of pattern? */
+		ms.step()
+		switch ms.pat[p] {
+		case '(': /* start capture */
+			if p+1 < len(ms.pat) && ms.pat[p+1] == ')' { /* position capture? */
+				return ms.startCapture(s, p+2, _CAP_POSITION)
+			}
+			return ms.startCapture(s, p+1, _CAP_UNFINISHED)
+		case ')': /* end capture */
+			return ms.endCapture(s, p+1)
+		case '$':
+			if p+1 == len(ms.pat) { /* is the '$' the last char in pattern? */
+				if s != len(ms.src) { /* check end of string */
+					return -1
+				}
+				return s
+			} /* else go to default */
+		case _L_ESC: /* escaped sequences not in the format class[*+?-]? */
+			if p+1 >= len(ms.pat) {
+				break /* malformed; let classEnd() raise the error */
+			}
+			switch ms.pat[p+1] {
+			case 'b': /* balanced string? */
+				if s = ms.matchBalance(s, p+2); s == -1 {
+					return -1
+				}
+				p += 4 /* else return match(ms, s, p + 4); */
+				continue
+			case 'f': /* frontier? */
+				p += 2
+				if p >= len(ms.pat) || ms.pat[p] != '[' {
+					ms.error("missing '[' after '%%f' in pattern")
+				}
+				ep := ms.classEnd(p) /* points to what is next */
+				var prev, cur byte   /* '\0' at the boundaries */
+				if s > 0 {
+					prev = ms.src[s-1]
+				}
+				if s < len(ms.src) {
+					cur = ms.src[s]
+				}
+				if !ms.matchBracketClass(prev, p, ep-1) &&
+					ms.matchBracketClass(cur, p, ep-1) {
+					p = ep /* return match(ms, s, ep); */
+					continue
+				}
+				return -1 /* match failed */
+			case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9': /* capture results (%0-%9)? */
+				if s = ms.matchCapture(s, ms.pat[p+1]); s == -1 {
+					return -1
+				}
+				p += 2 /* return match(ms, s, p + 2) */
+				continue
+			}
+		}
+
+		/* default: pattern class plus optional suffix */
+		ep := ms.classEnd(p) /* points to optional suffix */
+		var epc byte
+		if ep < len(ms.pat) {
+			epc = ms.pat[ep]
+		}
+		if !ms.singleMatch(s, p, ep) { /* does not match at least once? */
+			if epc == '*' || epc == '?' || epc == '-' { /* accept empty? */
+				p = ep + 1 /* return match(ms, s, ep + 1); */
+				continue
+			}
+			return -1 /* '+' or no suffix */
+		}
+		/* matched once */
+		switch epc {
+		case '?': /* optional */
+			if res := ms.doMatch(s+1, ep+1); res != -1 {
+				return res
+			}
+			p = ep + 1 /* else return match(ms, s, ep + 1); */
+		case '+': /* 1 or more repetitions */
+			return ms.maxExpand(s+1, p, ep) /* 1 match already done */
+		case '*': /* 0 or more repetitions */
+			return ms.maxExpand(s, p, ep)
+		case '-': /* 0 or more repetitions (minimum) */
+			return ms.minExpand(s, p, ep)
+		default: /* no suffix */
+			s++
+			p = ep /* return match(ms, s + 1, ep); */
+		}
+	}
+	return s
+}
+
+// 把第i个捕获压栈，没有捕获时整个匹配就是第0个捕获
+// lua-5.3.4/src/lstrlib.c#push_onecapture()
+func (ms *matchState) pushOneCapture(i, s, e int) {
+	if i >= ms.level {
+		if i == 0 { /* ms->level == 0, too */
+			ms.L.PushString(ms.src[s:e]) /* add whole match */
+		} else {
+			ms.error("invalid capture index %%%d", i+1)
+		}
+	} else {
+		l := ms.capture[i].len
+		if l == _CAP_UNFINISHED {
+			ms.error("unfinished capture")
+		}
+		init := ms.capture[i].init
+		if l == _CAP_POSITION {
+			ms.L.PushInteger(int64(init + 1))
+		} else {
+			ms.L.PushString(ms.src[init : init+l])
+		}
+	}
+}
+
+// s为-1时只压入捕获（string.find），否则没有捕获时压入整个匹配
+// lua-5.3.4/src/lstrlib.c#push_captures()
+func (ms *matchState) pushCaptures(s, e int) int {
+	nLevels := ms.level
+	if nLevels == 0 && s != -1 {
+		nLevels = 1 /* return whole match */
+	}
+	if !ms.L.CheckStack(nLevels) {
+		ms.error("too many captures")
+	}
+	for i := 0; i < nLevels; i++ {
+		ms.pushOneCapture(i, s, e)
+	}
+	return nLevels /* number of strings pushed */
+}
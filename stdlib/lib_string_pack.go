@@ -0,0 +1,429 @@
+package stdlib
+
+import (
+	"encoding/binary"
+	. "luago/api"
+	. "luago/auxlib"
+	"math"
+	"strings"
+)
+
+/*
+ * string.pack/string.unpack/string.packsize，照搬lstrlib.c的实现。
+ * 本机参数和64位平台上的官方实现一致：int为4字节，long、size_t、lua_Integer和lua_Number都是8字节，
+ * 最大对齐为8，本机字节序为小端
+ */
+
+const (
+	_MAXINTSIZE     = 16        /* maximum size for the binary representation of an integer */
+	_SZINT          = 8         /* size of a lua_Integer */
+	_MAXALIGN       = 8         /* maximum alignment */
+	_MAXSIZE        = 1<<31 - 1 /* maximum size of a packed item or of the whole result */
+	_PACKPADBYTE    = 0x00      /* value used for padding */
+	_NATIVE_LITTLE  = true      /* native endianness */
+	_SIZEOF_INT     = 4
+	_SIZEOF_LONG    = 8
+	_SIZEOF_SIZET   = 8
+	_SIZEOF_FLOAT   = 4
+	_SIZEOF_DOUBLE  = 8
+	_SIZEOF_NUMBER  = 8
+	_SIZEOF_INTEGER = _SZINT
+)
+
+/* options for pack/unpack */
+type kOption int
+
+const (
+	kInt       kOption = iota /* signed integers */
+	kUint                     /* unsigned integers */
+	kFloat                    /* floating-point numbers */
+	kNumber                   /* Lua "native" floating-point numbers */
+	kDouble                   /* double-precision floating-point numbers */
+	kChar                     /* fixed-length strings */
+	kString                   /* strings with prefixed length */
+	kZstr                     /* zero-terminated strings */
+	kPadding                  /* padding */
+	kPaddAlign                /* padding for alignment */
+	kNop                      /* no-op (configuration or spaces) */
+)
+
+/* information to pack/unpack stuff */
+type packHeader struct {
+	L        LuaState
+	fmt      string
+	pos      int /* current position in fmt */
+	isLittle bool
+	maxAlign int
+}
+
+func newPackHeader(L LuaState, fmt string) *packHeader {
+	return &packHeader{L: L, fmt: fmt,
+		isLittle: _NATIVE_LITTLE, maxAlign: 1}
+}
+
+func (h *packHeader) hasMore() bool {
+	return h.pos < len(h.fmt)
+}
+
+func (h *packHeader) byteOrder() binary.ByteOrder {
+	if h.isLittle {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+func (h *packHeader) error(format string, a ...interface{}) {
+	Error(h.L, format, a...)
+}
+
+/* read an integer numeral from string 'fmt' or return 'df' if there is no numeral */
+// lua-5.3.4/src/lstrlib.c#getnum()
+func (h *packHeader) getNum(df int) int {
+	if !h.hasMore() || !isDigit(h.fmt[h.pos]) { /* no number? */
+		return df /* return default value */
+	}
+	a := 0
+	for {
+		a = a*10 + int(h.fmt[h.pos]-'0')
+		h.pos++
+		if !h.hasMore() || !isDigit(h.fmt[h.pos]) || a > (_MAXSIZE-9)/10 {
+			return a
+		}
+	}
+}
+
+/*
+ * Read an integer numeral and raises an error if it is larger
+ * than the maximum size for integers.
+ */
+// lua-5.3.4/src/lstrlib.c#getnumlimit()
+func (h *packHeader) getNumLimit(df int) int {
+	sz := h.getNum(df)
+	if sz > _MAXINTSIZE || sz <= 0 {
+		h.error("integral size (%d) out of limits [1,%d]", sz, _MAXINTSIZE)
+	}
+	return sz
+}
+
+/* read option and its size */
+// lua-5.3.4/src/lstrlib.c#getoption()
+func (h *packHeader) getOption() (kOption, int) {
+	opt := h.fmt[h.pos]
+	h.pos++
+	switch opt {
+	case 'b':
+		return kInt, 1
+	case 'B':
+		return kUint, 1
+	case 'h':
+		return kInt, 2
+	case 'H':
+		return kUint, 2
+	case 'l':
+		return kInt, _SIZEOF_LONG
+	case 'L':
+		return kUint, _SIZEOF_LONG
+	case 'j':
+		return kInt, _SIZEOF_INTEGER
+	case 'J':
+		return kUint, _SIZEOF_INTEGER
+	case 'T':
+		return kUint, _SIZEOF_SIZET
+	case 'f':
+		return kFloat, _SIZEOF_FLOAT
+	case 'd':
+		return kDouble, _SIZEOF_DOUBLE
+	case 'n':
+		return kNumber, _SIZEOF_NUMBER
+	case 'i':
+		return kInt, h.getNumLimit(_SIZEOF_INT)
+	case 'I':
+		return kUint, h.getNumLimit(_SIZEOF_INT)
+	case 's':
+		return kString, h.getNumLimit(_SIZEOF_SIZET)
+	case 'c':
+		size := h.getNum(-1)
+		if size == -1 {
+			h.error("missing size for format option 'c'")
+		}
+		return kChar, size
+	case 'z':
+		return kZstr, 0
+	case 'x':
+		return kPadding, 1
+	case 'X':
+		return kPaddAlign, 0
+	case ' ':
+	case '<':
+		h.isLittle = true
+	case '>':
+		h.isLittle = false
+	case '=':
+		h.isLittle = _NATIVE_LITTLE
+	case '!':
+		h.maxAlign = h.getNumLimit(_MAXALIGN)
+	default:
+		h.error("invalid format option '%c'", opt)
+	}
+	return kNop, 0
+}
+
+/*
+ * Read, classify, and fill other details about the next option.
+ * 'psize' is filled with option's size, 'notoalign' with its
+ * alignment requirements.
+ * Local variable 'size' gets the size to be aligned. (Kpadal option
+ * always gets its full alignment, other options are limited by
+ * the maximum alignment ('maxalign'). Kchar option needs no alignment
+ * despite its size.
+ */
+// lua-5.3.4/src/lstrlib.c#getdetails()
+func (h *packHeader) getDetails(totalSize int) (opt kOption, size, nToAlign int) {
+	opt, size = h.getOption()
+	align := size          /* usually, alignment follows size */
+	if opt == kPaddAlign { /* 'X' gets alignment from following option */
+		if !h.hasMore() {
+			ArgError(h.L, 1, "invalid next option for option 'X'")
+		}
+		var nextOpt kOption
+		if nextOpt, align = h.getOption(); nextOpt == kChar || align == 0 {
+			ArgError(h.L, 1, "invalid next option for option 'X'")
+		}
+	}
+	if align <= 1 || opt == kChar { /* need no alignment? */
+		nToAlign = 0
+	} else {
+		if align > h.maxAlign { /* enforce maximum alignment */
+			align = h.maxAlign
+		}
+		if align&(align-1) != 0 { /* is 'align' not a power of 2? */
+			ArgError(h.L, 1, "format asks for alignment not power of 2")
+		}
+		nToAlign = (align - totalSize&(align-1)) & (align - 1)
+	}
+	return
+}
+
+/*
+ * Pack integer 'n' with 'size' bytes and 'islittle' endianness.
+ * The final 'if' handles the case when 'size' is larger than
+ * the size of a Lua integer, correcting the extra sign-extension
+ * bytes if necessary (by default they would be zeros).
+ */
+// lua-5.3.4/src/lstrlib.c#packint()
+func packInt(b *strings.Builder, n uint64, isLittle bool, size int, neg bool) {
+	buff := make([]byte, size)
+	for i := 0; i < size; i++ {
+		var c byte
+		if i < _SZINT {
+			c = byte(n >> uint(8*i))
+		} else if neg { /* negative number need sign extension */
+			c = 0xFF
+		}
+		if isLittle {
+			buff[i] = c
+		} else {
+			buff[size-1-i] = c
+		}
+	}
+	b.Write(buff)
+}
+
+// lua-5.3.4/src/lstrlib.c#unpackint()
+func (h *packHeader) unpackInt(str string, size int, isSigned bool) int64 {
+	var res uint64
+	limit := size
+	if limit > _SZINT {
+		limit = _SZINT
+	}
+	for i := limit - 1; i >= 0; i-- {
+		res <<= 8
+		if h.isLittle {
+			res |= uint64(str[i])
+		} else {
+			res |= uint64(str[size-1-i])
+		}
+	}
+	if size < _SZINT { /* real size smaller than lua_Integer? */
+		if isSigned { /* needs sign extension? */
+			mask := uint64(1) << uint(size*8-1)
+			res = (res ^ mask) - mask /* do sign extension */
+		}
+	} else if size > _SZINT { /* must check unread bytes */
+		var mask byte
+		if isSigned && int64(res) < 0 {
+			mask = 0xFF
+		}
+		for i := limit; i < size; i++ {
+			var c byte
+			if h.isLittle {
+				c = str[i]
+			} else {
+				c = str[size-1-i]
+			}
+			if c != mask {
+				h.error("%d-byte integer does not fit into Lua Integer", size)
+			}
+		}
+	}
+	return int64(res)
+}
+
+// string.pack (fmt, v1, v2, ···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.pack
+// lua-5.3.4/src/lstrlib.c#str_pack()
+func strPack(L LuaState) int {
+	h := newPackHeader(L, CheckString(L, 1))
+	var b strings.Builder
+	arg := 1       /* current argument to pack */
+	totalSize := 0 /* accumulate total size of result */
+	for h.hasMore() {
+		opt, size, nToAlign := h.getDetails(totalSize)
+		totalSize += nToAlign + size
+		for ; nToAlign > 0; nToAlign-- {
+			b.WriteByte(_PACKPADBYTE) /* fill alignment */
+		}
+		arg++
+		switch opt {
+		case kInt: /* signed integers */
+			n := CheckInteger(L, arg)
+			if size < _SZINT { /* need overflow check? */
+				lim := int64(1) << uint(size*8-1)
+				if n < -lim || n >= lim {
+					ArgError(L, arg, "integer overflow")
+				}
+			}
+			packInt(&b, uint64(n), h.isLittle, size, n < 0)
+		case kUint: /* unsigned integers */
+			n := CheckInteger(L, arg)
+			if size < _SZINT { /* need overflow check? */
+				if uint64(n) >= uint64(1)<<uint(size*8) {
+					ArgError(L, arg, "unsigned overflow")
+				}
+			}
+			packInt(&b, uint64(n), h.isLittle, size, false)
+		case kFloat: /* C float */
+			buff := make([]byte, 4)
+			f := float32(CheckNumber(L, arg))
+			h.byteOrder().PutUint32(buff, math.Float32bits(f))
+			b.Write(buff)
+		case kNumber, kDouble: /* Lua float, C double */
+			buff := make([]byte, 8)
+			f := CheckNumber(L, arg)
+			h.byteOrder().PutUint64(buff, math.Float64bits(f))
+			b.Write(buff)
+		case kChar: /* fixed-size string */
+			s := CheckString(L, arg)
+			if len(s) > size {
+				ArgError(L, arg, "string longer than given size")
+			}
+			b.WriteString(s)                 /* add string */
+			for i := len(s); i < size; i++ { /* pad extra space */
+				b.WriteByte(_PACKPADBYTE)
+			}
+		case kString: /* strings with length count */
+			s := CheckString(L, arg)
+			if size < _SZINT && uint64(len(s)) >= uint64(1)<<uint(size*8) {
+				ArgError(L, arg, "string length does not fit in given size")
+			}
+			packInt(&b, uint64(len(s)), h.isLittle, size, false) /* pack length */
+			b.WriteString(s)
+			totalSize += len(s)
+		case kZstr: /* zero-terminated string */
+			s := CheckString(L, arg)
+			if strings.IndexByte(s, 0) >= 0 {
+				ArgError(L, arg, "string contains zeros")
+			}
+			b.WriteString(s)
+			b.WriteByte(0) /* add zero at the end */
+			totalSize += len(s) + 1
+		case kPadding:
+			b.WriteByte(_PACKPADBYTE)
+			arg--
+		case kPaddAlign, kNop:
+			arg-- /* undo increment */
+		}
+	}
+	L.PushString(b.String())
+	return 1
+}
+
+// string.packsize (fmt)
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.packsize
+// lua-5.3.4/src/lstrlib.c#str_packsize()
+func strPackSize(L LuaState) int {
+	h := newPackHeader(L, CheckString(L, 1))
+	totalSize := 0 /* accumulate total size of result */
+	for h.hasMore() {
+		opt, size, nToAlign := h.getDetails(totalSize)
+		size += nToAlign /* total space used by option */
+		if totalSize > _MAXSIZE-size {
+			ArgError(L, 1, "format result too large")
+		}
+		totalSize += size
+		if opt == kString || opt == kZstr {
+			ArgError(L, 1, "variable-length format")
+		}
+	}
+	L.PushInteger(int64(totalSize))
+	return 1
+}
+
+// string.unpack (fmt, s [, pos])
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.unpack
+// lua-5.3.4/src/lstrlib.c#str_unpack()
+func strUnpack(L LuaState) int {
+	h := newPackHeader(L, CheckString(L, 1))
+	data := CheckString(L, 2)
+	ld := len(data)
+	pos := posRelat(OptInteger(L, 3, 1), ld) - 1
+	if pos < 0 || pos > int64(ld) {
+		ArgError(L, 3, "initial position out of string")
+	}
+	n := 0 /* number of results */
+	for h.hasMore() {
+		opt, size, nToAlign := h.getDetails(int(pos))
+		if int64(nToAlign+size) > int64(ld)-pos {
+			ArgError(L, 2, "data string too short")
+		}
+		pos += int64(nToAlign) /* skip alignment */
+		/* stack space for item + next position */
+		if !L.CheckStack(2) {
+			h.error("too many results")
+		}
+		n++
+		switch opt {
+		case kInt, kUint:
+			res := h.unpackInt(data[pos:], size, opt == kInt)
+			L.PushInteger(res)
+		case kFloat:
+			bits := h.byteOrder().Uint32([]byte(data[pos : pos+4]))
+			L.PushNumber(float64(math.Float32frombits(bits)))
+		case kNumber, kDouble:
+			bits := h.byteOrder().Uint64([]byte(data[pos : pos+8]))
+			L.PushNumber(math.Float64frombits(bits))
+		case kChar:
+			L.PushString(data[pos : pos+int64(size)])
+		case kString:
+			l := uint64(h.unpackInt(data[pos:], size, false))
+			if l > uint64(int64(ld)-pos-int64(size)) {
+				ArgError(L, 2, "data string too short")
+			}
+			start := pos + int64(size)
+			L.PushString(data[start : start+int64(l)])
+			pos += int64(l) /* skip string */
+		case kZstr:
+			l := strings.IndexByte(data[pos:], 0)
+			if l < 0 {
+				ArgError(L, 2, "unfinished string for format 'z'")
+			}
+			L.PushString(data[pos : pos+int64(l)])
+			pos += int64(l) + 1 /* skip string plus final '\0' */
+		case kPaddAlign, kPadding, kNop:
+			n-- /* undo increment */
+		}
+		pos += int64(size)
+	}
+	L.PushInteger(pos + 1) /* next position */
+	return n + 1
+}
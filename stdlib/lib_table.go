@@ -0,0 +1,419 @@
+package stdlib
+
+import (
+	. "luago/api"
+	. "luago/auxlib"
+	"math"
+	"strings"
+	"time"
+)
+
+/*
+ * Operations that an object must define to mimic a table
+ * (some functions only need some of them)
+ */
+const (
+	_TAB_R  = 1               /* read */
+	_TAB_W  = 2               /* write */
+	_TAB_L  = 4               /* length */
+	_TAB_RW = _TAB_R | _TAB_W /* read/write */
+)
+
+var tabFuncs = map[string]GoFunction{
+	"concat": tabConcat,
+	"insert": tabInsert,
+	"pack":   tabPack,
+	"unpack": tabUnpack,
+	"remove": tabRemove,
+	"move":   tabMove,
+	"sort":   tabSort,
+}
+
+// lua-5.3.4/src/ltablib.c#luaopen_table()
+func OpenTable(L LuaState) int {
+	NewLib(L, tabFuncs)
+	return 1
+}
+
+/*
+ * Check that 'arg' either is a table or can behave like one (that is,
+ * has a metatable with the required metamethods)
+ */
+// lua-5.3.4/src/ltablib.c#checktab()
+func checkTab(L LuaState, arg int, what int) {
+	if L.Type(arg) != LUA_TTABLE { /* is it not a table? */
+		n := 1                    /* number of elements to pop */
+		if L.GetMetatable(arg) && /* must have metatable */
+			(what&_TAB_R == 0 || checkField(L, "__index", &n)) &&
+			(what&_TAB_W == 0 || checkField(L, "__newindex", &n)) &&
+			(what&_TAB_L == 0 || checkField(L, "__len", &n)) {
+			L.Pop(n) /* pop metatable and tested metamethods */
+		} else {
+			CheckType(L, arg, LUA_TTABLE) /* force an error */
+		}
+	}
+}
+
+// lua-5.3.4/src/ltablib.c#checkfield()
+func checkField(L LuaState, key string, n *int) bool {
+	L.PushString(key)
+	*n++
+	return L.RawGet(-*n) != LUA_TNIL
+}
+
+// lua-5.3.4/src/ltablib.c#aux_getn()
+func auxGetN(L LuaState, n int, w int) int64 {
+	checkTab(L, n, w|_TAB_L)
+	return Len(L, n)
+}
+
+// table.insert (list, [pos,] value)
+// http://www.lua.org/manual/5.3/manual.html#pdf-table.insert
+// lua-5.3.4/src/ltablib.c#tinsert()
+func tabInsert(L LuaState) int {
+	e := auxGetN(L, 1, _TAB_RW) + 1 /* first empty element */
+	var pos int64                   /* where to insert new element */
+	switch L.GetTop() {
+	case 2: /* called with only 2 arguments */
+		pos = e /* insert new element at the end */
+	case 3:
+		pos = CheckInteger(L, 2) /* 2nd argument is the position */
+		/* check whether 'pos' is in [1, e] */
+		if uint64(pos)-1 >= uint64(e) {
+			ArgError(L, 2, "position out of bounds")
+		}
+		for i := e; i > pos; i-- { /* move up elements */
+			checkBudget(L, i)
+			L.GetI(1, i-1)
+			L.SetI(1, i) /* t[i] = t[i - 1] */
+		}
+	default:
+		return Error(L, "wrong number of arguments to 'insert'")
+	}
+	L.SetI(1, pos) /* t[pos] = v */
+	return 0
+}
+
+// table.remove (list [, pos])
+// http://www.lua.org/manual/5.3/manual.html#pdf-table.remove
+// lua-5.3.4/src/ltablib.c#tremove()
+func tabRemove(L LuaState) int {
+	size := auxGetN(L, 1, _TAB_RW)
+	pos := OptInteger(L, 2, size)
+	if pos != size { /* validate 'pos' if given */
+		/* check whether 'pos' is in [1, size + 1] */
+		if uint64(pos)-1 > uint64(size) {
+			ArgError(L, 1, "position out of bounds")
+		}
+	}
+	L.GetI(1, pos) /* result = t[pos] */
+	for ; pos < size; pos++ {
+		checkBudget(L, pos)
+		L.GetI(1, pos+1)
+		L.SetI(1, pos) /* t[pos] = t[pos + 1] */
+	}
+	L.PushNil()
+	L.SetI(1, pos) /* t[pos] = nil */
+	return 1
+}
+
+// table.move (a1, f, e, t [,a2])
+// http://www.lua.org/manual/5.3/manual.html#pdf-table.move
+// lua-5.3.4/src/ltablib.c#tmove()
+/*
+ * Copy elements (1[f], ..., 1[e]) into (tt[t], tt[t+1], ...). Whenever
+ * possible, copy in increasing order, which is better for rehashing.
+ * "possible" means destination after original range, or smaller
+ * than origin, or copying to another table.
+ */
+func tabMove(L LuaState) int {
+	f := CheckInteger(L, 2)
+	e := CheckInteger(L, 3)
+	t := CheckInteger(L, 4)
+	tt := 1 /* destination table */
+	if !L.IsNoneOrNil(5) {
+		tt = 5
+	}
+	checkTab(L, 1, _TAB_R)
+	checkTab(L, tt, _TAB_W)
+	if e >= f { /* otherwise, nothing to move */
+		if !(f > 0 || e < math.MaxInt64+f) {
+			ArgError(L, 3, "too many elements to move")
+		}
+		n := e - f /* number of elements minus 1 (avoid overflows) */
+		if t > math.MaxInt64-n {
+			ArgError(L, 4, "destination wrap around")
+		}
+		if t > e || t <= f || (tt != 1 && !L.Compare(1, tt, LUA_OPEQ)) {
+			for i := int64(0); i <= n; i++ {
+				checkBudget(L, i)
+				L.GetI(1, f+i)
+				L.SetI(tt, t+i)
+			}
+		} else {
+			for i := n; i >= 0; i-- {
+				checkBudget(L, i)
+				L.GetI(1, f+i)
+				L.SetI(tt, t+i)
+			}
+		}
+	}
+	L.PushValue(tt) /* return destination table */
+	return 1
+}
+
+// table.concat (list [, sep [, i [, j]]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-table.concat
+// lua-5.3.4/src/ltablib.c#tconcat()
+func tabConcat(L LuaState) int {
+	last := auxGetN(L, 1, _TAB_R)
+	sep := OptString(L, 2, "")
+	i := OptInteger(L, 3, 1)
+	last = OptInteger(L, 4, last)
+
+	var b strings.Builder
+	for ; i < last; i++ {
+		checkBudget(L, i)
+		addField(L, &b, i)
+		b.WriteString(sep)
+	}
+	if i == last { /* add last value (if interval was not empty) */
+		addField(L, &b, i)
+	}
+	L.PushString(b.String())
+	return 1
+}
+
+// lua-5.3.4/src/ltablib.c#addfield()
+func addField(L LuaState, b *strings.Builder, i int64) {
+	L.GetI(1, i)
+	if !L.IsString(-1) {
+		Error(L, "invalid value (at index %d) in table for 'concat'", i)
+	}
+	b.WriteString(L.ToString(-1))
+	L.Pop(1)
+}
+
+// table.pack (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-table.pack
+// lua-5.3.4/src/ltablib.c#pack()
+func tabPack(L LuaState) int {
+	n := L.GetTop()           /* number of elements to pack */
+	L.CreateTable(n, 1)       /* create result table */
+	L.Insert(1)               /* put it at index 1 */
+	for i := n; i >= 1; i-- { /* assign elements */
+		L.SetI(1, int64(i))
+	}
+	L.PushInteger(int64(n))
+	L.SetField(1, "n") /* t.n = number of elements */
+	return 1           /* return table */
+}
+
+// table.unpack (list [, i [, j]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-table.unpack
+// lua-5.3.4/src/ltablib.c#unpack()
+func tabUnpack(L LuaState) int {
+	i := OptInteger(L, 2, 1)
+	var e int64
+	if L.IsNoneOrNil(3) {
+		e = Len(L, 1)
+	} else {
+		e = CheckInteger(L, 3)
+	}
+	if i > e { /* empty range */
+		return 0
+	}
+	n := uint64(e) - uint64(i) /* number of elements minus 1 (avoid overflows) */
+	if n >= math.MaxInt32 || !L.CheckStack(int(n+1)) {
+		return Error(L, "too many results to unpack")
+	}
+	for ; i < e; i++ { /* push arg[i..e - 1] (to avoid overflows) */
+		L.GetI(1, i)
+	}
+	L.GetI(1, e) /* push last element */
+	return int(n + 1)
+}
+
+/*
+ * Quicksort
+ * (based on 'Algorithms in MODULA-3', Robert Sedgewick;
+ *  Addison-Wesley, 1993.)
+ */
+
+/* size of smaller partitions that use a deterministic pivot choice */
+const _RANLIMIT = 100
+
+// table.sort (list [, comp])
+// http://www.lua.org/manual/5.3/manual.html#pdf-table.sort
+// lua-5.3.4/src/ltablib.c#sort()
+func tabSort(L LuaState) int {
+	n := auxGetN(L, 1, _TAB_RW)
+	if n > 1 { /* non-trivial interval? */
+		if n >= math.MaxInt32 {
+			ArgError(L, 1, "array too big")
+		}
+		if !L.IsNoneOrNil(2) { /* is there a 2nd argument? */
+			CheckType(L, 2, LUA_TFUNCTION) /* must be a function */
+		}
+		L.SetTop(2) /* make sure there are two arguments */
+		auxSort(L, 1, uint(n), 0)
+	}
+	return 0
+}
+
+// lua-5.3.4/src/ltablib.c#set2()
+func set2(L LuaState, i, j uint) {
+	L.SetI(1, int64(i))
+	L.SetI(1, int64(j))
+}
+
+/*
+ * Return true iff value at stack index 'a' is less than the value at
+ * index 'b' (according to the order of the sort).
+ */
+// lua-5.3.4/src/ltablib.c#sort_comp()
+func sortComp(L LuaState, a, b int) bool {
+	if L.IsNil(2) { /* no function? */
+		return L.Compare(a, b, LUA_OPLT) /* a < b */
+	} else { /* function */
+		L.PushValue(2)         /* push function */
+		L.PushValue(a - 1)     /* -1 to compensate function */
+		L.PushValue(b - 2)     /* -2 to compensate function and 'a' */
+		L.Call(2, 1)           /* call function */
+		res := L.ToBoolean(-1) /* get result */
+		L.Pop(1)               /* pop result */
+		return res
+	}
+}
+
+/*
+ * Does the partition: Pivot P is at the top of the stack.
+ * precondition: a[lo] <= P == a[up-1] <= a[up],
+ * so it only needs to do the partition from lo + 1 to up - 2.
+ * Pos-condition: a[lo .. i - 1] <= a[i] == P <= a[i + 1 .. up]
+ * returns 'i'.
+ */
+// lua-5.3.4/src/ltablib.c#partition()
+func partition(L LuaState, lo, up uint) uint {
+	i := lo     /* will be incremented before first use */
+	j := up - 1 /* will be decremented before first use */
+	/* loop invariant: a[lo .. i] <= P <= a[j .. up], a[up - 1] == P */
+	for {
+		/* next loop: repeat ++i while a[i] < P */
+		for {
+			i++
+			checkBudget(L, int64(i))
+			L.GetI(1, int64(i))
+			if !sortComp(L, -1, -2) {
+				break
+			}
+			if i == up-1 { /* a[i] < P  but a[up - 1] == P  ?? */
+				sortError(L) /* 'invalid order function' */
+			}
+			L.Pop(1) /* remove a[i] */
+		}
+		/* after the loop, a[i] >= P and a[lo .. i - 1] < P */
+		/* next loop: repeat --j while P < a[j] */
+		for {
+			j--
+			checkBudget(L, int64(j))
+			L.GetI(1, int64(j))
+			if !sortComp(L, -3, -1) {
+				break
+			}
+			if j < i { /* j < i  but  a[j] > P ?? */
+				sortError(L) /* 'invalid order function' */
+			}
+			L.Pop(1) /* remove a[j] */
+		}
+		/* after the loop, a[j] <= P and a[j + 1 .. up] >= P */
+		if j < i { /* no elements to be exchanged? */
+			L.Pop(1) /* pop a[j] */
+			/* swap pivot (a[up - 1]) with a[i] to satisfy pos-condition */
+			set2(L, up-1, i)
+			return i
+		}
+		/* otherwise, swap a[i] - a[j] to restore invariant and repeat */
+		set2(L, i, j)
+	}
+}
+
+func sortError(L LuaState) {
+	Error(L, "invalid order function for sorting")
+}
+
+/*
+ * Choose an element in the middle (2nd-3th quarters) of [lo,up]
+ * "randomized" by 'rnd'
+ */
+// lua-5.3.4/src/ltablib.c#choosePivot()
+func choosePivot(lo, up, rnd uint) uint {
+	r4 := (up - lo) / 4 /* range/4 */
+	return rnd%(r4*2) + (lo + r4)
+}
+
+// lua-5.3.4/src/ltablib.c#l_randomizePivot()
+func randomizePivot() uint {
+	return uint(time.Now().UnixNano())
+}
+
+/*
+ * QuickSort algorithm (recursive function)
+ */
+// lua-5.3.4/src/ltablib.c#auxsort()
+func auxSort(L LuaState, lo, up, rnd uint) {
+	for lo < up { /* loop for tail recursion */
+		/* sort elements 'lo', 'p', and 'up' */
+		L.GetI(1, int64(lo))
+		L.GetI(1, int64(up))
+		if sortComp(L, -1, -2) { /* a[up] < a[lo]? */
+			set2(L, lo, up) /* swap a[lo] - a[up] */
+		} else {
+			L.Pop(2) /* remove both values */
+		}
+		if up-lo == 1 { /* only 2 elements? */
+			break /* already sorted */
+		}
+		var p uint                         /* Pivot index */
+		if up-lo < _RANLIMIT || rnd == 0 { /* small interval or no randomize? */
+			p = (lo + up) / 2 /* middle element is a good pivot */
+		} else { /* for larger intervals, it is expensive to compute */
+			p = choosePivot(lo, up, rnd)
+		}
+		L.GetI(1, int64(p))
+		L.GetI(1, int64(lo))
+		if sortComp(L, -2, -1) { /* a[p] < a[lo]? */
+			set2(L, p, lo) /* swap a[p] - a[lo] */
+		} else {
+			L.Pop(1) /* remove second element */
+			L.GetI(1, int64(up))
+			if sortComp(L, -1, -2) { /* a[up] < a[p]? */
+				set2(L, p, up) /* swap up - p */
+			} else {
+				L.Pop(2) /* clean stack */
+			}
+		}
+		if up-lo == 2 { /* only 3 elements? */
+			break /* already sorted */
+		}
+		L.GetI(1, int64(p))    /* get median (Pivot) */
+		L.PushValue(-1)        /* push Pivot */
+		L.GetI(1, int64(up-1)) /* push a[up - 1] */
+		set2(L, p, up-1)       /* a[p] = a[up - 1]; a[up - 1] = a[p] */
+		p = partition(L, lo, up)
+		var n uint
+		/* a[lo .. p - 1] <= a[p] == P <= a[p + 1 .. up] */
+		if p-lo < up-p { /* lower interval is shorter? */
+			auxSort(L, lo, p-1, rnd) /* call recursively for lower interval */
+			n = p - lo               /* size of smaller interval */
+			lo = p + 1               /* tail call for [p + 1 .. up] (upper interval) */
+		} else {
+			auxSort(L, p+1, up, rnd) /* call recursively for upper interval */
+			n = up - p               /* size of smaller interval */
+			up = p - 1               /* tail call for [lo .. p - 1]  (lower interval) */
+		}
+		if (up-lo)/128 > n { /* partition too imbalanced? */
+			rnd = randomizePivot() /* try a new randomization */
+		}
+	} /* tail call auxsort(L, lo, up, rnd) */
+}
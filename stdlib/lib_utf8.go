@@ -0,0 +1,230 @@
+package stdlib
+
+import (
+	. "luago/api"
+	. "luago/auxlib"
+	"luago/utf8"
+	"math"
+	"strings"
+)
+
+/* pattern to match a single UTF-8 character */
+const _UTF8PATT = "[\x00-\x7F\xC2-\xF4][\x80-\xBF]*"
+
+var utf8Lib = map[string]GoFunction{
+	"offset":    byteOffset,
+	"codepoint": codePoint,
+	"char":      utfChar,
+	"len":       utfLen,
+	"codes":     iterCodes,
+}
+
+// lua-5.3.4/src/lutf8lib.c#luaopen_utf8()
+func OpenUTF8(L LuaState) int {
+	NewLib(L, utf8Lib)
+	L.PushString(_UTF8PATT)
+	L.SetField(-2, "charpattern")
+	return 1
+}
+
+// lua-5.3.4/src/lutf8lib.c#iscont()
+func isCont(s string, i int64) bool {
+	return i < int64(len(s)) && s[i]&0xC0 == 0x80
+}
+
+// utf8.len (s [, i [, j]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-utf8.len
+// lua-5.3.4/src/lutf8lib.c#utflen()
+/*
+ * utf8len(s [, i [, j]]) --> number of characters that start in the
+ * range [i,j], or nil + current position if 's' is not well formed in
+ * that interval
+ */
+func utfLen(L LuaState) int {
+	n := int64(0)
+	s := CheckString(L, 1)
+	posi := posRelat(OptInteger(L, 2, 1), len(s))
+	posj := posRelat(OptInteger(L, 3, -1), len(s))
+	if !(1 <= posi && posi-1 <= int64(len(s))) {
+		ArgError(L, 2, "initial position out of string")
+	}
+	posi--
+	if !(posj-1 < int64(len(s))) {
+		ArgError(L, 3, "final position out of string")
+	}
+	posj--
+	for posi <= posj {
+		_, size := utf8.Decode(s[posi:])
+		if size == 0 { /* conversion error? */
+			L.PushNil()             /* return nil ... */
+			L.PushInteger(posi + 1) /* ... and current position */
+			return 2
+		}
+		posi += int64(size)
+		n++
+	}
+	L.PushInteger(n)
+	return 1
+}
+
+// utf8.codepoint (s [, i [, j]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-utf8.codepoint
+// lua-5.3.4/src/lutf8lib.c#codepoint()
+/*
+ * codepoint(s, [i, [j]])  -> returns codepoints for all characters
+ * that start in the range [i,j]
+ */
+func codePoint(L LuaState) int {
+	s := CheckString(L, 1)
+	posi := posRelat(OptInteger(L, 2, 1), len(s))
+	pose := posRelat(OptInteger(L, 3, posi), len(s))
+	if posi < 1 {
+		ArgError(L, 2, "out of range")
+	}
+	if pose > int64(len(s)) {
+		ArgError(L, 3, "out of range")
+	}
+	if posi > pose {
+		return 0 /* empty interval; return no values */
+	}
+	if pose-posi >= math.MaxInt32 { /* (lua_Integer -> int) overflow? */
+		return Error(L, "string slice too long")
+	}
+	n := int(pose - posi + 1)
+	if !L.CheckStack(n) {
+		return Error(L, "string slice too long")
+	}
+	n = 0
+	for i := posi - 1; i < pose; {
+		code, size := utf8.Decode(s[i:])
+		if size == 0 {
+			return Error(L, "invalid UTF-8 code")
+		}
+		L.PushInteger(int64(code))
+		i += int64(size)
+		n++
+	}
+	return n
+}
+
+// 检查arg处的码点并返回它的UTF-8编码
+// lua-5.3.4/src/lutf8lib.c#pushutfchar()
+func utfCharBytes(L LuaState, arg int) []byte {
+	code := CheckInteger(L, arg)
+	if uint64(code) > utf8.MAXUTF {
+		ArgError(L, arg, "value out of range")
+	}
+	return utf8.Encode(uint32(code))
+}
+
+// utf8.char (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-utf8.char
+// lua-5.3.4/src/lutf8lib.c#utfchar()
+/*
+ * utfchar(n1, n2, ...)  -> char(n1)..char(n2)...
+ */
+func utfChar(L LuaState) int {
+	n := L.GetTop() /* number of arguments */
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		b.Write(utfCharBytes(L, i))
+	}
+	L.PushString(b.String())
+	return 1
+}
+
+// utf8.offset (s, n [, i])
+// http://www.lua.org/manual/5.3/manual.html#pdf-utf8.offset
+// lua-5.3.4/src/lutf8lib.c#byteoffset()
+/*
+ * offset(s, n, [i])  -> index where n-th character counting from
+ *   position 'i' starts; 0 means character at 'i'.
+ */
+func byteOffset(L LuaState) int {
+	s := CheckString(L, 1)
+	sLen := int64(len(s))
+	n := CheckInteger(L, 2)
+	posi := int64(1)
+	if n < 0 {
+		posi = sLen + 1
+	}
+	posi = posRelat(OptInteger(L, 3, posi), len(s))
+	if !(1 <= posi && posi-1 <= sLen) {
+		ArgError(L, 3, "position out of range")
+	}
+	posi--
+	if n == 0 {
+		/* find beginning of current byte sequence */
+		for posi > 0 && isCont(s, posi) {
+			posi--
+		}
+	} else {
+		if isCont(s, posi) {
+			return Error(L, "initial position is a continuation byte")
+		}
+		if n < 0 {
+			for n < 0 && posi > 0 { /* move back */
+				for { /* find beginning of previous character */
+					posi--
+					if !(posi > 0 && isCont(s, posi)) {
+						break
+					}
+				}
+				n++
+			}
+		} else {
+			n-- /* do not move for 1st character */
+			for n > 0 && posi < sLen {
+				for { /* find beginning of next character */
+					posi++
+					if !isCont(s, posi) {
+						break /* (cannot pass final '\0') */
+					}
+				}
+				n--
+			}
+		}
+	}
+	if n == 0 { /* did it find given character? */
+		L.PushInteger(posi + 1)
+	} else { /* no such character */
+		L.PushNil()
+	}
+	return 1
+}
+
+// lua-5.3.4/src/lutf8lib.c#iter_aux()
+func iterAux(L LuaState) int {
+	s := CheckString(L, 1)
+	sLen := int64(len(s))
+	n := L.ToInteger(2) - 1
+	if n < 0 { /* first iteration? */
+		n = 0 /* start from here */
+	} else if n < sLen {
+		n++ /* skip current byte */
+		for isCont(s, n) {
+			n++ /* and its continuations */
+		}
+	}
+	if n >= sLen {
+		return 0 /* no more codepoints */
+	}
+	code, size := utf8.Decode(s[n:])
+	if size == 0 || isCont(s, n+int64(size)) {
+		return Error(L, "invalid UTF-8 code")
+	}
+	L.PushInteger(n + 1)
+	L.PushInteger(int64(code))
+	return 2
+}
+
+// utf8.codes (s)
+// http://www.lua.org/manual/5.3/manual.html#pdf-utf8.codes
+// lua-5.3.4/src/lutf8lib.c#iter_codes()
+func iterCodes(L LuaState) int {
+	CheckString(L, 1)
+	L.PushGoFunction(iterAux)
+	L.PushValue(1)
+	L.PushInteger(0)
+	return 3
+}
@@ -0,0 +1,46 @@
+package stdlib_test
+
+import (
+	. "luago/api"
+	. "luago/auxlib"
+	"luago/state"
+	"luago/stdlib"
+	"testing"
+)
+
+// lua/utf8.lua用assert检查utf8库和词法分析器的\u{XXX}转义，任何一个断言失败都会报错
+func TestUTF8Script(t *testing.T) {
+	L := state.New()
+	stdlib.OpenLibs(L)
+	if status := DoFile(L, "../lua/utf8.lua"); status != LUA_OK {
+		t.Fatalf("utf8.lua: %s", L.ToString(-1))
+	}
+}
+
+func TestUTF8(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{`return utf8.char(72, 0x4E16, 0x10FFFF)`, "H世\U0010FFFF"},
+		{`return utf8.len("a\u{E9}\u{4E16}")`, "3"},
+		{`return utf8.len("a\xFF")`, "nil"},
+		{`return select(2, utf8.len("a\xFFb"))`, "2"},
+		{`return utf8.codepoint("\u{4E16}")`, "19990"},
+		{`return utf8.offset("a\u{E9}b", 3)`, "4"},
+		{`return utf8.offset("a\u{E9}b", -1)`, "4"},
+		{`return pcall(utf8.codepoint, "\xFF")`, "false"},
+		{`return pcall(utf8.char, -1)`, "false"},
+	}
+	for _, tt := range tests {
+		L := state.New()
+		stdlib.OpenLibs(L)
+		if status := DoString(L, tt.code); status != LUA_OK {
+			t.Errorf("%s: %s", tt.code, L.ToString(-1))
+			continue
+		}
+		if got := ToStringMeta(L, 1); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
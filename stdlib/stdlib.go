@@ -0,0 +1,54 @@
+package stdlib
+
+import (
+	. "luago/api"
+	. "luago/auxlib"
+)
+
+/*标准库*/
+
+// 每个库都提供一个Open函数，它创建库表并把库函数放进去，最后把库表留在栈顶并返回1
+
+/*
+ * these libs are loaded by lua.c and are readily available to any Lua
+ * program
+ */
+var loadedLibs = []struct {
+	name string
+	open GoFunction
+}{
+	{"_G", OpenBase},
+	{"package", OpenPackage},
+	{"coroutine", OpenCoroutine},
+	{"table", OpenTable},
+	{"io", OpenIO},
+	{"os", OpenOS},
+	{"string", OpenString},
+	{"math", OpenMath},
+	{"utf8", OpenUTF8},
+	{"debug", OpenDebug},
+}
+
+// 循环次数由参数决定的Go循环（模式匹配、table.move等）每走这么多步检查一次执行预算
+const budgetSteps = 1024
+
+// 在这样的循环的第i步调用，定期扣除执行预算，超时或者超出预算时中止脚本
+func checkBudget(L LuaState, i int64) {
+	if i%budgetSteps == 0 {
+		L.ConsumeBudget(budgetSteps)
+	}
+}
+
+// 打开所有标准库，库表会被放进package.loaded和同名的全局变量里。
+// 还没有设置文件系统时，设置成操作系统的文件系统（参见SetFileSystem）
+// lua-5.3.4/src/linit.c#luaL_openlibs()
+func OpenLibs(L LuaState) {
+	if !HasFileSystem(L) {
+		SetFileSystem(L, OSFileSystem{})
+	}
+	/* "require" functions from 'loadedLibs' and set results to global table */
+	for _, lib := range loadedLibs {
+		RequireF(L, lib.name, lib.open, true)
+		L.Pop(1) /* remove lib */
+	}
+}
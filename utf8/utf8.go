@@ -0,0 +1,62 @@
+package utf8
+
+/*
+ * Lua 5.3的UTF-8编解码，词法分析器的\u{XXX}转义和utf8库共用。
+ * 和Go的unicode/utf8不同：编码时接受最大0x7FFFFFFF的码点（最长6个字节），
+ * 代理对（U+D800~U+DFFF）也会正常编码，而不是被替换成U+FFFD
+ */
+
+const (
+	MAXUNICODE = 0x10FFFF   // 解码时接受的最大码点
+	MAXUTF     = 0x7FFFFFFF // 编码时接受的最大码点
+)
+
+// lua-5.3.4/src/lobject.c#luaO_utf8esc()
+func Encode(x uint32) []byte {
+	if x < 0x80 { /* ascii? */
+		return []byte{byte(x)}
+	}
+	var buf [6]byte
+	n := len(buf)
+	mfb := uint32(0x3f) /* maximum that fits in first byte */
+	for {               /* add continuation bytes */
+		n--
+		buf[n] = byte(0x80 | (x & 0x3f))
+		x >>= 6       /* remove added bits */
+		mfb >>= 1     /* now there is one less bit available in first byte */
+		if x <= mfb { /* still needs continuation byte? */
+			break
+		}
+	}
+	n--
+	buf[n] = byte((^mfb << 1) | x) /* add first byte */
+	return buf[n:]
+}
+
+// 解码s开头的UTF-8序列，返回码点和序列的长度，
+// 序列无效（包括超长编码和大于MAXUNICODE的码点）时返回的长度为0
+// lua-5.3.4/src/lutf8lib.c#utf8_decode()
+func Decode(s string) (uint32, int) {
+	limits := [...]uint32{0xFF, 0x7F, 0x7FF, 0xFFFF}
+	if len(s) == 0 {
+		return 0, 0
+	}
+	c := uint32(s[0])
+	var res uint32
+	if c < 0x80 { /* ascii? */
+		return c, 1
+	}
+	count := 0                   /* to count number of continuation bytes */
+	for ; c&0x40 != 0; c <<= 1 { /* still have continuation bytes? */
+		count++
+		if count >= len(s) || s[count]&0xC0 != 0x80 { /* not a continuation byte? */
+			return 0, 0 /* invalid byte sequence */
+		}
+		res = (res << 6) | uint32(s[count]&0x3F) /* add lower 6 bits from cont. byte */
+	}
+	res |= (c & 0x7F) << uint(count*5) /* add first byte */
+	if count > 3 || res > MAXUNICODE || res <= limits[count] {
+		return 0, 0 /* invalid byte sequence */
+	}
+	return res, count + 1
+}
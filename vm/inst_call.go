@@ -64,11 +64,10 @@ func tailCall(inst Instruction, vm LuaVM) {
 	a, b, _ := inst.ABC()
 	a++
 
-	// TODO: optimize tail call!
-	c := 0
+	// 被调函数直接复用当前调用帧，它的返回值就是当前函数的返回值，
+	// 所以紧跟在后面的RETURN指令不会再执行
 	nArgs := pushFuncAndArgs(a, b, vm)
-	vm.Call(nArgs, c-1)
-	popResults(a, c, vm)
+	vm.TailCall(nArgs)
 }
 
 // return R(A), ... ,R(A+B-2)
@@ -67,6 +67,21 @@ func (I Instruction) CMode() byte {
 	return opcodes[I.Opcode()].argCMode
 }
 
+// 操作码是否合法
+func (I Instruction) IsValid() bool {
+	return I.Opcode() < len(opcodes)
+}
+
+// 测试指令（EQ、LT、LE、TEST、TESTSET）的下一条指令必须是JMP
+func (I Instruction) IsTest() bool {
+	return opcodes[I.Opcode()].testFlag == 1
+}
+
+// 指令是否会修改寄存器A（调试信息里推断变量名时使用）
+func (I Instruction) SetsA() bool {
+	return opcodes[I.Opcode()].setAFlag == 1
+}
+
 func (I Instruction) Execute(vm api.LuaVM) {
 	action := opcodes[I.Opcode()].action
 	if action != nil {